@@ -0,0 +1,10 @@
+// Package api contains the OpenAPI specification of the JSON API used by single page
+// applications to render the login, consent and logout pages themselves.
+package api
+
+import _ "embed"
+
+// OpenApiSpec is the OpenAPI specification of the JSON API
+//
+//go:embed openapi.yaml
+var OpenApiSpec []byte
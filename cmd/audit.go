@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"login-provider/internal/audit"
+	"login-provider/internal/config"
+	"os"
+	"strings"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the audit record",
+}
+
+var (
+	auditKeyFiles []string
+	auditFirst    uint64
+)
+
+var verifyAuditCmd = &cobra.Command{
+	Use:   "verify FILE...",
+	Short: "Verify the hash chain of audit files",
+	Long: "Verify that the events of the audit files have not been changed and that none is missing. Pass rotated files from the oldest to the current one. " +
+		"The events are verified with the configured audit.hmac_key and the keys given, e.g. the ones used before the key has been changed. Compare the last event printed with the one received by syslog or the webhook to detect removed events at the end",
+	Args:          cobra.MinimumNArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		verifier := &audit.Verifier{First: auditFirst}
+		auditConf, err := config.NewConfiguration().AuditConfig()
+		if err != nil {
+			return err
+		}
+		if len(auditConf.HmacKey) != 0 {
+			verifier.Keys = append(verifier.Keys, []byte(auditConf.HmacKey))
+		}
+		for _, keyFile := range auditKeyFiles {
+			key, err := ioutil.ReadFile(keyFile)
+			if err != nil {
+				return err
+			}
+			verifier.Keys = append(verifier.Keys, []byte(strings.TrimSpace(string(key))))
+		}
+
+		for _, name := range args {
+			file, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			err = verifier.Verify(file)
+			_ = file.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		sequence, hash := verifier.Last()
+		cmd.Printf("%d audit events verified, the last one is %d with hash %s\n", verifier.Count, sequence, hash)
+		return nil
+	},
+}
+
+func init() {
+	verifyAuditCmd.Flags().StringArrayVar(&auditKeyFiles, "key-file", nil, "File with another HMAC key of the events, e.g. the key before it has been changed. Can be repeated")
+	verifyAuditCmd.Flags().Uint64Var(&auditFirst, "first", 0, "The sequence number of the first event, 1 if no file has been rotated away")
+	auditCmd.AddCommand(verifyAuditCmd)
+	RootCmd.AddCommand(auditCmd)
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"login-provider/internal/config"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration",
+}
+
+var validateConfigCmd = &cobra.Command{
+	Use:           "validate",
+	Short:         "Validate the configuration",
+	Long:          "Validate the configuration and report all problems found",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := config.Validate(config.NewConfiguration()); err != nil {
+			return err
+		}
+		cmd.Println("Configuration is valid")
+		return nil
+	},
+}
+
+var printConfigCmd = &cobra.Command{
+	Use:           "print",
+	Short:         "Print the effective configuration",
+	Long:          "Print the config file merged with the defaults and the environment variables. Secrets are redacted",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return config.Print(cmd.OutOrStdout())
+	},
+}
+
+func init() {
+	configCmd.AddCommand(validateConfigCmd)
+	configCmd.AddCommand(printConfigCmd)
+	RootCmd.AddCommand(configCmd)
+}
@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io/ioutil"
 	"login-provider/cmd"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -38,8 +40,6 @@ func freePort() int {
 		}
 		tries++
 	}
-	// will never get here
-	return 0
 }
 
 func waitForServiceToStart(t *testing.T, port int) bool {
@@ -76,6 +76,8 @@ func TestStartService(t *testing.T) {
 	cmd.RootCmd.SetOut(os.Stdout)
 	port := freePort()
 	os.Setenv("PORT", fmt.Sprintf("%d", port))
+	os.Setenv("HYDRA_ADMIN_URL", "http://127.0.0.1:4445")
+	os.Setenv("AUTHENTICATE_URL", "http://127.0.0.1:8090/authenticate")
 
 	// WHEN
 	go func() {
@@ -108,3 +110,55 @@ func TestVersion(t *testing.T) {
 	require.NoError(t, err)
 	require.Contains(t, w.value, "version master", "Default version must be master")
 }
+
+func TestConfigValidate(t *testing.T) {
+	// GIVEN
+	os.Setenv("HYDRA_ADMIN_URL", "http://127.0.0.1:4445")
+	os.Setenv("AUTHENTICATE_URL", "http://127.0.0.1:8090/authenticate")
+	cmd.RootCmd.SetArgs([]string{"config", "validate"})
+	w := &stringWriter{}
+	cmd.RootCmd.SetOut(w)
+
+	// WHEN
+	err := cmd.RootCmd.Execute()
+
+	// THEN
+	require.NoError(t, err)
+	assert.Contains(t, w.value, "Configuration is valid")
+}
+
+func TestConfigValidateReportsAllProblems(t *testing.T) {
+	// GIVEN
+	os.Setenv("HYDRA_ADMIN_URL", "127.0.0.1:4445")
+	os.Setenv("LOG_LEVEL", "verbose")
+	defer os.Setenv("HYDRA_ADMIN_URL", "http://127.0.0.1:4445")
+	defer os.Unsetenv("LOG_LEVEL")
+	cmd.RootCmd.SetArgs([]string{"config", "validate"})
+
+	// WHEN
+	err := cmd.RootCmd.Execute()
+
+	// THEN
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "hydra_admin_url")
+	assert.Contains(t, err.Error(), "log.level")
+}
+
+func TestConfigPrintRedactsSecrets(t *testing.T) {
+	// GIVEN
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte("hydra_admin_url: http://127.0.0.1:4445\n"+
+		"webhook:\n  client_secret: very-secret\n"), 0600))
+	cmd.RootCmd.SetArgs([]string{"config", "print", "--config", file})
+	w := &stringWriter{}
+	cmd.RootCmd.SetOut(w)
+
+	// WHEN
+	err := cmd.RootCmd.Execute()
+
+	// THEN
+	require.NoError(t, err)
+	assert.Contains(t, w.value, "hydra_admin_url: http://127.0.0.1:4445")
+	assert.Contains(t, w.value, "client_secret: '******'")
+	assert.NotContains(t, w.value, "very-secret")
+}
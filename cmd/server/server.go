@@ -1,43 +1,105 @@
 package server
 
 import (
+	"context"
+	"expvar"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"io/fs"
+	"login-provider/internal/cert_manager"
 	"login-provider/internal/config"
 	"login-provider/internal/handler"
+	"login-provider/internal/i18n"
 	"login-provider/internal/logging"
 	"login-provider/internal/middleware"
-	"os"
-	"strings"
+	"login-provider/internal/theme"
+	"login-provider/web"
+	"net/http"
 )
 
 func Serve(cmd *cobra.Command, args []string) {
 	conf := config.NewConfiguration()
 	logging.ConfigureLogging(conf)
 
+	if err := config.Validate(conf); err != nil {
+		l := log.With().Logger()
+		if validationErr, ok := err.(*config.ValidationError); ok {
+			l = l.With().Strs("_problems", validationErr.Problems).Logger()
+		}
+		l.Fatal().Msg(err.Error())
+	}
+
 	router := gin.New()
 	router.Use(gin.Recovery())
 	router.Use(middleware.CorrelationId())
 	router.Use(middleware.RequestId())
 	router.Use(middleware.Logger())
-	if strings.HasSuffix(os.Getenv("PWD"), "cmd") {
-		// because of root_test.go
-		router.LoadHTMLGlob("../web/templates/*")
-	} else {
-		router.LoadHTMLGlob("web/templates/*")
+	router.Use(middleware.ClientIP(conf))
+	router.Use(middleware.AuditOrigin())
+	router.Use(middleware.Locale())
+	router.Use(middleware.Theme(conf))
+
+	templates, _ := fs.Sub(web.Templates, "templates")
+	renderer, err := theme.NewRenderer(templates, conf.TemplatesDirectory(), conf.Themes(), i18n.FuncMap())
+	if err != nil {
+		l := log.With().Err(err).Logger()
+		l.Fatal().Msg("Failed to load templates")
 	}
+	router.HTMLRender = renderer
 
-	handler.RegisterRoutes(router, conf)
+	config.OnChange(logging.Reconfigure)
+	config.OnChange(renderer.Reconfigure)
 
-	addr := conf.Address()
+	// certificates are loaded again on change. certs stays nil if TLS is not configured
+	var certs *cert_manager.Manager
 	if tlsConfig, err := conf.TlsConfig(); err == nil {
+		certs, err = cert_manager.NewManager(tlsConfig)
+		if err != nil {
+			l := log.With().Err(err).Logger()
+			l.Fatal().Msg("Failed to load TLS certificates")
+		}
+		config.OnChange(certs.Reconfigure)
+		go certs.Watch(context.Background())
+	}
+
+	handler.RegisterRoutes(router, conf, certs)
+
+	// configuration changes are applied without restart. Only the address, switching between
+	// HTTP and HTTPS and the static assets directory require a restart
+	go config.Watch(context.Background())
+
+	// the metrics are served on their own address, which is not meant to be reachable publicly
+	if metricsAddr := conf.MetricsAddress(); len(metricsAddr) != 0 {
+		go serveMetrics(metricsAddr)
+	}
+
+	addr := conf.Address()
+	if certs != nil {
 		log.Info().
 			Msg("Listening and serving HTTPS on " + addr)
-		router.RunTLS(addr, tlsConfig.CertFile, tlsConfig.KeyFile)
+		server := &http.Server{Addr: addr, Handler: router, TLSConfig: certs.TLSConfig()}
+		err = server.ListenAndServeTLS("", "")
 	} else {
 		log.Info().
 			Msg("Listening and serving HTTP on " + addr)
-		router.Run(addr)
+		err = router.Run(addr)
+	}
+	if err != nil {
+		l := log.With().Err(err).Logger()
+		l.Fatal().Msg("Failed to serve requests")
+	}
+}
+
+// serveMetrics publishes the expvar variables, e.g. the circuit breaker states and retries of the
+// upstream services, under /debug/vars
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	log.Info().
+		Msg("Serving metrics on " + addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		l := log.With().Err(err).Logger()
+		l.Fatal().Msg("Failed to serve metrics")
 	}
-}
\ No newline at end of file
+}
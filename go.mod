@@ -1,15 +1,18 @@
 module login-provider
 
-go 1.14
+go 1.16
 
 require (
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/gin-gonic/gin v1.6.3
 	github.com/go-openapi/runtime v0.19.15
 	github.com/google/uuid v1.1.1
 	github.com/mitchellh/mapstructure v1.3.2
 	github.com/ory/hydra-client-go v1.5.0-beta.5
 	github.com/rs/zerolog v1.19.0
+	github.com/spf13/cast v1.3.0
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/viper v1.4.0
 	github.com/stretchr/testify v1.4.0
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -0,0 +1,167 @@
+// Package accounts remembers the accounts recently used in a browser. They are kept in a cookie,
+// which is encrypted and authenticated with AES-GCM, so users can neither read the subjects nor
+// tamper with the list. The cookie only identifies accounts, users have to authenticate again
+// to switch to another one.
+package accounts
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"login-provider/internal/config"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const cookieName = "accounts"
+
+// ErrDisabled is returned if no cookie secret is configured
+var ErrDisabled = errors.New("account chooser disabled")
+
+// Account is an account used in the browser
+type Account struct {
+	Subject string `json:"sub"`
+	// Name is the email address the user signed in with
+	Name     string    `json:"name"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// Jar reads and writes the cookie holding the accounts
+type Jar struct {
+	aead   cipher.AEAD
+	max    int
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+func NewJar(conf *config.AccountsConfig) (*Jar, error) {
+	if len(conf.CookieSecret) == 0 {
+		return nil, ErrDisabled
+	}
+
+	// AES-256 requires a key of 32 bytes
+	key := sha256.Sum256([]byte(conf.CookieSecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Jar{aead: aead, max: conf.Max, maxAge: conf.MaxAge, now: time.Now}, nil
+}
+
+// Read returns the accounts remembered in the browser, the most recently used first. Accounts
+// not used within the configured period are left out. A cookie, which can't be decrypted, is ignored.
+func (j *Jar) Read(r *http.Request) []Account {
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(data) < j.aead.NonceSize() {
+		return nil
+	}
+	nonce, ciphertext := data[:j.aead.NonceSize()], data[j.aead.NonceSize():]
+	plaintext, err := j.aead.Open(nil, nonce, ciphertext, []byte(cookieName))
+	if err != nil {
+		return nil
+	}
+
+	var accounts []Account
+	if err := json.Unmarshal(plaintext, &accounts); err != nil {
+		return nil
+	}
+	return j.recent(accounts)
+}
+
+// Find returns the remembered account with the given subject
+func (j *Jar) Find(r *http.Request, subject string) (Account, bool) {
+	for _, account := range j.Read(r) {
+		if account.Subject == subject {
+			return account, true
+		}
+	}
+	return Account{}, false
+}
+
+// Remember adds the account to the cookie, respectively marks it as used now
+func (j *Jar) Remember(w http.ResponseWriter, r *http.Request, subject, name string) {
+	accounts := []Account{{Subject: subject, Name: name, LastUsed: j.now()}}
+	for _, account := range j.Read(r) {
+		if account.Subject != subject {
+			accounts = append(accounts, account)
+		}
+	}
+	j.write(w, r, accounts)
+}
+
+// Forget removes the account with the given subject from the cookie
+func (j *Jar) Forget(w http.ResponseWriter, r *http.Request, subject string) {
+	var accounts []Account
+	for _, account := range j.Read(r) {
+		if account.Subject != subject {
+			accounts = append(accounts, account)
+		}
+	}
+	j.write(w, r, accounts)
+}
+
+func (j *Jar) write(w http.ResponseWriter, r *http.Request, accounts []Account) {
+	accounts = j.recent(accounts)
+
+	cookie := &http.Cookie{
+		Name:     cookieName,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+	if len(accounts) == 0 {
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+		return
+	}
+
+	plaintext, err := json.Marshal(accounts)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, j.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return
+	}
+
+	cookie.Value = base64.RawURLEncoding.EncodeToString(j.aead.Seal(nonce, nonce, plaintext, []byte(cookieName)))
+	cookie.MaxAge = int(j.maxAge.Seconds())
+	http.SetCookie(w, cookie)
+}
+
+// recent orders the accounts by their last use and drops the ones exceeding the maximum number
+// or not used within the configured period
+func (j *Jar) recent(accounts []Account) []Account {
+	sort.SliceStable(accounts, func(a, b int) bool {
+		return accounts[a].LastUsed.After(accounts[b].LastUsed)
+	})
+
+	now := j.now()
+	result := make([]Account, 0, len(accounts))
+	for _, account := range accounts {
+		if len(result) == j.max {
+			break
+		}
+		if j.maxAge > 0 && now.Sub(account.LastUsed) > j.maxAge {
+			continue
+		}
+		result = append(result, account)
+	}
+	return result
+}
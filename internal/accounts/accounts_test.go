@@ -0,0 +1,111 @@
+package accounts
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"login-provider/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+func newJar(t *testing.T, max int) *Jar {
+	jar, err := NewJar(&config.AccountsConfig{CookieSecret: secret, Max: max, MaxAge: time.Hour})
+	require.NoError(t, err)
+	return jar
+}
+
+// roundTrip passes the cookie set by the given function to a new request
+func roundTrip(r *http.Request, set func(w http.ResponseWriter, r *http.Request)) *http.Request {
+	w := httptest.NewRecorder()
+	set(w, r)
+
+	next := httptest.NewRequest(http.MethodGet, "/login", nil)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge >= 0 {
+			next.AddCookie(cookie)
+		}
+	}
+	return next
+}
+
+func TestJarRemembersMostRecentlyUsedAccounts(t *testing.T) {
+	// GIVEN
+	jar := newJar(t, 2)
+	now := time.Now()
+	jar.now = func() time.Time { return now }
+	r := httptest.NewRequest(http.MethodGet, "/login", nil)
+
+	// WHEN
+	for _, subject := range []string{"1", "2", "3", "2"} {
+		now = now.Add(time.Second)
+		r = roundTrip(r, func(w http.ResponseWriter, r *http.Request) {
+			jar.Remember(w, r, subject, "user"+subject+"@example.com")
+		})
+	}
+
+	// THEN
+	accounts := jar.Read(r)
+	require.Len(t, accounts, 2)
+	assert.Equal(t, "2", accounts[0].Subject)
+	assert.Equal(t, "user2@example.com", accounts[0].Name)
+	assert.Equal(t, "3", accounts[1].Subject)
+}
+
+func TestJarForgetsAccounts(t *testing.T) {
+	// GIVEN
+	jar := newJar(t, 5)
+	r := roundTrip(httptest.NewRequest(http.MethodGet, "/login", nil), func(w http.ResponseWriter, r *http.Request) {
+		jar.Remember(w, r, "1", "foo@example.com")
+	})
+
+	// WHEN
+	w := httptest.NewRecorder()
+	jar.Forget(w, r, "1")
+
+	// THEN
+	require.Len(t, w.Result().Cookies(), 1)
+	assert.Equal(t, -1, w.Result().Cookies()[0].MaxAge, "The cookie must be removed if no account is left")
+}
+
+func TestJarIgnoresExpiredAccounts(t *testing.T) {
+	// GIVEN
+	jar := newJar(t, 5)
+	now := time.Now()
+	jar.now = func() time.Time { return now }
+	r := roundTrip(httptest.NewRequest(http.MethodGet, "/login", nil), func(w http.ResponseWriter, r *http.Request) {
+		jar.Remember(w, r, "1", "foo@example.com")
+	})
+
+	// WHEN
+	now = now.Add(2 * time.Hour)
+
+	// THEN
+	assert.Empty(t, jar.Read(r))
+}
+
+func TestJarIgnoresForeignCookies(t *testing.T) {
+	// GIVEN
+	other, err := NewJar(&config.AccountsConfig{CookieSecret: "another secret, which is long enough", Max: 5})
+	require.NoError(t, err)
+	r := roundTrip(httptest.NewRequest(http.MethodGet, "/login", nil), func(w http.ResponseWriter, r *http.Request) {
+		other.Remember(w, r, "1", "foo@example.com")
+	})
+	tampered := httptest.NewRequest(http.MethodGet, "/login", nil)
+	tampered.AddCookie(&http.Cookie{Name: cookieName, Value: "bm90IGVuY3J5cHRlZA"})
+
+	// WHEN & THEN
+	assert.Empty(t, newJar(t, 5).Read(r))
+	assert.Empty(t, newJar(t, 5).Read(tampered))
+}
+
+func TestNewJarRequiresSecret(t *testing.T) {
+	// WHEN
+	_, err := NewJar(&config.AccountsConfig{})
+
+	// THEN
+	assert.Equal(t, ErrDisabled, err)
+}
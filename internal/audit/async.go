@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	// queueSize is the number of events waiting for a slow sink, further events are dropped
+	queueSize = 1000
+	// writeAttempts is how often an event is written to a slow sink before it is dropped
+	writeAttempts = 3
+	// retryDelay is the delay before the first retry, it doubles with every further one
+	retryDelay = time.Second
+	// closeTimeout is how long closing waits for the queued events to be written
+	closeTimeout = 5 * time.Second
+)
+
+// ErrQueueFull is returned if an event is dropped, because too many events wait for a slow sink
+var ErrQueueFull = errors.New("audit queue is full")
+
+// asyncSink writes the events to a sink in the background, so logins don't wait for slow sinks like
+// the webhook. The order of the events is kept. Failed writes are retried, events which still can't
+// be written are logged with their sequence number, so they can be recovered from the audit file.
+type asyncSink struct {
+	sink       Sink
+	entries    chan []byte
+	done       chan error
+	retryDelay time.Duration
+}
+
+func newAsyncSink(sink Sink, retryDelay time.Duration) *asyncSink {
+	s := &asyncSink{
+		sink:       sink,
+		entries:    make(chan []byte, queueSize),
+		done:       make(chan error, 1),
+		retryDelay: retryDelay,
+	}
+	go s.run()
+	return s
+}
+
+// Write queues the event. It returns ErrQueueFull rather than blocking the caller.
+func (s *asyncSink) Write(entry []byte) error {
+	select {
+	case s.entries <- entry:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (s *asyncSink) run() {
+	for entry := range s.entries {
+		s.write(entry)
+	}
+	s.done <- s.sink.Close()
+}
+
+func (s *asyncSink) write(entry []byte) {
+	delay := s.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := s.sink.Write(entry)
+		if err == nil {
+			return
+		} else if attempt == writeAttempts {
+			var event struct {
+				Sequence uint64 `json:"seq"`
+			}
+			_ = json.Unmarshal(entry, &event)
+			log.Err(err).Uint64("_seq", event.Sequence).Msg("Failed to write audit event, it is dropped")
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Close writes the queued events and closes the sink. It gives up after closeTimeout.
+func (s *asyncSink) Close() error {
+	close(s.entries)
+	select {
+	case err := <-s.done:
+		return err
+	case <-time.After(closeTimeout):
+		return fmt.Errorf("gave up writing %d queued audit events", len(s.entries))
+	}
+}
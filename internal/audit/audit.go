@@ -0,0 +1,265 @@
+// Package audit records the security relevant decisions of the login provider, like logins and
+// consent decisions, as structured events. They are written to their own sinks, apart from the
+// operational logs. Each event contains the hash of the previous one, which makes the record
+// tamper-evident.
+//
+// With a configured key the hashes are HMACs, so events can only be changed, inserted or removed
+// by somebody knowing the key. Plain SHA-256 hashes only detect changes by somebody, who does not
+// bother to compute the hashes of the following events again. In both cases the events at the end
+// of the record can be removed unnoticed, unless the last hash is compared with a copy kept apart,
+// like the events sent to syslog or the webhook.
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/config"
+	"sync"
+	"time"
+)
+
+// The types of events
+const (
+	LoginSucceeded = "login.succeeded"
+	LoginFailed    = "login.failed"
+	MfaSucceeded   = "mfa.succeeded"
+	MfaFailed      = "mfa.failed"
+	MfaSkipped     = "mfa.skipped"
+	ConsentGranted = "consent.granted"
+	ConsentDenied  = "consent.denied"
+	Logout         = "logout"
+	// Lockout is emitted if a rate limit stops a user or client from trying any further
+	Lockout = "lockout"
+)
+
+// Event is an entry of the audit record
+type Event struct {
+	// Sequence numbers the events. It starts at 1 if the chain can't be continued.
+	Sequence uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Subject  string    `json:"subject,omitempty"`
+	// Email is the email address entered by the user, if the subject is not known
+	Email     string `json:"email,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	// Methods are the authentication methods used, like pwd, sms or email
+	Methods   []string `json:"amr,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	Audiences []string `json:"audiences,omitempty"`
+	// Reason explains failures and lockouts, respectively why consent has been granted automatically
+	Reason     string `json:"reason,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	// KeyID identifies the key of the HMAC, empty for plain hashes. The key may change within the
+	// chain, when the configuration is reloaded.
+	KeyID string `json:"key_id,omitempty"`
+	// PreviousHash is the hash of the previous event, empty for the first one
+	PreviousHash string `json:"prev_hash"`
+	// Hash is the hex encoded HMAC-SHA256, respectively SHA-256 hash without key, of the event
+	// without the hash itself, see Verify
+	Hash string `json:"hash,omitempty"`
+}
+
+// Origin describes the request an event has been caused by
+type Origin struct {
+	RemoteAddr string
+	RequestID  string
+	UserAgent  string
+}
+
+type originKey struct{}
+
+// WithOrigin returns a context, which adds the given origin to the events emitted with it
+func WithOrigin(ctx context.Context, origin *Origin) context.Context {
+	return context.WithValue(ctx, originKey{}, origin)
+}
+
+// OriginFrom returns the origin added to the context, respectively nil
+func OriginFrom(ctx context.Context) *Origin {
+	origin, _ := ctx.Value(originKey{}).(*Origin)
+	return origin
+}
+
+// Sink writes the events, which are passed as a line of JSON without line break
+type Sink interface {
+	Write(entry []byte) error
+	Close() error
+}
+
+// Emitter chains the events and writes them to the configured sinks
+type Emitter struct {
+	// mutex keeps the order of the events in the sinks the order of the chain
+	mutex    sync.Mutex
+	sinks    []Sink
+	sequence uint64
+	lastHash string
+	// key is the key of the HMACs, nil for plain hashes
+	key []byte
+	now func() time.Time
+}
+
+// NewEmitter creates the sinks configured. The chain continues after the last event of the
+// audit file, if there is one.
+func NewEmitter(conf config.Configuration) (*Emitter, error) {
+	auditConf, err := conf.AuditConfig()
+	if err != nil {
+		return nil, err
+	}
+	sinks, err := newSinks(conf, auditConf)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Emitter{sinks: sinks, key: hmacKey(auditConf), now: time.Now}
+	if file := auditConf.File; len(file) != 0 {
+		last, err := lastEvent(file)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		if last != nil {
+			e.sequence, e.lastHash = last.Sequence, last.Hash
+		}
+	}
+	return e, nil
+}
+
+// Reconfigure creates the sinks of the given configuration. They replace the current ones, which
+// are closed, when the returned function is called. The chain continues, with the new key if it
+// has been changed. The events name the ID of their key, so the verifier picks the right one.
+func (e *Emitter) Reconfigure(conf config.Configuration) (func(), error) {
+	auditConf, err := conf.AuditConfig()
+	if err != nil {
+		return nil, err
+	}
+	sinks, err := newSinks(conf, auditConf)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		e.mutex.Lock()
+		previous := e.sinks
+		e.sinks, e.key = sinks, hmacKey(auditConf)
+		e.mutex.Unlock()
+		// slow sinks write their queued events first, the emitter must not wait for that
+		closeSinks(previous)
+	}, nil
+}
+
+// newSinks creates the configured sinks. The file is written right away, so it keeps every event.
+// The syslog and the webhook are written in the background, as they may be slow.
+func newSinks(conf config.Configuration, auditConf *config.AuditConfig) ([]Sink, error) {
+	var sinks []Sink
+	if len(auditConf.File) != 0 {
+		sink, err := newFileSink(auditConf.File, int64(auditConf.MaxSize)*1024*1024, auditConf.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if auditConf.Syslog {
+		sink, err := newSyslogSink(auditConf.SyslogNetwork, auditConf.SyslogAddress, auditConf.SyslogTag)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, newAsyncSink(sink, retryDelay))
+	}
+	if len(auditConf.WebhookUrl) != 0 {
+		sink, err := newWebhookSink(conf, auditConf.WebhookUrl)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, newAsyncSink(sink, retryDelay))
+	}
+	return sinks, nil
+}
+
+func hmacKey(auditConf *config.AuditConfig) []byte {
+	if len(auditConf.HmacKey) == 0 {
+		return nil
+	}
+	return []byte(auditConf.HmacKey)
+}
+
+func closeSinks(sinks []Sink) {
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			log.Err(err).Msg("Failed to close audit sink")
+		}
+	}
+}
+
+// Emit writes the event to all sinks. The origin of the context, the time, the sequence number
+// and the hashes are set by the emitter. Failing sinks are logged, but do not fail the caller.
+// Slow sinks only queue the event, so the lock is held just as long as the audit file is written.
+func (e *Emitter) Emit(ctx context.Context, event *Event) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if len(e.sinks) == 0 {
+		return
+	}
+
+	entry := *event
+	if origin := OriginFrom(ctx); origin != nil {
+		entry.RemoteAddr, entry.RequestID, entry.UserAgent = origin.RemoteAddr, origin.RequestID, origin.UserAgent
+	}
+	entry.Sequence = e.sequence + 1
+	entry.Time = e.now().UTC()
+	entry.PreviousHash = e.lastHash
+	line, hash, err := chain(&entry, e.key)
+	if err != nil {
+		log.Ctx(ctx).Err(err).Str("_type", event.Type).Msg("Failed to encode audit event")
+		return
+	}
+	e.sequence, e.lastHash = entry.Sequence, hash
+
+	for _, sink := range e.sinks {
+		if err := sink.Write(line); err != nil {
+			log.Ctx(ctx).Err(err).Str("_type", event.Type).Uint64("_seq", entry.Sequence).Msg("Failed to write audit event")
+		}
+	}
+}
+
+// chain encodes the event and appends its hash. The hash covers the encoded event up to the hash,
+// which includes the hash of the previous event and the ID of the key.
+func chain(event *Event, key []byte) ([]byte, string, error) {
+	event.Hash = ""
+	event.KeyID = ""
+	if key != nil {
+		event.KeyID = keyID(key)
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash := digest(key, data)
+	line := append(data[:len(data)-1], `,"hash":"`+hash+`"}`...)
+	return line, hash, nil
+}
+
+// keyID derives the ID of the key, which tells the verifier the key to use without revealing it
+func keyID(key []byte) string {
+	return digest(key, []byte("audit key id"))[:16]
+}
+
+// digest returns the hex encoded HMAC-SHA256 of the data, respectively its SHA-256 hash if the key is nil
+func digest(key, data []byte) string {
+	if key == nil {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
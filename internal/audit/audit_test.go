@@ -0,0 +1,291 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"login-provider/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type auditConfiguration struct {
+	config.Configuration
+	audit *config.AuditConfig
+}
+
+func (c *auditConfiguration) AuditConfig() (*config.AuditConfig, error) {
+	return c.audit, nil
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func verifyFiles(t *testing.T, files ...string) (*Verifier, error) {
+	verifier := &Verifier{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		if err := verifier.Verify(strings.NewReader(string(data))); err != nil {
+			return verifier, err
+		}
+	}
+	return verifier, nil
+}
+
+func TestEmitterChainsEvents(t *testing.T) {
+	// GIVEN
+	dir, remove := tempDir(t)
+	defer remove()
+	file := filepath.Join(dir, "audit.log")
+	conf := &auditConfiguration{audit: &config.AuditConfig{File: file}}
+	emitter, err := NewEmitter(conf)
+	require.NoError(t, err)
+	ctx := WithOrigin(context.Background(), &Origin{RemoteAddr: "192.0.2.1", RequestID: "req-1"})
+
+	// WHEN
+	emitter.Emit(ctx, &Event{Type: LoginFailed, Email: "foo@example.com", Reason: "invalid_credentials"})
+	emitter.Emit(ctx, &Event{Type: LoginSucceeded, Subject: "foo", Methods: []string{"pwd"}})
+	closeSinks(emitter.sinks)
+	// the chain continues after a restart
+	restarted, err := NewEmitter(conf)
+	require.NoError(t, err)
+	restarted.Emit(ctx, &Event{Type: Logout, Subject: "foo", SessionID: "sid"})
+	closeSinks(restarted.sinks)
+
+	// THEN
+	verifier, err := verifyFiles(t, file)
+	require.NoError(t, err)
+	assert.Equal(t, 3, verifier.Count)
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	var first Event
+	require.NoError(t, json.Unmarshal([]byte(strings.Split(string(data), "\n")[0]), &first))
+	assert.Equal(t, uint64(1), first.Sequence)
+	assert.Equal(t, LoginFailed, first.Type)
+	assert.Equal(t, "192.0.2.1", first.RemoteAddr)
+	assert.Equal(t, "req-1", first.RequestID)
+	assert.Empty(t, first.PreviousHash)
+}
+
+func TestVerifierDetectsTampering(t *testing.T) {
+	// GIVEN
+	dir, remove := tempDir(t)
+	defer remove()
+	file := filepath.Join(dir, "audit.log")
+	emitter, err := NewEmitter(&auditConfiguration{audit: &config.AuditConfig{File: file}})
+	require.NoError(t, err)
+	for _, subject := range []string{"foo", "bar", "baz"} {
+		emitter.Emit(context.Background(), &Event{Type: LoginSucceeded, Subject: subject})
+	}
+	closeSinks(emitter.sinks)
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	// WHEN
+	changed := (&Verifier{}).Verify(strings.NewReader(strings.Replace(string(data), `"bar"`, `"evil"`, 1)))
+	removed := (&Verifier{}).Verify(strings.NewReader(lines[0] + "\n" + lines[2] + "\n"))
+
+	// THEN
+	assert.EqualError(t, changed, "line 2: event 2 has been changed")
+	assert.EqualError(t, removed, "line 2: event 3 does not follow event 1")
+}
+
+func TestVerifierRequiresKeyOfChain(t *testing.T) {
+	// GIVEN
+	dir, remove := tempDir(t)
+	defer remove()
+	file := filepath.Join(dir, "audit.log")
+	key := "0123456789abcdef0123456789abcdef"
+	emitter, err := NewEmitter(&auditConfiguration{audit: &config.AuditConfig{File: file, HmacKey: key}})
+	require.NoError(t, err)
+	for _, subject := range []string{"foo", "bar"} {
+		emitter.Emit(context.Background(), &Event{Type: LoginSucceeded, Subject: subject})
+	}
+	closeSinks(emitter.sinks)
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	// somebody without the key rewrites the chain with plain hashes
+	forgedFirst, _, err := chain(&Event{Sequence: 1, Type: LoginSucceeded, Subject: "evil"}, nil)
+	require.NoError(t, err)
+
+	// WHEN
+	keyed := (&Verifier{Keys: [][]byte{[]byte(key)}}).Verify(strings.NewReader(string(data)))
+	withoutKey := (&Verifier{}).Verify(strings.NewReader(string(data)))
+	forgedErr := (&Verifier{Keys: [][]byte{[]byte(key)}}).Verify(strings.NewReader(string(forgedFirst)))
+	truncated := (&Verifier{Keys: [][]byte{[]byte(key)}, First: 1}).Verify(strings.NewReader(strings.SplitN(string(data), "\n", 2)[1]))
+
+	// THEN
+	assert.NoError(t, keyed)
+	assert.EqualError(t, withoutKey, "line 1: event 1 has been chained with the unknown key "+keyID([]byte(key)))
+	assert.EqualError(t, forgedErr, "line 1: event 1 has no HMAC")
+	assert.EqualError(t, truncated, "line 1: event 2 is not the first event 1")
+}
+
+func TestVerifierAcceptsKeyChangedWithinChain(t *testing.T) {
+	// GIVEN
+	dir, remove := tempDir(t)
+	defer remove()
+	file := filepath.Join(dir, "audit.log")
+	oldKey, newKey := "0123456789abcdef0123456789abcdef", "fedcba9876543210fedcba9876543210"
+	emitter, err := NewEmitter(&auditConfiguration{audit: &config.AuditConfig{File: file, HmacKey: oldKey}})
+	require.NoError(t, err)
+	emitter.Emit(context.Background(), &Event{Type: LoginSucceeded, Subject: "foo"})
+	apply, err := emitter.Reconfigure(&auditConfiguration{audit: &config.AuditConfig{File: file, HmacKey: newKey}})
+	require.NoError(t, err)
+	apply()
+	emitter.Emit(context.Background(), &Event{Type: LoginSucceeded, Subject: "bar"})
+	closeSinks(emitter.sinks)
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+
+	// WHEN
+	bothKeys := &Verifier{Keys: [][]byte{[]byte(newKey), []byte(oldKey)}}
+	bothErr := bothKeys.Verify(strings.NewReader(string(data)))
+	newKeyErr := (&Verifier{Keys: [][]byte{[]byte(newKey)}}).Verify(strings.NewReader(string(data)))
+
+	// THEN
+	require.NoError(t, bothErr)
+	assert.Equal(t, 2, bothKeys.Count)
+	assert.EqualError(t, newKeyErr, "line 1: event 1 has been chained with the unknown key "+keyID([]byte(oldKey)))
+}
+
+func TestFileIsRotated(t *testing.T) {
+	// GIVEN
+	dir, remove := tempDir(t)
+	defer remove()
+	file := filepath.Join(dir, "audit.log")
+	sink, err := newFileSink(file, 600, 2)
+	require.NoError(t, err)
+	emitter := &Emitter{sinks: []Sink{sink}, now: func() time.Time { return time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC) }}
+
+	// WHEN
+	for i := 0; i < 10; i++ {
+		emitter.Emit(context.Background(), &Event{Type: LoginSucceeded, Subject: "foo"})
+	}
+	closeSinks(emitter.sinks)
+
+	// THEN
+	_, err = os.Stat(file + ".3")
+	assert.True(t, os.IsNotExist(err), "Only the configured number of backups must be kept")
+	verifier, err := verifyFiles(t, file+".2", file+".1", file)
+	require.NoError(t, err)
+	assert.Equal(t, emitter.sequence, verifier.sequence)
+	assert.Less(t, verifier.Count, 10)
+}
+
+func TestWebhookReceivesEvents(t *testing.T) {
+	// GIVEN
+	var received Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	emitter := &Emitter{sinks: []Sink{&webhookSink{url: server.URL, httpClient: server.Client()}}, now: time.Now}
+
+	// WHEN
+	emitter.Emit(context.Background(), &Event{
+		Type:     ConsentGranted,
+		Subject:  "foo",
+		ClientID: "app",
+		Scopes:   []string{"openid", "email"},
+	})
+
+	// THEN
+	assert.Equal(t, ConsentGranted, received.Type)
+	assert.Equal(t, []string{"openid", "email"}, received.Scopes)
+	assert.Equal(t, emitter.lastHash, received.Hash)
+}
+
+// recordingSink records the entries written. It fails the first writes and blocks until released, if told to.
+type recordingSink struct {
+	mutex    sync.Mutex
+	entries  []string
+	failures int
+	// blocked is signaled when a write waits for release
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (s *recordingSink) Write(entry []byte) error {
+	if s.release != nil {
+		select {
+		case s.blocked <- struct{}{}:
+		default:
+		}
+		<-s.release
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("unavailable")
+	}
+	s.entries = append(s.entries, string(entry))
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func (s *recordingSink) written() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.entries
+}
+
+func TestSlowSinkDoesNotBlockEmitter(t *testing.T) {
+	// GIVEN
+	slow := &recordingSink{blocked: make(chan struct{}, 1), release: make(chan struct{})}
+	sink := newAsyncSink(slow, time.Millisecond)
+	emitter := &Emitter{sinks: []Sink{sink}, now: time.Now}
+	emitter.Emit(context.Background(), &Event{Type: LoginSucceeded, Subject: "foo"})
+	<-slow.blocked
+
+	// WHEN
+	for i := 0; i < queueSize; i++ {
+		emitter.Emit(context.Background(), &Event{Type: LoginSucceeded, Subject: "foo"})
+	}
+	dropped := sink.Write([]byte(`{"seq":0}`))
+	close(slow.release)
+	require.NoError(t, sink.Close())
+
+	// THEN
+	assert.Equal(t, ErrQueueFull, dropped)
+	// the first event is being written while the queue is full
+	written := slow.written()
+	assert.Len(t, written, queueSize+1)
+	verifier := &Verifier{}
+	require.NoError(t, verifier.Verify(strings.NewReader(strings.Join(written, "\n"))))
+	assert.Equal(t, uint64(queueSize+1), verifier.sequence)
+}
+
+func TestFailedWritesAreRetried(t *testing.T) {
+	// GIVEN
+	failing := &recordingSink{failures: writeAttempts - 1}
+	sink := newAsyncSink(failing, time.Millisecond)
+	emitter := &Emitter{sinks: []Sink{sink}, now: time.Now}
+
+	// WHEN
+	emitter.Emit(context.Background(), &Event{Type: Logout, Subject: "foo"})
+	require.NoError(t, sink.Close())
+
+	// THEN
+	require.Len(t, failing.written(), 1)
+	assert.Contains(t, failing.written()[0], emitter.lastHash)
+}
@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// tailSize is the number of bytes read from the end of the audit file to find the last event
+const tailSize = 64 * 1024
+
+// fileSink appends the events to a file. The file is renamed to file.1 once it reached the maximum
+// size, the backups before are shifted to file.2 and so on. The emitter serializes the calls.
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newFileSink(path string, maxSize int64, maxBackups int) (*fileSink, error) {
+	s := &fileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+func (s *fileSink) Write(entry []byte) error {
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(entry))+1 > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(append(entry, '\n'))
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+
+	if s.maxBackups == 0 {
+		if err := os.Remove(s.path); err != nil {
+			return err
+		}
+	} else {
+		_ = os.Remove(s.backup(s.maxBackups))
+		for i := s.maxBackups - 1; i > 0; i-- {
+			if err := os.Rename(s.backup(i), s.backup(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if err := os.Rename(s.path, s.backup(1)); err != nil {
+			return err
+		}
+	}
+	return s.open()
+}
+
+func (s *fileSink) backup(i int) string {
+	return s.path + "." + strconv.Itoa(i)
+}
+
+func (s *fileSink) Close() error {
+	return s.file.Close()
+}
+
+// lastEvent returns the last event written to the audit file or, if it has just been rotated, to
+// the first backup. It returns nil if there is none.
+func lastEvent(path string) (*Event, error) {
+	for _, file := range []string{path, path + ".1"} {
+		line, err := lastLine(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read last audit event: %w", err)
+		}
+		if len(line) == 0 {
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return nil, fmt.Errorf("failed to read last audit event of %s: %w", file, err)
+		}
+		return &event, nil
+	}
+	return nil, nil
+}
+
+func lastLine(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - tailSize
+	if offset < 0 {
+		offset = 0
+	}
+	tail := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	tail = bytes.TrimRight(tail, "\n")
+	return tail[bytes.LastIndexByte(tail, '\n')+1:], nil
+}
@@ -0,0 +1,30 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package audit
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// syslogSink sends the events to syslog with the facility authpriv
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func newSyslogSink(network, address, tag string) (*syslogSink, error) {
+	writer, err := syslog.Dial(network, address, syslog.LOG_AUTHPRIV|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) Write(entry []byte) error {
+	return s.writer.Info(string(entry))
+}
+
+func (s *syslogSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package audit
+
+import "errors"
+
+func newSyslogSink(string, string, string) (Sink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// maxLineSize limits the size of an event read by the Verifier
+const maxLineSize = 1024 * 1024
+
+// Verifier checks that the events read have not been changed and that none is missing in between.
+// Rotated files are verified by passing them to the same verifier from the oldest to the current
+// one. The first event is trusted unless First is set, as the events before may have been removed
+// by the rotation. Removed events at the end are only detected by comparing the last event with a
+// copy kept apart, like the events sent to syslog or the webhook.
+//
+// The key may have been changed while the events were written. Each event is verified with the
+// key matching its key ID. Without keys, only events chained with plain hashes are accepted, with
+// keys, only events chained with one of them.
+type Verifier struct {
+	// Keys are the keys of the HMACs, empty if the events have been chained without key
+	Keys [][]byte
+	keys map[string][]byte
+	// First is the sequence number of the first event, any if 0
+	First    uint64
+	started  bool
+	sequence uint64
+	lastHash string
+	// Count is the number of events verified
+	Count int
+}
+
+// Last returns the sequence number and the hash of the last event verified
+func (v *Verifier) Last() (uint64, string) {
+	return v.sequence, v.lastHash
+}
+
+// Verify reads the events from the given reader. It returns an error describing the first event,
+// which has been changed or doesn't follow the previous one.
+func (v *Verifier) Verify(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		entry := scanner.Bytes()
+		if len(bytes.TrimSpace(entry)) == 0 {
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal(entry, &event); err != nil {
+			return fmt.Errorf("line %d: invalid event: %w", line, err)
+		}
+		suffix := []byte(`,"hash":"` + event.Hash + `"}`)
+		if len(event.Hash) == 0 || !bytes.HasSuffix(entry, suffix) {
+			return fmt.Errorf("line %d: event %d has no hash at the end", line, event.Sequence)
+		}
+		key, err := v.key(&event)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		hash := digest(key, append(entry[:len(entry)-len(suffix):len(entry)-len(suffix)], '}'))
+		if !hmac.Equal([]byte(hash), []byte(event.Hash)) {
+			return fmt.Errorf("line %d: event %d has been changed", line, event.Sequence)
+		}
+		if !v.started && v.First != 0 && event.Sequence != v.First {
+			return fmt.Errorf("line %d: event %d is not the first event %d", line, event.Sequence, v.First)
+		}
+		if v.started && (event.PreviousHash != v.lastHash || event.Sequence != v.sequence+1) {
+			return fmt.Errorf("line %d: event %d does not follow event %d", line, event.Sequence, v.sequence)
+		}
+
+		v.started, v.sequence, v.lastHash = true, event.Sequence, event.Hash
+		v.Count++
+	}
+	return scanner.Err()
+}
+
+// key returns the key the given event has been chained with, nil for plain hashes
+func (v *Verifier) key(event *Event) ([]byte, error) {
+	if v.keys == nil {
+		v.keys = make(map[string][]byte)
+		for _, key := range v.Keys {
+			v.keys[keyID(key)] = key
+		}
+	}
+
+	if len(event.KeyID) == 0 {
+		if len(v.keys) != 0 {
+			return nil, fmt.Errorf("event %d has no HMAC", event.Sequence)
+		}
+		return nil, nil
+	}
+	key, ok := v.keys[event.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("event %d has been chained with the unknown key %s", event.Sequence, event.KeyID)
+	}
+	return key, nil
+}
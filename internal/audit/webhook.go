@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"login-provider/internal/config"
+	"login-provider/internal/upstream"
+	"net/http"
+)
+
+// webhookSink posts each event as JSON to the webhook. It is called with the settings of the
+// audit_webhook upstream service.
+type webhookSink struct {
+	url        string
+	httpClient *http.Client
+}
+
+func newWebhookSink(conf config.Configuration, url string) (*webhookSink, error) {
+	httpClient, err := upstream.NewClient(conf, config.AuditWebhook)
+	if err != nil {
+		return nil, err
+	}
+	return &webhookSink{url: url, httpClient: httpClient}, nil
+}
+
+func (s *webhookSink) Write(entry []byte) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.url, bytes.NewReader(entry))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call audit webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit webhook responded with status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	s.httpClient.CloseIdleConnections()
+	return nil
+}
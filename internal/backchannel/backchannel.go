@@ -0,0 +1,185 @@
+// Package backchannel notifies webhooks and local listeners about logouts. Webhooks receive
+// signed logout tokens as defined by https://openid.net/specs/openid-connect-backchannel-1_0.html
+package backchannel
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"login-provider/internal/config"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event describes a logout
+type Event struct {
+	Subject   string
+	SessionID string
+}
+
+// Listener is notified about logouts to clear local state, like logins still waiting for a second factor
+type Listener func(ctx context.Context, event *Event)
+
+type Notifier struct {
+	mutex     sync.RWMutex
+	settings  *settings
+	listeners []Listener
+	now       func() time.Time
+}
+
+// settings are the configurable parts of the notifier
+type settings struct {
+	key      *rsa.PrivateKey
+	keyId    string
+	issuer   string
+	webhooks []config.Webhook
+	client   *http.Client
+}
+
+// NewNotifier creates a notifier for the given logout configuration. Webhooks are only notified
+// if a signing key is configured.
+func NewNotifier(conf *config.LogoutConfig) (*Notifier, error) {
+	s, err := newSettings(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notifier{settings: s, now: time.Now}, nil
+}
+
+// Reconfigure loads the signing key and webhooks of the given configuration. These replace the
+// current ones when the returned function is called. Subscribed listeners are kept.
+func (n *Notifier) Reconfigure(conf config.Configuration) (func(), error) {
+	s, err := newSettings(conf.LogoutConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		n.mutex.Lock()
+		defer n.mutex.Unlock()
+		n.settings = s
+	}, nil
+}
+
+func newSettings(conf *config.LogoutConfig) (*settings, error) {
+	s := &settings{
+		issuer: conf.Issuer,
+		client: &http.Client{Timeout: conf.BackChannel.Timeout},
+	}
+
+	if len(conf.BackChannel.SigningKeyFile) == 0 {
+		if len(conf.BackChannel.Webhooks) != 0 {
+			log.Warn().Msg("No signing key configured for back-channel logout. Webhooks will not be notified")
+		}
+		return s, nil
+	}
+
+	key, err := loadKey(conf.BackChannel.SigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	s.key = key
+	s.keyId = conf.BackChannel.KeyId
+	s.webhooks = conf.BackChannel.Webhooks
+	return s, nil
+}
+
+// Subscribe registers a listener, which is notified about each logout
+func (n *Notifier) Subscribe(listener Listener) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.listeners = append(n.listeners, listener)
+}
+
+// Notify informs the listeners and webhooks about the given logout. Failures are logged only, as
+// the user is logged out anyway. Notify returns after all webhooks responded or timed out.
+func (n *Notifier) Notify(ctx context.Context, event *Event) {
+	if n == nil {
+		return
+	}
+
+	n.mutex.RLock()
+	listeners := n.listeners
+	s := n.settings
+	n.mutex.RUnlock()
+
+	for _, listener := range listeners {
+		listener(ctx, event)
+	}
+
+	var wg sync.WaitGroup
+	for _, webhook := range s.webhooks {
+		wg.Add(1)
+		go func(webhook config.Webhook) {
+			defer wg.Done()
+			n.notifyWebhook(ctx, s, webhook, event)
+		}(webhook)
+	}
+	wg.Wait()
+}
+
+func (n *Notifier) notifyWebhook(ctx context.Context, s *settings, webhook config.Webhook, event *Event) {
+	logger := log.Ctx(ctx).With().Str("_webhook", webhook.Url).Logger()
+
+	token, err := n.logoutToken(s, webhook.Audience, event)
+	if err != nil {
+		logger.Err(err).Msg("Failed to create logout token")
+		return
+	}
+
+	form := url.Values{"logout_token": {token}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, strings.NewReader(form.Encode()))
+	if err != nil {
+		logger.Err(err).Msg("Failed to create back-channel logout request")
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Back-channel logout notification failed")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		logger.Warn().Int("_status", resp.StatusCode).Msg("Back-channel logout notification rejected")
+		return
+	}
+	logger.Debug().Msg("Back-channel logout notification sent")
+}
+
+func loadKey(file string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read back-channel logout signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("back-channel logout signing key is not pem encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse back-channel logout signing key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("back-channel logout signing key is not an RSA key")
+	}
+	return rsaKey, nil
+}
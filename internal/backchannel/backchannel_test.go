@@ -0,0 +1,179 @@
+package backchannel
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"login-provider/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receiver struct {
+	*httptest.Server
+	mutex  sync.Mutex
+	tokens []string
+}
+
+func newReceiver() *receiver {
+	r := &receiver{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.tokens = append(r.tokens, req.PostFormValue("logout_token"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	return r
+}
+
+func (r *receiver) received() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.tokens
+}
+
+func writeKey(t *testing.T) (*rsa.PrivateKey, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, ioutil.WriteFile(file, data, 0600))
+	return key, file
+}
+
+func logoutConfig(keyFile string, webhooks ...config.Webhook) *config.LogoutConfig {
+	return &config.LogoutConfig{
+		Issuer: "https://hydra.example.com/",
+		BackChannel: &config.BackChannelConfig{
+			SigningKeyFile: keyFile,
+			KeyId:          "logout-1",
+			Timeout:        time.Second,
+			Webhooks:       webhooks,
+		},
+	}
+}
+
+func verify(t *testing.T, key *rsa.PublicKey, token string) map[string]interface{} {
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	require.NoError(t, rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature))
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	claims := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	return claims
+}
+
+func TestNotifySendsSignedLogoutTokensToWebhooks(t *testing.T) {
+	// GIVEN
+	key, keyFile := writeKey(t)
+	first := newReceiver()
+	defer first.Close()
+	second := newReceiver()
+	defer second.Close()
+
+	notifier, err := NewNotifier(logoutConfig(keyFile,
+		config.Webhook{Url: first.URL, Audience: "first"},
+		config.Webhook{Url: second.URL, Audience: "second"}))
+	require.NoError(t, err)
+
+	// WHEN
+	notifier.Notify(context.Background(), &Event{Subject: "1", SessionID: "baz"})
+
+	// THEN
+	require.Len(t, first.received(), 1)
+	require.Len(t, second.received(), 1)
+
+	claims := verify(t, &key.PublicKey, first.received()[0])
+	assert.Equal(t, "https://hydra.example.com/", claims["iss"])
+	assert.Equal(t, "first", claims["aud"])
+	assert.Equal(t, "1", claims["sub"])
+	assert.Equal(t, "baz", claims["sid"])
+	assert.Contains(t, claims["events"], logoutEvent)
+	assert.NotEmpty(t, claims["jti"])
+	assert.Equal(t, claims["iat"].(float64)+logoutTokenTtl.Seconds(), claims["exp"])
+	assert.NotContains(t, claims, "nonce")
+
+	assert.Equal(t, "second", verify(t, &key.PublicKey, second.received()[0])["aud"])
+}
+
+func TestNotifyInformsListeners(t *testing.T) {
+	// GIVEN
+	notifier, err := NewNotifier(logoutConfig(""))
+	require.NoError(t, err)
+
+	var received *Event
+	notifier.Subscribe(func(ctx context.Context, event *Event) {
+		received = event
+	})
+
+	// WHEN
+	notifier.Notify(context.Background(), &Event{Subject: "1"})
+
+	// THEN
+	require.NotNil(t, received)
+	assert.Equal(t, "1", received.Subject)
+}
+
+func TestNotifySkipsWebhooksWithoutSigningKey(t *testing.T) {
+	// GIVEN
+	webhook := newReceiver()
+	defer webhook.Close()
+	notifier, err := NewNotifier(logoutConfig("", config.Webhook{Url: webhook.URL}))
+	require.NoError(t, err)
+
+	// WHEN
+	notifier.Notify(context.Background(), &Event{Subject: "1"})
+
+	// THEN
+	assert.Empty(t, webhook.received())
+	assert.Empty(t, notifier.Keys().Keys)
+}
+
+func TestNewNotifierFailsForInvalidKey(t *testing.T) {
+	// GIVEN
+	file := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, ioutil.WriteFile(file, []byte("no key"), 0600))
+
+	// WHEN
+	_, err := NewNotifier(logoutConfig(file))
+
+	// THEN
+	assert.Error(t, err)
+}
+
+func TestKeysPublishesPublicKey(t *testing.T) {
+	// GIVEN
+	key, keyFile := writeKey(t)
+	notifier, err := NewNotifier(logoutConfig(keyFile))
+	require.NoError(t, err)
+
+	// WHEN
+	keys := notifier.Keys()
+
+	// THEN
+	require.Len(t, keys.Keys, 1)
+	assert.Equal(t, "logout-1", keys.Keys[0].Kid)
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()), keys.Keys[0].N)
+	assert.Equal(t, "AQAB", keys.Keys[0].E)
+}
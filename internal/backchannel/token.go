@@ -0,0 +1,114 @@
+package backchannel
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"time"
+)
+
+// logoutEvent is the member of the events claim identifying logout tokens
+const logoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// logoutTokenTtl limits how long receivers accept a logout token. It only has to cover the delivery.
+const logoutTokenTtl = 2 * time.Minute
+
+// JSONWebKey is the public part of the signing key
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// Keys returns the key set receivers verify the logout tokens with
+func (n *Notifier) Keys() *JSONWebKeySet {
+	keys := &JSONWebKeySet{Keys: []JSONWebKey{}}
+	if n == nil {
+		return keys
+	}
+
+	n.mutex.RLock()
+	s := n.settings
+	n.mutex.RUnlock()
+	if s.key == nil {
+		return keys
+	}
+
+	public := s.key.PublicKey
+	keys.Keys = append(keys.Keys, JSONWebKey{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: s.keyId,
+		N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+	})
+	return keys
+}
+
+// logoutToken creates a logout token for the given event signed with RS256
+func (n *Notifier) logoutToken(s *settings, audience string, event *Event) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := n.now()
+	header := map[string]string{"alg": "RS256", "typ": "logout+jwt"}
+	if len(s.keyId) != 0 {
+		header["kid"] = s.keyId
+	}
+
+	claims := map[string]interface{}{
+		"iss":    s.issuer,
+		"aud":    audience,
+		"iat":    now.Unix(),
+		"exp":    now.Add(logoutTokenTtl).Unix(),
+		"jti":    hex.EncodeToString(jti),
+		"events": map[string]interface{}{logoutEvent: map[string]interface{}{}},
+	}
+	if len(event.Subject) != 0 {
+		claims["sub"] = event.Subject
+	}
+	if len(event.SessionID) != 0 {
+		claims["sid"] = event.SessionID
+	}
+
+	encodedHeader, err := encodeSegment(header)
+	if err != nil {
+		return "", err
+	}
+	encodedClaims, err := encodeSegment(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encodedHeader + "." + encodedClaims
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func encodeSegment(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,273 @@
+// Package cert_manager serves the TLS certificates of the login provider. Certificates are loaded
+// again whenever their files change, so rotated certificates are used without a restart.
+package cert_manager
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/config"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// debounce is the time to wait for further changes of the certificate files before loading them.
+// Key and certificate are usually replaced one after the other.
+const debounce = 500 * time.Millisecond
+
+// expiryWarning is the period before the expiry of a certificate, its use is logged as warning
+const expiryWarning = 30 * 24 * time.Hour
+
+// CertificateInfo describes a served certificate
+type CertificateInfo struct {
+	Subject  string    `json:"subject"`
+	DnsNames []string  `json:"dns_names,omitempty"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// Expired tells whether the certificate is not valid anymore at the given time
+func (ci *CertificateInfo) Expired(now time.Time) bool {
+	return now.After(ci.NotAfter)
+}
+
+type Manager struct {
+	mutex    sync.RWMutex
+	settings *settings
+	// reconfigured notifies Watch about changed certificate files
+	reconfigured chan struct{}
+}
+
+// settings hold the loaded certificates together with the configuration they were loaded from
+type settings struct {
+	conf *config.TlsConfig
+	// fallback is served to clients not asking for the name of one of the additional certificates
+	fallback     *tls.Certificate
+	certificates []*tls.Certificate
+	tlsConfig    *tls.Config
+}
+
+// NewManager loads the certificates of the given TLS configuration
+func NewManager(conf *config.TlsConfig) (*Manager, error) {
+	s, err := newSettings(conf)
+	if err != nil {
+		return nil, err
+	}
+	s.log()
+
+	return &Manager{settings: s, reconfigured: make(chan struct{}, 1)}, nil
+}
+
+// Reconfigure loads the certificates and TLS settings of the given configuration. These are
+// used for new connections when the returned function is called.
+func (m *Manager) Reconfigure(conf config.Configuration) (func(), error) {
+	tlsConfig, err := conf.TlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w. Switching from HTTPS to HTTP requires a restart", err)
+	}
+
+	s, err := newSettings(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		m.mutex.Lock()
+		m.settings = s
+		m.mutex.Unlock()
+
+		s.log()
+		select {
+		case m.reconfigured <- struct{}{}:
+		default:
+		}
+	}, nil
+}
+
+// TLSConfig returns the configuration to be used by the server. It always refers to the
+// currently loaded certificates and settings.
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: m.current().tlsConfig.MinVersion,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return m.current().tlsConfig, nil
+		},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return m.current().certificate(hello)
+		},
+	}
+}
+
+// Certificates describes the served certificates, the default one first
+func (m *Manager) Certificates() []CertificateInfo {
+	if m == nil {
+		return nil
+	}
+
+	s := m.current()
+	infos := []CertificateInfo{describe(s.fallback)}
+	for _, certificate := range s.certificates {
+		infos = append(infos, describe(certificate))
+	}
+	return infos
+}
+
+// Watch loads the certificates again whenever their files change until the given context is done.
+// The active certificates are kept if the changed ones can't be loaded.
+func (m *Manager) Watch(ctx context.Context) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to watch the TLS certificates. Rotated certificates require a restart")
+		return
+	}
+	defer watcher.Close()
+
+	// directories are watched to detect files replaced by cert-manager or config maps
+	files := m.watch(watcher)
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.reconfigured:
+			files = m.watch(watcher)
+		case event := <-watcher.Events:
+			if files[filepath.Clean(event.Name)] || filepath.Base(event.Name) == "..data" {
+				timer = time.After(debounce)
+			}
+		case err := <-watcher.Errors:
+			log.Warn().Err(err).Msg("Failed to watch the TLS certificates")
+		case <-timer:
+			timer = nil
+			m.reload()
+		}
+	}
+}
+
+func (m *Manager) current() *settings {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.settings
+}
+
+// watch adds the directories of the current certificate files to the watcher and returns the files
+func (m *Manager) watch(watcher *fsnotify.Watcher) map[string]bool {
+	conf := m.current().conf
+	paths := []string{conf.KeyFile, conf.CertFile}
+	for _, certificate := range conf.Certificates {
+		paths = append(paths, certificate.KeyFile, certificate.CertFile)
+	}
+
+	files := make(map[string]bool)
+	for _, path := range paths {
+		files[filepath.Clean(path)] = true
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			log.Warn().Err(err).Str("_file", path).Msg("Failed to watch the TLS certificate")
+		}
+	}
+	return files
+}
+
+func (m *Manager) reload() {
+	m.mutex.Lock()
+	s, err := newSettings(m.settings.conf)
+	if err != nil {
+		m.mutex.Unlock()
+		l := log.With().Err(err).Logger()
+		l.Error().Msg("Failed to load the changed TLS certificates. Keeping the active ones")
+		return
+	}
+	m.settings = s
+	m.mutex.Unlock()
+
+	log.Info().Msg("TLS certificates reloaded")
+	s.log()
+}
+
+func newSettings(conf *config.TlsConfig) (*settings, error) {
+	version, err := conf.Version()
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := conf.CipherSuiteIds()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &settings{conf: conf}
+	if s.fallback, err = loadCertificate(conf.CertFile, conf.KeyFile); err != nil {
+		return nil, err
+	}
+	for _, c := range conf.Certificates {
+		certificate, err := loadCertificate(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		s.certificates = append(s.certificates, certificate)
+	}
+
+	s.tlsConfig = &tls.Config{
+		MinVersion:     version,
+		CipherSuites:   cipherSuites,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: s.certificate,
+	}
+	return s, nil
+}
+
+// certificate selects the certificate for the name the client asked for
+func (s *settings) certificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if len(hello.ServerName) != 0 {
+		for _, certificate := range s.certificates {
+			if certificate.Leaf.VerifyHostname(hello.ServerName) == nil {
+				return certificate, nil
+			}
+		}
+	}
+	return s.fallback, nil
+}
+
+func (s *settings) log() {
+	now := time.Now()
+	for _, certificate := range append([]*tls.Certificate{s.fallback}, s.certificates...) {
+		info := describe(certificate)
+		l := log.With().Str("_subject", info.Subject).Strs("_dns_names", info.DnsNames).
+			Time("_not_after", info.NotAfter).Logger()
+		switch {
+		case info.Expired(now):
+			l.Error().Msg("TLS certificate expired")
+		case info.NotAfter.Sub(now) < expiryWarning:
+			l.Warn().Msg("TLS certificate expires soon")
+		default:
+			l.Debug().Msg("Serving TLS certificate")
+		}
+	}
+}
+
+func loadCertificate(certFile, keyFile string) (*tls.Certificate, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate %s: %w", certFile, err)
+	}
+	if len(certificate.Certificate) == 0 {
+		return nil, errors.New("no TLS certificate found in " + certFile)
+	}
+
+	if certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("failed to parse TLS certificate %s: %w", certFile, err)
+	}
+	return &certificate, nil
+}
+
+func describe(certificate *tls.Certificate) CertificateInfo {
+	return CertificateInfo{
+		Subject:  certificate.Leaf.Subject.String(),
+		DnsNames: certificate.Leaf.DNSNames,
+		NotAfter: certificate.Leaf.NotAfter,
+	}
+}
@@ -0,0 +1,180 @@
+package cert_manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"login-provider/internal/config"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate writes a self signed certificate for the given name and returns the cert and key file
+func writeCertificate(t *testing.T, dir, name string, notAfter time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+// serve accepts TLS connections with the configuration of the manager until the test ends
+func serve(t *testing.T, m *Manager) string {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", m.TLSConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// servedName connects to the given address asking for the given name and returns the name of the served certificate
+func servedName(t *testing.T, addr, serverName string, maxVersion uint16) (string, error) {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, InsecureSkipVerify: true, MaxVersion: maxVersion})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestManagerSelectsCertificateByServerName(t *testing.T) {
+	// GIVEN
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "login.example.com", time.Now().Add(time.Hour))
+	apiCertFile, apiKeyFile := writeCertificate(t, dir, "api.example.com", time.Now().Add(time.Hour))
+
+	m, err := NewManager(&config.TlsConfig{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		MinVersion:   "1.2",
+		Certificates: []config.Certificate{{CertFile: apiCertFile, KeyFile: apiKeyFile}},
+	})
+	require.NoError(t, err)
+	addr := serve(t, m)
+
+	// WHEN
+	api, err1 := servedName(t, addr, "api.example.com", 0)
+	other, err2 := servedName(t, addr, "other.example.com", 0)
+
+	// THEN
+	require.NoError(t, err1)
+	require.NoError(t, err2)
+	assert.Equal(t, "api.example.com", api)
+	assert.Equal(t, "login.example.com", other)
+}
+
+func TestManagerRejectsOutdatedTlsVersions(t *testing.T) {
+	// GIVEN
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "login.example.com", time.Now().Add(time.Hour))
+
+	m, err := NewManager(&config.TlsConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3"})
+	require.NoError(t, err)
+	addr := serve(t, m)
+
+	// WHEN
+	_, err = servedName(t, addr, "login.example.com", tls.VersionTLS12)
+
+	// THEN
+	assert.Error(t, err)
+}
+
+func TestNewManagerFailsForInvalidSettings(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "login.example.com", time.Now().Add(time.Hour))
+
+	for _, conf := range []*config.TlsConfig{
+		{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.4"},
+		{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{CertFile: certFile, KeyFile: certFile, MinVersion: "1.2"},
+	} {
+		// WHEN
+		_, err := NewManager(conf)
+
+		// THEN
+		assert.Error(t, err)
+	}
+}
+
+func TestWatchReloadsChangedCertificates(t *testing.T) {
+	// GIVEN
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "login.example.com", time.Now().Add(time.Hour))
+
+	m, err := NewManager(&config.TlsConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2"})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Watch(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// WHEN
+	renewed := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	writeCertificate(t, dir, "login.example.com", renewed)
+
+	// THEN
+	assert.Eventually(t, func() bool {
+		return m.Certificates()[0].NotAfter.Equal(renewed)
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestCertificatesReportsExpiry(t *testing.T) {
+	// GIVEN
+	dir := t.TempDir()
+	expiry := time.Now().Add(-time.Minute).Truncate(time.Second)
+	certFile, keyFile := writeCertificate(t, dir, "login.example.com", expiry)
+
+	m, err := NewManager(&config.TlsConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2"})
+	require.NoError(t, err)
+
+	// WHEN
+	certificates := m.Certificates()
+
+	// THEN
+	require.Len(t, certificates, 1)
+	assert.Equal(t, "CN=login.example.com", certificates[0].Subject)
+	assert.Equal(t, []string{"login.example.com"}, certificates[0].DnsNames)
+	assert.True(t, certificates[0].NotAfter.Equal(expiry))
+	assert.True(t, certificates[0].Expired(time.Now()))
+}
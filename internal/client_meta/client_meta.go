@@ -1,14 +1,29 @@
 package client_meta
 
 import (
+	"errors"
 	"github.com/mitchellh/mapstructure"
+	"login-provider/internal/i18n"
 	"login-provider/internal/utils"
+	"strings"
 )
 
+// ErrScopeNotRequested is returned if a scope to be granted has not been requested by the client
+var ErrScopeNotRequested = errors.New("scope not requested")
+
+// ErrAudienceNotRequested is returned if an audience to be granted has not been requested by the client
+var ErrAudienceNotRequested = errors.New("audience not requested")
+
 type ScopeInfo struct {
-	Scope       string
-	Mandatory   bool
-	Description string
+	Scope       string `json:"scope"`
+	Mandatory   bool   `json:"mandatory"`
+	Description string `json:"description"`
+}
+
+type AudienceInfo struct {
+	Audience    string `json:"audience"`
+	Mandatory   bool   `json:"mandatory"`
+	Description string `json:"description"`
 }
 
 type DataAccessArea struct {
@@ -16,32 +31,162 @@ type DataAccessArea struct {
 	ScopeInfos  []ScopeInfo
 }
 
+// ClaimFilter restricts the values of multi valued claims, like groups or roles, a client
+// is allowed to see. A value passes the filter if it is either listed in Allowed or starts
+// with one of the configured Prefixes. An empty filter lets all values pass.
+type ClaimFilter struct {
+	Prefixes []string `json:"prefixes" mapstructure:"prefixes"`
+	Allowed  []string `json:"allowed" mapstructure:"allowed"`
+}
+
+// Descriptions maps scopes, respectively audiences to their human readable descriptions. A value is
+// either the description itself or, keyed by a locale, a map of localized descriptions, like
+// "de": {"email": "Ihre E-Mail-Adresse"}.
+type Descriptions map[string]interface{}
+
+// ConsentPolicy holds the client specific consent policy settings, which extend, respectively
+// override the globally configured consent policy
+type ConsentPolicy struct {
+	// Trusted marks the client as first party client
+	Trusted bool `json:"trusted" mapstructure:"trusted"`
+	// SkipScopes lists the scopes, which do not require consent for this client
+	SkipScopes []string `json:"skip_scopes" mapstructure:"skip_scopes"`
+	// MaxAge is the period of time in seconds after which the user has to consent again
+	MaxAge int64 `json:"max_age" mapstructure:"max_age"`
+}
+
 type ClientMetaInfo struct {
-	AskConsent        bool              `json:"ask_consent" mapstructure:"ask_consent"`
-	MandatoryScopes   []string          `json:"mandatory_scopes" mapstructure:"mandatory_scopes"`
-	ScopeDescriptions map[string]string `json:"scope_descriptions" mapstructure:"scope_descriptions"`
+	AskConsent        bool         `json:"ask_consent" mapstructure:"ask_consent"`
+	MandatoryScopes   []string     `json:"mandatory_scopes" mapstructure:"mandatory_scopes"`
+	ScopeDescriptions Descriptions `json:"scope_descriptions" mapstructure:"scope_descriptions"`
+	GroupsFilter      ClaimFilter  `json:"groups_filter" mapstructure:"groups_filter"`
+	RolesFilter       ClaimFilter  `json:"roles_filter" mapstructure:"roles_filter"`
+	// MandatoryAudiences lists the audiences, the user cannot deselect on the consent page
+	MandatoryAudiences   []string      `json:"mandatory_audiences" mapstructure:"mandatory_audiences"`
+	AudienceDescriptions Descriptions  `json:"audience_descriptions" mapstructure:"audience_descriptions"`
+	ConsentPolicy        ConsentPolicy `json:"consent_policy" mapstructure:"consent_policy"`
+	// Theme names the theme to render the pages for this client in
+	Theme string `json:"theme" mapstructure:"theme"`
+	// ConfirmLogout enforces asking the user before logging out, even if the logout has been
+	// initiated by the client
+	ConfirmLogout bool `json:"confirm_logout" mapstructure:"confirm_logout"`
+}
+
+func (f ClaimFilter) Apply(values []string) []string {
+	if len(f.Prefixes) == 0 && len(f.Allowed) == 0 {
+		return values
+	}
+
+	filtered := []string{}
+	for _, value := range values {
+		if utils.Contains(f.Allowed, value) || f.hasPrefix(value) {
+			filtered = append(filtered, value)
+		}
+	}
+	return filtered
+}
+
+func (f ClaimFilter) hasPrefix(value string) bool {
+	for _, prefix := range f.Prefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
 func (cmi *ClientMetaInfo) Unmarshal(data interface{}) error {
 	return mapstructure.Decode(data, cmi)
 }
 
-func (cmi *ClientMetaInfo) CreateScopeInfos(scopes []string) []ScopeInfo {
+// Lookup returns the description for the given key in the given locale. If there is no localized
+// description, the non localized one is returned. If there is none at all, the result is empty.
+func (d Descriptions) Lookup(key, locale string) string {
+	if localized, ok := d[locale].(map[string]interface{}); ok {
+		if description, ok := localized[key].(string); ok {
+			return description
+		}
+	}
+	if description, ok := d[key].(string); ok {
+		return description
+	}
+	return ""
+}
+
+func (cmi *ClientMetaInfo) CreateScopeInfos(scopes []string, locale string) []ScopeInfo {
 	var scopeInfos []ScopeInfo
 	for _, scope := range scopes {
-		if utils.Contains(cmi.MandatoryScopes, scope) {
-			scopeInfos = append(scopeInfos, ScopeInfo{
-				Scope:       scope,
-				Mandatory:   true,
-				Description: cmi.ScopeDescriptions[scope],
-			})
-		} else {
-			scopeInfos = append(scopeInfos, ScopeInfo{
-				Scope:       scope,
-				Mandatory:   false,
-				Description: cmi.ScopeDescriptions[scope],
-			})
-		}
+		scopeInfos = append(scopeInfos, ScopeInfo{
+			Scope:       scope,
+			Mandatory:   utils.Contains(cmi.MandatoryScopes, scope),
+			Description: cmi.scopeDescription(scope, locale),
+		})
 	}
 	return scopeInfos
 }
+
+// scopeDescription falls back to the default descriptions of well known scopes, respectively to
+// the scope itself, if the client does not describe the given scope
+func (cmi *ClientMetaInfo) scopeDescription(scope, locale string) string {
+	if description := cmi.ScopeDescriptions.Lookup(scope, locale); len(description) != 0 {
+		return description
+	}
+	if _, ok := i18n.Lookup(i18n.DefaultLocale, "scope."+scope); ok {
+		return i18n.Translate(locale, "scope."+scope)
+	}
+	return scope
+}
+
+// GrantScopes computes the scopes to grant from the requested ones and those selected by
+// the user. Mandatory scopes are always granted if requested. The result does not contain
+// duplicates. If the selection contains a scope, which has not been requested, the selection
+// has been tampered with and ErrScopeNotRequested is returned.
+func (cmi *ClientMetaInfo) GrantScopes(requested, selected []string) ([]string, error) {
+	for _, scope := range selected {
+		if !utils.Contains(requested, scope) {
+			return nil, ErrScopeNotRequested
+		}
+	}
+
+	granted := []string{}
+	for _, scope := range requested {
+		if (utils.Contains(selected, scope) || utils.Contains(cmi.MandatoryScopes, scope)) &&
+			!utils.Contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return granted, nil
+}
+
+func (cmi *ClientMetaInfo) CreateAudienceInfos(audiences []string, locale string) []AudienceInfo {
+	var audienceInfos []AudienceInfo
+	for _, audience := range audiences {
+		audienceInfos = append(audienceInfos, AudienceInfo{
+			Audience:    audience,
+			Mandatory:   utils.Contains(cmi.MandatoryAudiences, audience),
+			Description: cmi.AudienceDescriptions.Lookup(audience, locale),
+		})
+	}
+	return audienceInfos
+}
+
+// GrantAudiences computes the audiences to grant from the requested ones and those selected
+// by the user. Mandatory audiences are always granted if requested. If an audience has been
+// selected, which has not been requested, the submission has been tampered with and
+// ErrAudienceNotRequested is returned.
+func (cmi *ClientMetaInfo) GrantAudiences(requested, selected []string) ([]string, error) {
+	for _, audience := range selected {
+		if !utils.Contains(requested, audience) {
+			return nil, ErrAudienceNotRequested
+		}
+	}
+
+	granted := []string{}
+	for _, audience := range requested {
+		if (utils.Contains(selected, audience) || utils.Contains(cmi.MandatoryAudiences, audience)) &&
+			!utils.Contains(granted, audience) {
+			granted = append(granted, audience)
+		}
+	}
+	return granted, nil
+}
@@ -0,0 +1,147 @@
+package client_meta
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEmptyClaimFilterLetsAllValuesPass(t *testing.T) {
+	// GIVEN
+	filter := ClaimFilter{}
+	groups := []string{"internal-admins", "app-users"}
+
+	// WHEN
+	filtered := filter.Apply(groups)
+
+	// THEN
+	assert.Equal(t, groups, filtered)
+}
+
+func TestClaimFilterAppliesPrefixesAndAllowList(t *testing.T) {
+	// GIVEN
+	filter := ClaimFilter{
+		Prefixes: []string{"app-"},
+		Allowed:  []string{"employees"},
+	}
+	groups := []string{"internal-admins", "app-users", "employees", "app-admins"}
+
+	// WHEN
+	filtered := filter.Apply(groups)
+
+	// THEN
+	assert.Equal(t, []string{"app-users", "employees", "app-admins"}, filtered)
+}
+
+func TestClaimFilterIsDecodedFromClientMetadata(t *testing.T) {
+	// GIVEN
+	metadata := map[string]interface{}{
+		"groups_filter": map[string]interface{}{
+			"prefixes": []interface{}{"app-"},
+		},
+		"roles_filter": map[string]interface{}{
+			"allowed": []interface{}{"reader"},
+		},
+	}
+	cmi := &ClientMetaInfo{}
+
+	// WHEN
+	err := cmi.Unmarshal(metadata)
+
+	// THEN
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app-"}, cmi.GroupsFilter.Prefixes)
+	assert.Equal(t, []string{"reader"}, cmi.RolesFilter.Allowed)
+	assert.Equal(t, []string{"reader"}, cmi.RolesFilter.Apply([]string{"writer", "reader"}))
+}
+
+func TestCreateAudienceInfosMarksMandatoryAudiences(t *testing.T) {
+	// GIVEN
+	cmi := &ClientMetaInfo{
+		MandatoryAudiences:   []string{"https://api.example.com"},
+		AudienceDescriptions: Descriptions{"https://api.example.com": "Example API"},
+	}
+
+	// WHEN
+	infos := cmi.CreateAudienceInfos([]string{"https://api.example.com", "https://other.example.com"}, "en")
+
+	// THEN
+	assert.Equal(t, []AudienceInfo{
+		{Audience: "https://api.example.com", Mandatory: true, Description: "Example API"},
+		{Audience: "https://other.example.com", Mandatory: false},
+	}, infos)
+}
+
+func TestGrantAudiencesGrantsOnlyRequestedAudiences(t *testing.T) {
+	// GIVEN
+	cmi := &ClientMetaInfo{MandatoryAudiences: []string{"mandatory", "not-requested-mandatory"}}
+	requested := []string{"mandatory", "optional", "deselected"}
+	selected := []string{"optional", "optional"}
+
+	// WHEN
+	granted, err := cmi.GrantAudiences(requested, selected)
+
+	// THEN
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mandatory", "optional"}, granted)
+}
+
+func TestGrantAudiencesFailsForAudiencesWhichHaveNotBeenRequested(t *testing.T) {
+	// GIVEN
+	cmi := &ClientMetaInfo{}
+
+	// WHEN
+	granted, err := cmi.GrantAudiences([]string{"https://api.example.com"}, []string{"https://api.example.com", "https://admin.example.com"})
+
+	// THEN
+	assert.Equal(t, ErrAudienceNotRequested, err)
+	assert.Nil(t, granted)
+}
+
+func TestGrantScopesFailsForScopesWhichHaveNotBeenRequested(t *testing.T) {
+	// GIVEN
+	cmi := &ClientMetaInfo{}
+
+	// WHEN
+	granted, err := cmi.GrantScopes([]string{"openid", "email"}, []string{"email", "admin"})
+
+	// THEN
+	assert.Equal(t, ErrScopeNotRequested, err)
+	assert.Nil(t, granted)
+}
+
+func TestGrantScopesAddsRequestedMandatoryScopesWithoutDuplicates(t *testing.T) {
+	// GIVEN
+	cmi := &ClientMetaInfo{MandatoryScopes: []string{"openid", "offline"}}
+
+	// WHEN
+	granted, err := cmi.GrantScopes([]string{"openid", "email", "profile"}, []string{"email", "openid", "email"})
+
+	// THEN
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"openid", "email"}, granted)
+}
+
+func TestScopeDescriptionsAreLocalized(t *testing.T) {
+	// GIVEN
+	metadata := map[string]interface{}{
+		"scope_descriptions": map[string]interface{}{
+			"orders": "Your orders",
+			"de": map[string]interface{}{
+				"orders": "Ihre Bestellungen",
+			},
+		},
+	}
+	cmi := &ClientMetaInfo{}
+	assert.NoError(t, cmi.Unmarshal(metadata))
+
+	// WHEN
+	german := cmi.CreateScopeInfos([]string{"orders", "email", "custom"}, "de")
+	english := cmi.CreateScopeInfos([]string{"orders", "email", "custom"}, "en")
+
+	// THEN
+	assert.Equal(t, "Ihre Bestellungen", german[0].Description)
+	assert.Equal(t, "Ihre E-Mail-Adresse", german[1].Description, "Well known scopes must have default descriptions")
+	assert.Equal(t, "custom", german[2].Description, "Scope itself must be used if there is no description")
+	assert.Equal(t, "Your orders", english[0].Description)
+	assert.Equal(t, "Your email address", english[1].Description)
+}
@@ -1,12 +1,19 @@
 package config
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
+	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -14,64 +21,472 @@ const (
 	registerUrl     = "register_url"
 	authenticateUrl = "authenticate_url"
 	hydraAdminUrl   = "hydra_admin_url"
+	hydraApiVersion = "hydra_api_version"
+	publicUrl       = "public_url"
+	trustedProxies  = "trusted_proxies"
 	rootHomeUrl = "root_home_url"
 
 	tlsKeyFile = "tls.key"
 	tlsCertFile = "tls.cert"
 	tlsTrustStoreFile = "tls.trust_store"
+	tlsMinVersion = "tls.min_version"
+	tlsCipherSuites = "tls.cipher_suites"
+	tlsCertificates = "tls.certificates"
+	tlsClients = "tls.clients"
+
+	upstreamAuth = "auth"
+
+	resilience = "resilience"
 
 	logLevel = "log.level"
 
+	claimsGroupsScope     = "claims.groups_scope"
+	claimsRolesScope      = "claims.roles_scope"
+	claimsAttributesScope = "claims.attributes_scope"
+
+	consentTrustedClients = "consent.trusted_clients"
+	consentSkipScopes     = "consent.skip_scopes"
+	consentMaxAge         = "consent.max_age"
+
+	logoutDefaultRedirectUrl    = "logout.default_redirect_url"
+	logoutAutoAcceptRpInitiated = "logout.auto_accept_rp_initiated"
+	logoutIssuer                = "logout.issuer"
+	logoutFrontChannel          = "logout.front_channel"
+	logoutBackChannelSigningKey = "logout.back_channel.signing_key"
+	logoutBackChannelKeyId      = "logout.back_channel.key_id"
+	logoutBackChannelTimeout    = "logout.back_channel.timeout"
+	logoutBackChannelWebhooks   = "logout.back_channel.webhooks"
+
+	accountsCookieSecret = "accounts.cookie_secret"
+	accountsAlwaysChoose = "accounts.always_choose"
+	accountsMax          = "accounts.max"
+	accountsMaxAge       = "accounts.max_age"
+
+	usersStore = "users.store"
+	usersFile  = "users.file"
+
+	registrationEnabled         = "registration.enabled"
+	registrationFields          = "registration.fields"
+	registrationVerificationTtl = "registration.verification_ttl"
+
+	passwordResetEnabled       = "password_reset.enabled"
+	passwordResetTokenTtl      = "password_reset.token_ttl"
+	passwordResetMaxPerAddress = "password_reset.max_per_address"
+	passwordResetAddressWindow = "password_reset.address_window"
+
+	passwordlessEnabled       = "passwordless.enabled"
+	passwordlessTtl           = "passwordless.ttl"
+	passwordlessMaxAttempts   = "passwordless.max_attempts"
+	passwordlessMaxPerAddress = "passwordless.max_per_address"
+	passwordlessAddressWindow = "passwordless.address_window"
+
+	passwordPolicyMinLength         = "password_policy.min_length"
+	passwordPolicyRequireLower      = "password_policy.require_lower"
+	passwordPolicyRequireUpper      = "password_policy.require_upper"
+	passwordPolicyRequireDigit      = "password_policy.require_digit"
+	passwordPolicyRequireSymbol     = "password_policy.require_symbol"
+	passwordPolicyDeniedWords       = "password_policy.denied_words"
+	passwordPolicyBreachedDirectory = "password_policy.breached_directory"
+	passwordPolicyCheckOnLogin      = "password_policy.check_on_login"
+	passwordPolicyRequireChange     = "password_policy.require_change"
+
+	mailFrom         = "mail.from"
+	mailSmtpHost     = "mail.smtp.host"
+	mailSmtpPort     = "mail.smtp.port"
+	mailSmtpUsername = "mail.smtp.username"
+	mailSmtpPassword = "mail.smtp.password"
+
+	smsGateway      = "sms.gateway"
+	smsWebhookUrl   = "sms.webhook_url"
+	smsFile         = "sms.file"
+	smsCodeTtl      = "sms.code_ttl"
+	smsMaxPerNumber = "sms.max_per_number"
+	smsNumberWindow = "sms.number_window"
+	smsMaxAttempts  = "sms.max_attempts"
+	smsSecondFactor = "sms.second_factor"
+	smsVerifyPhone  = "sms.verify_phone"
+
+	deviceMaxAttempts   = "device.max_attempts"
+	deviceAttemptWindow = "device.attempt_window"
+
+	auditFile          = "audit.file"
+	auditMaxSize       = "audit.max_size"
+	auditMaxBackups    = "audit.max_backups"
+	auditSyslog        = "audit.syslog.enabled"
+	auditSyslogNetwork = "audit.syslog.network"
+	auditSyslogAddress = "audit.syslog.address"
+	auditSyslogTag     = "audit.syslog.tag"
+	auditWebhookUrl    = "audit.webhook_url"
+	auditHmacKey       = "audit.hmac_key"
+
+	templatesDirectory = "templates.directory"
+	staticDirectory    = "static.directory"
+	themes             = "themes"
+	clientThemes       = "client_themes"
+
 	host = "host"
 	port = "port"
+
+	metricsAddress = "metrics_address"
 )
 
 type Configuration interface {
-	// TODO: update methods returning Urls to return URL type and error
 	Address() string
+	// MetricsAddress returns the address /debug/vars is served on. Empty if the metrics are not
+	// served at all
+	MetricsAddress() string
 	TlsConfig() (*TlsConfig, error)
 	TlsTrustStore() (string, error)
-	RegisterUrl() string
-	AuthenticateUrl() string
-	HydraAdminUrl() string
-	LogLevel() zerolog.Level
+	// UpstreamTlsConfig returns the TLS settings used to call the given upstream service
+	UpstreamTlsConfig(upstream Upstream) *UpstreamTlsConfig
+	// UpstreamAuthConfig returns the credentials used to call the given upstream service. Secrets
+	// configured as files are read. An error is returned if one of them can't be read.
+	UpstreamAuthConfig(upstream Upstream) (*UpstreamAuthConfig, error)
+	// ResilienceConfig returns the timeout, retry and circuit breaker settings for calls to the
+	// given upstream service
+	ResilienceConfig(upstream Upstream) *ResilienceConfig
+	// RegisterUrl returns nil if no registration url is configured
+	RegisterUrl() (*url.URL, error)
+	AuthenticateUrl() (*url.URL, error)
+	// PublicUrl returns the url browsers reach the login provider at. It is used to build the links
+	// sent by email. nil if not configured
+	PublicUrl() (*url.URL, error)
+	// TrustedProxies returns the networks of the reverse proxies, whose X-Forwarded-For header is
+	// followed to determine the address of the client
+	TrustedProxies() ([]*net.IPNet, error)
+	HydraAdminUrl() (*url.URL, error)
+	// HydraApiVersion returns the version of the hydra admin API to use. HydraAuto lets the login
+	// provider ask hydra for its version.
+	HydraApiVersion() (HydraVersion, error)
+	// LogLevel returns the info level together with an error if an unsupported level is configured
+	LogLevel() (zerolog.Level, error)
+	ClaimsConfig() *ClaimsConfig
+	ConsentConfig() *ConsentConfig
+	LogoutConfig() *LogoutConfig
+	DeviceConfig() *DeviceConfig
+	// AccountsConfig returns the settings of the account chooser. An error is returned if the
+	// cookie secret can't be read.
+	AccountsConfig() (*AccountsConfig, error)
+	UsersConfig() *UsersConfig
+	RegistrationConfig() *RegistrationConfig
+	PasswordResetConfig() *PasswordResetConfig
+	PasswordlessConfig() *PasswordlessConfig
+	PasswordPolicyConfig() *PasswordPolicyConfig
+	// MailConfig returns the settings of the SMTP server. An error is returned if the password
+	// can't be read.
+	MailConfig() (*MailConfig, error)
+	SmsConfig() *SmsConfig
+	AuditConfig() (*AuditConfig, error)
+	TemplatesDirectory() string
+	StaticDirectory() string
+	Themes() map[string]*Theme
+	ClientThemes() map[string]string
 }
 
 type TlsConfig struct {
 	KeyFile  string
 	CertFile string
+	// MinVersion is the minimum TLS version accepted, e.g. "1.2"
+	MinVersion string
+	// CipherSuites lists the names of the cipher suites enabled for TLS 1.0 - 1.2. The defaults of
+	// Go are used if empty. TLS 1.3 cipher suites are not configurable.
+	CipherSuites []string
+	// Certificates are served instead of the default one to clients asking for one of their
+	// names via SNI
+	Certificates []Certificate
+}
+
+// Certificate configures an additional certificate together with its private key (both pem encoded)
+type Certificate struct {
+	KeyFile  string `mapstructure:"key"`
+	CertFile string `mapstructure:"cert"`
+}
+
+// HydraVersion selects the version of the hydra admin API
+type HydraVersion string
+
+const (
+	HydraAuto HydraVersion = "auto"
+	HydraV1   HydraVersion = "v1"
+	HydraV2   HydraVersion = "v2"
+)
+
+// Upstream names a service the login provider calls
+type Upstream string
+
+const (
+	HydraAdmin   Upstream = "hydra_admin"
+	Authenticate Upstream = "authenticate"
+	SmsWebhook   Upstream = "sms_webhook"
+	AuditWebhook Upstream = "audit_webhook"
+)
+
+// Upstreams lists all upstream services
+var Upstreams = []Upstream{HydraAdmin, Authenticate, SmsWebhook, AuditWebhook}
+
+// UpstreamTlsConfig configures the TLS connections to an upstream service. The trust store is
+// shared by all upstream services.
+type UpstreamTlsConfig struct {
+	TrustStoreFile string
+	// ClientCertificate is presented to the upstream service if it asks for one. nil if not configured
+	ClientCertificate *Certificate
+}
+
+// The supported types of upstream authentication
+const (
+	AuthNone              = ""
+	AuthBearer            = "bearer"
+	AuthBasic             = "basic"
+	AuthClientCredentials = "client_credentials"
+)
+
+// UpstreamAuthConfig configures how the login provider authenticates to an upstream service
+type UpstreamAuthConfig struct {
+	// Type is one of AuthNone, AuthBearer, AuthBasic or AuthClientCredentials
+	Type string
+	// Token is sent as bearer token if Type is AuthBearer
+	Token string
+	// Username and Password are used for AuthBasic
+	Username string
+	Password string
+	// ClientId, ClientSecret, TokenUrl and Scopes are used to retrieve access tokens with the
+	// OAuth2 client credentials grant if Type is AuthClientCredentials
+	ClientId     string
+	ClientSecret string
+	TokenUrl     string
+	Scopes       []string
+}
+
+// ResilienceConfig configures how calls to an upstream service deal with failures
+type ResilienceConfig struct {
+	// Timeout limits each attempt to call the upstream service. Disabled if 0
+	Timeout time.Duration
+	// MaxRetries is the number of times failed GET requests are retried
+	MaxRetries int
+	// FailureThreshold is the number of consecutive failures, after which calls to the upstream
+	// service are rejected for OpenDuration. The circuit breaker is disabled if 0
+	FailureThreshold int
+	OpenDuration     time.Duration
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Version returns the configured minimum TLS version
+func (t *TlsConfig) Version() (uint16, error) {
+	version, ok := tlsVersions[t.MinVersion]
+	if !ok {
+		return 0, fmt.Errorf("%s: unsupported TLS version %q, use one of 1.0, 1.1, 1.2 or 1.3", tlsMinVersion, t.MinVersion)
+	}
+	return version, nil
+}
+
+// CipherSuiteIds returns the ids of the configured cipher suites. Only cipher suites without
+// known security issues are supported.
+func (t *TlsConfig) CipherSuiteIds() ([]uint16, error) {
+	if len(t.CipherSuites) == 0 {
+		return nil, nil
+	}
+
+	supported := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		supported[suite.Name] = suite.ID
+	}
+
+	var ids []uint16
+	for _, name := range t.CipherSuites {
+		id, ok := supported[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported cipher suite %q", tlsCipherSuites, name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ClaimsConfig configures the scopes, which have to be granted to expose
+// the authorization related user data as claims
+type ClaimsConfig struct {
+	GroupsScope     string
+	RolesScope      string
+	AttributesScope string
+}
+
+// DefaultTheme is the name of the theme used if no other theme is selected for a client
+const DefaultTheme = "default"
+
+// defaultLogoUrl points to the logo embedded into the binary
+const defaultLogoUrl = "/static/img/logo.svg"
+
+// Theme configures the branding of the pages
+type Theme struct {
+	Name         string `mapstructure:"-"`
+	LogoUrl      string `mapstructure:"logo_url"`
+	PrimaryColor string `mapstructure:"primary_color"`
+	CssUrl       string `mapstructure:"css_url"`
+	// TemplateDir is the directory with templates overriding the default ones
+	TemplateDir string `mapstructure:"template_dir"`
+}
+
+// ConsentConfig configures the global consent policy
+type ConsentConfig struct {
+	// TrustedClients lists the ids of first party clients, consent is granted automatically for
+	TrustedClients []string
+	// SkipScopes lists the scopes, which never require the consent of the user
+	SkipScopes []string
+	// MaxAge is the period of time after which the user has to consent again. Zero disables it
+	MaxAge time.Duration
+}
+
+// LogoutConfig configures the logout flow
+type LogoutConfig struct {
+	// DefaultRedirectUrl is where users, who declined to log out, are sent to if the relying party
+	// did not provide a post logout redirect uri and has no client uri registered
+	DefaultRedirectUrl string
+	// AutoAcceptRpInitiated enables logging out without confirmation if the relying party proved
+	// the session by sending an id token hint
+	AutoAcceptRpInitiated bool
+	// Issuer is the issuer of the id tokens, i.e. the public url of hydra. It is passed to the
+	// front-channel logout uris and used as issuer of the back-channel logout tokens
+	Issuer string
+	// FrontChannel enables rendering the front-channel logout uris of all clients of the session
+	FrontChannel bool
+	BackChannel  *BackChannelConfig
+}
+
+// BackChannelConfig configures the notification of webhooks about logouts
+type BackChannelConfig struct {
+	// SigningKeyFile is the pem encoded RSA private key the logout tokens are signed with.
+	// Back-channel notifications are disabled if not set
+	SigningKeyFile string
+	KeyId          string
+	Timeout        time.Duration
+	Webhooks       []Webhook
+}
+
+// Webhook is notified about each logout
+type Webhook struct {
+	Url string `mapstructure:"url"`
+	// Audience is the audience of the logout tokens sent to the webhook
+	Audience string `mapstructure:"audience"`
+}
+
+// loadErr holds the error occurred while reading the config file. It is reported by Validate
+var loadErr error
+
+// configFile is the config file given on the command line. It is read again on reload
+var configFile string
+
+// store holds the active configuration. It is replaced as a whole on reload, so readers either
+// see the old or the new configuration, but never a mix of both
+var store atomic.Value
+
+func init() {
+	v, _ := newViper("")
+	store.Store(v)
 }
 
 // Loads and reads the config and environment variables if set
 func Load(file *string) func() {
 	return func() {
-		viper.SetDefault(logLevel, "info")
-		viper.SetDefault(port, "8080")
+		reloadMutex.Lock()
+		defer reloadMutex.Unlock()
+
+		configFile = *file
+		v, err := newViper(configFile)
+		loadErr = err
+		store.Store(v)
+	}
+}
 
-		viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-		viper.AutomaticEnv()
+func newViper(file string) (*viper.Viper, error) {
+	v := viper.New()
+	v.SetDefault(logLevel, "info")
+	v.SetDefault(port, "8080")
+	v.SetDefault(hydraApiVersion, string(HydraAuto))
+	v.SetDefault(tlsMinVersion, "1.2")
+	v.SetDefault(claimsGroupsScope, "groups")
+	v.SetDefault(claimsRolesScope, "roles")
+	v.SetDefault(claimsAttributesScope, "attributes")
+	v.SetDefault(logoutAutoAcceptRpInitiated, true)
+	v.SetDefault(logoutBackChannelTimeout, "5s")
+	v.SetDefault(accountsMax, 5)
+	v.SetDefault(accountsMaxAge, "720h")
+	v.SetDefault(registrationVerificationTtl, "24h")
+	v.SetDefault(passwordResetTokenTtl, "1h")
+	v.SetDefault(passwordResetMaxPerAddress, 3)
+	v.SetDefault(passwordResetAddressWindow, "1h")
+	v.SetDefault(passwordlessTtl, "15m")
+	v.SetDefault(passwordlessMaxAttempts, 5)
+	v.SetDefault(passwordlessMaxPerAddress, 3)
+	v.SetDefault(passwordlessAddressWindow, "1h")
+	v.SetDefault(passwordPolicyMinLength, 8)
+	v.SetDefault(passwordPolicyCheckOnLogin, true)
+	v.SetDefault(mailSmtpPort, 587)
+	v.SetDefault(smsCodeTtl, "5m")
+	v.SetDefault(smsMaxPerNumber, 3)
+	v.SetDefault(smsNumberWindow, "1h")
+	v.SetDefault(smsMaxAttempts, 5)
+	v.SetDefault(deviceMaxAttempts, 5)
+	v.SetDefault(deviceAttemptWindow, "15m")
+	v.SetDefault(auditMaxSize, 100)
+	v.SetDefault(auditMaxBackups, 10)
+	v.SetDefault(auditSyslogTag, "login-provider")
+	for _, upstream := range Upstreams {
+		prefix := resilience + "." + string(upstream) + "."
+		v.SetDefault(prefix+"timeout", "10s")
+		v.SetDefault(prefix+"max_retries", 2)
+		v.SetDefault(prefix+"failure_threshold", 5)
+		v.SetDefault(prefix+"open_duration", "30s")
+	}
 
-		if *file != "" {
-			viper.SetConfigFile(*file)
-			if err := viper.ReadInConfig(); err != nil {
-				fmt.Printf(`Config file not found because "%s"\n`, err)
-			}
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	if file != "" {
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			return v, fmt.Errorf("failed to read config file %s: %w", file, err)
 		}
 	}
+	return v, nil
+}
+
+func current() *viper.Viper {
+	return store.Load().(*viper.Viper)
 }
 
-type configuration struct {}
+// configuration reads the active configuration unless bound to a specific one, e.g. to validate
+// a configuration before activating it
+type configuration struct {
+	v *viper.Viper
+}
 
 func NewConfiguration() Configuration {
 	return &configuration{}
 }
 
+func (c *configuration) viper() *viper.Viper {
+	if c.v != nil {
+		return c.v
+	}
+	return current()
+}
+
 func (c *configuration) Address() string {
-	return viper.GetString(host) + ":" + viper.GetString(port)
+	return c.viper().GetString(host) + ":" + c.viper().GetString(port)
+}
+
+func (c *configuration) MetricsAddress() string {
+	return c.viper().GetString(metricsAddress)
 }
 
 func (c *configuration) TlsConfig() (*TlsConfig, error) {
-	tlsKeyFile := viper.GetString(tlsKeyFile)
+	tlsKeyFile := c.viper().GetString(tlsKeyFile)
 	if len(tlsKeyFile) == 0 {
 		return nil, errors.New("no TLS key configured")
 	}
@@ -79,7 +494,7 @@ func (c *configuration) TlsConfig() (*TlsConfig, error) {
 		return nil, errors.New("configured TLS key not available")
 	}
 
-	tlsCertFile := viper.GetString(tlsCertFile)
+	tlsCertFile := c.viper().GetString(tlsCertFile)
 	if len(tlsCertFile) == 0 {
 		return nil, errors.New("no TLS cert configured")
 	}
@@ -87,14 +502,22 @@ func (c *configuration) TlsConfig() (*TlsConfig, error) {
 		return nil, errors.New("configured TLS cert not available")
 	}
 
+	var certificates []Certificate
+	if err := c.viper().UnmarshalKey(tlsCertificates, &certificates); err != nil {
+		return nil, fmt.Errorf("failed to read the additional certificates: %w", err)
+	}
+
 	return &TlsConfig{
-		KeyFile:  tlsKeyFile,
-		CertFile: tlsCertFile,
+		KeyFile:      tlsKeyFile,
+		CertFile:     tlsCertFile,
+		MinVersion:   c.viper().GetString(tlsMinVersion),
+		CipherSuites: c.viper().GetStringSlice(tlsCipherSuites),
+		Certificates: certificates,
 	}, nil
 }
 
 func (c *configuration) TlsTrustStore() (string, error) {
-	value := viper.GetString(tlsTrustStoreFile)
+	value := c.viper().GetString(tlsTrustStoreFile)
 	if len(value) == 0 {
 		return "", errors.New("no TLS key configured")
 	}
@@ -104,33 +527,522 @@ func (c *configuration) TlsTrustStore() (string, error) {
 	return value, nil
 }
 
-func (c *configuration) RegisterUrl() string  {
-	return viper.GetString(registerUrl)
+func (c *configuration) UpstreamTlsConfig(upstream Upstream) *UpstreamTlsConfig {
+	conf := &UpstreamTlsConfig{}
+	if trustStore, err := c.TlsTrustStore(); err == nil {
+		conf.TrustStoreFile = trustStore
+	}
+
+	prefix := tlsClients + "." + string(upstream)
+	keyFile := c.viper().GetString(prefix + ".key")
+	certFile := c.viper().GetString(prefix + ".cert")
+	if len(keyFile) != 0 || len(certFile) != 0 {
+		conf.ClientCertificate = &Certificate{KeyFile: keyFile, CertFile: certFile}
+	}
+	return conf
+}
+
+func (c *configuration) UpstreamAuthConfig(upstream Upstream) (*UpstreamAuthConfig, error) {
+	prefix := upstreamAuth + "." + string(upstream) + "."
+
+	conf := &UpstreamAuthConfig{
+		Type:     c.viper().GetString(prefix + "type"),
+		Username: c.viper().GetString(prefix + "username"),
+		ClientId: c.viper().GetString(prefix + "client_id"),
+		TokenUrl: c.viper().GetString(prefix + "token_url"),
+		Scopes:   c.viper().GetStringSlice(prefix + "scopes"),
+	}
+
+	var err error
+	if conf.Token, err = c.secret(prefix + "token"); err != nil {
+		return nil, err
+	}
+	if conf.Password, err = c.secret(prefix + "password"); err != nil {
+		return nil, err
+	}
+	if conf.ClientSecret, err = c.secret(prefix + "client_secret"); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// secret returns the value of the given key. If not set, it reads the file configured by the key
+// suffixed with "_file". This way secrets can be mounted as files instead of putting them into
+// the config file or the environment.
+func (c *configuration) secret(key string) (string, error) {
+	if value := c.viper().GetString(key); len(value) != 0 {
+		return value, nil
+	}
+
+	file := c.viper().GetString(key + "_file")
+	if len(file) == 0 {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("%s_file: failed to read secret: %w", key, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (c *configuration) ResilienceConfig(upstream Upstream) *ResilienceConfig {
+	prefix := resilience + "." + string(upstream) + "."
+	return &ResilienceConfig{
+		Timeout:          c.viper().GetDuration(prefix + "timeout"),
+		MaxRetries:       c.viper().GetInt(prefix + "max_retries"),
+		FailureThreshold: c.viper().GetInt(prefix + "failure_threshold"),
+		OpenDuration:     c.viper().GetDuration(prefix + "open_duration"),
+	}
+}
+
+func (c *configuration) RegisterUrl() (*url.URL, error) {
+	if len(c.viper().GetString(registerUrl)) == 0 {
+		return nil, nil
+	}
+	return c.parseUrl(registerUrl)
+}
+
+func (c *configuration) PublicUrl() (*url.URL, error) {
+	if len(c.viper().GetString(publicUrl)) == 0 {
+		return nil, nil
+	}
+	return c.parseUrl(publicUrl)
+}
+
+func (c *configuration) TrustedProxies() ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range c.viper().GetStringSlice(trustedProxies) {
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil {
+				if v4 := ip.To4(); v4 != nil {
+					ip = v4
+				}
+				networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+				continue
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q is neither an IP address nor a network", trustedProxies, value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func (c *configuration) HydraAdminUrl() (*url.URL, error) {
+	return c.parseUrl(hydraAdminUrl)
 }
 
-func (c *configuration) HydraAdminUrl() string  {
-	return viper.GetString(hydraAdminUrl)
+func (c *configuration) HydraApiVersion() (HydraVersion, error) {
+	switch value := HydraVersion(c.viper().GetString(hydraApiVersion)); value {
+	case HydraAuto, HydraV1, HydraV2:
+		return value, nil
+	default:
+		return HydraAuto, fmt.Errorf("%s: unsupported version %q, use one of auto, v1 or v2", hydraApiVersion, value)
+	}
 }
 
-func (c *configuration) AuthenticateUrl() string  {
-	return viper.GetString(authenticateUrl)
+func (c *configuration) AuthenticateUrl() (*url.URL, error) {
+	return c.parseUrl(authenticateUrl)
 }
 
-func (c *configuration) LogLevel() zerolog.Level  {
-	switch viper.GetString(logLevel) {
+func (c *configuration) LogLevel() (zerolog.Level, error) {
+	switch value := c.viper().GetString(logLevel); value {
 	case "panic":
-		return zerolog.PanicLevel
+		return zerolog.PanicLevel, nil
 	case "fatal":
-		return zerolog.FatalLevel
+		return zerolog.FatalLevel, nil
 	case "error":
-		return zerolog.ErrorLevel
+		return zerolog.ErrorLevel, nil
 	case "warn":
-		return zerolog.WarnLevel
+		return zerolog.WarnLevel, nil
+	case "info":
+		return zerolog.InfoLevel, nil
 	case "debug":
-		return zerolog.DebugLevel
+		return zerolog.DebugLevel, nil
 	default:
-		return zerolog.InfoLevel
+		return zerolog.InfoLevel, fmt.Errorf(
+			"%s: unsupported level %q, supported are panic, fatal, error, warn, info and debug", logLevel, value)
+	}
+}
+
+// parseUrl parses the absolute http(s) url configured for the given key
+func (c *configuration) parseUrl(key string) (*url.URL, error) {
+	return parseUrlValue(key, c.viper().GetString(key))
+}
+
+func parseUrlValue(key, value string) (*url.URL, error) {
+	if len(value) == 0 {
+		return nil, fmt.Errorf("%s: is required", key)
+	}
+
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %q is not a valid url: %w", key, value, err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || len(parsed.Host) == 0 {
+		return nil, fmt.Errorf("%s: %q is not an absolute http or https url", key, value)
+	}
+	return parsed, nil
+}
+
+func (c *configuration) ClaimsConfig() *ClaimsConfig {
+	return &ClaimsConfig{
+		GroupsScope:     c.viper().GetString(claimsGroupsScope),
+		RolesScope:      c.viper().GetString(claimsRolesScope),
+		AttributesScope: c.viper().GetString(claimsAttributesScope),
+	}
+}
+
+func (c *configuration) ConsentConfig() *ConsentConfig {
+	return &ConsentConfig{
+		TrustedClients: c.viper().GetStringSlice(consentTrustedClients),
+		SkipScopes:     c.viper().GetStringSlice(consentSkipScopes),
+		MaxAge:         c.viper().GetDuration(consentMaxAge),
+	}
+}
+
+// AccountsConfig configures the account chooser and the cookie remembering the accounts used
+// in a browser
+type AccountsConfig struct {
+	// CookieSecret is used to encrypt and sign the cookie. The account chooser is disabled if empty
+	CookieSecret string
+	// AlwaysChoose shows the account chooser whenever accounts are remembered, not only if
+	// requested by the client with prompt=select_account
+	AlwaysChoose bool
+	// Max is the number of accounts remembered
+	Max int
+	// MaxAge is the period accounts are remembered after their last use
+	MaxAge time.Duration
+}
+
+func (c *configuration) AccountsConfig() (*AccountsConfig, error) {
+	secret, err := c.secret(accountsCookieSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccountsConfig{
+		CookieSecret: secret,
+		AlwaysChoose: c.viper().GetBool(accountsAlwaysChoose),
+		Max:          c.viper().GetInt(accountsMax),
+		MaxAge:       c.viper().GetDuration(accountsMaxAge),
+	}, nil
+}
+
+// The supported user stores
+const (
+	UsersStoreNone   = ""
+	UsersStoreMemory = "memory"
+	UsersStoreFile   = "file"
+)
+
+// UsersConfig configures the store of the users registered with the login provider itself
+type UsersConfig struct {
+	// Store is one of UsersStoreNone, UsersStoreMemory or UsersStoreFile. Users are only
+	// authenticated by the authentication service if UsersStoreNone
+	Store string
+	// File is the JSON file the users are kept in if Store is UsersStoreFile
+	File string
+}
+
+func (c *configuration) UsersConfig() *UsersConfig {
+	return &UsersConfig{
+		Store: c.viper().GetString(usersStore),
+		File:  c.viper().GetString(usersFile),
+	}
+}
+
+// RegistrationFields lists the names of the fields, which can be added to the registration form
+var RegistrationFields = []string{"first_name", "last_name", "user_name", "phone"}
+
+// RegistrationConfig configures the self-service registration
+type RegistrationConfig struct {
+	// Enabled enables the registration pages. It requires a user store, the public url and a mail server
+	Enabled bool
+	// Fields are asked for in addition to the email address and the password
+	Fields []RegistrationField
+	// VerificationTtl is the period the link to verify the email address is valid
+	VerificationTtl time.Duration
+}
+
+// RegistrationField is a field of the registration form
+type RegistrationField struct {
+	// Name is one of RegistrationFields
+	Name     string `mapstructure:"name"`
+	Required bool   `mapstructure:"required"`
+}
+
+func (c *configuration) RegistrationConfig() *RegistrationConfig {
+	var fields []RegistrationField
+	if err := c.viper().UnmarshalKey(registrationFields, &fields); err != nil {
+		log.Warn().Err(err).Msg("Failed to read configured registration fields")
+	}
+
+	return &RegistrationConfig{
+		Enabled:         c.viper().GetBool(registrationEnabled),
+		Fields:          fields,
+		VerificationTtl: c.viper().GetDuration(registrationVerificationTtl),
 	}
 }
 
+// PasswordResetConfig configures resetting forgotten passwords of the users in the user store
+type PasswordResetConfig struct {
+	// Enabled enables the password reset pages. It requires a user store, the public url and a mail server
+	Enabled bool
+	// TokenTtl is the period the link to reset the password is valid
+	TokenTtl time.Duration
+	// MaxPerAddress is the number of emails sent to an address within AddressWindow. Unlimited if 0
+	MaxPerAddress int
+	AddressWindow time.Duration
+}
+
+func (c *configuration) PasswordResetConfig() *PasswordResetConfig {
+	return &PasswordResetConfig{
+		Enabled:       c.viper().GetBool(passwordResetEnabled),
+		TokenTtl:      c.viper().GetDuration(passwordResetTokenTtl),
+		MaxPerAddress: c.viper().GetInt(passwordResetMaxPerAddress),
+		AddressWindow: c.viper().GetDuration(passwordResetAddressWindow),
+	}
+}
+
+// PasswordlessConfig configures the login without a password. Users of the user store receive a link
+// and a code by email instead.
+type PasswordlessConfig struct {
+	// Enabled enables the login by email. It requires a user store, the public url and a mail server
+	Enabled bool
+	// Ttl is the period the link and the code are valid
+	Ttl time.Duration
+	// MaxAttempts is the number of codes, which can be entered per login challenge. Unlimited if 0
+	MaxAttempts int
+	// MaxPerAddress is the number of emails sent to an address within AddressWindow. Unlimited if 0
+	MaxPerAddress int
+	AddressWindow time.Duration
+}
 
+func (c *configuration) PasswordlessConfig() *PasswordlessConfig {
+	return &PasswordlessConfig{
+		Enabled:       c.viper().GetBool(passwordlessEnabled),
+		Ttl:           c.viper().GetDuration(passwordlessTtl),
+		MaxAttempts:   c.viper().GetInt(passwordlessMaxAttempts),
+		MaxPerAddress: c.viper().GetInt(passwordlessMaxPerAddress),
+		AddressWindow: c.viper().GetDuration(passwordlessAddressWindow),
+	}
+}
+
+// PasswordPolicyConfig configures the rules passwords have to follow
+type PasswordPolicyConfig struct {
+	MinLength int
+	// RequireLower, RequireUpper, RequireDigit and RequireSymbol require at least one character
+	// of the respective class
+	RequireLower  bool
+	RequireUpper  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// DeniedWords must not be contained in passwords, regardless of the case
+	DeniedWords []string
+	// BreachedDirectory contains the hashes of breached passwords in the range format of Have I Been
+	// Pwned: a file per first 5 hex digits of the SHA-1 hash, listing the remaining digits. Passwords
+	// are not checked against breaches if empty.
+	BreachedDirectory string
+	// CheckOnLogin checks the passwords of users logging in. Users of the user store are asked to
+	// change a password, which does not follow the policy. Users of the authentication service are
+	// only logged, as their password can't be changed by the login provider.
+	CheckOnLogin bool
+	// RequireChange does not let users of the user store skip changing such a password
+	RequireChange bool
+}
+
+func (c *configuration) PasswordPolicyConfig() *PasswordPolicyConfig {
+	return &PasswordPolicyConfig{
+		MinLength:         c.viper().GetInt(passwordPolicyMinLength),
+		RequireLower:      c.viper().GetBool(passwordPolicyRequireLower),
+		RequireUpper:      c.viper().GetBool(passwordPolicyRequireUpper),
+		RequireDigit:      c.viper().GetBool(passwordPolicyRequireDigit),
+		RequireSymbol:     c.viper().GetBool(passwordPolicyRequireSymbol),
+		DeniedWords:       c.viper().GetStringSlice(passwordPolicyDeniedWords),
+		BreachedDirectory: c.viper().GetString(passwordPolicyBreachedDirectory),
+		CheckOnLogin:      c.viper().GetBool(passwordPolicyCheckOnLogin),
+		RequireChange:     c.viper().GetBool(passwordPolicyRequireChange),
+	}
+}
+
+// MailConfig configures the SMTP server emails are sent with
+type MailConfig struct {
+	// From is the sender address of the emails
+	From string
+	// Host is the host name of the SMTP server. Sending emails is disabled if empty
+	Host string
+	Port int
+	// Username and Password authenticate to the SMTP server if Username is set
+	Username string
+	Password string
+}
+
+func (c *configuration) MailConfig() (*MailConfig, error) {
+	password, err := c.secret(mailSmtpPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MailConfig{
+		From:     c.viper().GetString(mailFrom),
+		Host:     c.viper().GetString(mailSmtpHost),
+		Port:     c.viper().GetInt(mailSmtpPort),
+		Username: c.viper().GetString(mailSmtpUsername),
+		Password: password,
+	}, nil
+}
+
+// The supported SMS gateways
+const (
+	SmsGatewayNone    = ""
+	SmsGatewayWebhook = "webhook"
+	SmsGatewayFile    = "file"
+)
+
+// SmsConfig configures the codes sent by SMS to verify phone numbers and as second factor
+type SmsConfig struct {
+	// Gateway is one of SmsGatewayNone, SmsGatewayWebhook or SmsGatewayFile
+	Gateway string
+	// WebhookUrl receives the messages as JSON if Gateway is SmsGatewayWebhook
+	WebhookUrl string
+	// File is the file the messages are appended to if Gateway is SmsGatewayFile. Meant for testing
+	File string
+	// CodeTtl is the period a code is valid
+	CodeTtl time.Duration
+	// MaxPerNumber is the number of codes sent to a phone number within NumberWindow. Unlimited if 0
+	MaxPerNumber int
+	NumberWindow time.Duration
+	// MaxAttempts is the number of codes, which can be entered per login challenge. Unlimited if 0
+	MaxAttempts int
+	// SecondFactor requires users with a phone number to enter a code sent to it after their password
+	SecondFactor bool
+	// VerifyPhone asks users of the user store to verify their phone number after logging in
+	VerifyPhone bool
+}
+
+func (c *configuration) SmsConfig() *SmsConfig {
+	return &SmsConfig{
+		Gateway:      c.viper().GetString(smsGateway),
+		WebhookUrl:   c.viper().GetString(smsWebhookUrl),
+		File:         c.viper().GetString(smsFile),
+		CodeTtl:      c.viper().GetDuration(smsCodeTtl),
+		MaxPerNumber: c.viper().GetInt(smsMaxPerNumber),
+		NumberWindow: c.viper().GetDuration(smsNumberWindow),
+		MaxAttempts:  c.viper().GetInt(smsMaxAttempts),
+		SecondFactor: c.viper().GetBool(smsSecondFactor),
+		VerifyPhone:  c.viper().GetBool(smsVerifyPhone),
+	}
+}
+
+// AuditConfig configures where the audit events are written to. Each of the sinks is disabled if
+// its file, address or url is empty, except syslog, which needs to be enabled.
+type AuditConfig struct {
+	// File is the file the events are appended to as lines of JSON
+	File string
+	// MaxSize is the size in megabytes the file is rotated at. It is never rotated if 0
+	MaxSize int
+	// MaxBackups is the number of rotated files kept
+	MaxBackups int
+	// Syslog sends the events to the syslog daemon at SyslogAddress. The local one is used if
+	// SyslogNetwork is empty.
+	Syslog        bool
+	SyslogNetwork string
+	SyslogAddress string
+	SyslogTag     string
+	// WebhookUrl receives each event as JSON
+	WebhookUrl string
+	// HmacKey is the key the events are chained with. Plain SHA-256 hashes are used if empty
+	HmacKey string
+}
+
+func (c *configuration) AuditConfig() (*AuditConfig, error) {
+	key, err := c.secret(auditHmacKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuditConfig{
+		File:          c.viper().GetString(auditFile),
+		MaxSize:       c.viper().GetInt(auditMaxSize),
+		MaxBackups:    c.viper().GetInt(auditMaxBackups),
+		Syslog:        c.viper().GetBool(auditSyslog),
+		SyslogNetwork: c.viper().GetString(auditSyslogNetwork),
+		SyslogAddress: c.viper().GetString(auditSyslogAddress),
+		SyslogTag:     c.viper().GetString(auditSyslogTag),
+		WebhookUrl:    c.viper().GetString(auditWebhookUrl),
+		HmacKey:       key,
+	}, nil
+}
+
+// DeviceConfig configures the verification of user codes of the device authorization grant
+type DeviceConfig struct {
+	// MaxAttempts is the number of user codes, which can be entered per client IP and per device
+	// challenge within AttemptWindow. Unlimited if 0
+	MaxAttempts   int
+	AttemptWindow time.Duration
+}
+
+func (c *configuration) DeviceConfig() *DeviceConfig {
+	return &DeviceConfig{
+		MaxAttempts:   c.viper().GetInt(deviceMaxAttempts),
+		AttemptWindow: c.viper().GetDuration(deviceAttemptWindow),
+	}
+}
+
+func (c *configuration) LogoutConfig() *LogoutConfig {
+	return &LogoutConfig{
+		DefaultRedirectUrl:    c.viper().GetString(logoutDefaultRedirectUrl),
+		AutoAcceptRpInitiated: c.viper().GetBool(logoutAutoAcceptRpInitiated),
+		Issuer:                c.viper().GetString(logoutIssuer),
+		FrontChannel:          c.viper().GetBool(logoutFrontChannel),
+		BackChannel:           c.backChannelConfig(),
+	}
+}
+
+func (c *configuration) backChannelConfig() *BackChannelConfig {
+	var webhooks []Webhook
+	if err := c.viper().UnmarshalKey(logoutBackChannelWebhooks, &webhooks); err != nil {
+		log.Warn().Err(err).Msg("Failed to read configured back-channel logout webhooks")
+	}
+
+	return &BackChannelConfig{
+		SigningKeyFile: c.viper().GetString(logoutBackChannelSigningKey),
+		KeyId:          c.viper().GetString(logoutBackChannelKeyId),
+		Timeout:        c.viper().GetDuration(logoutBackChannelTimeout),
+		Webhooks:       webhooks,
+	}
+}
+
+func (c *configuration) TemplatesDirectory() string {
+	return c.viper().GetString(templatesDirectory)
+}
+
+func (c *configuration) StaticDirectory() string {
+	return c.viper().GetString(staticDirectory)
+}
+
+func (c *configuration) Themes() map[string]*Theme {
+	configured := make(map[string]*Theme)
+	if err := c.viper().UnmarshalKey(themes, &configured); err != nil {
+		log.Warn().Err(err).Msg("Failed to read configured themes")
+	}
+
+	for name, theme := range configured {
+		if theme == nil {
+			theme = &Theme{}
+			configured[name] = theme
+		}
+		theme.Name = name
+	}
+	if _, ok := configured[DefaultTheme]; !ok {
+		configured[DefaultTheme] = &Theme{Name: DefaultTheme, LogoUrl: defaultLogoUrl}
+	}
+	return configured
+}
+
+func (c *configuration) ClientThemes() map[string]string {
+	return c.viper().GetStringMapString(clientThemes)
+}
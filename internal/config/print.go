@@ -0,0 +1,79 @@
+package config
+
+import (
+	"github.com/spf13/cast"
+	"gopkg.in/yaml.v2"
+	"io"
+	"strings"
+)
+
+// redacted replaces the values of secrets when printing the configuration
+const redacted = "******"
+
+// secretKeyParts identify config keys holding secrets
+var secretKeyParts = []string{"password", "secret", "token", "credential"}
+
+// publicKeySuffixes identify config keys, which don't hold secrets themselves, like token_url
+// or the files secrets are read from
+var publicKeySuffixes = []string{"_url", "_file"}
+
+// Print writes the effective configuration, i.e. the config file merged with the defaults and the
+// environment variables, as yaml. The values of secrets are redacted.
+func Print(w io.Writer) error {
+	settings := redact(current().AllSettings())
+
+	data, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func redact(settings map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		result[key] = redactValue(key, value)
+	}
+	return result
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return redact(v)
+	case map[interface{}]interface{}:
+		// maps nested in lists are not normalized by viper
+		converted := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			converted[cast.ToString(k)] = item
+		}
+		return redact(converted)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, item := range v {
+			values[i] = redactValue(key, item)
+		}
+		return values
+	}
+
+	if isSecret(key) && value != nil && value != "" {
+		return redacted
+	}
+	return value
+}
+
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, suffix := range publicKeySuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return false
+		}
+	}
+	for _, part := range secretKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Listener prepares the reconfiguration of a component for a new configuration. The returned
+// function applies it. It is only called if all listeners prepared the new configuration
+// successfully, so either all components or none are reconfigured.
+type Listener func(conf Configuration) (apply func(), err error)
+
+// debounce is the time to wait for further changes of the config file before reloading it
+const debounce = 200 * time.Millisecond
+
+var (
+	reloadMutex sync.Mutex
+	listeners   []Listener
+)
+
+// OnChange registers a listener, which is notified about each reload
+func OnChange(listener Listener) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	listeners = append(listeners, listener)
+}
+
+// Reload reads the config file again. The new configuration is only activated if it is valid and
+// all listeners accept it. Otherwise the active configuration is kept and the error is returned.
+func Reload() error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	candidate, err := newViper(configFile)
+	if err != nil {
+		return err
+	}
+
+	conf := &configuration{v: candidate}
+	if err := Validate(conf); err != nil {
+		return err
+	}
+
+	var apply []func()
+	for _, listener := range listeners {
+		f, err := listener(conf)
+		if err != nil {
+			return err
+		}
+		apply = append(apply, f)
+	}
+
+	store.Store(candidate)
+	for _, f := range apply {
+		f()
+	}
+	return nil
+}
+
+// loadedFile returns the config file given on the command line
+func loadedFile() string {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	return configFile
+}
+
+// Watch reloads the configuration on SIGHUP and whenever the config file changes until the given
+// context is done
+func Watch(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	file := loadedFile()
+	var events <-chan fsnotify.Event
+	if file != "" {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to watch the config file. Reload it by sending SIGHUP")
+		} else if err := watcher.Add(filepath.Dir(file)); err != nil {
+			log.Warn().Err(err).Msg("Failed to watch the config file. Reload it by sending SIGHUP")
+			watcher.Close()
+		} else {
+			defer watcher.Close()
+			events = watcher.Events
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		signal.Stop(signals)
+	}()
+
+	// the directory is watched to detect files replaced by editors or config maps. Changes are
+	// collected for a moment, as a single save usually causes several events
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			reload("signal", file)
+		case event := <-events:
+			if isConfigFileEvent(event, file) {
+				timer = time.After(debounce)
+			}
+		case <-timer:
+			timer = nil
+			reload("file change", file)
+		}
+	}
+}
+
+func isConfigFileEvent(event fsnotify.Event, file string) bool {
+	if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+		return false
+	}
+	// config maps are mounted as symlinks, which are replaced on change
+	return filepath.Clean(event.Name) == filepath.Clean(file) ||
+		filepath.Base(event.Name) == "..data"
+}
+
+func reload(trigger, file string) {
+	logger := log.With().Str("_trigger", trigger).Str("_file", file).Logger()
+
+	if err := Reload(); err != nil {
+		l := logger.With().Err(err).Logger()
+		if validationErr, ok := err.(*ValidationError); ok {
+			l = l.With().Strs("_problems", validationErr.Problems).Logger()
+		}
+		l.Error().Msg("Rejected new configuration. Keeping the active one")
+		return
+	}
+	logger.Info().Msg("Configuration reloaded")
+}
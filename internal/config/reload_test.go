@@ -0,0 +1,151 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const validConfig = `
+hydra_admin_url: https://127.0.0.1:4445
+authenticate_url: http://127.0.0.1:8090/authenticate
+log:
+  level: `
+
+func writeConfig(t *testing.T, file, content string) {
+	require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+}
+
+// loadConfig loads the given config file and removes all listeners registered by other tests
+func loadConfig(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, file, content)
+	Load(&file)()
+	require.NoError(t, loadErr)
+
+	reloadMutex.Lock()
+	listeners = nil
+	reloadMutex.Unlock()
+	return file
+}
+
+func activeLogLevel(t *testing.T) zerolog.Level {
+	level, err := NewConfiguration().LogLevel()
+	require.NoError(t, err)
+	return level
+}
+
+func TestReloadAppliesValidConfig(t *testing.T) {
+	// GIVEN
+	file := loadConfig(t, validConfig+"debug")
+	var applied zerolog.Level
+	OnChange(func(conf Configuration) (func(), error) {
+		level, err := conf.LogLevel()
+		return func() { applied = level }, err
+	})
+	writeConfig(t, file, validConfig+"warn")
+
+	// WHEN
+	err := Reload()
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, zerolog.WarnLevel, activeLogLevel(t))
+	assert.Equal(t, zerolog.WarnLevel, applied)
+}
+
+func TestReloadKeepsActiveConfigIfNewOneIsInvalid(t *testing.T) {
+	// GIVEN
+	file := loadConfig(t, validConfig+"debug")
+	applied := false
+	OnChange(func(conf Configuration) (func(), error) {
+		return func() { applied = true }, nil
+	})
+	writeConfig(t, file, validConfig+"verbose")
+
+	// WHEN
+	err := Reload()
+
+	// THEN
+	require.Error(t, err)
+	assert.IsType(t, &ValidationError{}, err)
+	assert.Equal(t, zerolog.DebugLevel, activeLogLevel(t))
+	assert.False(t, applied)
+}
+
+func TestReloadKeepsActiveConfigIfRejectedByListener(t *testing.T) {
+	// GIVEN
+	file := loadConfig(t, validConfig+"debug")
+	applied := false
+	OnChange(func(conf Configuration) (func(), error) {
+		return func() { applied = true }, nil
+	})
+	OnChange(func(conf Configuration) (func(), error) {
+		return nil, errors.New("broken templates")
+	})
+	writeConfig(t, file, validConfig+"warn")
+
+	// WHEN
+	err := Reload()
+
+	// THEN
+	assert.EqualError(t, err, "broken templates")
+	assert.Equal(t, zerolog.DebugLevel, activeLogLevel(t))
+	assert.False(t, applied)
+}
+
+func TestWatchReloadsChangedConfigFile(t *testing.T) {
+	// GIVEN
+	file := loadConfig(t, validConfig+"debug")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Watch(ctx)
+	// give the watcher time to start
+	time.Sleep(100 * time.Millisecond)
+
+	// WHEN
+	writeConfig(t, file, validConfig+"error")
+
+	// THEN
+	assert.Eventually(t, func() bool {
+		level, _ := NewConfiguration().LogLevel()
+		return level == zerolog.ErrorLevel
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestWatchReloadsConfigOnSighup(t *testing.T) {
+	// GIVEN
+	loadConfig(t, validConfig+"debug")
+	reloaded := make(chan struct{}, 1)
+	OnChange(func(conf Configuration) (func(), error) {
+		return func() { reloaded <- struct{}{} }, nil
+	})
+	// make sure the test process is not terminated, even if the watcher has not been started yet
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Watch(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// WHEN
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+	// THEN
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration has not been reloaded")
+	}
+}
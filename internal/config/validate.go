@@ -0,0 +1,362 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"login-provider/internal/utils"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// minSecretLength is the minimum length of secrets used to derive encryption keys from
+const minSecretLength = 32
+
+// ValidationError lists all problems found in the configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks the loaded configuration. All problems found are reported at once by
+// returning a *ValidationError.
+func Validate(conf Configuration) error {
+	c, ok := conf.(*configuration)
+	if !ok {
+		return errors.New("unsupported configuration")
+	}
+	v := &validator{v: c.viper()}
+
+	// a config bound to a specific instance is validated before reloading. It has been read successfully
+	if loadErr != nil && c.v == nil {
+		v.report(loadErr)
+	}
+
+	_, err := conf.HydraAdminUrl()
+	v.report(err)
+	_, err = conf.HydraApiVersion()
+	v.report(err)
+	_, err = conf.AuthenticateUrl()
+	v.report(err)
+	_, err = conf.RegisterUrl()
+	v.report(err)
+	_, err = conf.LogLevel()
+	v.report(err)
+
+	if value, err := strconv.Atoi(v.v.GetString(port)); err != nil || value < 1 || value > 65535 {
+		v.problem("%s: %q is not a valid port", port, v.v.GetString(port))
+	}
+	if value := v.v.GetString(metricsAddress); len(value) != 0 {
+		if _, _, err := net.SplitHostPort(value); err != nil {
+			v.problem("%s: %q is not a valid address, use host:port", metricsAddress, value)
+		}
+	}
+
+	if len(v.v.GetString(tlsKeyFile)) != 0 || len(v.v.GetString(tlsCertFile)) != 0 {
+		if tlsConfig, err := conf.TlsConfig(); err != nil {
+			v.problem("tls: %s", err)
+		} else {
+			_, err = tlsConfig.Version()
+			v.report(err)
+			_, err = tlsConfig.CipherSuiteIds()
+			v.report(err)
+			for i, certificate := range tlsConfig.Certificates {
+				v.checkFile(fmt.Sprintf("%s[%d].key", tlsCertificates, i), certificate.KeyFile)
+				v.checkFile(fmt.Sprintf("%s[%d].cert", tlsCertificates, i), certificate.CertFile)
+			}
+		}
+	}
+	v.file(tlsTrustStoreFile)
+	for _, upstream := range Upstreams {
+		if certificate := conf.UpstreamTlsConfig(upstream).ClientCertificate; certificate != nil {
+			key := tlsClients + "." + string(upstream)
+			v.checkFile(key+".key", certificate.KeyFile)
+			v.checkFile(key+".cert", certificate.CertFile)
+		}
+	}
+
+	for _, upstream := range Upstreams {
+		v.upstreamAuth(conf, upstream)
+
+		prefix := resilience + "." + string(upstream) + "."
+		v.duration(prefix + "timeout")
+		v.duration(prefix + "open_duration")
+		v.count(prefix + "max_retries")
+		v.count(prefix + "failure_threshold")
+	}
+
+	v.duration(consentMaxAge)
+	v.directory(templatesDirectory)
+	v.directory(staticDirectory)
+
+	configuredThemes := conf.Themes()
+	for name, theme := range configuredThemes {
+		if len(theme.TemplateDir) != 0 {
+			v.checkDirectory(fmt.Sprintf("%s.%s.template_dir", themes, name), theme.TemplateDir)
+		}
+	}
+	for client, theme := range conf.ClientThemes() {
+		if _, ok := configuredThemes[theme]; !ok {
+			v.problem("%s.%s: theme %q is not configured", clientThemes, client, theme)
+		}
+	}
+
+	v.optionalUrl(logoutDefaultRedirectUrl)
+	v.optionalUrl(logoutIssuer)
+	v.file(logoutBackChannelSigningKey)
+	v.duration(logoutBackChannelTimeout)
+	webhooks := conf.LogoutConfig().BackChannel.Webhooks
+	for i, webhook := range webhooks {
+		key := fmt.Sprintf("%s[%d].url", logoutBackChannelWebhooks, i)
+		if _, err := parseUrlValue(key, webhook.Url); err != nil {
+			v.report(err)
+		}
+	}
+	if len(webhooks) != 0 && len(v.v.GetString(logoutBackChannelSigningKey)) == 0 {
+		v.problem("%s: is required to notify the configured webhooks", logoutBackChannelSigningKey)
+	}
+
+	if accounts, err := conf.AccountsConfig(); err != nil {
+		v.report(err)
+	} else if len(accounts.CookieSecret) != 0 && len(accounts.CookieSecret) < minSecretLength {
+		v.problem("%s: must be at least %d characters long", accountsCookieSecret, minSecretLength)
+	}
+	v.count(accountsMax)
+	v.duration(accountsMaxAge)
+
+	_, err = conf.PublicUrl()
+	v.report(err)
+	_, err = conf.TrustedProxies()
+	v.report(err)
+
+	users := conf.UsersConfig()
+	switch users.Store {
+	case UsersStoreNone, UsersStoreMemory:
+	case UsersStoreFile:
+		if len(users.File) == 0 {
+			v.problem("%s: is required for the %s store", usersFile, UsersStoreFile)
+		}
+	default:
+		v.problem("%s: unsupported store %q, use one of %s or %s", usersStore, users.Store, UsersStoreMemory, UsersStoreFile)
+	}
+
+	registration := conf.RegistrationConfig()
+	for i, field := range registration.Fields {
+		if !utils.Contains(RegistrationFields, field.Name) {
+			v.problem("%s[%d].name: unsupported field %q, use one of %s", registrationFields, i, field.Name,
+				strings.Join(RegistrationFields, ", "))
+		}
+	}
+	v.duration(registrationVerificationTtl)
+	v.duration(passwordResetTokenTtl)
+	v.count(passwordResetMaxPerAddress)
+	v.duration(passwordResetAddressWindow)
+	v.duration(passwordlessTtl)
+	v.count(passwordlessMaxAttempts)
+	v.count(passwordlessMaxPerAddress)
+	v.duration(passwordlessAddressWindow)
+	v.count(passwordPolicyMinLength)
+	v.directory(passwordPolicyBreachedDirectory)
+
+	mail, err := conf.MailConfig()
+	v.report(err)
+	if value := v.v.GetString(mailSmtpPort); len(value) != 0 {
+		if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+			v.problem("%s: %q is not a valid port", mailSmtpPort, value)
+		}
+	}
+
+	// the registration, the password reset and the passwordless login send links to the users in the user store
+	requireMail := func(feature string) {
+		required := func(key string, value string) {
+			if len(value) == 0 {
+				v.problem("%s: is required to enable the %s", key, feature)
+			}
+		}
+		required(usersStore, users.Store)
+		required(publicUrl, v.v.GetString(publicUrl))
+		if mail != nil {
+			required(mailFrom, mail.From)
+			required(mailSmtpHost, mail.Host)
+		}
+	}
+	if registration.Enabled {
+		requireMail("registration")
+	}
+	if conf.PasswordResetConfig().Enabled {
+		requireMail("password reset")
+	}
+	if conf.PasswordlessConfig().Enabled {
+		requireMail("passwordless login")
+	}
+
+	sms := conf.SmsConfig()
+	switch sms.Gateway {
+	case SmsGatewayNone:
+		if sms.SecondFactor || sms.VerifyPhone {
+			v.problem("%s: is required to send codes by SMS", smsGateway)
+		}
+	case SmsGatewayWebhook:
+		if len(sms.WebhookUrl) == 0 {
+			v.problem("%s: is required for the %s gateway", smsWebhookUrl, SmsGatewayWebhook)
+		}
+	case SmsGatewayFile:
+		if len(sms.File) == 0 {
+			v.problem("%s: is required for the %s gateway", smsFile, SmsGatewayFile)
+		}
+	default:
+		v.problem("%s: unsupported gateway %q, use one of %s or %s", smsGateway, sms.Gateway, SmsGatewayWebhook, SmsGatewayFile)
+	}
+	v.optionalUrl(smsWebhookUrl)
+	v.duration(smsCodeTtl)
+	v.count(smsMaxPerNumber)
+	v.duration(smsNumberWindow)
+	v.count(smsMaxAttempts)
+
+	v.count(deviceMaxAttempts)
+	v.duration(deviceAttemptWindow)
+
+	if audit, err := conf.AuditConfig(); err != nil {
+		v.report(err)
+	} else {
+		if len(audit.File) != 0 {
+			v.checkDirectory(auditFile, filepath.Dir(audit.File))
+		}
+		switch audit.SyslogNetwork {
+		case "":
+		case "udp", "tcp", "unix", "unixgram":
+			if len(audit.SyslogAddress) == 0 {
+				v.problem("%s: is required for the %s network", auditSyslogAddress, audit.SyslogNetwork)
+			}
+		default:
+			v.problem("%s: unsupported network %q, use one of udp, tcp, unix or unixgram", auditSyslogNetwork, audit.SyslogNetwork)
+		}
+		if len(audit.HmacKey) != 0 && len(audit.HmacKey) < minSecretLength {
+			v.problem("%s: must be at least %d characters long", auditHmacKey, minSecretLength)
+		}
+	}
+	v.count(auditMaxSize)
+	v.count(auditMaxBackups)
+	v.optionalUrl(auditWebhookUrl)
+
+	if len(v.problems) != 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+func (v *validator) upstreamAuth(conf Configuration, upstream Upstream) {
+	auth, err := conf.UpstreamAuthConfig(upstream)
+	if err != nil {
+		v.report(err)
+		return
+	}
+
+	prefix := upstreamAuth + "." + string(upstream) + "."
+	required := func(name, value string) {
+		if len(value) == 0 {
+			v.problem("%s%s: is required for %s authentication", prefix, name, auth.Type)
+		}
+	}
+
+	switch auth.Type {
+	case AuthNone:
+	case AuthBearer:
+		required("token", auth.Token)
+	case AuthBasic:
+		required("username", auth.Username)
+		required("password", auth.Password)
+	case AuthClientCredentials:
+		required("client_id", auth.ClientId)
+		required("client_secret", auth.ClientSecret)
+		_, err := parseUrlValue(prefix+"token_url", auth.TokenUrl)
+		v.report(err)
+	default:
+		v.problem("%stype: unsupported authentication %q, use one of %s, %s or %s", prefix, auth.Type,
+			AuthBearer, AuthBasic, AuthClientCredentials)
+	}
+}
+
+type validator struct {
+	v        *viper.Viper
+	problems []string
+}
+
+func (v *validator) problem(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) report(err error) {
+	if err != nil {
+		v.problems = append(v.problems, err.Error())
+	}
+}
+
+// file checks the file configured for the given key exists if set
+func (v *validator) file(key string) {
+	if value := v.v.GetString(key); len(value) != 0 {
+		v.checkFile(key, value)
+	}
+}
+
+func (v *validator) checkFile(key, value string) {
+	if len(value) == 0 {
+		v.problem("%s: is required", key)
+	} else if info, err := os.Stat(value); err != nil {
+		v.problem("%s: file %q is not available", key, value)
+	} else if info.IsDir() {
+		v.problem("%s: %q is a directory", key, value)
+	}
+}
+
+// directory checks the directory configured for the given key exists if set
+func (v *validator) directory(key string) {
+	if value := v.v.GetString(key); len(value) != 0 {
+		v.checkDirectory(key, value)
+	}
+}
+
+func (v *validator) checkDirectory(key, value string) {
+	if info, err := os.Stat(value); err != nil {
+		v.problem("%s: directory %q is not available", key, value)
+	} else if !info.IsDir() {
+		v.problem("%s: %q is not a directory", key, value)
+	}
+}
+
+func (v *validator) duration(key string) {
+	value := v.v.Get(key)
+	if value == nil {
+		return
+	}
+	if duration, err := cast.ToDurationE(value); err != nil {
+		v.problem("%s: %q is not a valid duration, e.g. 720h", key, value)
+	} else if duration < 0 {
+		v.problem("%s: must not be negative", key)
+	}
+}
+
+// count checks the value configured for the given key is a non negative number
+func (v *validator) count(key string) {
+	value := v.v.Get(key)
+	if value == nil {
+		return
+	}
+	if count, err := cast.ToIntE(value); err != nil || count < 0 {
+		v.problem("%s: %v is not a valid number, it must not be negative", key, value)
+	}
+}
+
+func (v *validator) optionalUrl(key string) {
+	if value := v.v.GetString(key); len(value) != 0 {
+		_, err := parseUrlValue(key, value)
+		v.report(err)
+	}
+}
@@ -0,0 +1,386 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setValidConfig activates a valid configuration and returns it to be modified by the tests
+func setValidConfig() *viper.Viper {
+	v, _ := newViper("")
+	v.Set(hydraAdminUrl, "https://127.0.0.1:4445")
+	v.Set(authenticateUrl, "http://127.0.0.1:8090/authenticate")
+	store.Store(v)
+	return v
+}
+
+func TestValidateAcceptsValidConfig(t *testing.T) {
+	// GIVEN
+	setValidConfig()
+
+	// WHEN
+	err := Validate(NewConfiguration())
+
+	// THEN
+	assert.NoError(t, err)
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	// GIVEN
+	v := setValidConfig()
+	v.Set(hydraAdminUrl, "")
+	v.Set(hydraApiVersion, "v3")
+	v.Set(authenticateUrl, "ftp://127.0.0.1/authenticate")
+	v.Set(registerUrl, "::not a url")
+	v.Set(logLevel, "verbose")
+	v.Set(port, "http")
+	v.Set(metricsAddress, "9090")
+	v.Set(tlsKeyFile, "/does/not/exist.pem")
+	v.Set(consentMaxAge, "a month")
+	v.Set(clientThemes, map[string]string{"foo": "acme"})
+
+	// WHEN
+	err := Validate(NewConfiguration())
+
+	// THEN
+	require.Error(t, err)
+	problems := err.(*ValidationError).Problems
+	assert.Len(t, problems, 10)
+	assert.Contains(t, problems, "hydra_admin_url: is required")
+	assert.Contains(t, problems, `hydra_api_version: unsupported version "v3", use one of auto, v1 or v2`)
+	assert.Contains(t, problems, `authenticate_url: "ftp://127.0.0.1/authenticate" is not an absolute http or https url`)
+	assert.Contains(t, problems, `log.level: unsupported level "verbose", supported are panic, fatal, error, warn, info and debug`)
+	assert.Contains(t, problems, `port: "http" is not a valid port`)
+	assert.Contains(t, problems, `metrics_address: "9090" is not a valid address, use host:port`)
+	assert.Contains(t, problems, "tls: configured TLS key not available")
+	assert.Contains(t, problems, `consent.max_age: "a month" is not a valid duration, e.g. 720h`)
+	assert.Contains(t, problems, `client_themes.foo: theme "acme" is not configured`)
+}
+
+func TestValidateRequiresSigningKeyForWebhooks(t *testing.T) {
+	// GIVEN
+	v := setValidConfig()
+	v.Set(logoutBackChannelWebhooks, []map[string]string{{"url": "https://sessions.example.com/logout"}})
+
+	// WHEN
+	err := Validate(NewConfiguration())
+
+	// THEN
+	require.Error(t, err)
+	assert.Equal(t, []string{"logout.back_channel.signing_key: is required to notify the configured webhooks"},
+		err.(*ValidationError).Problems)
+}
+
+func TestValidateChecksTlsSettings(t *testing.T) {
+	// GIVEN
+	dir := t.TempDir()
+	file := filepath.Join(dir, "tls.pem")
+	require.NoError(t, ioutil.WriteFile(file, []byte("pem"), 0600))
+
+	v := setValidConfig()
+	v.Set(tlsKeyFile, file)
+	v.Set(tlsCertFile, file)
+	v.Set(tlsMinVersion, "1.4")
+	v.Set(tlsCipherSuites, []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"})
+	v.Set(tlsCertificates, []map[string]string{{"key": file, "cert": filepath.Join(dir, "missing.pem")}})
+
+	// WHEN
+	err := Validate(NewConfiguration())
+
+	// THEN
+	require.Error(t, err)
+	assert.Equal(t, []string{
+		`tls.min_version: unsupported TLS version "1.4", use one of 1.0, 1.1, 1.2 or 1.3`,
+		`tls.cipher_suites: unsupported cipher suite "TLS_RSA_WITH_RC4_128_SHA"`,
+		fmt.Sprintf("tls.certificates[0].cert: file %q is not available", filepath.Join(dir, "missing.pem")),
+	}, err.(*ValidationError).Problems)
+}
+
+func TestValidateChecksClientCertificates(t *testing.T) {
+	// GIVEN
+	dir := t.TempDir()
+	file := filepath.Join(dir, "client.pem")
+	require.NoError(t, ioutil.WriteFile(file, []byte("pem"), 0600))
+
+	v := setValidConfig()
+	v.Set(tlsClients+".hydra_admin.key", file)
+	v.Set(tlsClients+".hydra_admin.cert", file)
+	v.Set(tlsClients+".authenticate.cert", file)
+
+	// WHEN
+	err := Validate(NewConfiguration())
+
+	// THEN
+	require.Error(t, err)
+	assert.Equal(t, []string{"tls.clients.authenticate.key: is required"}, err.(*ValidationError).Problems)
+	assert.Equal(t, &Certificate{KeyFile: file, CertFile: file},
+		NewConfiguration().UpstreamTlsConfig(HydraAdmin).ClientCertificate)
+}
+
+func TestUpstreamAuthConfigReadsSecretsFromFilesAndEnvironment(t *testing.T) {
+	// GIVEN
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, ioutil.WriteFile(secretFile, []byte("from-file\n"), 0600))
+	require.NoError(t, os.Setenv("AUTH_AUTHENTICATE_PASSWORD", "from-env"))
+	defer os.Unsetenv("AUTH_AUTHENTICATE_PASSWORD")
+
+	v := setValidConfig()
+	v.Set(upstreamAuth+".hydra_admin.type", AuthClientCredentials)
+	v.Set(upstreamAuth+".hydra_admin.client_id", "login-provider")
+	v.Set(upstreamAuth+".hydra_admin.client_secret_file", secretFile)
+	v.Set(upstreamAuth+".hydra_admin.token_url", "https://127.0.0.1:4444/oauth2/token")
+	v.Set(upstreamAuth+".authenticate.type", AuthBasic)
+	v.Set(upstreamAuth+".authenticate.username", "login-provider")
+
+	conf := NewConfiguration()
+
+	// WHEN
+	hydraAuth, hydraErr := conf.UpstreamAuthConfig(HydraAdmin)
+	authenticateAuth, authenticateErr := conf.UpstreamAuthConfig(Authenticate)
+
+	// THEN
+	require.NoError(t, hydraErr)
+	require.NoError(t, authenticateErr)
+	assert.Equal(t, "from-file", hydraAuth.ClientSecret)
+	assert.Equal(t, "from-env", authenticateAuth.Password)
+	assert.NoError(t, Validate(conf))
+}
+
+func TestValidateChecksUpstreamAuth(t *testing.T) {
+	// GIVEN
+	v := setValidConfig()
+	v.Set(upstreamAuth+".hydra_admin.type", AuthClientCredentials)
+	v.Set(upstreamAuth+".hydra_admin.client_id", "login-provider")
+	v.Set(upstreamAuth+".hydra_admin.client_secret_file", "/does/not/exist")
+	v.Set(upstreamAuth+".authenticate.type", "kerberos")
+
+	// WHEN
+	err := Validate(NewConfiguration())
+
+	// THEN
+	require.Error(t, err)
+	problems := err.(*ValidationError).Problems
+	require.Len(t, problems, 2)
+	assert.Contains(t, problems[0], "auth.hydra_admin.client_secret_file: failed to read secret")
+	assert.Equal(t, `auth.authenticate.type: unsupported authentication "kerberos", use one of bearer, basic or client_credentials`, problems[1])
+}
+
+func TestValidateChecksResilienceSettings(t *testing.T) {
+	// GIVEN
+	v := setValidConfig()
+	v.Set(resilience+".hydra_admin.timeout", "soon")
+	v.Set(resilience+".authenticate.max_retries", -1)
+
+	// WHEN
+	err := Validate(NewConfiguration())
+
+	// THEN
+	require.Error(t, err)
+	assert.Equal(t, []string{
+		`resilience.hydra_admin.timeout: "soon" is not a valid duration, e.g. 720h`,
+		`resilience.authenticate.max_retries: -1 is not a valid number, it must not be negative`,
+	}, err.(*ValidationError).Problems)
+}
+
+func TestValidateRequiresLongCookieSecret(t *testing.T) {
+	// GIVEN
+	v := setValidConfig()
+	v.Set(accountsCookieSecret, "too short")
+
+	// WHEN
+	err := Validate(NewConfiguration())
+
+	// THEN
+	require.Error(t, err)
+	assert.Equal(t, []string{"accounts.cookie_secret: must be at least 32 characters long"}, err.(*ValidationError).Problems)
+}
+
+func TestValidateChecksRegistrationRequirements(t *testing.T) {
+	// GIVEN
+	v := setValidConfig()
+	v.Set(registrationEnabled, true)
+	v.Set(registrationFields, []map[string]interface{}{{"name": "first_name"}, {"name": "age"}})
+	v.Set(mailSmtpHost, "smtp.example.com")
+
+	// WHEN
+	err := Validate(NewConfiguration())
+
+	// THEN
+	require.Error(t, err)
+	assert.ElementsMatch(t, []string{
+		`registration.fields[1].name: unsupported field "age", use one of first_name, last_name, user_name, phone`,
+		"users.store: is required to enable the registration",
+		"public_url: is required to enable the registration",
+		"mail.from: is required to enable the registration",
+	}, err.(*ValidationError).Problems)
+}
+
+func TestValidateChecksPasswordResetRequirements(t *testing.T) {
+	// GIVEN
+	v := setValidConfig()
+	v.Set(passwordResetEnabled, true)
+	v.Set(passwordResetTokenTtl, "soon")
+	v.Set(usersStore, UsersStoreMemory)
+	v.Set(publicUrl, "https://login.example.com")
+	v.Set(mailFrom, "login@example.com")
+
+	// WHEN
+	err := Validate(NewConfiguration())
+
+	// THEN
+	require.Error(t, err)
+	assert.ElementsMatch(t, []string{
+		`password_reset.token_ttl: "soon" is not a valid duration, e.g. 720h`,
+		"mail.smtp.host: is required to enable the password reset",
+	}, err.(*ValidationError).Problems)
+}
+
+func TestValidateChecksSmsGateway(t *testing.T) {
+	// GIVEN
+	v := setValidConfig()
+	v.Set(smsGateway, SmsGatewayWebhook)
+	v.Set(smsSecondFactor, true)
+	v.Set(smsNumberWindow, "often")
+
+	// WHEN
+	err := Validate(NewConfiguration())
+
+	// THEN
+	require.Error(t, err)
+	assert.ElementsMatch(t, []string{
+		"sms.webhook_url: is required for the webhook gateway",
+		`sms.number_window: "often" is not a valid duration, e.g. 720h`,
+	}, err.(*ValidationError).Problems)
+}
+
+func TestValidateChecksPasswordlessRequirements(t *testing.T) {
+	// GIVEN
+	v := setValidConfig()
+	v.Set(passwordlessEnabled, true)
+	v.Set(passwordlessMaxAttempts, -1)
+	v.Set(passwordlessAddressWindow, "often")
+	v.Set(publicUrl, "https://login.example.com")
+	v.Set(mailFrom, "login@example.com")
+	v.Set(mailSmtpHost, "smtp.example.com")
+
+	// WHEN
+	err := Validate(NewConfiguration())
+
+	// THEN
+	require.Error(t, err)
+	assert.ElementsMatch(t, []string{
+		"passwordless.max_attempts: -1 is not a valid number, it must not be negative",
+		`passwordless.address_window: "often" is not a valid duration, e.g. 720h`,
+		"users.store: is required to enable the passwordless login",
+	}, err.(*ValidationError).Problems)
+}
+
+func TestValidateChecksBreachedDirectory(t *testing.T) {
+	// GIVEN
+	v := setValidConfig()
+	v.Set(passwordPolicyBreachedDirectory, "/does/not/exist")
+
+	// WHEN
+	err := Validate(NewConfiguration())
+
+	// THEN
+	require.Error(t, err)
+	assert.Equal(t, []string{
+		`password_policy.breached_directory: directory "/does/not/exist" is not available`,
+	}, err.(*ValidationError).Problems)
+}
+
+func TestTypedUrls(t *testing.T) {
+	// GIVEN
+	setValidConfig()
+	conf := NewConfiguration()
+
+	// WHEN
+	hydra, hydraErr := conf.HydraAdminUrl()
+	register, registerErr := conf.RegisterUrl()
+
+	// THEN
+	require.NoError(t, hydraErr)
+	assert.Equal(t, "127.0.0.1:4445", hydra.Host)
+	assert.NoError(t, registerErr)
+	assert.Nil(t, register)
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	// GIVEN
+	v := setValidConfig()
+	v.Set("hydra.auth.client_secret", "very-secret")
+	v.Set("hydra.auth.client_id", "login-provider")
+	v.Set("hydra.auth.token_url", "https://127.0.0.1:4444/oauth2/token")
+	buf := &bytes.Buffer{}
+
+	// WHEN
+	err := Print(buf)
+
+	// THEN
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "client_id: login-provider")
+	assert.Contains(t, buf.String(), "client_secret: '******'")
+	assert.Contains(t, buf.String(), "token_url: https://127.0.0.1:4444/oauth2/token")
+	assert.NotContains(t, buf.String(), "very-secret")
+}
+
+func TestValidateChecksAuditSinks(t *testing.T) {
+	// GIVEN
+	v := setValidConfig()
+	v.Set(auditFile, "/does/not/exist/audit.log")
+	v.Set(auditSyslog, true)
+	v.Set(auditSyslogNetwork, "http")
+	v.Set(auditWebhookUrl, "::invalid")
+	v.Set(auditHmacKey, "short")
+
+	// WHEN
+	err := Validate(NewConfiguration())
+
+	// THEN
+	require.Error(t, err)
+	assert.Equal(t, []string{
+		`audit.file: directory "/does/not/exist" is not available`,
+		`audit.syslog.network: unsupported network "http", use one of udp, tcp, unix or unixgram`,
+		"audit.hmac_key: must be at least 32 characters long",
+		`audit.webhook_url: "::invalid" is not a valid url: parse "::invalid": missing protocol scheme`,
+	}, err.(*ValidationError).Problems)
+}
+
+func TestTrustedProxies(t *testing.T) {
+	// GIVEN
+	v := setValidConfig()
+	v.Set(trustedProxies, []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::1"})
+
+	// WHEN
+	networks, err := NewConfiguration().TrustedProxies()
+
+	// THEN
+	require.NoError(t, err)
+	require.Len(t, networks, 3)
+	assert.Equal(t, "10.0.0.0/8", networks[0].String())
+	assert.Equal(t, "192.0.2.1/32", networks[1].String())
+	assert.Equal(t, "2001:db8::1/128", networks[2].String())
+}
+
+func TestValidateChecksTrustedProxies(t *testing.T) {
+	// GIVEN
+	v := setValidConfig()
+	v.Set(trustedProxies, []string{"10.0.0.0/8", "proxy.example.com"})
+
+	// WHEN
+	err := Validate(NewConfiguration())
+
+	// THEN
+	require.Error(t, err)
+	assert.Equal(t, []string{
+		`trusted_proxies: "proxy.example.com" is neither an IP address nor a network`,
+	}, err.(*ValidationError).Problems)
+}
@@ -0,0 +1,146 @@
+package consent_policy
+
+import (
+	"login-provider/internal/config"
+	"login-provider/internal/utils"
+	"time"
+)
+
+// defaultRememberFor is the period of time in seconds a consent is remembered by hydra
+// if no maximum age is configured
+const defaultRememberFor = 3600
+
+// Grant represents a consent previously given by the user
+type Grant struct {
+	ClientID  string
+	Scopes    []string
+	GrantedAt time.Time
+}
+
+// Request holds everything required to decide whether the user has to be asked for consent.
+// Trusted, SkipScopes and MaxAge are the client specific settings from the client metadata.
+// These extend, respectively override the global policy.
+type Request struct {
+	ClientID        string
+	AskConsent      bool
+	Trusted         bool
+	SkipScopes      []string
+	MaxAge          time.Duration
+	HydraSkip       bool
+	RequestedScopes []string
+	PreviousGrants  []Grant
+}
+
+type Decision struct {
+	// AutoApprove is true if consent can be granted without asking the user
+	AutoApprove bool
+	// Reason describes why the decision has been made
+	Reason string
+	// ImplicitScopes are the requested scopes, which are granted without asking the user
+	ImplicitScopes []string
+	// ConsentScopes are the requested scopes, the user has to consent to
+	ConsentScopes []string
+	// RememberFor is the period of time in seconds the consent shall be remembered by hydra
+	RememberFor int64
+}
+
+// Engine evaluates the globally configured consent policy together with the client specific
+// settings to decide whether the user has to be asked for consent
+type Engine struct {
+	policy *config.ConsentConfig
+	now    func() time.Time
+}
+
+func NewEngine(policy *config.ConsentConfig) *Engine {
+	return &Engine{policy: policy, now: time.Now}
+}
+
+func (e *Engine) Evaluate(r *Request) *Decision {
+	maxAge := e.maxAge(r)
+	decision := &Decision{
+		ImplicitScopes: e.ImplicitScopes(r.RequestedScopes, r.SkipScopes),
+		RememberFor:    defaultRememberFor,
+	}
+	if maxAge > 0 {
+		decision.RememberFor = int64(maxAge / time.Second)
+	}
+
+	for _, scope := range r.RequestedScopes {
+		if !utils.Contains(decision.ImplicitScopes, scope) {
+			decision.ConsentScopes = append(decision.ConsentScopes, scope)
+		}
+	}
+
+	switch {
+	case r.Trusted || utils.Contains(e.policy.TrustedClients, r.ClientID):
+		return decision.approve("trusted client")
+	case !r.AskConsent:
+		return decision.approve("client does not require consent")
+	case len(decision.ConsentScopes) == 0:
+		return decision.approve("only implicitly granted scopes requested")
+	case !r.HydraSkip:
+		return decision.ask("no remembered consent")
+	}
+
+	last := lastGrant(r.ClientID, r.PreviousGrants)
+	switch {
+	case last == nil && maxAge > 0:
+		return decision.ask("age of previous consent unknown")
+	case last == nil:
+		return decision.approve("consent remembered by hydra")
+	case maxAge > 0 && e.now().Sub(last.GrantedAt) > maxAge:
+		return decision.ask("previous consent expired")
+	}
+
+	for _, scope := range decision.ConsentScopes {
+		if !utils.Contains(last.Scopes, scope) {
+			return decision.ask("new scopes requested since last consent")
+		}
+	}
+
+	return decision.approve("previous consent covers all requested scopes")
+}
+
+// ImplicitScopes returns those of the requested scopes, which do not require consent
+// according to the global policy and the given client specific skip scopes
+func (e *Engine) ImplicitScopes(requested, clientSkipScopes []string) []string {
+	implicit := []string{}
+	for _, scope := range requested {
+		if utils.Contains(e.policy.SkipScopes, scope) || utils.Contains(clientSkipScopes, scope) {
+			implicit = append(implicit, scope)
+		}
+	}
+	return implicit
+}
+
+func (e *Engine) maxAge(r *Request) time.Duration {
+	if r.MaxAge > 0 {
+		return r.MaxAge
+	}
+	return e.policy.MaxAge
+}
+
+func (d *Decision) approve(reason string) *Decision {
+	d.AutoApprove = true
+	d.Reason = reason
+	return d
+}
+
+func (d *Decision) ask(reason string) *Decision {
+	d.AutoApprove = false
+	d.Reason = reason
+	return d
+}
+
+func lastGrant(clientID string, grants []Grant) *Grant {
+	var last *Grant
+	for i := range grants {
+		if grants[i].ClientID != clientID {
+			continue
+		}
+		if last == nil || grants[i].GrantedAt.After(last.GrantedAt) {
+			last = &grants[i]
+		}
+	}
+	return last
+}
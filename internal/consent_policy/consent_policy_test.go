@@ -0,0 +1,117 @@
+package consent_policy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"login-provider/internal/config"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestEngine(policy *config.ConsentConfig) *Engine {
+	engine := NewEngine(policy)
+	engine.now = func() time.Time { return now }
+	return engine
+}
+
+func TestTrustedClientsAreApprovedAutomatically(t *testing.T) {
+	// GIVEN
+	engine := newTestEngine(&config.ConsentConfig{TrustedClients: []string{"first-party"}})
+
+	// WHEN
+	byConfig := engine.Evaluate(&Request{ClientID: "first-party", AskConsent: true, RequestedScopes: []string{"email"}})
+	byMetadata := engine.Evaluate(&Request{ClientID: "other", AskConsent: true, Trusted: true, RequestedScopes: []string{"email"}})
+	untrusted := engine.Evaluate(&Request{ClientID: "other", AskConsent: true, RequestedScopes: []string{"email"}})
+
+	// THEN
+	assert.True(t, byConfig.AutoApprove)
+	assert.True(t, byMetadata.AutoApprove)
+	assert.False(t, untrusted.AutoApprove)
+}
+
+func TestSkipScopesAreGrantedImplicitly(t *testing.T) {
+	// GIVEN
+	engine := newTestEngine(&config.ConsentConfig{SkipScopes: []string{"openid"}})
+
+	// WHEN
+	onlySkipped := engine.Evaluate(&Request{
+		ClientID:        "foo",
+		AskConsent:      true,
+		SkipScopes:      []string{"offline"},
+		RequestedScopes: []string{"openid", "offline"},
+	})
+	mixed := engine.Evaluate(&Request{
+		ClientID:        "foo",
+		AskConsent:      true,
+		RequestedScopes: []string{"openid", "email"},
+	})
+
+	// THEN
+	assert.True(t, onlySkipped.AutoApprove)
+	assert.False(t, mixed.AutoApprove)
+	assert.Equal(t, []string{"openid"}, mixed.ImplicitScopes)
+	assert.Equal(t, []string{"email"}, mixed.ConsentScopes)
+}
+
+func TestNewScopesRequireFreshConsent(t *testing.T) {
+	// GIVEN
+	engine := newTestEngine(&config.ConsentConfig{})
+	grants := []Grant{
+		{ClientID: "foo", Scopes: []string{"email"}, GrantedAt: now.Add(-2 * time.Hour)},
+		{ClientID: "bar", Scopes: []string{"email", "profile"}, GrantedAt: now.Add(-1 * time.Hour)},
+	}
+
+	// WHEN
+	covered := engine.Evaluate(&Request{
+		ClientID: "foo", AskConsent: true, HydraSkip: true,
+		RequestedScopes: []string{"email"}, PreviousGrants: grants,
+	})
+	extended := engine.Evaluate(&Request{
+		ClientID: "foo", AskConsent: true, HydraSkip: true,
+		RequestedScopes: []string{"email", "profile"}, PreviousGrants: grants,
+	})
+
+	// THEN
+	assert.True(t, covered.AutoApprove)
+	assert.False(t, extended.AutoApprove)
+}
+
+func TestExpiredConsentRequiresFreshConsent(t *testing.T) {
+	// GIVEN
+	engine := newTestEngine(&config.ConsentConfig{MaxAge: 24 * time.Hour})
+	grants := []Grant{{ClientID: "foo", Scopes: []string{"email"}, GrantedAt: now.Add(-25 * time.Hour)}}
+
+	// WHEN
+	globalMaxAge := engine.Evaluate(&Request{
+		ClientID: "foo", AskConsent: true, HydraSkip: true,
+		RequestedScopes: []string{"email"}, PreviousGrants: grants,
+	})
+	clientMaxAge := engine.Evaluate(&Request{
+		ClientID: "foo", AskConsent: true, HydraSkip: true, MaxAge: 48 * time.Hour,
+		RequestedScopes: []string{"email"}, PreviousGrants: grants,
+	})
+	unknownAge := engine.Evaluate(&Request{
+		ClientID: "foo", AskConsent: true, HydraSkip: true,
+		RequestedScopes: []string{"email"},
+	})
+
+	// THEN
+	assert.False(t, globalMaxAge.AutoApprove)
+	assert.Equal(t, int64(24*60*60), globalMaxAge.RememberFor)
+	assert.True(t, clientMaxAge.AutoApprove)
+	assert.Equal(t, int64(48*60*60), clientMaxAge.RememberFor)
+	assert.False(t, unknownAge.AutoApprove)
+}
+
+func TestClientsNotAskingForConsentAreApprovedAutomatically(t *testing.T) {
+	// GIVEN
+	engine := newTestEngine(&config.ConsentConfig{})
+
+	// WHEN
+	decision := engine.Evaluate(&Request{ClientID: "foo", RequestedScopes: []string{"email"}})
+
+	// THEN
+	assert.True(t, decision.AutoApprove)
+	assert.Equal(t, int64(defaultRememberFor), decision.RememberFor)
+}
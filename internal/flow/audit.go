@@ -0,0 +1,12 @@
+package flow
+
+import "login-provider/internal/hydra"
+
+// clientID returns the id of the client recorded with audit events, respectively an empty string if
+// hydra did not report the client
+func clientID(client *hydra.Client) string {
+	if client == nil {
+		return ""
+	}
+	return client.ClientID
+}
@@ -0,0 +1,227 @@
+package flow
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/audit"
+	"login-provider/internal/client_meta"
+	"login-provider/internal/consent_policy"
+	"login-provider/internal/hydra"
+	"login-provider/internal/profile_api"
+	"login-provider/internal/utils"
+	"time"
+)
+
+// Consent holds the information about a consent request
+type Consent struct {
+	Challenge      string
+	Request        *hydra.ConsentRequest
+	ClientMeta     *client_meta.ClientMetaInfo
+	Authentication *profile_api.AuthenticationResponse
+	// Decision is the decision of the consent policy engine
+	Decision *consent_policy.Decision
+}
+
+// ConsentDecision is the decision of the user about a consent request
+type ConsentDecision struct {
+	Approved         bool
+	GrantedScopes    []string
+	GrantedAudiences []string
+	Remember         bool
+}
+
+// UILocales returns the locales requested by the client for the consent UI
+func (c *Consent) UILocales() []string {
+	if c.Request.OidcContext == nil {
+		return nil
+	}
+	return c.Request.OidcContext.UILocales
+}
+
+// ScopeInfos returns the information about the requested scopes the user has to consent to.
+// Scopes granted implicitly by the consent policy are not included.
+func (c *Consent) ScopeInfos(locale string) []client_meta.ScopeInfo {
+	return c.ClientMeta.CreateScopeInfos(c.Decision.ConsentScopes, locale)
+}
+
+func (c *Consent) AudienceInfos(locale string) []client_meta.AudienceInfo {
+	return c.ClientMeta.CreateAudienceInfos(c.Request.RequestedAccessTokenAudience, locale)
+}
+
+// GetConsent retrieves the consent request for the given challenge and evaluates the consent
+// policy for it
+func (s *Service) GetConsent(ctx context.Context, challenge string) (*Consent, error) {
+	logger := log.Ctx(ctx)
+
+	request, err := s.admin.GetConsentRequest(ctx, challenge)
+	if err != nil {
+		logger.Err(err).Msg("Error while communicating with hydra to get consent request")
+		return nil, &HydraError{Operation: "get consent request", Err: err}
+	}
+
+	consent := &Consent{
+		Challenge:      challenge,
+		Request:        request,
+		ClientMeta:     &client_meta.ClientMetaInfo{},
+		Authentication: &profile_api.AuthenticationResponse{},
+	}
+
+	// raw data contains the url to the users profile, as well as all the data, which can be retrieved
+	// from that endpont. So parse it and set the values in AccessToken and IDToken accordingly taking
+	// granted scopes into account
+	_ = consent.Authentication.Unmarshal(request.Context)
+
+	// check whether consent is required for given client and which consent values are required
+	if request.Client != nil {
+		_ = consent.ClientMeta.Unmarshal(request.Client.Metadata)
+	} else {
+		request.Client = &hydra.Client{}
+	}
+
+	// make sure the client gets only those groups and roles it is allowed to see
+	user := &consent.Authentication.User
+	user.Groups = consent.ClientMeta.GroupsFilter.Apply(user.Groups)
+	user.Roles = consent.ClientMeta.RolesFilter.Apply(user.Roles)
+
+	consent.Decision = consent_policy.NewEngine(s.conf.ConsentConfig()).
+		Evaluate(s.newPolicyRequest(ctx, request, consent.ClientMeta))
+	logger.Debug().
+		Bool("_auto_approve", consent.Decision.AutoApprove).
+		Str("_reason", consent.Decision.Reason).
+		Msg("Consent policy evaluated")
+
+	return consent, nil
+}
+
+// AcceptConsentAutomatically grants all requested scopes and audiences. It is used if the consent
+// policy decided the user does not need to be asked.
+func (s *Service) AcceptConsentAutomatically(ctx context.Context, consent *Consent) (string, error) {
+	return s.acceptConsent(ctx, consent,
+		consent.Request.RequestedScope,
+		consent.Request.RequestedAccessTokenAudience,
+		false)
+}
+
+// DecideConsent rejects or accepts the consent request according to the decision of the user.
+// Only requested scopes and audiences are granted. If the decision contains a scope, which has not
+// been requested, client_meta.ErrScopeNotRequested is returned, respectively
+// client_meta.ErrAudienceNotRequested for an audience.
+func (s *Service) DecideConsent(ctx context.Context, consent *Consent, decision *ConsentDecision) (string, error) {
+	logger := log.Ctx(ctx)
+
+	if !decision.Approved {
+		redirectTo, err := s.admin.RejectConsentRequest(ctx, consent.Challenge, &hydra.Rejection{
+			Error:     "User rejected consent",
+			ErrorHint: "consent_rejected",
+		})
+		if err != nil {
+			logger.Err(err).Msg("Error while communicating with hydra to reject consent request")
+			return "", &HydraError{Operation: "reject consent request", Err: err}
+		}
+
+		s.auditor.Emit(ctx, &audit.Event{
+			Type:      audit.ConsentDenied,
+			Subject:   consent.Request.Subject,
+			ClientID:  clientID(consent.Request.Client),
+			Scopes:    consent.Request.RequestedScope,
+			Audiences: consent.Request.RequestedAccessTokenAudience,
+		})
+		return redirectTo, nil
+	}
+
+	grantedScopes, err := consent.ClientMeta.GrantScopes(consent.Request.RequestedScope, decision.GrantedScopes)
+	if err != nil {
+		logger.Warn().
+			Strs("_requested_scopes", consent.Request.RequestedScope).
+			Strs("_submitted_scopes", decision.GrantedScopes).
+			Msg("Submitted consent contains scopes, which have not been requested")
+		return "", err
+	}
+	// scopes, which do not require consent, are granted implicitly
+	for _, scope := range consent.Decision.ImplicitScopes {
+		if !utils.Contains(grantedScopes, scope) {
+			grantedScopes = append(grantedScopes, scope)
+		}
+	}
+	grantedAudiences, err := consent.ClientMeta.GrantAudiences(consent.Request.RequestedAccessTokenAudience, decision.GrantedAudiences)
+	if err != nil {
+		logger.Warn().
+			Strs("_requested_audiences", consent.Request.RequestedAccessTokenAudience).
+			Strs("_submitted_audiences", decision.GrantedAudiences).
+			Msg("Submitted consent contains audiences, which have not been requested")
+		return "", err
+	}
+
+	return s.acceptConsent(ctx, consent, grantedScopes, grantedAudiences, decision.Remember)
+}
+
+func (s *Service) acceptConsent(ctx context.Context, consent *Consent, grantedScopes, grantedAudiences []string, remember bool) (string, error) {
+	ar := consent.Authentication
+
+	redirectTo, err := s.admin.AcceptConsentRequest(ctx, consent.Challenge, &hydra.AcceptConsent{
+		GrantAccessTokenAudience: grantedAudiences,
+		GrantScope:               grantedScopes,
+		RememberFor:              consent.Decision.RememberFor,
+		Remember:                 remember,
+		HandledAt:                time.Now(),
+		Session: &hydra.ConsentClaims{
+			IDToken:     ar.CreateIdTokenClaims(grantedScopes, s.conf.ClaimsConfig()),
+			AccessToken: ar.CreateAccessTokenClaims(grantedScopes, s.conf.ClaimsConfig()),
+		},
+	})
+	if err != nil {
+		log.Ctx(ctx).Err(err).Msg("Error while communicating with hydra to accept consent request")
+		return "", &HydraError{Operation: "accept consent request", Err: err}
+	}
+
+	event := &audit.Event{
+		Type:      audit.ConsentGranted,
+		Subject:   consent.Request.Subject,
+		ClientID:  clientID(consent.Request.Client),
+		Scopes:    grantedScopes,
+		Audiences: grantedAudiences,
+	}
+	if consent.Decision.AutoApprove {
+		event.Reason = consent.Decision.Reason
+	}
+	s.auditor.Emit(ctx, event)
+	return redirectTo, nil
+}
+
+// newPolicyRequest creates the request for the consent policy engine. If hydra remembers a
+// previous consent, the previous consents of the subject are fetched from hydra to let the
+// engine decide whether these are still valid
+func (s *Service) newPolicyRequest(ctx context.Context, cr *hydra.ConsentRequest, info *client_meta.ClientMetaInfo) *consent_policy.Request {
+	request := &consent_policy.Request{
+		ClientID:        cr.Client.ClientID,
+		AskConsent:      info.AskConsent,
+		Trusted:         info.ConsentPolicy.Trusted,
+		SkipScopes:      info.ConsentPolicy.SkipScopes,
+		MaxAge:          time.Duration(info.ConsentPolicy.MaxAge) * time.Second,
+		HydraSkip:       cr.Skip,
+		RequestedScopes: cr.RequestedScope,
+	}
+
+	if !cr.Skip {
+		return request
+	}
+
+	sessions, err := s.admin.ListConsentSessions(ctx, cr.Subject)
+	if err != nil {
+		// without previous consents the engine will ask the user if a maximum age is configured
+		log.Ctx(ctx).Warn().Err(err).Msg("Failed to retrieve previous consent sessions from hydra")
+		return request
+	}
+
+	for _, session := range sessions {
+		if session.ConsentRequest == nil || session.ConsentRequest.Client == nil {
+			continue
+		}
+		request.PreviousGrants = append(request.PreviousGrants, consent_policy.Grant{
+			ClientID:  session.ConsentRequest.Client.ClientID,
+			Scopes:    session.GrantScope,
+			GrantedAt: session.HandledAt,
+		})
+	}
+	return request
+}
@@ -0,0 +1,66 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/audit"
+	"login-provider/internal/hydra"
+	"net/http"
+	"strings"
+)
+
+// ErrInvalidUserCode is returned if hydra does not know the user code entered for a device challenge
+var ErrInvalidUserCode = errors.New("invalid user code")
+
+// ErrTooManyAttempts is returned if too many codes have been entered from the same client IP or
+// for the same challenge
+var ErrTooManyAttempts = errors.New("too many attempts")
+
+// ErrUnsupported is returned if the version of hydra does not support the requested flow
+var ErrUnsupported = hydra.ErrUnsupported
+
+// UserCode is the code shown by a device, which has been entered by the user to authorize the device
+type UserCode struct {
+	Challenge string
+	Code      string
+	// ClientIP is the address the code has been entered from. The number of attempts is limited per address
+	ClientIP string
+}
+
+// NormalizeUserCode removes separators and white space users tend to enter. User codes are case insensitive.
+func NormalizeUserCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "", "\t", "").Replace(code))
+}
+
+// VerifyUserCode lets hydra verify the user code for the device challenge. If the code is valid,
+// the user continues with the login and consent flows. The number of attempts per client IP and
+// per challenge is limited to protect the codes against brute-force attacks.
+func (s *Service) VerifyUserCode(ctx context.Context, userCode *UserCode) (string, error) {
+	logger := log.Ctx(ctx)
+	conf := s.conf.DeviceConfig()
+
+	ipKey := "ip:" + userCode.ClientIP
+	challengeKey := "challenge:" + userCode.Challenge
+	if !s.deviceAttempts.Allow(ipKey, conf.MaxAttempts, conf.AttemptWindow) ||
+		!s.deviceAttempts.Allow(challengeKey, conf.MaxAttempts, conf.AttemptWindow) {
+		logger.Warn().Str("_client_ip", userCode.ClientIP).Msg("Too many user codes entered")
+		s.auditor.Emit(ctx, &audit.Event{Type: audit.Lockout, Reason: "user_code"})
+		return "", ErrTooManyAttempts
+	}
+
+	redirectTo, err := s.admin.AcceptUserCodeRequest(ctx, userCode.Challenge, NormalizeUserCode(userCode.Code))
+	var hydraErr *hydra.Error
+	if errors.As(err, &hydraErr) && hydraErr.StatusCode >= http.StatusBadRequest && hydraErr.StatusCode < http.StatusInternalServerError {
+		logger.Warn().Err(err).Msg("Hydra rejected the user code")
+		return "", ErrInvalidUserCode
+	} else if err != nil {
+		logger.Err(err).Msg("Error while communicating with hydra to accept user code")
+		return "", &HydraError{Operation: "accept user code", Err: err}
+	}
+
+	// the attempts of the address are kept, otherwise codes of device flows started by the client
+	// itself would let it guess on
+	s.deviceAttempts.Reset(challengeKey)
+	return redirectTo, nil
+}
@@ -0,0 +1,82 @@
+package flow
+
+import (
+	"errors"
+	"login-provider/internal/audit"
+	"login-provider/internal/backchannel"
+	"login-provider/internal/config"
+	"login-provider/internal/hydra"
+	"login-provider/internal/mail"
+	"login-provider/internal/profile_api"
+	"login-provider/internal/rate_limit"
+	"login-provider/internal/sms"
+	"login-provider/internal/upstream"
+	"login-provider/internal/user_store"
+)
+
+// ErrInvalidCredentials is returned if the user could not be authenticated with the given credentials
+var ErrInvalidCredentials = errors.New("invalid user name or password")
+
+// ErrUnavailable is matched by errors.Is if hydra or the authentication service is temporarily
+// not available
+var ErrUnavailable = upstream.ErrUnavailable
+
+// HydraError is returned if the communication with hydra failed
+type HydraError struct {
+	Operation string
+	Err       error
+}
+
+func (e *HydraError) Error() string {
+	return "failed to " + e.Operation + ": " + e.Err.Error()
+}
+
+func (e *HydraError) Unwrap() error {
+	return e.Err
+}
+
+// Service implements the login, consent and logout flows. It is used by the HTML as well as
+// by the JSON API handlers.
+type Service struct {
+	admin    hydra.Admin
+	profiles *profile_api.Client
+	// users is nil if no user store is configured
+	users  user_store.Store
+	mailer mail.Sender
+	// smsGateway sends the codes to verify phone numbers
+	smsGateway sms.Gateway
+	notifier   *backchannel.Notifier
+	// auditor records logins, consent decisions, logouts and lockouts
+	auditor *audit.Emitter
+	conf    config.Configuration
+	// deviceAttempts counts the user codes entered per client IP and per device challenge
+	deviceAttempts *rate_limit.Limiter
+	// loginCodeAttempts counts the codes sent by email entered per login challenge, loginEmails the
+	// emails to log in and to reset the password sent per address
+	loginCodeAttempts *rate_limit.Limiter
+	loginEmails       *rate_limit.Limiter
+	// smsNumbers counts the codes sent per phone number, smsAttempts the ones entered per login challenge
+	smsNumbers    *rate_limit.Limiter
+	smsAttempts   *rate_limit.Limiter
+	pendingLogins *pendingLogins
+}
+
+func NewService(admin hydra.Admin, profiles *profile_api.Client, users user_store.Store, mailer mail.Sender,
+	smsGateway sms.Gateway, notifier *backchannel.Notifier, auditor *audit.Emitter, conf config.Configuration) *Service {
+	return &Service{
+		admin:             admin,
+		profiles:          profiles,
+		users:             users,
+		mailer:            mailer,
+		smsGateway:        smsGateway,
+		notifier:          notifier,
+		auditor:           auditor,
+		conf:              conf,
+		deviceAttempts:    rate_limit.NewLimiter(),
+		loginCodeAttempts: rate_limit.NewLimiter(),
+		loginEmails:       rate_limit.NewLimiter(),
+		smsNumbers:        rate_limit.NewLimiter(),
+		smsAttempts:       rate_limit.NewLimiter(),
+		pendingLogins:     newPendingLogins(),
+	}
+}
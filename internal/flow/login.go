@@ -0,0 +1,209 @@
+package flow
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/audit"
+	"login-provider/internal/client_meta"
+	"login-provider/internal/hydra"
+	"login-provider/internal/profile_api"
+	"login-provider/internal/user_store"
+	"login-provider/internal/utils"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Credentials are the credentials submitted by the user to log in
+type Credentials struct {
+	Challenge string
+	Email     string
+	Password  string
+	Remember  bool
+	// Locale is the locale of the code sent by SMS, if the user has to enter one
+	Locale string
+	// BrowserKey is a random value kept by the browser. The steps after the password can only be
+	// completed with it, so the login challenge alone, which leaks with urls, does not suffice.
+	BrowserKey string
+}
+
+// Login holds the information about a login request
+type Login struct {
+	Challenge  string
+	Request    *hydra.LoginRequest
+	ClientMeta *client_meta.ClientMetaInfo
+}
+
+// UILocales returns the locales requested by the client for the login UI
+func (l *Login) UILocales() []string {
+	if l.Request.OidcContext == nil {
+		return nil
+	}
+	return l.Request.OidcContext.UILocales
+}
+
+// SelectAccount returns whether the client asked to let the user select an account (prompt=select_account)
+func (l *Login) SelectAccount() bool {
+	requestUrl, err := url.Parse(l.Request.RequestURL)
+	if err != nil {
+		return false
+	}
+	return utils.Contains(strings.Fields(requestUrl.Query().Get("prompt")), "select_account")
+}
+
+// SwitchAccountUrl returns the url restarting the authorization request with prompt=login. Hydra
+// insists on the subject it authenticated already if it skips the login, so users have to sign in
+// again with a new login request to switch to another account. The login hint prefills the email address.
+func (l *Login) SwitchAccountUrl(loginHint string) (string, error) {
+	requestUrl, err := url.Parse(l.Request.RequestURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := requestUrl.Query()
+	query.Set("prompt", "login")
+	if len(loginHint) != 0 {
+		query.Set("login_hint", loginHint)
+	} else {
+		query.Del("login_hint")
+	}
+	requestUrl.RawQuery = query.Encode()
+	return requestUrl.String(), nil
+}
+
+// LoginHint returns the email address the client expects the user to sign in with
+func (l *Login) LoginHint() string {
+	if l.Request.OidcContext == nil {
+		return ""
+	}
+	return l.Request.OidcContext.LoginHint
+}
+
+// LoginResult is the outcome of a successful login
+type LoginResult struct {
+	RedirectTo string
+	// Subject identifies the authenticated user in hydra
+	Subject string
+	// Sms is set instead of RedirectTo if the user has to enter a code sent by SMS first
+	Sms *SmsChallenge
+	// PasswordChange is set instead of RedirectTo if the user has to choose a new password first
+	PasswordChange *PasswordChangeChallenge
+}
+
+func (s *Service) GetLogin(ctx context.Context, challenge string) (*Login, error) {
+	// get info about the login request for the given challenge
+	request, err := s.admin.GetLoginRequest(ctx, challenge)
+	if err != nil {
+		log.Ctx(ctx).Err(err).Msg("Error while communicating with hydra to get new login request")
+		return nil, &HydraError{Operation: "get login request", Err: err}
+	}
+
+	login := &Login{Challenge: challenge, Request: request, ClientMeta: &client_meta.ClientMetaInfo{}}
+	if request.Client != nil {
+		_ = login.ClientMeta.Unmarshal(request.Client.Metadata)
+	}
+	return login, nil
+}
+
+// AcceptSkippedLogin accepts a login request, hydra was already able to authenticate the user for
+func (s *Service) AcceptSkippedLogin(ctx context.Context, login *Login) (string, error) {
+	logger := log.Ctx(ctx)
+	logger.Debug().Msg("User authentication skipped")
+
+	// grant login request
+	redirectTo, err := s.admin.AcceptLoginRequest(ctx, login.Challenge, &hydra.AcceptLogin{Subject: login.Request.Subject})
+	if err != nil {
+		logger.Err(err).Msg("Error while communicating with hydra to accept login request")
+		return "", &HydraError{Operation: "accept login request", Err: err}
+	}
+
+	return redirectTo, nil
+}
+
+// Login authenticates the user with the given credentials and accepts the login request, unless the
+// user has to enter a code sent by SMS or choose a new password first
+func (s *Service) Login(ctx context.Context, credentials *Credentials) (*LoginResult, error) {
+	subjectId, authResponse, err := s.authenticate(ctx, credentials.Email, credentials.Password)
+	if errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrEmailNotVerified) {
+		reason := "invalid_credentials"
+		if errors.Is(err, ErrEmailNotVerified) {
+			reason = "email_not_verified"
+		}
+		s.auditor.Emit(ctx, &audit.Event{Type: audit.LoginFailed, Email: credentials.Email, Reason: reason})
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	login := &pendingLogin{
+		browserHash:  sha256.Sum256([]byte(credentials.BrowserKey)),
+		subject:      subjectId,
+		authResponse: authResponse,
+		remember:     credentials.Remember,
+		weakPassword: s.checkPassword(ctx, subjectId, credentials.Password),
+	}
+	if s.users != nil {
+		if _, err := s.users.ByID(ctx, subjectId); err == nil {
+			login.stored = true
+		}
+	}
+
+	smsChallenge, err := s.requireSmsCode(ctx, credentials, login)
+	if err != nil {
+		return nil, err
+	} else if smsChallenge != nil {
+		return &LoginResult{Subject: subjectId, Sms: smsChallenge}, nil
+	}
+
+	return s.completeLogin(ctx, credentials.Challenge, login)
+}
+
+// authenticate returns the subject and the profile of the user with the given credentials. Users
+// registered with the login provider take precedence over the ones of the authentication service,
+// once they verified their email address. Until then anybody may have registered the address, so
+// its user in the authentication service must still be able to log in.
+func (s *Service) authenticate(ctx context.Context, email, password string) (string, *profile_api.AuthenticationResponse, error) {
+	logger := log.Ctx(ctx)
+
+	var unverified *user_store.User
+	if s.users != nil {
+		user, err := s.users.ByEmail(ctx, email)
+		if err == nil && user.EmailVerified {
+			if !user_store.CheckPassword(user.PasswordHash, password) {
+				logger.Warn().Msg("User authentication failed")
+				return "", nil, ErrInvalidCredentials
+			}
+			return user.ID, authenticationResponse(user), nil
+		} else if err == nil {
+			unverified = user
+		} else if !errors.Is(err, user_store.ErrNotFound) {
+			logger.Err(err).Msg("Failed to read user")
+			return "", nil, err
+		}
+	}
+
+	authenticateUrl, err := s.conf.AuthenticateUrl()
+	if err != nil {
+		logger.Err(err).Msg("No valid authentication url configured")
+		return "", nil, err
+	}
+
+	authResponse, err := s.profiles.AuthenticateUser(ctx, authenticateUrl.String(), email, password)
+	if errors.Is(err, ErrUnavailable) {
+		l := logger.With().Err(err).Logger()
+		l.Error().Msg("Authentication service not available")
+		return "", nil, err
+	} else if err != nil {
+		if unverified != nil && user_store.CheckPassword(unverified.PasswordHash, password) {
+			logger.Info().Str("_subject", unverified.ID).Msg("User did not verify the email address yet")
+			return "", nil, ErrEmailNotVerified
+		}
+		l := logger.With().Err(err).Logger()
+		l.Warn().Msg("User authentication failed")
+		return "", nil, ErrInvalidCredentials
+	}
+
+	return strconv.Itoa(authResponse.User.ID), authResponse, nil
+}
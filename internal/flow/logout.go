@@ -0,0 +1,286 @@
+package flow
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/audit"
+	"login-provider/internal/backchannel"
+	"login-provider/internal/client_meta"
+	"login-provider/internal/hydra"
+	"login-provider/internal/profile_api"
+	"login-provider/internal/utils"
+	"net/url"
+	"strings"
+)
+
+// Logout holds the information about a logout request
+type Logout struct {
+	Challenge string
+	Request   *hydra.LogoutRequest
+	// User is the name of the user to be logged out. Falls back to the subject if unknown
+	User string
+	// Client is the relying party, which initiated the logout. It is nil if the logout has not
+	// been initiated by a relying party or the relying party could not be determined
+	Client     *hydra.Client
+	ClientMeta *client_meta.ClientMetaInfo
+	// PostLogoutRedirectUri and State are the parameters sent by the relying party
+	PostLogoutRedirectUri string
+	State                 string
+	// IdTokenHint is true if the relying party proved the session by sending an id token
+	IdTokenHint bool
+	// sessions are the consent sessions of the subject
+	sessions []*hydra.ConsentSession
+}
+
+// ClientName returns the name of the relying party, which initiated the logout
+func (l *Logout) ClientName() string {
+	if l.Client == nil {
+		return ""
+	}
+	if len(l.Client.ClientName) != 0 {
+		return l.Client.ClientName
+	}
+	return l.Client.ClientID
+}
+
+// GetLogout retrieves the logout request for the given challenge. If the logout has been initiated
+// by a relying party, the relying party is determined from the parameters of the original request.
+func (s *Service) GetLogout(ctx context.Context, challenge string) (*Logout, error) {
+	request, err := s.admin.GetLogoutRequest(ctx, challenge)
+	if err != nil {
+		log.Ctx(ctx).Err(err).Msg("Error while communicating with hydra to get logout request")
+		return nil, &HydraError{Operation: "get logout request", Err: err}
+	}
+
+	logout := &Logout{
+		Challenge:  challenge,
+		Request:    request,
+		User:       request.Subject,
+		ClientMeta: &client_meta.ClientMetaInfo{},
+	}
+
+	var clientID string
+	if requestUrl, err := url.Parse(request.RequestURL); err == nil {
+		query := requestUrl.Query()
+		logout.PostLogoutRedirectUri = query.Get("post_logout_redirect_uri")
+		logout.State = query.Get("state")
+
+		hint := query.Get("id_token_hint")
+		logout.IdTokenHint = len(hint) != 0
+		// hydra verified the id token hint already, so there is no need to check the signature
+		if clientID = query.Get("client_id"); len(clientID) == 0 && logout.IdTokenHint {
+			clientID = audience(hint)
+		}
+	}
+
+	if request.Client != nil && len(request.Client.ClientID) != 0 {
+		// hydra v2 reports the relying party itself
+		logout.Client = request.Client
+		_ = logout.ClientMeta.Unmarshal(request.Client.Metadata)
+	} else if len(clientID) != 0 {
+		s.loadClient(ctx, logout, clientID)
+	}
+	if len(logout.Request.Subject) != 0 {
+		s.loadSessions(ctx, logout)
+	}
+
+	return logout, nil
+}
+
+// RequiresConfirmation returns whether the user has to confirm the logout. Logouts initiated by a
+// relying party, which proved the session with an id token hint, are accepted without asking the
+// user unless disabled globally or for the relying party.
+func (s *Service) RequiresConfirmation(logout *Logout) bool {
+	if !logout.Request.RpInitiated || !logout.IdTokenHint || logout.ClientMeta.ConfirmLogout {
+		return true
+	}
+	return !s.conf.LogoutConfig().AutoAcceptRpInitiated
+}
+
+// DecideLogout accepts or rejects the logout request and returns the url to redirect the user to.
+// If the user declined to log out, the user is sent back to the relying party, respectively to the
+// configured default. The returned url is empty if neither is known.
+func (s *Service) DecideLogout(ctx context.Context, logout *Logout, approved bool) (string, error) {
+	logger := log.Ctx(ctx)
+
+	if !approved {
+		err := s.admin.RejectLogoutRequest(ctx, logout.Challenge)
+		if err != nil {
+			logger.Err(err).Msg("Error while communicating with hydra to reject logout request")
+			return "", &HydraError{Operation: "reject logout request", Err: err}
+		}
+
+		return s.cancelRedirect(logout), nil
+	}
+
+	redirectTo, err := s.admin.AcceptLogoutRequest(ctx, logout.Challenge)
+	if err != nil {
+		logger.Err(err).Msg("Error while communicating with hydra to accept logout request")
+		return "", &HydraError{Operation: "accept logout request", Err: err}
+	}
+
+	s.auditor.Emit(ctx, &audit.Event{
+		Type:      audit.Logout,
+		Subject:   logout.Request.Subject,
+		ClientID:  clientID(logout.Client),
+		SessionID: logout.Request.Sid,
+	})
+	s.notifier.Notify(ctx, &backchannel.Event{
+		Subject:   logout.Request.Subject,
+		SessionID: logout.Request.Sid,
+	})
+
+	return redirectTo, nil
+}
+
+// ClearLocalState is notified about logouts to drop the logins of the subject, which are still
+// waiting for a code sent by SMS or a new password, together with the codes entered for them
+func (s *Service) ClearLocalState(ctx context.Context, event *backchannel.Event) {
+	if len(event.Subject) == 0 {
+		return
+	}
+
+	challenges := s.pendingLogins.removeSubject(event.Subject)
+	for _, challenge := range challenges {
+		s.smsAttempts.Reset("challenge:" + challenge)
+	}
+	if len(challenges) != 0 {
+		log.Ctx(ctx).Info().Str("_subject", event.Subject).Int("_logins", len(challenges)).Msg("Pending logins of logged out user removed")
+	}
+}
+
+// FrontChannelLogoutUrls returns the front-channel logout uris of all clients of the session to be
+// loaded in iframes after the user logged out. The list is empty if front-channel logout is disabled.
+func (s *Service) FrontChannelLogoutUrls(logout *Logout) []string {
+	conf := s.conf.LogoutConfig()
+	if !conf.FrontChannel {
+		return nil
+	}
+
+	var urls []string
+	var clients []string
+	for _, session := range logout.sessions {
+		cr := session.ConsentRequest
+		if cr == nil || cr.Client == nil || len(cr.Client.FrontchannelLogoutURI) == 0 {
+			continue
+		}
+		// only the clients of the session to be terminated are notified
+		if len(logout.Request.Sid) != 0 && cr.LoginSessionID != logout.Request.Sid {
+			continue
+		}
+		if utils.Contains(clients, cr.Client.ClientID) {
+			continue
+		}
+		clients = append(clients, cr.Client.ClientID)
+
+		logoutUri, err := url.Parse(cr.Client.FrontchannelLogoutURI)
+		if err != nil {
+			continue
+		}
+		if cr.Client.FrontchannelLogoutSessionRequired {
+			query := logoutUri.Query()
+			query.Set("iss", conf.Issuer)
+			query.Set("sid", logout.Request.Sid)
+			logoutUri.RawQuery = query.Encode()
+		}
+		urls = append(urls, logoutUri.String())
+	}
+	return urls
+}
+
+// cancelRedirect determines where to send users, who declined to log out. The post logout redirect
+// uri is only used if it is registered for the client to not end up as an open redirect.
+func (s *Service) cancelRedirect(logout *Logout) string {
+	if logout.Client != nil {
+		if len(logout.PostLogoutRedirectUri) != 0 &&
+			utils.Contains(logout.Client.PostLogoutRedirectUris, logout.PostLogoutRedirectUri) {
+			redirectUri, err := url.Parse(logout.PostLogoutRedirectUri)
+			if err == nil {
+				if len(logout.State) != 0 {
+					query := redirectUri.Query()
+					query.Set("state", logout.State)
+					redirectUri.RawQuery = query.Encode()
+				}
+				return redirectUri.String()
+			}
+		}
+		if len(logout.Client.ClientURI) != 0 {
+			return logout.Client.ClientURI
+		}
+	}
+	return s.conf.LogoutConfig().DefaultRedirectUrl
+}
+
+func (s *Service) loadClient(ctx context.Context, logout *Logout, clientID string) {
+	client, err := s.admin.GetClient(ctx, clientID)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Str("_client_id", clientID).
+			Msg("Failed to retrieve the client, which initiated the logout, from hydra")
+		return
+	}
+
+	logout.Client = client
+	_ = logout.ClientMeta.Unmarshal(client.Metadata)
+}
+
+// loadSessions loads the consent sessions of the subject and determines the name of the user to be
+// logged out. Hydra knows only the subject, so the user data is taken from the context of these sessions
+func (s *Service) loadSessions(ctx context.Context, logout *Logout) {
+	sessions, err := s.admin.ListConsentSessions(ctx, logout.Request.Subject)
+	if err != nil {
+		log.Ctx(ctx).Debug().Err(err).Msg("Failed to retrieve consent sessions of the subject from hydra")
+		return
+	}
+
+	logout.sessions = sessions
+	for _, session := range sessions {
+		if session.ConsentRequest == nil || session.ConsentRequest.Context == nil {
+			continue
+		}
+		ar := &profile_api.AuthenticationResponse{}
+		if err := ar.Unmarshal(session.ConsentRequest.Context); err != nil {
+			continue
+		}
+		if len(ar.User.UserName) != 0 {
+			logout.User = ar.User.UserName
+			return
+		} else if len(ar.User.Email) != 0 {
+			logout.User = ar.User.Email
+			return
+		}
+	}
+}
+
+// audience returns the (first) audience of the given id token
+func audience(idToken string) string {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+
+	var claims struct {
+		Aud interface{} `json:"aud"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+
+	switch aud := claims.Aud.(type) {
+	case string:
+		return aud
+	case []interface{}:
+		if len(aud) != 0 {
+			if value, ok := aud[0].(string); ok {
+				return value
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package flow
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/config"
+	"login-provider/internal/password_policy"
+	"login-provider/internal/user_store"
+)
+
+// PasswordChangeChallenge asks the user to choose a new password, because the current one does not
+// follow the password policy
+type PasswordChangeChallenge struct {
+	Challenge string
+	// Reason is the rule of the password policy the current password breaks
+	Reason error
+	// Optional is true if the user may keep the current password for now
+	Optional bool
+}
+
+// PasswordChange is the new password chosen by the user to complete the login
+type PasswordChange struct {
+	Challenge            string
+	Password             string
+	PasswordConfirmation string
+	// Skip is true if the user wants to keep the current password
+	Skip bool
+	// BrowserKey is the key of the browser the password has been entered in
+	BrowserKey string
+}
+
+func (p *pendingLogin) passwordChange(challenge string, conf config.Configuration) *PasswordChangeChallenge {
+	return &PasswordChangeChallenge{
+		Challenge: challenge,
+		Reason:    p.weakPassword,
+		Optional:  !conf.PasswordPolicyConfig().RequireChange,
+	}
+}
+
+// checkPassword returns the rule of the password policy the password of the user logging in breaks,
+// respectively nil. The login continues if the password can't be checked.
+func (s *Service) checkPassword(ctx context.Context, subject, password string) error {
+	logger := log.Ctx(ctx)
+
+	policyConf := s.conf.PasswordPolicyConfig()
+	if !policyConf.CheckOnLogin {
+		return nil
+	}
+	err := password_policy.NewPolicy(policyConf).Check(password)
+	if err == nil {
+		return nil
+	} else if !password_policy.Violated(err) {
+		logger.Err(err).Str("_subject", subject).Msg("Failed to check password against the password policy")
+		return nil
+	}
+	logger.Warn().Str("_subject", subject).Str("_reason", err.Error()).Msg("Password does not follow the password policy")
+	return err
+}
+
+// GetPasswordChange returns why the user logging in with the given challenge in the browser with the
+// given key has to choose a new password
+func (s *Service) GetPasswordChange(challenge, browserKey string) (*PasswordChangeChallenge, error) {
+	pending := s.pendingLogins.get(challenge, stepPasswordChange, browserKey)
+	if pending == nil {
+		return nil, ErrLoginExpired
+	}
+	return pending.passwordChange(challenge, s.conf), nil
+}
+
+// ChangePassword sets the new password chosen by the user and accepts the login request. The other
+// login sessions of the user are revoked, like after a password reset.
+func (s *Service) ChangePassword(ctx context.Context, change *PasswordChange) (*LoginResult, error) {
+	logger := log.Ctx(ctx)
+
+	pending := s.pendingLogins.get(change.Challenge, stepPasswordChange, change.BrowserKey)
+	if pending == nil {
+		return nil, ErrLoginExpired
+	}
+
+	if change.Skip {
+		if s.conf.PasswordPolicyConfig().RequireChange {
+			logger.Warn().Str("_subject", pending.subject).Msg("Tried to skip the password change")
+			return nil, ErrSkipNotAllowed
+		}
+		s.pendingLogins.remove(change.Challenge)
+		return s.acceptPendingLogin(ctx, change.Challenge, pending)
+	}
+
+	problems := make(map[string]error)
+	s.validatePassword(problems, change.Password, change.PasswordConfirmation)
+	if len(problems) != 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	user, err := s.users.ByID(ctx, pending.subject)
+	if err != nil {
+		logger.Err(err).Str("_subject", pending.subject).Msg("Failed to read user to change the password for")
+		return nil, err
+	}
+	hash, err := user_store.HashPassword(change.Password)
+	if err != nil {
+		return nil, err
+	}
+	user.PasswordHash = hash
+	if err := s.users.Update(ctx, user); err != nil {
+		logger.Err(err).Str("_subject", user.ID).Msg("Failed to change password")
+		return nil, err
+	}
+	logger.Info().Str("_subject", user.ID).Msg("Password changed on login")
+	s.pendingLogins.remove(change.Challenge)
+
+	// whoever knew the old password must not stay logged in
+	if err := s.admin.RevokeLoginSessions(ctx, user.ID); err != nil {
+		logger.Err(err).Str("_subject", user.ID).Msg("Failed to revoke login sessions after password change")
+	}
+	return s.acceptPendingLogin(ctx, change.Challenge, pending)
+}
@@ -0,0 +1,124 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/audit"
+	"login-provider/internal/user_store"
+	netmail "net/mail"
+	"strings"
+)
+
+// ErrPasswordResetDisabled is returned if resetting forgotten passwords is not enabled
+var ErrPasswordResetDisabled = errors.New("password reset disabled")
+
+// ResetRequest is the data entered by a user who forgot the password
+type ResetRequest struct {
+	// Challenge is the login challenge the user returns to after changing the password. Optional
+	Challenge string
+	Email     string
+	// Locale is the locale the email is written in
+	Locale string
+}
+
+// PasswordReset is the new password chosen with the link sent by email
+type PasswordReset struct {
+	Token                string
+	Password             string
+	PasswordConfirmation string
+}
+
+// RequestPasswordReset sends a link to choose a new password to the given email address. Nothing is
+// sent to unknown email addresses, but no error is returned either, so nobody learns which addresses
+// are registered. The emails are limited per address whether it is registered or not for the same reason.
+func (s *Service) RequestPasswordReset(ctx context.Context, request *ResetRequest) error {
+	logger := log.Ctx(ctx)
+
+	resetConf := s.conf.PasswordResetConfig()
+	if !resetConf.Enabled || s.users == nil {
+		return ErrPasswordResetDisabled
+	}
+
+	email := strings.TrimSpace(request.Email)
+	if len(email) == 0 {
+		return &ValidationError{Problems: map[string]error{"email": ErrRequired}}
+	} else if address, err := netmail.ParseAddress(email); err != nil || address.Address != email {
+		return &ValidationError{Problems: map[string]error{"email": ErrInvalidEmail}}
+	}
+
+	if !s.loginEmails.Allow("reset:"+strings.ToLower(email), resetConf.MaxPerAddress, resetConf.AddressWindow) {
+		logger.Warn().Msg("Too many password resets requested for email address")
+		s.auditor.Emit(ctx, &audit.Event{Type: audit.Lockout, Reason: "reset_email"})
+		return ErrTooManyAttempts
+	}
+
+	user, err := s.users.ByEmail(ctx, email)
+	if errors.Is(err, user_store.ErrNotFound) {
+		logger.Info().Msg("Password reset requested for unknown email address")
+		return nil
+	} else if err != nil {
+		logger.Err(err).Msg("Failed to read user to reset the password for")
+		return err
+	}
+
+	logger.Info().Str("_subject", user.ID).Msg("Password reset requested")
+	return s.sendToken(ctx, user, &tokenMail{
+		purpose:   user_store.PurposeResetPassword,
+		path:      "/password/reset",
+		message:   "mail.reset",
+		challenge: request.Challenge,
+		locale:    request.Locale,
+		ttl:       resetConf.TokenTtl,
+	})
+}
+
+// ResetPassword sets the new password of the user the token was sent to and logs the user out of
+// all hydra login sessions. The other links sent to reset the password become invalid. It returns
+// the login challenge the reset was requested from, if any.
+func (s *Service) ResetPassword(ctx context.Context, reset *PasswordReset) (string, error) {
+	logger := log.Ctx(ctx)
+
+	if !s.conf.PasswordResetConfig().Enabled || s.users == nil {
+		return "", ErrPasswordResetDisabled
+	}
+
+	// the token is only used up by a valid password, so users can correct their input
+	problems := make(map[string]error)
+	s.validatePassword(problems, reset.Password, reset.PasswordConfirmation)
+	if len(problems) != 0 {
+		return "", &ValidationError{Problems: problems}
+	}
+
+	token, err := s.users.ConsumeToken(ctx, user_store.PurposeResetPassword, reset.Token)
+	if err != nil {
+		return "", err
+	}
+	user, err := s.users.ByID(ctx, token.UserID)
+	if err != nil {
+		logger.Err(err).Str("_subject", token.UserID).Msg("Failed to read user to reset the password for")
+		return "", err
+	}
+
+	hash, err := user_store.HashPassword(reset.Password)
+	if err != nil {
+		return "", err
+	}
+	user.PasswordHash = hash
+	// the user proved to receive emails sent to the address
+	user.EmailVerified = true
+	if err := s.users.Update(ctx, user); err != nil {
+		logger.Err(err).Str("_subject", user.ID).Msg("Failed to change password")
+		return "", err
+	}
+	logger.Info().Str("_subject", user.ID).Msg("Password reset")
+	if err := s.users.RemoveTokens(ctx, user_store.PurposeResetPassword, user.ID); err != nil {
+		logger.Err(err).Str("_subject", user.ID).Msg("Failed to remove other password reset tokens")
+	}
+
+	// whoever knew the old password must not stay logged in
+	if err := s.admin.RevokeLoginSessions(ctx, user.ID); err != nil {
+		logger.Err(err).Str("_subject", user.ID).Msg("Failed to revoke login sessions after password reset")
+	}
+	return token.Challenge, nil
+}
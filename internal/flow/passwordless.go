@@ -0,0 +1,201 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/audit"
+	"login-provider/internal/hydra"
+	"login-provider/internal/i18n"
+	"login-provider/internal/mail"
+	"login-provider/internal/user_store"
+	netmail "net/mail"
+	"strings"
+)
+
+var (
+	// ErrPasswordlessDisabled is returned if the login by email is not enabled
+	ErrPasswordlessDisabled = errors.New("passwordless login disabled")
+	// ErrInvalidCode is returned if a code sent by email is wrong, expired or has been used already
+	ErrInvalidCode = errors.New("invalid login code")
+)
+
+// amrEmail is the authentication method reference of a login with a link or a code sent by email
+const amrEmail = "email"
+
+// EmailLogin is the email address entered by a user who wants to log in without a password
+type EmailLogin struct {
+	Challenge string
+	Email     string
+	// Locale is the locale the email is written in
+	Locale string
+}
+
+// LoginCode is the code sent by email, which has been entered by the user
+type LoginCode struct {
+	Challenge string
+	Code      string
+}
+
+// RequestLoginEmail sends a link and a code to log in to the given email address. Both are only valid
+// for the given login challenge and replace the ones sent to the user before. Nothing is sent to unknown
+// or unverified email addresses, but no error is returned either, so nobody learns which addresses are
+// registered. The emails are limited per address whether it is registered or not for the same reason.
+func (s *Service) RequestLoginEmail(ctx context.Context, request *EmailLogin) error {
+	logger := log.Ctx(ctx)
+
+	conf := s.conf.PasswordlessConfig()
+	if !conf.Enabled || s.users == nil {
+		return ErrPasswordlessDisabled
+	}
+
+	email := strings.TrimSpace(request.Email)
+	if len(email) == 0 {
+		return &ValidationError{Problems: map[string]error{"email": ErrRequired}}
+	} else if address, err := netmail.ParseAddress(email); err != nil || address.Address != email {
+		return &ValidationError{Problems: map[string]error{"email": ErrInvalidEmail}}
+	}
+
+	if !s.loginEmails.Allow("address:"+strings.ToLower(email), conf.MaxPerAddress, conf.AddressWindow) {
+		logger.Warn().Msg("Too many login emails requested for email address")
+		s.auditor.Emit(ctx, &audit.Event{Type: audit.Lockout, Reason: "login_email"})
+		return ErrTooManyAttempts
+	}
+
+	user, err := s.users.ByEmail(ctx, email)
+	if errors.Is(err, user_store.ErrNotFound) {
+		logger.Info().Msg("Login by email requested for unknown email address")
+		return nil
+	} else if err != nil {
+		logger.Err(err).Msg("Failed to read user to log in by email")
+		return err
+	}
+	// anybody could have registered the address, the password of the registration has to be verified first
+	if !user.EmailVerified {
+		logger.Info().Str("_subject", user.ID).Msg("Login by email requested for unverified email address")
+		return nil
+	}
+
+	value, linkToken, err := user_store.NewToken(user_store.PurposeLoginLink, user.ID, request.Challenge, conf.Ttl)
+	if err != nil {
+		return err
+	}
+	code, codeToken, err := user_store.NewCode(user_store.PurposeLoginCode, user.ID, request.Challenge, conf.Ttl)
+	if err != nil {
+		return err
+	}
+	link, err := s.link("/login/email/verify", value)
+	if err != nil {
+		return err
+	}
+	for _, token := range []*user_store.Token{linkToken, codeToken} {
+		if err := s.users.RemoveTokens(ctx, token.Purpose, user.ID); err != nil {
+			logger.Err(err).Str("_purpose", token.Purpose).Msg("Failed to remove previous tokens")
+			return err
+		}
+		if err := s.users.SaveToken(ctx, token); err != nil {
+			logger.Err(err).Str("_purpose", token.Purpose).Msg("Failed to save token")
+			return err
+		}
+	}
+
+	if err := s.mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: i18n.Translate(request.Locale, "mail.login.subject"),
+		Body:    i18n.Translate(request.Locale, "mail.login.body", link, code),
+	}); err != nil {
+		logger.Err(err).Str("_subject", user.ID).Msg("Failed to send login email")
+		return err
+	}
+	logger.Info().Str("_subject", user.ID).Msg("Login email sent")
+	return nil
+}
+
+// VerifyLoginLink logs in the user the link has been sent to
+func (s *Service) VerifyLoginLink(ctx context.Context, value string) (*LoginResult, error) {
+	if !s.conf.PasswordlessConfig().Enabled || s.users == nil {
+		return nil, ErrPasswordlessDisabled
+	}
+
+	token, err := s.users.ConsumeToken(ctx, user_store.PurposeLoginLink, value)
+	if err != nil {
+		return nil, err
+	}
+	return s.acceptEmailLogin(ctx, token)
+}
+
+// VerifyLoginCode logs in the user the code has been sent to. The number of codes entered per login
+// challenge is limited, because the codes are short enough to be guessed otherwise.
+func (s *Service) VerifyLoginCode(ctx context.Context, loginCode *LoginCode) (*LoginResult, error) {
+	logger := log.Ctx(ctx)
+
+	conf := s.conf.PasswordlessConfig()
+	if !conf.Enabled || s.users == nil {
+		return nil, ErrPasswordlessDisabled
+	}
+
+	challengeKey := "challenge:" + loginCode.Challenge
+	if !s.loginCodeAttempts.Allow(challengeKey, conf.MaxAttempts, conf.Ttl) {
+		logger.Warn().Msg("Too many login codes entered")
+		s.auditor.Emit(ctx, &audit.Event{Type: audit.Lockout, Reason: "login_code"})
+		return nil, ErrTooManyAttempts
+	}
+
+	token, err := s.users.ConsumeToken(ctx, user_store.PurposeLoginCode, user_store.CodeValue(loginCode.Challenge, loginCode.Code))
+	if errors.Is(err, user_store.ErrInvalidToken) {
+		logger.Warn().Msg("Invalid login code entered")
+		return nil, ErrInvalidCode
+	} else if err != nil {
+		return nil, err
+	}
+
+	s.loginCodeAttempts.Reset(challengeKey)
+	return s.acceptEmailLogin(ctx, token)
+}
+
+// acceptEmailLogin accepts the login request the token has been issued for. Users with an unverified
+// email address are refused, because their password may have been chosen by someone else, who would be
+// able to log in as soon as the address counted as verified.
+func (s *Service) acceptEmailLogin(ctx context.Context, token *user_store.Token) (*LoginResult, error) {
+	logger := log.Ctx(ctx)
+
+	user, err := s.users.ByID(ctx, token.UserID)
+	if err != nil {
+		logger.Err(err).Str("_subject", token.UserID).Msg("Failed to read user to log in by email")
+		return nil, err
+	}
+	if !user.EmailVerified {
+		logger.Warn().Str("_subject", user.ID).Msg("User did not verify the email address yet")
+		return nil, ErrInvalidToken
+	}
+
+	// hydra insists on the subject it authenticated already if it skips the login
+	login, err := s.GetLogin(ctx, token.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	if login.Request.Skip && login.Request.Subject != user.ID {
+		logger.Warn().Str("_subject", user.ID).Msg("Login request belongs to another user")
+		return nil, ErrInvalidToken
+	}
+
+	redirectTo, err := s.admin.AcceptLoginRequest(ctx, token.Challenge, &hydra.AcceptLogin{
+		Acr:     "0",
+		Amr:     []string{amrEmail},
+		Context: authenticationResponse(user),
+		Subject: user.ID,
+	})
+	if err != nil {
+		logger.Err(err).Msg("Error while communicating with hydra to accept login request")
+		return nil, &HydraError{Operation: "accept login request", Err: err}
+	}
+
+	s.auditor.Emit(ctx, &audit.Event{
+		Type:     audit.LoginSucceeded,
+		Subject:  user.ID,
+		ClientID: clientID(login.Request.Client),
+		Methods:  []string{amrEmail},
+	})
+	logger.Info().Str("_subject", user.ID).Msg("User logged in by email")
+	return &LoginResult{RedirectTo: redirectTo, Subject: user.ID}, nil
+}
@@ -0,0 +1,153 @@
+package flow
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/audit"
+	"login-provider/internal/hydra"
+	"login-provider/internal/profile_api"
+	"sync"
+	"time"
+)
+
+// ErrLoginExpired is returned if the user took too long to complete the steps after entering the
+// password. The user has to log in again.
+var ErrLoginExpired = errors.New("login expired")
+
+// The steps a login can wait for after the password has been entered
+const (
+	stepSmsCode        = "sms_code"
+	stepPasswordChange = "password_change"
+)
+
+// passwordChangeTtl is how long users have to choose a new password after logging in with one,
+// which does not follow the password policy
+const passwordChangeTtl = 15 * time.Minute
+
+// pendingLogin is a login, which is accepted once the user completed the current step
+type pendingLogin struct {
+	step string
+	// browserHash is the hash of the key of the browser the password has been entered in
+	browserHash [sha256.Size]byte
+	// reason is why a code has been sent by SMS, empty if none has been sent
+	reason  string
+	subject string
+	// stored is true for users of the user store, which keeps whether their phone number is verified
+	// and lets them change the password
+	stored       bool
+	authResponse *profile_api.AuthenticationResponse
+	remember     bool
+	// weakPassword is the rule of the password policy the password breaks, respectively nil
+	weakPassword error
+	number       string
+	codeHash     [sha256.Size]byte
+	expiresAt    time.Time
+}
+
+// pendingLogins keeps the logins waiting for a step per login challenge. They are kept in memory,
+// so users have to log in again after a restart.
+type pendingLogins struct {
+	mutex  sync.Mutex
+	logins map[string]*pendingLogin
+	now    func() time.Time
+}
+
+func newPendingLogins() *pendingLogins {
+	return &pendingLogins{logins: make(map[string]*pendingLogin), now: time.Now}
+}
+
+func (p *pendingLogins) put(challenge string, login *pendingLogin) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.now()
+	for key, pending := range p.logins {
+		if now.After(pending.expiresAt) {
+			delete(p.logins, key)
+		}
+	}
+	p.logins[challenge] = login
+}
+
+// get returns the pending login of the given challenge waiting for the given step, respectively nil
+// if there is none, it expired or it has been started in another browser
+func (p *pendingLogins) get(challenge, step, browserKey string) *pendingLogin {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	pending, ok := p.logins[challenge]
+	if !ok || pending.step != step || p.now().After(pending.expiresAt) {
+		return nil
+	}
+	browserHash := sha256.Sum256([]byte(browserKey))
+	if subtle.ConstantTimeCompare(browserHash[:], pending.browserHash[:]) != 1 {
+		return nil
+	}
+	return pending
+}
+
+func (p *pendingLogins) remove(challenge string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.logins, challenge)
+}
+
+// removeSubject removes the pending logins of the given subject and returns their challenges
+func (p *pendingLogins) removeSubject(subject string) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var challenges []string
+	for challenge, pending := range p.logins {
+		if pending.subject == subject {
+			challenges = append(challenges, challenge)
+			delete(p.logins, challenge)
+		}
+	}
+	return challenges
+}
+
+// completeLogin accepts the login request unless the user has to change the password first. Only
+// users of the user store can change it here. The weak passwords of the users of the authentication
+// service have been logged by checkPassword, the login provider can't do more about them.
+func (s *Service) completeLogin(ctx context.Context, challenge string, pending *pendingLogin) (*LoginResult, error) {
+	if pending.weakPassword == nil || !pending.stored {
+		return s.acceptPendingLogin(ctx, challenge, pending)
+	}
+
+	// pending logins are shared by concurrent requests, so a copy waits for the new password
+	waiting := *pending
+	waiting.step = stepPasswordChange
+	waiting.expiresAt = time.Now().Add(passwordChangeTtl)
+	s.pendingLogins.put(challenge, &waiting)
+	return &LoginResult{Subject: pending.subject, PasswordChange: waiting.passwordChange(challenge, s.conf)}, nil
+}
+
+func (s *Service) acceptPendingLogin(ctx context.Context, challenge string, pending *pendingLogin) (*LoginResult, error) {
+	accept := &hydra.AcceptLogin{
+		Acr:         "0",
+		Context:     pending.authResponse,
+		Remember:    pending.remember,
+		RememberFor: 3600,
+		Subject:     pending.subject,
+	}
+	if pending.reason == smsSecondFactor {
+		accept.Amr = []string{"pwd", "sms", "mfa"}
+	}
+
+	redirectTo, err := s.admin.AcceptLoginRequest(ctx, challenge, accept)
+	if err != nil {
+		log.Ctx(ctx).Err(err).Msg("Error while communicating with hydra to accept login request")
+		return nil, &HydraError{Operation: "accept login request", Err: err}
+	}
+
+	methods := accept.Amr
+	if len(methods) == 0 {
+		methods = []string{"pwd"}
+	}
+	s.auditor.Emit(ctx, &audit.Event{Type: audit.LoginSucceeded, Subject: pending.subject, Methods: methods})
+	return &LoginResult{RedirectTo: redirectTo, Subject: pending.subject}, nil
+}
@@ -0,0 +1,300 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/audit"
+	"login-provider/internal/config"
+	"login-provider/internal/hydra"
+	"login-provider/internal/i18n"
+	"login-provider/internal/mail"
+	"login-provider/internal/password_policy"
+	"login-provider/internal/profile_api"
+	"login-provider/internal/user_store"
+	netmail "net/mail"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRegistrationDisabled is returned if the self-service registration is not enabled
+	ErrRegistrationDisabled = errors.New("registration disabled")
+	// ErrEmailNotVerified is returned if a registered user tries to log in before verifying the email address
+	ErrEmailNotVerified = errors.New("email address not verified")
+	// ErrInvalidToken is returned if a link sent by email is invalid, expired or has been used already
+	ErrInvalidToken = user_store.ErrInvalidToken
+)
+
+// The problems of a form besides the rules of the password policy
+var (
+	ErrRequired         = errors.New("value is required")
+	ErrInvalidEmail     = errors.New("invalid email address")
+	ErrPasswordMismatch = errors.New("passwords do not match")
+)
+
+// Registration is the data entered by a new user
+type Registration struct {
+	// Challenge is the login challenge the user returns to after verifying the email address. Optional
+	Challenge            string
+	Email                string
+	Password             string
+	PasswordConfirmation string
+	// Fields holds the values of the configured registration fields
+	Fields map[string]string
+	// Locale is the locale the verification email is written in
+	Locale string
+}
+
+// ValidationError lists the problems of a submitted form per form field
+type ValidationError struct {
+	Problems map[string]error
+}
+
+func (e *ValidationError) Error() string {
+	var problems []string
+	for field, err := range e.Problems {
+		problems = append(problems, field+": "+err.Error())
+	}
+	sort.Strings(problems)
+	return "invalid form: " + strings.Join(problems, ", ")
+}
+
+// Register creates a user and sends the link to verify the email address. The user can't log in
+// before using it. Nothing is created if the email address is registered already, so nobody learns
+// which addresses are registered. If that user did not verify the address yet, a new link replaces
+// the previous ones. The password and fields entered are kept with the link until it is used.
+func (s *Service) Register(ctx context.Context, registration *Registration) error {
+	logger := log.Ctx(ctx)
+
+	registrationConf := s.conf.RegistrationConfig()
+	if !registrationConf.Enabled || s.users == nil {
+		return ErrRegistrationDisabled
+	}
+	if err := s.validateRegistration(registration, registrationConf); err != nil {
+		return err
+	}
+
+	hash, err := user_store.HashPassword(registration.Password)
+	if err != nil {
+		return err
+	}
+	user := &user_store.User{
+		ID:           uuid.New().String(),
+		Email:        strings.TrimSpace(registration.Email),
+		PasswordHash: hash,
+		Fields:       make(map[string]string),
+		CreatedAt:    time.Now(),
+	}
+	for _, field := range registrationConf.Fields {
+		if value := strings.TrimSpace(registration.Fields[field.Name]); len(value) != 0 {
+			user.Fields[field.Name] = value
+		}
+	}
+
+	err = s.users.Create(ctx, user)
+	if errors.Is(err, user_store.ErrExists) {
+		existing, err := s.users.ByEmail(ctx, user.Email)
+		if err != nil {
+			logger.Err(err).Msg("Failed to read registered user")
+			return err
+		}
+		if existing.EmailVerified {
+			logger.Info().Str("_subject", existing.ID).Msg("Email address is registered already")
+			return nil
+		}
+		// somebody else may have registered the address, so the links sent before become invalid and
+		// the password and fields entered now only count once the address is verified with the new one
+		if err := s.users.RemoveTokens(ctx, user_store.PurposeVerifyEmail, existing.ID); err != nil {
+			logger.Err(err).Str("_subject", existing.ID).Msg("Failed to remove previous verification links")
+			return err
+		}
+		existing.PasswordHash, existing.Fields = user.PasswordHash, user.Fields
+		user = existing
+	} else if err != nil {
+		logger.Err(err).Msg("Failed to create user")
+		return err
+	} else {
+		logger.Info().Str("_subject", user.ID).Msg("User registered")
+	}
+
+	return s.sendToken(ctx, user, &tokenMail{
+		purpose:      user_store.PurposeVerifyEmail,
+		path:         "/register/verify",
+		message:      "mail.verify",
+		challenge:    registration.Challenge,
+		locale:       registration.Locale,
+		ttl:          registrationConf.VerificationTtl,
+		registration: true,
+	})
+}
+
+func (s *Service) validateRegistration(registration *Registration, registrationConf *config.RegistrationConfig) error {
+	problems := make(map[string]error)
+
+	if email := strings.TrimSpace(registration.Email); len(email) == 0 {
+		problems["email"] = ErrRequired
+	} else if address, err := netmail.ParseAddress(email); err != nil || address.Address != email {
+		problems["email"] = ErrInvalidEmail
+	}
+
+	s.validatePassword(problems, registration.Password, registration.PasswordConfirmation)
+
+	for _, field := range registrationConf.Fields {
+		if field.Required && len(strings.TrimSpace(registration.Fields[field.Name])) == 0 {
+			problems[field.Name] = ErrRequired
+		}
+	}
+
+	if len(problems) != 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validatePassword adds the problems of a new password to the given problems of a form
+func (s *Service) validatePassword(problems map[string]error, password, confirmation string) {
+	if err := password_policy.NewPolicy(s.conf.PasswordPolicyConfig()).Check(password); err != nil {
+		problems["password"] = err
+	} else if password != confirmation {
+		problems["password_confirmation"] = ErrPasswordMismatch
+	}
+}
+
+// tokenMail describes an email carrying a link with a token to one of the pages of the login provider
+type tokenMail struct {
+	purpose string
+	// path is the page the link points to
+	path string
+	// message is the prefix of the keys of the subject and the body of the email
+	message   string
+	challenge string
+	locale    string
+	ttl       time.Duration
+	// registration keeps the password hash and the fields of the user with the token
+	registration bool
+}
+
+func (s *Service) sendToken(ctx context.Context, user *user_store.User, m *tokenMail) error {
+	value, token, err := user_store.NewToken(m.purpose, user.ID, m.challenge, m.ttl)
+	if err != nil {
+		return err
+	}
+	if m.registration {
+		token.PasswordHash, token.Fields = user.PasswordHash, user.Fields
+	}
+	link, err := s.link(m.path, value)
+	if err != nil {
+		return err
+	}
+	if err := s.users.SaveToken(ctx, token); err != nil {
+		log.Ctx(ctx).Err(err).Str("_purpose", m.purpose).Msg("Failed to save token")
+		return err
+	}
+
+	if err := s.mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: i18n.Translate(m.locale, m.message+".subject"),
+		Body:    i18n.Translate(m.locale, m.message+".body", link),
+	}); err != nil {
+		log.Ctx(ctx).Err(err).Str("_subject", user.ID).Str("_purpose", m.purpose).Msg("Failed to send email")
+		return err
+	}
+	return nil
+}
+
+// VerifyEmail marks the email address of the user the token was sent to as verified. The user is
+// logged in if the login request the registration started from is still pending. Otherwise the
+// redirect of the result is empty and the user has to log in.
+func (s *Service) VerifyEmail(ctx context.Context, value string) (*LoginResult, error) {
+	logger := log.Ctx(ctx)
+
+	if s.users == nil {
+		return nil, ErrRegistrationDisabled
+	}
+
+	token, err := s.users.ConsumeToken(ctx, user_store.PurposeVerifyEmail, value)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.users.ByID(ctx, token.UserID)
+	if err != nil {
+		logger.Err(err).Str("_subject", token.UserID).Msg("Failed to read user to verify")
+		return nil, err
+	}
+	if len(token.PasswordHash) != 0 && !user.EmailVerified {
+		user.PasswordHash, user.Fields = token.PasswordHash, token.Fields
+	}
+	user.EmailVerified = true
+	if err := s.users.Update(ctx, user); err != nil {
+		logger.Err(err).Str("_subject", user.ID).Msg("Failed to mark email address as verified")
+		return nil, err
+	}
+	logger.Info().Str("_subject", user.ID).Msg("Email address verified")
+
+	result := &LoginResult{Subject: user.ID}
+	if len(token.Challenge) == 0 {
+		return result, nil
+	}
+
+	// the login request may have expired meanwhile or hydra authenticated somebody else in this browser
+	login, err := s.GetLogin(ctx, token.Challenge)
+	if err != nil || login.Request.Skip && login.Request.Subject != user.ID {
+		logger.Info().Msg("Login request of the registration is not pending anymore")
+		return result, nil
+	}
+
+	redirectTo, err := s.admin.AcceptLoginRequest(ctx, token.Challenge, &hydra.AcceptLogin{
+		Acr:     "0",
+		Context: authenticationResponse(user),
+		Subject: user.ID,
+	})
+	if err != nil {
+		logger.Err(err).Msg("Error while communicating with hydra to accept login request")
+		return result, nil
+	}
+	s.auditor.Emit(ctx, &audit.Event{
+		Type:     audit.LoginSucceeded,
+		Subject:  user.ID,
+		ClientID: clientID(login.Request.Client),
+		Methods:  []string{amrEmail},
+	})
+	result.RedirectTo = redirectTo
+	return result, nil
+}
+
+// link returns the absolute url of the given page of the login provider carrying the given token
+func (s *Service) link(path, token string) (string, error) {
+	publicUrl, err := s.conf.PublicUrl()
+	if err != nil {
+		return "", err
+	}
+	if publicUrl == nil {
+		return "", fmt.Errorf("no public url configured to create links to %s", path)
+	}
+
+	link := *publicUrl
+	link.Path = strings.TrimSuffix(link.Path, "/") + path
+	link.RawQuery = url.Values{"token": {token}}.Encode()
+	return link.String(), nil
+}
+
+// authenticationResponse returns the profile of a registered user in the format of the
+// authentication service. It is passed to the consent flow as context of the login.
+func authenticationResponse(user *user_store.User) *profile_api.AuthenticationResponse {
+	return &profile_api.AuthenticationResponse{
+		User: profile_api.User{
+			FirstName:           user.Fields["first_name"],
+			LastName:            user.Fields["last_name"],
+			UserName:            user.Fields["user_name"],
+			Email:               user.Email,
+			PhoneNumber:         user.Fields["phone"],
+			PhoneNumberVerified: user.PhoneVerified,
+			Address:             &profile_api.Address{},
+		},
+	}
+}
@@ -0,0 +1,210 @@
+package flow
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/audit"
+	"login-provider/internal/i18n"
+	"login-provider/internal/sms"
+	"login-provider/internal/user_store"
+	"strings"
+	"time"
+)
+
+// ErrSkipNotAllowed is returned if a user tries to skip the second factor. Only verifying the phone
+// number can be skipped.
+var ErrSkipNotAllowed = errors.New("code must not be skipped")
+
+// The reasons to send a code by SMS
+const (
+	smsSecondFactor = "second_factor"
+	smsVerifyPhone  = "verify_phone"
+)
+
+// SmsChallenge describes the code sent by SMS, which the user has to enter to complete the login
+type SmsChallenge struct {
+	Challenge string
+	// Number is the masked phone number the code has been sent to
+	Number string
+	// Optional is true if the user may skip verifying the phone number
+	Optional bool
+}
+
+// SmsCode is the code sent by SMS, which has been entered by the user
+type SmsCode struct {
+	Challenge string
+	Code      string
+	// Skip is true if the user does not want to verify the phone number now
+	Skip bool
+	// BrowserKey is the key of the browser the password has been entered in
+	BrowserKey string
+}
+
+// requireSmsCode sends a code by SMS if the user has to enter one to complete the login. It returns
+// nil if the login can continue right away.
+func (s *Service) requireSmsCode(ctx context.Context, credentials *Credentials, login *pendingLogin) (*SmsChallenge, error) {
+	logger := log.Ctx(ctx)
+
+	smsConf := s.conf.SmsConfig()
+	phoneNumber := login.authResponse.User.PhoneNumber
+	if len(phoneNumber) == 0 || !smsConf.SecondFactor && !smsConf.VerifyPhone {
+		return nil, nil
+	}
+	number, err := sms.NormalizeNumber(phoneNumber)
+	if err != nil {
+		logger.Warn().Str("_subject", login.subject).Msg("Phone number of user is invalid, no code sent")
+		return nil, nil
+	}
+
+	pending := *login
+	pending.number = number
+	switch {
+	case smsConf.SecondFactor:
+		pending.reason = smsSecondFactor
+	case smsConf.VerifyPhone && pending.stored && !pending.authResponse.User.PhoneNumberVerified:
+		pending.reason = smsVerifyPhone
+	default:
+		return nil, nil
+	}
+
+	if err := s.sendSmsCode(ctx, credentials.Challenge, &pending, credentials.Locale); err != nil {
+		return nil, err
+	}
+	return pending.challenge(credentials.Challenge), nil
+}
+
+// sendSmsCode sends a new code to the phone number of the pending login and keeps the login until
+// the code expires. The number of codes sent to a number is limited, because every message costs
+// money and annoys the owner of the number.
+func (s *Service) sendSmsCode(ctx context.Context, challenge string, pending *pendingLogin, locale string) error {
+	logger := log.Ctx(ctx)
+
+	smsConf := s.conf.SmsConfig()
+	if !s.smsNumbers.Allow("number:"+pending.number, smsConf.MaxPerNumber, smsConf.NumberWindow) {
+		logger.Warn().Str("_subject", pending.subject).Msg("Too many codes sent to phone number")
+		s.auditor.Emit(ctx, &audit.Event{Type: audit.Lockout, Subject: pending.subject, Reason: "sms_number"})
+		return ErrTooManyAttempts
+	}
+
+	code, err := user_store.RandomCode()
+	if err != nil {
+		return err
+	}
+	if err := s.smsGateway.Send(ctx, &sms.Message{
+		To:   pending.number,
+		Body: i18n.Translate(locale, "sms.message", code),
+	}); err != nil {
+		logger.Err(err).Str("_subject", pending.subject).Msg("Failed to send code by SMS")
+		return err
+	}
+
+	// pending logins are shared by concurrent requests, so a copy is kept with the new code
+	updated := *pending
+	updated.step = stepSmsCode
+	updated.codeHash = sha256.Sum256([]byte(code))
+	updated.expiresAt = time.Now().Add(smsConf.CodeTtl)
+	s.pendingLogins.put(challenge, &updated)
+	logger.Info().Str("_subject", pending.subject).Str("_reason", pending.reason).Msg("Code sent by SMS")
+	return nil
+}
+
+func (p *pendingLogin) challenge(challenge string) *SmsChallenge {
+	return &SmsChallenge{
+		Challenge: challenge,
+		Number:    sms.MaskNumber(p.number),
+		Optional:  p.reason == smsVerifyPhone,
+	}
+}
+
+// GetSmsChallenge returns the code the user has to enter to complete the login with the given challenge
+// in the browser with the given key
+func (s *Service) GetSmsChallenge(challenge, browserKey string) (*SmsChallenge, error) {
+	pending := s.pendingLogins.get(challenge, stepSmsCode, browserKey)
+	if pending == nil {
+		return nil, ErrInvalidCode
+	}
+	return pending.challenge(challenge), nil
+}
+
+// ResendSmsCode sends a new code for the login with the given challenge. The previous one becomes invalid.
+func (s *Service) ResendSmsCode(ctx context.Context, challenge, browserKey, locale string) error {
+	pending := s.pendingLogins.get(challenge, stepSmsCode, browserKey)
+	if pending == nil {
+		return ErrInvalidCode
+	}
+	return s.sendSmsCode(ctx, challenge, pending, locale)
+}
+
+// VerifySmsCode continues the login once the user entered the code sent by SMS. The phone
+// number counts as verified from then on. The number of codes entered per login challenge is
+// limited, because the codes are short enough to be guessed otherwise.
+func (s *Service) VerifySmsCode(ctx context.Context, smsCode *SmsCode) (*LoginResult, error) {
+	logger := log.Ctx(ctx)
+
+	pending := s.pendingLogins.get(smsCode.Challenge, stepSmsCode, smsCode.BrowserKey)
+	if pending == nil {
+		return nil, ErrInvalidCode
+	}
+
+	if smsCode.Skip {
+		if pending.reason != smsVerifyPhone {
+			logger.Warn().Str("_subject", pending.subject).Msg("Tried to skip the second factor")
+			s.auditor.Emit(ctx, &audit.Event{Type: audit.MfaFailed, Subject: pending.subject, Reason: "skip_not_allowed"})
+			return nil, ErrSkipNotAllowed
+		}
+		s.pendingLogins.remove(smsCode.Challenge)
+		s.auditor.Emit(ctx, &audit.Event{Type: audit.MfaSkipped, Subject: pending.subject, Reason: pending.reason})
+		return s.completeLogin(ctx, smsCode.Challenge, pending)
+	}
+
+	smsConf := s.conf.SmsConfig()
+	challengeKey := "challenge:" + smsCode.Challenge
+	if !s.smsAttempts.Allow(challengeKey, smsConf.MaxAttempts, smsConf.CodeTtl) {
+		logger.Warn().Str("_subject", pending.subject).Msg("Too many SMS codes entered")
+		s.auditor.Emit(ctx, &audit.Event{Type: audit.Lockout, Subject: pending.subject, Reason: "sms_code"})
+		return nil, ErrTooManyAttempts
+	}
+	codeHash := sha256.Sum256([]byte(strings.TrimSpace(smsCode.Code)))
+	if subtle.ConstantTimeCompare(codeHash[:], pending.codeHash[:]) != 1 {
+		logger.Warn().Str("_subject", pending.subject).Msg("Invalid SMS code entered")
+		s.auditor.Emit(ctx, &audit.Event{Type: audit.MfaFailed, Subject: pending.subject, Methods: []string{"sms"}, Reason: "invalid_code"})
+		return nil, ErrInvalidCode
+	}
+	s.smsAttempts.Reset(challengeKey)
+	s.pendingLogins.remove(smsCode.Challenge)
+	s.auditor.Emit(ctx, &audit.Event{Type: audit.MfaSucceeded, Subject: pending.subject, Methods: []string{"sms"}, Reason: pending.reason})
+
+	verified := *pending
+	authResponse := *pending.authResponse
+	authResponse.User.PhoneNumberVerified = true
+	verified.authResponse = &authResponse
+	if verified.stored {
+		s.markPhoneVerified(ctx, &verified)
+	}
+	return s.completeLogin(ctx, smsCode.Challenge, &verified)
+}
+
+// markPhoneVerified keeps that the user of the user store verified the phone number. The login
+// continues if that fails, the user is asked again next time.
+func (s *Service) markPhoneVerified(ctx context.Context, pending *pendingLogin) {
+	logger := log.Ctx(ctx)
+
+	user, err := s.users.ByID(ctx, pending.subject)
+	if err != nil {
+		logger.Err(err).Str("_subject", pending.subject).Msg("Failed to read user to mark phone number as verified")
+		return
+	}
+	// the number may have been changed meanwhile
+	if number, err := sms.NormalizeNumber(user.Fields["phone"]); err != nil || number != pending.number {
+		return
+	}
+	user.PhoneVerified = true
+	if err := s.users.Update(ctx, user); err != nil {
+		logger.Err(err).Str("_subject", user.ID).Msg("Failed to mark phone number as verified")
+		return
+	}
+	logger.Info().Str("_subject", user.ID).Msg("Phone number verified")
+}
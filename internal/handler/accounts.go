@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/accounts"
+	"login-provider/internal/config"
+	"login-provider/internal/flow"
+	"net/http"
+	"net/url"
+)
+
+// The actions of the account chooser
+const (
+	selectAccount  = "select"
+	anotherAccount = "another"
+	forgetAccount  = "forget"
+)
+
+type accountForm struct {
+	Challenge string `form:"challenge" binding:"required"`
+	Action    string `form:"action" binding:"required"`
+	Subject   string `form:"subject"`
+}
+
+// chooserAccount is an account listed by the account chooser
+type chooserAccount struct {
+	accounts.Account
+	// Current is true if hydra authenticated the user with this account already
+	Current bool
+}
+
+// ChooseAccount handles the decision of the user on the account chooser. Users continue with the
+// account hydra authenticated already, sign in to one of the other remembered accounts or to
+// another one, or let the browser forget an account.
+func ChooseAccount(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logger := log.Ctx(c.Request.Context())
+
+		var accountData accountForm
+		if err := c.ShouldBind(&accountData); err != nil {
+			logger.Err(err).Msg("Failed to parse data from submitted account form")
+			HandleBadRequest(c, conf)
+			return
+		}
+
+		jar, _ := accountJar(c, conf)
+		if jar == nil {
+			logger.Warn().Msg("Account chooser submitted, but disabled")
+			HandleBadRequest(c, conf)
+			return
+		}
+
+		login, err := svc.GetLogin(c.Request.Context(), accountData.Challenge)
+		if err != nil {
+			if handleUnavailable(c, err) {
+				return
+			}
+			// the login request is unknown or expired, the logs tell why
+			HandleBadRequest(c, conf)
+			return
+		}
+
+		negotiateLocale(c, login.UILocales())
+		if login.Request.Client != nil {
+			selectTheme(c, conf, login.Request.Client.ClientID, login.ClientMeta)
+		}
+
+		switch accountData.Action {
+		case forgetAccount:
+			jar.Forget(c.Writer, c.Request, accountData.Subject)
+			c.Redirect(302, "/login?"+url.Values{"login_challenge": {accountData.Challenge}}.Encode())
+		case selectAccount:
+			account, _ := jar.Find(c.Request, accountData.Subject)
+			if login.Request.Skip && accountData.Subject == login.Request.Subject {
+				continueSession(c, svc, conf, jar, login, account)
+				return
+			}
+			signInAgain(c, conf, login, account.Name)
+		case anotherAccount:
+			signInAgain(c, conf, login, "")
+		default:
+			logger.Warn().Str("_action", accountData.Action).Msg("Unknown action submitted from account chooser")
+			HandleBadRequest(c, conf)
+		}
+	}
+}
+
+// accountJar returns the jar of the remembered accounts together with the settings of the account
+// chooser. The jar is nil if the account chooser is disabled.
+func accountJar(c *gin.Context, conf config.Configuration) (*accounts.Jar, *config.AccountsConfig) {
+	accountsConf, err := conf.AccountsConfig()
+	if err != nil {
+		log.Ctx(c.Request.Context()).Err(err).Msg("Failed to read the settings of the account chooser")
+		return nil, nil
+	}
+
+	jar, err := accounts.NewJar(accountsConf)
+	if err != nil {
+		if !errors.Is(err, accounts.ErrDisabled) {
+			log.Ctx(c.Request.Context()).Err(err).Msg("Failed to create the account cookie")
+		}
+		return nil, accountsConf
+	}
+	return jar, accountsConf
+}
+
+func renderAccountChooser(c *gin.Context, login *flow.Login, remembered []accounts.Account) {
+	var current string
+	if login.Request.Skip {
+		current = login.Request.Subject
+	}
+
+	known := false
+	listed := make([]chooserAccount, 0, len(remembered))
+	for _, account := range remembered {
+		listed = append(listed, chooserAccount{Account: account, Current: account.Subject == current})
+		known = known || account.Subject == current
+	}
+
+	render(c, http.StatusOK, "accounts.html", gin.H{
+		"title":     "title.login",
+		"challenge": login.Challenge,
+		"accounts":  listed,
+		// the session hydra knows about can be continued even if the browser forgot the account
+		"current": !known && len(current) != 0,
+		"subject": current,
+	})
+}
+
+// continueSession accepts the login request for the account hydra authenticated the user with already
+func continueSession(c *gin.Context, svc *flow.Service, conf config.Configuration, jar *accounts.Jar, login *flow.Login, account accounts.Account) {
+	redirectTo, err := svc.AcceptSkippedLogin(c.Request.Context(), login)
+	if err != nil {
+		if handleUnavailable(c, err) {
+			return
+		}
+		// hydra refused to continue the session, the logs tell why
+		HandleBadRequest(c, conf)
+		return
+	}
+
+	if len(account.Subject) != 0 {
+		jar.Remember(c.Writer, c.Request, account.Subject, account.Name)
+	}
+	c.Redirect(302, redirectTo)
+}
+
+// signInAgain lets the user sign in to the account with the given email address, respectively to
+// another one if empty. If hydra authenticated the user already, the authorization request has
+// to be restarted.
+func signInAgain(c *gin.Context, conf config.Configuration, login *flow.Login, email string) {
+	if !login.Request.Skip {
+		renderLoginForm(c, conf, login.Challenge, email, "")
+		return
+	}
+
+	switchUrl, err := login.SwitchAccountUrl(email)
+	if err != nil {
+		log.Ctx(c.Request.Context()).Err(err).Msg("Failed to restart the authorization request")
+		HandleBadRequest(c, conf)
+		return
+	}
+	c.Redirect(302, switchUrl)
+}
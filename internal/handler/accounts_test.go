@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"login-provider/internal/accounts"
+	"login-provider/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const acceptLoginRequestPath = loginRequestPath + "/accept"
+
+func accountsConfig() *config.AccountsConfig {
+	return &config.AccountsConfig{CookieSecret: "0123456789abcdef0123456789abcdef", Max: 5, MaxAge: time.Hour}
+}
+
+func loginRequest(skip bool, subject, prompt string) map[string]interface{} {
+	return map[string]interface{}{
+		"challenge":   "foo",
+		"skip":        skip,
+		"subject":     subject,
+		"request_url": "https://hydra.example.com/oauth2/auth?client_id=bar&prompt=" + prompt,
+		"client":      map[string]interface{}{"client_id": "bar"},
+	}
+}
+
+// rememberAccounts returns the cookies of a browser, which remembers the given accounts
+func rememberAccounts(t *testing.T, subjects ...string) []*http.Cookie {
+	jar, err := accounts.NewJar(accountsConfig())
+	require.NoError(t, err)
+
+	var cookies []*http.Cookie
+	for _, subject := range subjects {
+		r := httptest.NewRequest(http.MethodGet, "/login", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		jar.Remember(w, r, subject, "user"+subject+"@example.com")
+		cookies = w.Result().Cookies()
+	}
+	return cookies
+}
+
+func chooseAccount(t *testing.T, router http.Handler, action, subject string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	form := url.Values{}
+	form.Set("challenge", "foo")
+	form.Set("action", action)
+	form.Set("subject", subject)
+
+	req, err := http.NewRequest(http.MethodPost, "/login/account", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	return serve(router, req)
+}
+
+func TestShowLoginPageShowsAccountChooserIfRequested(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(true, "1", "select_account"))
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL, accounts: accountsConfig()})
+	req := httptest.NewRequest(http.MethodGet, "/login?login_challenge=foo", nil)
+	for _, cookie := range rememberAccounts(t, "1", "2") {
+		req.AddCookie(cookie)
+	}
+
+	// WHEN
+	w := serve(router, req)
+
+	// THEN
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "user1@example.com")
+	assert.Contains(t, w.Body.String(), "user2@example.com")
+	assert.Nil(t, hydra.receivedBody(acceptLoginRequestPath), "The login request must not be accepted before the user chose")
+}
+
+func TestChooseAccountContinuesCurrentSession(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(true, "1", "select_account"))
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL, accounts: accountsConfig()})
+
+	// WHEN
+	w := chooseAccount(t, router, "select", "1", rememberAccounts(t, "1", "2"))
+
+	// THEN
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, w.Header().Get("Location"))
+	assert.Equal(t, "1", hydra.receivedBody(acceptLoginRequestPath)["subject"])
+}
+
+func TestChooseAccountRestartsAuthorizationForOtherAccount(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(true, "1", "select_account"))
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL, accounts: accountsConfig()})
+
+	// WHEN
+	w := chooseAccount(t, router, "select", "2", rememberAccounts(t, "1", "2"))
+
+	// THEN
+	require.Equal(t, http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "login", location.Query().Get("prompt"))
+	assert.Equal(t, "user2@example.com", location.Query().Get("login_hint"))
+	assert.Nil(t, hydra.receivedBody(acceptLoginRequestPath))
+}
+
+func TestChooseAccountForgetsAccount(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", "select_account"))
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL, accounts: accountsConfig()})
+
+	// WHEN
+	w := chooseAccount(t, router, "forget", "1", rememberAccounts(t, "1"))
+
+	// THEN
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/login?login_challenge=foo", w.Header().Get("Location"))
+	require.Len(t, w.Result().Cookies(), 1)
+	assert.Equal(t, -1, w.Result().Cookies()[0].MaxAge)
+}
+
+func TestChooseAccountRequiresCookieSecret(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL})
+
+	// WHEN
+	w := chooseAccount(t, router, "another", "", nil)
+
+	// THEN
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,455 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"login-provider/api"
+	"login-provider/internal/client_meta"
+	"login-provider/internal/config"
+	"login-provider/internal/flow"
+	"login-provider/internal/hydra"
+	"net/http"
+	"strings"
+)
+
+// The JSON API allows single page applications to render the login, consent and logout pages
+// themselves. It is described by the OpenAPI spec in api/openapi.yaml.
+
+type apiError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+type apiRedirect struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+type apiClient struct {
+	ClientID   string `json:"client_id"`
+	ClientName string `json:"client_name,omitempty"`
+	ClientURI  string `json:"client_uri,omitempty"`
+	LogoURI    string `json:"logo_uri,omitempty"`
+	PolicyURI  string `json:"policy_uri,omitempty"`
+	TosURI     string `json:"tos_uri,omitempty"`
+}
+
+type apiLoginInfo struct {
+	Challenge   string     `json:"challenge"`
+	Client      *apiClient `json:"client,omitempty"`
+	RegisterUrl string     `json:"register_url,omitempty"`
+	UILocales   []string   `json:"ui_locales,omitempty"`
+}
+
+type apiCredentials struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Email     string `json:"email" binding:"required"`
+	Password  string `json:"password" binding:"required"`
+	Remember  bool   `json:"remember"`
+}
+
+// apiLoginResult contains either the url to redirect to or the next step of the login: the code
+// sent by SMS or the new password
+type apiLoginResult struct {
+	RedirectTo     string             `json:"redirect_to,omitempty"`
+	Sms            *apiSmsChallenge   `json:"sms,omitempty"`
+	PasswordChange *apiPasswordChange `json:"password_change,omitempty"`
+}
+
+type apiSmsChallenge struct {
+	// PhoneNumber is masked, only the last digits are shown
+	PhoneNumber string `json:"phone_number"`
+	Optional    bool   `json:"optional"`
+}
+
+type apiSmsCode struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Code      string `json:"code"`
+	Skip      bool   `json:"skip"`
+}
+
+type apiPasswordChange struct {
+	// Reason is the rule of the password policy the current password breaks, like breached or too_short
+	Reason   string `json:"reason"`
+	Optional bool   `json:"optional"`
+}
+
+type apiNewPassword struct {
+	Challenge            string `json:"challenge" binding:"required"`
+	Password             string `json:"password"`
+	PasswordConfirmation string `json:"password_confirmation"`
+	Skip                 bool   `json:"skip"`
+}
+
+type apiConsentInfo struct {
+	Challenge          string                     `json:"challenge"`
+	Client             *apiClient                 `json:"client,omitempty"`
+	User               string                     `json:"user"`
+	Locale             string                     `json:"locale"`
+	RequestedScopes    []client_meta.ScopeInfo    `json:"requested_scopes"`
+	RequestedAudiences []client_meta.AudienceInfo `json:"requested_audiences"`
+}
+
+type apiConsentDecision struct {
+	Challenge        string   `json:"challenge" binding:"required"`
+	Approved         bool     `json:"approved"`
+	GrantedScopes    []string `json:"granted_scopes"`
+	GrantedAudiences []string `json:"granted_audiences"`
+	Remember         bool     `json:"remember"`
+}
+
+type apiLogoutInfo struct {
+	Challenge   string     `json:"challenge"`
+	Subject     string     `json:"subject,omitempty"`
+	User        string     `json:"user,omitempty"`
+	SessionID   string     `json:"sid,omitempty"`
+	RpInitiated bool       `json:"rp_initiated"`
+	Client      *apiClient `json:"client,omitempty"`
+}
+
+type apiLogoutDecision struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Approved  bool   `json:"approved"`
+}
+
+type apiLogoutResult struct {
+	// RedirectTo is empty if the user declined to log out and there is nowhere to send the user to
+	RedirectTo string `json:"redirect_to,omitempty"`
+	// FrontChannelLogoutUris have to be loaded in iframes before redirecting the user
+	FrontChannelLogoutUris []string `json:"frontchannel_logout_uris,omitempty"`
+}
+
+func registerApiRoutes(e *gin.Engine, svc *flow.Service, conf config.Configuration) {
+	g := e.Group("/api/v1")
+	g.GET("/openapi.yaml", OpenApiSpec)
+	g.GET("/login", GetLoginInfo(svc, conf))
+	g.POST("/login", SubmitCredentials(svc))
+	g.POST("/login/sms", SubmitSmsCode(svc))
+	g.POST("/login/password", SubmitNewPassword(svc))
+	g.GET("/consent", GetConsentInfo(svc))
+	g.POST("/consent", SubmitConsentDecision(svc))
+	g.GET("/logout", GetLogoutInfo(svc))
+	g.POST("/logout", SubmitLogoutDecision(svc))
+}
+
+func OpenApiSpec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/yaml", api.OpenApiSpec)
+}
+
+func GetLoginInfo(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		challenge := c.Query("login_challenge")
+		if len(challenge) == 0 {
+			apiBadRequest(c, "login_challenge is missing")
+			return
+		}
+
+		login, err := svc.GetLogin(c.Request.Context(), challenge)
+		if err != nil {
+			apiFailure(c, err)
+			return
+		}
+
+		// if hydra was already able to authenticate the user, there is nothing to render
+		if login.Request.Skip {
+			redirectTo, err := svc.AcceptSkippedLogin(c.Request.Context(), login)
+			if err != nil {
+				apiFailure(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, &apiRedirect{RedirectTo: redirectTo})
+			return
+		}
+
+		c.JSON(http.StatusOK, &apiLoginInfo{
+			Challenge:   challenge,
+			Client:      newApiClient(login.Request.Client),
+			RegisterUrl: registerUrl(conf, challenge),
+			UILocales:   login.UILocales(),
+		})
+	}
+}
+
+func SubmitCredentials(svc *flow.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var credentials apiCredentials
+		if err := c.ShouldBindJSON(&credentials); err != nil {
+			apiBadRequest(c, err.Error())
+			return
+		}
+
+		key, err := browserKey(c)
+		if err != nil {
+			apiFailure(c, err)
+			return
+		}
+		result, err := svc.Login(c.Request.Context(), &flow.Credentials{
+			Challenge:  credentials.Challenge,
+			Email:      credentials.Email,
+			Password:   credentials.Password,
+			Remember:   credentials.Remember,
+			Locale:     negotiateLocale(c, nil),
+			BrowserKey: key,
+		})
+		if err != nil {
+			apiFailure(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, newApiLoginResult(result))
+	}
+}
+
+func SubmitSmsCode(svc *flow.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var smsCode apiSmsCode
+		if err := c.ShouldBindJSON(&smsCode); err != nil {
+			apiBadRequest(c, err.Error())
+			return
+		}
+
+		result, err := svc.VerifySmsCode(c.Request.Context(), &flow.SmsCode{
+			Challenge:  smsCode.Challenge,
+			Code:       smsCode.Code,
+			Skip:       smsCode.Skip,
+			BrowserKey: currentBrowserKey(c),
+		})
+		if err != nil {
+			apiFailure(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, newApiLoginResult(result))
+	}
+}
+
+func SubmitNewPassword(svc *flow.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var newPassword apiNewPassword
+		if err := c.ShouldBindJSON(&newPassword); err != nil {
+			apiBadRequest(c, err.Error())
+			return
+		}
+
+		result, err := svc.ChangePassword(c.Request.Context(), &flow.PasswordChange{
+			Challenge:            newPassword.Challenge,
+			Password:             newPassword.Password,
+			PasswordConfirmation: newPassword.PasswordConfirmation,
+			Skip:                 newPassword.Skip,
+			BrowserKey:           currentBrowserKey(c),
+		})
+		var validationError *flow.ValidationError
+		switch {
+		case errors.As(err, &validationError):
+			problem := validationError.Problems["password"]
+			if problem == nil {
+				problem = validationError.Problems["password_confirmation"]
+			}
+			c.JSON(http.StatusBadRequest, &apiError{Error: "invalid_password", Description: problem.Error()})
+			return
+		case errors.Is(err, flow.ErrSkipNotAllowed):
+			c.JSON(http.StatusBadRequest, &apiError{Error: "invalid_password", Description: "the password has to be changed"})
+			return
+		case err != nil:
+			apiFailure(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, &apiRedirect{RedirectTo: result.RedirectTo})
+	}
+}
+
+func newApiLoginResult(result *flow.LoginResult) *apiLoginResult {
+	switch {
+	case result.Sms != nil:
+		return &apiLoginResult{Sms: &apiSmsChallenge{
+			PhoneNumber: result.Sms.Number,
+			Optional:    result.Sms.Optional,
+		}}
+	case result.PasswordChange != nil:
+		return &apiLoginResult{PasswordChange: &apiPasswordChange{
+			// the keys of the messages name the rules, e.g. error.password_breached
+			Reason:   strings.TrimPrefix(problemMessage(result.PasswordChange.Reason), "error.password_"),
+			Optional: result.PasswordChange.Optional,
+		}}
+	default:
+		return &apiLoginResult{RedirectTo: result.RedirectTo}
+	}
+}
+
+func GetConsentInfo(svc *flow.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		challenge := c.Query("consent_challenge")
+		if len(challenge) == 0 {
+			apiBadRequest(c, "consent_challenge is missing")
+			return
+		}
+
+		consent, err := svc.GetConsent(c.Request.Context(), challenge)
+		if err != nil {
+			apiFailure(c, err)
+			return
+		}
+
+		if consent.Decision.AutoApprove {
+			redirectTo, err := svc.AcceptConsentAutomatically(c.Request.Context(), consent)
+			if err != nil {
+				apiFailure(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, &apiRedirect{RedirectTo: redirectTo})
+			return
+		}
+
+		locale := negotiateLocale(c, consent.UILocales())
+		c.JSON(http.StatusOK, &apiConsentInfo{
+			Challenge:          challenge,
+			Client:             newApiClient(consent.Request.Client),
+			User:               consent.Authentication.User.UserName,
+			Locale:             locale,
+			RequestedScopes:    consent.ScopeInfos(locale),
+			RequestedAudiences: consent.AudienceInfos(locale),
+		})
+	}
+}
+
+func SubmitConsentDecision(svc *flow.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var decision apiConsentDecision
+		if err := c.ShouldBindJSON(&decision); err != nil {
+			apiBadRequest(c, err.Error())
+			return
+		}
+
+		consent, err := svc.GetConsent(c.Request.Context(), decision.Challenge)
+		if err != nil {
+			apiFailure(c, err)
+			return
+		}
+
+		redirectTo, err := svc.DecideConsent(c.Request.Context(), consent, &flow.ConsentDecision{
+			Approved:         decision.Approved,
+			GrantedScopes:    decision.GrantedScopes,
+			GrantedAudiences: decision.GrantedAudiences,
+			Remember:         decision.Remember,
+		})
+		if err != nil {
+			apiFailure(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, &apiRedirect{RedirectTo: redirectTo})
+	}
+}
+
+func GetLogoutInfo(svc *flow.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		challenge := c.Query("logout_challenge")
+		if len(challenge) == 0 {
+			apiBadRequest(c, "logout_challenge is missing")
+			return
+		}
+
+		logout, err := svc.GetLogout(c.Request.Context(), challenge)
+		if err != nil {
+			apiFailure(c, err)
+			return
+		}
+
+		if !svc.RequiresConfirmation(logout) {
+			apiDecideLogout(c, svc, logout, true)
+			return
+		}
+
+		c.JSON(http.StatusOK, &apiLogoutInfo{
+			Challenge:   challenge,
+			Subject:     logout.Request.Subject,
+			User:        logout.User,
+			SessionID:   logout.Request.Sid,
+			RpInitiated: logout.Request.RpInitiated,
+			Client:      newApiClient(logout.Client),
+		})
+	}
+}
+
+func SubmitLogoutDecision(svc *flow.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var decision apiLogoutDecision
+		if err := c.ShouldBindJSON(&decision); err != nil {
+			apiBadRequest(c, err.Error())
+			return
+		}
+
+		logout, err := svc.GetLogout(c.Request.Context(), decision.Challenge)
+		if err != nil {
+			apiFailure(c, err)
+			return
+		}
+
+		apiDecideLogout(c, svc, logout, decision.Approved)
+	}
+}
+
+func apiDecideLogout(c *gin.Context, svc *flow.Service, logout *flow.Logout, approved bool) {
+	redirectTo, err := svc.DecideLogout(c.Request.Context(), logout, approved)
+	if err != nil {
+		apiFailure(c, err)
+		return
+	}
+
+	result := &apiLogoutResult{RedirectTo: redirectTo}
+	if approved {
+		result.FrontChannelLogoutUris = svc.FrontChannelLogoutUrls(logout)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func newApiClient(client *hydra.Client) *apiClient {
+	if client == nil {
+		return nil
+	}
+	return &apiClient{
+		ClientID:   client.ClientID,
+		ClientName: client.ClientName,
+		ClientURI:  client.ClientURI,
+		LogoURI:    client.LogoURI,
+		PolicyURI:  client.PolicyURI,
+		TosURI:     client.TosURI,
+	}
+}
+
+func apiBadRequest(c *gin.Context, description string) {
+	log.Ctx(c.Request.Context()).Warn().Str("_reason", description).Msg("Invalid API request")
+	c.JSON(http.StatusBadRequest, &apiError{Error: "invalid_request", Description: description})
+}
+
+// apiFailure maps errors returned by the flow service to the corresponding error objects
+func apiFailure(c *gin.Context, err error) {
+	var hydraError *flow.HydraError
+
+	switch {
+	case errors.Is(err, flow.ErrInvalidCredentials):
+		c.JSON(http.StatusUnauthorized, &apiError{Error: "invalid_credentials", Description: err.Error()})
+	case errors.Is(err, flow.ErrEmailNotVerified):
+		c.JSON(http.StatusForbidden, &apiError{Error: "email_not_verified", Description: err.Error()})
+	case errors.Is(err, flow.ErrInvalidCode), errors.Is(err, flow.ErrSkipNotAllowed):
+		c.JSON(http.StatusBadRequest, &apiError{Error: "invalid_code", Description: err.Error()})
+	case errors.Is(err, flow.ErrLoginExpired):
+		c.JSON(http.StatusBadRequest, &apiError{Error: "invalid_request", Description: err.Error()})
+	case errors.Is(err, flow.ErrTooManyAttempts):
+		c.JSON(http.StatusTooManyRequests, &apiError{Error: "too_many_attempts", Description: err.Error()})
+	case errors.Is(err, client_meta.ErrScopeNotRequested):
+		c.JSON(http.StatusBadRequest, &apiError{Error: "invalid_scope", Description: err.Error()})
+	case errors.Is(err, client_meta.ErrAudienceNotRequested):
+		c.JSON(http.StatusBadRequest, &apiError{Error: "invalid_audience", Description: err.Error()})
+	case errors.Is(err, flow.ErrUnavailable):
+		c.Header("Retry-After", retryAfter)
+		c.JSON(http.StatusServiceUnavailable, &apiError{Error: "temporarily_unavailable", Description: "the service is temporarily unavailable, try again later"})
+	case errors.As(err, &hydraError):
+		c.JSON(http.StatusBadGateway, &apiError{Error: "upstream_error", Description: "failed to " + hydraError.Operation})
+	default:
+		c.JSON(http.StatusInternalServerError, &apiError{Error: "server_error"})
+	}
+}
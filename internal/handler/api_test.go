@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const loginRequestPath = "/oauth2/auth/requests/login"
+const logoutRequestPath = "/oauth2/auth/requests/logout"
+
+func callApi(t *testing.T, hydra *fakeHydra, method, path string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL})
+
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		require.NoError(t, err)
+	}
+
+	req, err := http.NewRequest(method, path, bytes.NewReader(payload))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	response := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w, response
+}
+
+// postJson posts the body to the JSON API and returns the status code and the decoded response
+func postJson(t *testing.T, router http.Handler, path string, body map[string]interface{}) (int, map[string]interface{}) {
+	payload, err := json.Marshal(body)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	w := serve(router, req)
+	response := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w.Code, response
+}
+
+func TestApiReturnsConsentInfoWithScopeInfos(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(consentRequestPath, consentRequest(
+		[]string{"openid", "email"},
+		map[string]interface{}{"ask_consent": true, "mandatory_scopes": []string{"openid"}}))
+
+	// WHEN
+	w, response := callApi(t, hydra, http.MethodGet, "/api/v1/consent?consent_challenge=foo", nil)
+
+	// THEN
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "foo", response["challenge"])
+	assert.Equal(t, "bar", response["client"].(map[string]interface{})["client_id"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"scope": "openid", "mandatory": true, "description": "Your identity"},
+		map[string]interface{}{"scope": "email", "mandatory": false, "description": "Your email address"},
+	}, response["requested_scopes"])
+}
+
+func TestApiAcceptsConsentDecision(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(consentRequestPath, consentRequest(
+		[]string{"openid", "email", "profile"},
+		map[string]interface{}{"ask_consent": true, "mandatory_scopes": []string{"openid"}}))
+
+	// WHEN
+	w, response := callApi(t, hydra, http.MethodPost, "/api/v1/consent", map[string]interface{}{
+		"challenge":      "foo",
+		"approved":       true,
+		"granted_scopes": []string{"email"},
+	})
+
+	// THEN
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://hydra.example.com"+acceptConsentRequestPath, response["redirect_to"])
+	assert.ElementsMatch(t, []string{"openid", "email"}, grantedScopes(hydra.receivedBody(acceptConsentRequestPath)))
+}
+
+func TestApiRejectsConsentDecisionWithScopesWhichHaveNotBeenRequested(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(consentRequestPath, consentRequest(
+		[]string{"openid", "email"},
+		map[string]interface{}{"ask_consent": true}))
+
+	// WHEN
+	w, response := callApi(t, hydra, http.MethodPost, "/api/v1/consent", map[string]interface{}{
+		"challenge":      "foo",
+		"approved":       true,
+		"granted_scopes": []string{"email", "admin"},
+	})
+
+	// THEN
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_scope", response["error"])
+	assert.Nil(t, hydra.receivedBody(acceptConsentRequestPath))
+}
+
+func TestApiReturnsLoginInfo(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, map[string]interface{}{
+		"challenge":    "foo",
+		"client":       map[string]interface{}{"client_id": "bar", "client_name": "Bar"},
+		"oidc_context": map[string]interface{}{"ui_locales": []string{"de"}},
+	})
+
+	// WHEN
+	w, response := callApi(t, hydra, http.MethodGet, "/api/v1/login?login_challenge=foo", nil)
+
+	// THEN
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "foo", response["challenge"])
+	assert.Equal(t, "Bar", response["client"].(map[string]interface{})["client_name"])
+	assert.Equal(t, []interface{}{"de"}, response["ui_locales"])
+}
+
+func TestApiRedirectsIfLoginCanBeSkipped(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, map[string]interface{}{"challenge": "foo", "skip": true, "subject": "1"})
+
+	// WHEN
+	w, response := callApi(t, hydra, http.MethodGet, "/api/v1/login?login_challenge=foo", nil)
+
+	// THEN
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://hydra.example.com"+loginRequestPath+"/accept", response["redirect_to"])
+	assert.Equal(t, "1", hydra.receivedBody(loginRequestPath + "/accept")["subject"])
+}
+
+func TestApiRejectsInvalidCredentials(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+
+	// WHEN
+	w, response := callApi(t, hydra, http.MethodPost, "/api/v1/login", map[string]interface{}{
+		"challenge": "foo",
+		"email":     "foo@example.com",
+		"password":  "secret",
+	})
+
+	// THEN
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "invalid_credentials", response["error"])
+}
+
+func TestApiReportsUnavailableHydra(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	hydra.Close()
+
+	// WHEN
+	w, response := callApi(t, hydra, http.MethodGet, "/api/v1/login?login_challenge=foo", nil)
+
+	// THEN
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Equal(t, "temporarily_unavailable", response["error"])
+}
+
+func TestApiRejectsRequestsWithoutChallenge(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+
+	for _, path := range []string{"/api/v1/login", "/api/v1/consent", "/api/v1/logout"} {
+		// WHEN
+		w, response := callApi(t, hydra, http.MethodGet, path, nil)
+
+		// THEN
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+		assert.Equal(t, "invalid_request", response["error"], path)
+	}
+}
+
+func TestApiReportsHydraErrors(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+
+	// WHEN
+	w, response := callApi(t, hydra, http.MethodGet, "/api/v1/logout?logout_challenge=foo", nil)
+
+	// THEN
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, "upstream_error", response["error"])
+}
+
+func TestApiReturnsLogoutInfo(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(logoutRequestPath, map[string]interface{}{"subject": "1", "sid": "baz", "rp_initiated": true})
+
+	// WHEN
+	w, response := callApi(t, hydra, http.MethodGet, "/api/v1/logout?logout_challenge=foo", nil)
+
+	// THEN
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", response["subject"])
+	assert.Equal(t, "baz", response["sid"])
+	assert.Equal(t, true, response["rp_initiated"])
+}
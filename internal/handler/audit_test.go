@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"login-provider/internal/audit"
+	"login-provider/internal/config"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoginsAreAudited(t *testing.T) {
+	// GIVEN
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "audit.log")
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := registrationConfig()
+	conf.hydraAdminUrl = hydra.URL
+	conf.audit = &config.AuditConfig{File: file, MaxSize: 1, MaxBackups: 1}
+	router := newTestRouter(conf)
+	registeredUser(t, router, box, "secret123")
+
+	// WHEN
+	logIn(t, router, "wrong123")
+	logIn(t, router, "secret123")
+
+	// THEN
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	require.NoError(t, (&audit.Verifier{}).Verify(bytes.NewReader(data)))
+	var events []audit.Event
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var event audit.Event
+		require.NoError(t, json.Unmarshal(line, &event))
+		events = append(events, event)
+	}
+	require.Len(t, events, 3)
+	assert.Equal(t, audit.LoginSucceeded, events[0].Type, "Verifying the email address logs the user in")
+	assert.Equal(t, []string{"email"}, events[0].Methods)
+	assert.Equal(t, audit.LoginFailed, events[1].Type)
+	assert.Equal(t, "foo@example.com", events[1].Email)
+	assert.Equal(t, "invalid_credentials", events[1].Reason)
+	assert.Equal(t, audit.LoginSucceeded, events[2].Type)
+	assert.Equal(t, events[0].Subject, events[2].Subject)
+	assert.Equal(t, []string{"pwd"}, events[2].Methods)
+}
+
+func TestLogoutIsAuditedWithClientOfRequestUrl(t *testing.T) {
+	// GIVEN
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "audit.log")
+	// hydra v1 does not return the client of logout requests
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(logoutRequestPath, logoutRequest(true, url.Values{"client_id": {"bar"}}))
+	hydra.respond("/clients/bar", logoutClient(nil))
+	conf := &MockConfiguration{hydraAdminUrl: hydra.URL, audit: &config.AuditConfig{File: file}}
+
+	// WHEN
+	w := submitLogout(t, conf, true)
+
+	// THEN
+	require.Equal(t, http.StatusFound, w.Code)
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	var event audit.Event
+	require.NoError(t, json.Unmarshal(bytes.TrimSpace(data), &event))
+	assert.Equal(t, audit.Logout, event.Type)
+	assert.Equal(t, "bar", event.ClientID)
+	assert.Equal(t, "baz", event.SessionID)
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// browserKeyCookie keeps a random key per browser. The steps of a login after the password, like
+// entering the code sent by SMS, are bound to it, because the login challenge leaks with urls, e.g.
+// in the Referer header.
+const browserKeyCookie = "browser_key"
+
+// browserKey returns the key of the browser and sets the cookie, if the browser has none yet
+func browserKey(c *gin.Context) (string, error) {
+	if key := currentBrowserKey(c); len(key) != 0 {
+		return key, nil
+	}
+
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	key := base64.RawURLEncoding.EncodeToString(data)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     browserKeyCookie,
+		Value:    key,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return key, nil
+}
+
+// currentBrowserKey returns the key of the browser, respectively an empty string if it has none
+func currentBrowserKey(c *gin.Context) string {
+	key, err := c.Cookie(browserKeyCookie)
+	if err != nil {
+		return ""
+	}
+	return key
+}
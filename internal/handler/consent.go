@@ -1,26 +1,24 @@
 package handler
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
-	"github.com/ory/hydra-client-go/client/admin"
-	"github.com/ory/hydra-client-go/models"
 	"github.com/rs/zerolog/log"
 	"login-provider/internal/client_meta"
 	"login-provider/internal/config"
-	"login-provider/internal/hydra"
-	"login-provider/internal/profile_api"
+	"login-provider/internal/flow"
 	"net/http"
-	"time"
 )
 
 type consentForm struct {
-	Challenge       string   `form:"challenge" binding:"required"`
-	GrantedScopes   []string `form:"granted_scopes[]"`
-	Remember        bool     `form:"remember"`
-	ConsentApproved bool     `form:"consent_approved"`
+	Challenge        string   `form:"challenge" binding:"required"`
+	GrantedScopes    []string `form:"granted_scopes[]"`
+	GrantedAudiences []string `form:"granted_audiences[]"`
+	Remember         bool     `form:"remember"`
+	ConsentApproved  bool     `form:"consent_approved"`
 }
 
-func ShowConsentPage(hf *hydra.ClientFactory, conf config.Configuration) gin.HandlerFunc {
+func ShowConsentPage(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		logger := log.Ctx(c.Request.Context())
 
@@ -32,139 +30,90 @@ func ShowConsentPage(hf *hydra.ClientFactory, conf config.Configuration) gin.Han
 			return
 		}
 
-		client := hf.NewClient(c.Request.Context())
-
-		response, err := client.Admin.GetConsentRequest(admin.NewGetConsentRequestParams().
-			WithConsentChallenge(consentChallenge))
+		consent, err := svc.GetConsent(c.Request.Context(), consentChallenge)
 		if err != nil {
-			logger.Err(err).Msg("Error while communicating with hydra to get consent request")
+			if handleUnavailable(c, err) {
+				return
+			}
 			// TODO: This is an internal error (hydra not available, the request is malformed, etc)
 			// So we have to redirect to "something went wrong page - please contact the admin"
-			c.HTML(http.StatusBadRequest, "consent.html", gin.H{"title": "Consent"})
+			render(c, http.StatusBadRequest, "consent.html", gin.H{"title": "title.consent"})
 			return
 		}
 
-		// raw data contains the url to the users profile, as well as all the data, which can be retrieved
-		// from that endpont. So parse it and set the values in AccessToken and IDToken accordingly taking
-		// granted scopes into account
-		authResponse := &profile_api.AuthenticationResponse{}
-		err = authResponse.Unmarshal(response.Payload.Context)
-
-		// check whether consent is required for given client and which consent values are required
-		info := &client_meta.ClientMetaInfo{}
-		err = info.Unmarshal(response.Payload.Client.Metadata)
-
-		if response.Payload.Skip || !info.AskConsent {
-			// grant login request
-			response, err := client.Admin.AcceptConsentRequest(
-				admin.NewAcceptConsentRequestParams().
-					WithConsentChallenge(consentChallenge).
-					WithBody(&models.AcceptConsentRequest{
-						GrantAccessTokenAudience: response.Payload.RequestedAccessTokenAudience,
-						GrantScope:               response.Payload.RequestedScope,
-						RememberFor:              3600,
-						HandledAt:                models.NullTime(time.Now()),
-						Session: &models.ConsentRequestSession{
-							IDToken: authResponse.CreateIdTokenClaims(response.Payload.RequestedScope),
-						},
-					}))
+		locale := negotiateLocale(c, consent.UILocales())
+		selectTheme(c, conf, consent.Request.Client.ClientID, consent.ClientMeta)
 
+		if consent.Decision.AutoApprove {
+			redirectTo, err := svc.AcceptConsentAutomatically(c.Request.Context(), consent)
 			if err != nil {
-				logger.Err(err).Msg("Error while communicating with hydra to accept consent request")
+				if handleUnavailable(c, err) {
+					return
+				}
 				// TODO: This is an internal error (hydra not available, the request is malformed, etc)
 				// So we have to redirect to "something went wrong page - please contact the admin"
-				c.HTML(http.StatusBadRequest, "consent.html", gin.H{"title": "Consent"})
+				render(c, http.StatusBadRequest, "consent.html", gin.H{"title": "title.consent"})
 				return
 			}
 
-			c.Redirect(302, response.Payload.RedirectTo)
+			c.Redirect(302, redirectTo)
 			return
 		}
 
-		// look which requested scopes are mandatory
-		scopeInfos := info.CreateScopeInfos(response.Payload.RequestedScope)
-
 		// If we are here render Consent page
-		c.HTML(http.StatusOK, "consent.html", gin.H{
-			"title":           "Consent",
-			"challenge":       consentChallenge,
-			"requestedScopes": scopeInfos,
-			"user":            authResponse.User.UserName,
-			"client":          response.Payload.Client,
+		render(c, http.StatusOK, "consent.html", gin.H{
+			"title":              "title.consent",
+			"challenge":          consentChallenge,
+			"requestedScopes":    consent.ScopeInfos(locale),
+			"requestedAudiences": consent.AudienceInfos(locale),
+			"user":               consent.Authentication.User.UserName,
+			"client":             consent.Request.Client,
 		})
 	}
 }
 
-func Consent(hf *hydra.ClientFactory, _ config.Configuration) gin.HandlerFunc {
+func Consent(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		logger := log.Ctx(c.Request.Context())
 
 		var consentData consentForm
 		if err := c.ShouldBind(&consentData); err != nil {
 			logger.Err(err).Msg("Failed to parse data from submitted consent form")
-			c.HTML(http.StatusBadRequest, "consent.html", gin.H{"title": "Consent"})
+			render(c, http.StatusBadRequest, "consent.html", gin.H{"title": "title.consent"})
 			return
 		}
 
-		client := hf.NewClient(c.Request.Context())
-
-		if !consentData.ConsentApproved {
-			response, err := client.Admin.RejectConsentRequest(admin.NewRejectConsentRequestParams().
-				WithConsentChallenge(consentData.Challenge).
-				WithBody(&models.RejectRequest{
-					Error:     "User rejected consent",
-					ErrorHint: "consent_rejected",
-				}))
-			if err != nil {
-				logger.Err(err).Msg("Error while communicating with hydra to reject consent request")
-				// TODO: This is an internal error (hydra not available, the request is malformed, etc)
-				// So we have to redirect to "something went wrong page - please contact the admin"
-				c.HTML(http.StatusBadRequest, "consent.html", gin.H{"title": "Consent"})
+		consent, err := svc.GetConsent(c.Request.Context(), consentData.Challenge)
+		if err != nil {
+			if handleUnavailable(c, err) {
 				return
 			}
-
-			c.Redirect(302, response.Payload.RedirectTo)
-			return
-		}
-
-		gcr, err := client.Admin.GetConsentRequest(admin.NewGetConsentRequestParams().
-			WithConsentChallenge(consentData.Challenge))
-		if err != nil {
-			logger.Err(err).Msg("Error while communicating with hydra to get consent request")
 			// TODO: This is an internal error (hydra not available, the request is malformed, etc)
 			// So we have to redirect to "something went wrong page - please contact the admin"
-			c.HTML(http.StatusBadRequest, "consent.html", gin.H{"title": "Consent"})
+			render(c, http.StatusBadRequest, "consent.html", gin.H{"title": "title.consent"})
 			return
 		}
+		selectTheme(c, conf, consent.Request.Client.ClientID, consent.ClientMeta)
 
-		ar := &profile_api.AuthenticationResponse{}
-		err = ar.Unmarshal(gcr.Payload.Context)
-
-		cmi := &client_meta.ClientMetaInfo{}
-		err = cmi.Unmarshal(gcr.Payload.Client.Metadata)
-
-		grantedScopes := append(consentData.GrantedScopes, cmi.MandatoryScopes...)
-
-		acr, err := client.Admin.AcceptConsentRequest(
-			admin.NewAcceptConsentRequestParams().
-				WithConsentChallenge(consentData.Challenge).
-				WithBody(&models.AcceptConsentRequest{
-					GrantAccessTokenAudience: gcr.Payload.RequestedAccessTokenAudience,
-					GrantScope:               append(consentData.GrantedScopes, cmi.MandatoryScopes...),
-					RememberFor:              3600,
-					Remember:                 consentData.Remember,
-					HandledAt:                models.NullTime(time.Now()),
-					Session: &models.ConsentRequestSession{
-						IDToken: ar.CreateIdTokenClaims(grantedScopes),
-					},
-				}))
-		if err != nil {
-			logger.Err(err).Msg("Error while communicating with hydra to accept consent request")
+		redirectTo, err := svc.DecideConsent(c.Request.Context(), consent, &flow.ConsentDecision{
+			Approved:         consentData.ConsentApproved,
+			GrantedScopes:    consentData.GrantedScopes,
+			GrantedAudiences: consentData.GrantedAudiences,
+			Remember:         consentData.Remember,
+		})
+		if errors.Is(err, client_meta.ErrScopeNotRequested) || errors.Is(err, client_meta.ErrAudienceNotRequested) {
+			render(c, http.StatusBadRequest, "consent.html", gin.H{"title": "title.consent"})
+			return
+		} else if err != nil {
+			if handleUnavailable(c, err) {
+				return
+			}
 			// TODO: This is an internal error (hydra not available, the request is malformed, etc)
 			// So we have to redirect to "something went wrong page - please contact the admin"
-			c.HTML(http.StatusBadRequest, "consent.html", gin.H{"title": "Consent"})
+			render(c, http.StatusBadRequest, "consent.html", gin.H{"title": "title.consent"})
 			return
 		}
-		c.Redirect(302, acr.Payload.RedirectTo)
+
+		c.Redirect(302, redirectTo)
 	}
 }
@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"login-provider/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const consentRequestPath = "/oauth2/auth/requests/consent"
+const acceptConsentRequestPath = "/oauth2/auth/requests/consent/accept"
+
+func consentRequest(requestedScopes []string, metadata map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"challenge":       "foo",
+		"subject":         "1",
+		"requested_scope": requestedScopes,
+		"client": map[string]interface{}{
+			"client_id": "bar",
+			"metadata":  metadata,
+		},
+		"context": map[string]interface{}{
+			"user": map[string]interface{}{"id": 1, "email": "foo@example.com"},
+		},
+	}
+}
+
+func submitConsent(t *testing.T, hydra *fakeHydra, grantedScopes ...string) *httptest.ResponseRecorder {
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL})
+
+	form := url.Values{}
+	form.Set("challenge", "foo")
+	form.Set("consent_approved", "true")
+	for _, scope := range grantedScopes {
+		form.Add("granted_scopes[]", scope)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/consent", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func grantedScopes(body map[string]interface{}) []string {
+	var scopes []string
+	for _, scope := range body["grant_scope"].([]interface{}) {
+		scopes = append(scopes, scope.(string))
+	}
+	return scopes
+}
+
+func TestConsentGrantsSelectedAndMandatoryScopes(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(consentRequestPath, consentRequest(
+		[]string{"openid", "email", "profile"},
+		map[string]interface{}{"ask_consent": true, "mandatory_scopes": []string{"openid"}}))
+
+	// WHEN
+	w := submitConsent(t, hydra, "email")
+
+	// THEN
+	require.Equal(t, http.StatusFound, w.Code)
+	body := hydra.receivedBody(acceptConsentRequestPath)
+	require.NotNil(t, body)
+	assert.ElementsMatch(t, []string{"openid", "email"}, grantedScopes(body))
+}
+
+func TestConsentRejectsScopesWhichHaveNotBeenRequested(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(consentRequestPath, consentRequest(
+		[]string{"openid", "email"},
+		map[string]interface{}{"ask_consent": true}))
+
+	// WHEN
+	w := submitConsent(t, hydra, "email", "groups")
+
+	// THEN
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Nil(t, hydra.receivedBody(acceptConsentRequestPath), "Consent must not be accepted")
+}
+
+func TestConsentDoesNotGrantMandatoryScopesWhichHaveNotBeenRequested(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(consentRequestPath, consentRequest(
+		[]string{"openid"},
+		map[string]interface{}{"ask_consent": true, "mandatory_scopes": []string{"openid", "offline"}}))
+
+	// WHEN
+	w := submitConsent(t, hydra)
+
+	// THEN
+	require.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, []string{"openid"}, grantedScopes(hydra.receivedBody(acceptConsentRequestPath)))
+}
+
+func TestConsentDoesNotGrantDuplicateScopes(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(consentRequestPath, consentRequest(
+		[]string{"openid", "email"},
+		map[string]interface{}{"ask_consent": true, "mandatory_scopes": []string{"openid"}}))
+
+	// WHEN
+	w := submitConsent(t, hydra, "email", "email", "openid")
+
+	// THEN
+	require.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, []string{"openid", "email"}, grantedScopes(hydra.receivedBody(acceptConsentRequestPath)))
+}
+
+// audienceConsentRequest requests the given audiences besides the openid scope
+func audienceConsentRequest(requestedAudiences []string, metadata map[string]interface{}) map[string]interface{} {
+	request := consentRequest([]string{"openid"}, metadata)
+	request["requested_access_token_audience"] = requestedAudiences
+	return request
+}
+
+func submitAudiences(t *testing.T, hydra *fakeHydra, grantedAudiences ...string) *httptest.ResponseRecorder {
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL})
+
+	form := url.Values{}
+	form.Set("challenge", "foo")
+	form.Set("consent_approved", "true")
+	form.Add("granted_scopes[]", "openid")
+	for _, audience := range grantedAudiences {
+		form.Add("granted_audiences[]", audience)
+	}
+	return postForm(t, router, "/consent", form)
+}
+
+func TestConsentDoesNotGrantDeselectedAudiences(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(consentRequestPath, audienceConsentRequest(
+		[]string{"https://api.example.com", "https://mail.example.com", "https://files.example.com"},
+		map[string]interface{}{"ask_consent": true, "mandatory_audiences": []string{"https://api.example.com"}}))
+
+	// WHEN
+	w := submitAudiences(t, hydra, "https://mail.example.com")
+
+	// THEN
+	require.Equal(t, http.StatusFound, w.Code)
+	body := hydra.receivedBody(acceptConsentRequestPath)
+	require.NotNil(t, body)
+	assert.Equal(t, []interface{}{"https://api.example.com", "https://mail.example.com"}, body["grant_access_token_audience"])
+}
+
+func TestConsentRejectsAudiencesWhichHaveNotBeenRequested(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(consentRequestPath, audienceConsentRequest(
+		[]string{"https://api.example.com"},
+		map[string]interface{}{"ask_consent": true}))
+
+	// WHEN
+	w := submitAudiences(t, hydra, "https://api.example.com", "https://admin.example.com")
+
+	// THEN
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Nil(t, hydra.receivedBody(acceptConsentRequestPath), "Consent must not be accepted")
+}
+
+func TestConsentPageIsRenderedInRequestedLocale(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	cr := consentRequest(
+		[]string{"openid", "email"},
+		map[string]interface{}{"ask_consent": true})
+	cr["oidc_context"] = map[string]interface{}{"ui_locales": []string{"de-DE"}}
+	hydra.respond(consentRequestPath, cr)
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL})
+
+	req, err := http.NewRequest(http.MethodGet, "/consent?consent_challenge=foo", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Language", "en")
+
+	// WHEN
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// THEN
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Ihre E-Mail-Adresse")
+	assert.Contains(t, w.Body.String(), "Erlauben")
+	assert.Contains(t, w.Body.String(), "lang=en")
+}
+
+func TestConsentPageIsRenderedInThemeOfClient(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(consentRequestPath, consentRequest(
+		[]string{"openid", "email"},
+		map[string]interface{}{"ask_consent": true, "theme": "acme"}))
+	router := newTestRouter(&MockConfiguration{
+		hydraAdminUrl: hydra.URL,
+		themes: map[string]*config.Theme{
+			config.DefaultTheme: {Name: config.DefaultTheme},
+			"acme":              {Name: "acme", PrimaryColor: "#ff6600", CssUrl: "https://acme.example.com/login.css"},
+		},
+	})
+
+	req, err := http.NewRequest(http.MethodGet, "/consent?consent_challenge=foo", nil)
+	require.NoError(t, err)
+
+	// WHEN
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// THEN
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "background-color: #ff6600")
+	assert.Contains(t, w.Body.String(), `href="https://acme.example.com/login.css"`)
+}
+
+func TestConsentPageReportsUnavailableHydra(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	hydra.Close()
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL})
+
+	req, err := http.NewRequest(http.MethodGet, "/consent?consent_challenge=foo", nil)
+	require.NoError(t, err)
+
+	// WHEN
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// THEN
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "The service is temporarily unavailable")
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/flow"
+	"login-provider/internal/middleware"
+	"net/http"
+)
+
+type deviceForm struct {
+	Challenge string `form:"challenge" binding:"required"`
+	UserCode  string `form:"user_code" binding:"required"`
+}
+
+// ShowDevicePage asks the user for the code shown by the device. Hydra sends users to this page
+// with a device challenge ("urls.device.verification"). The user code is prefilled if the device
+// showed a link containing it.
+func ShowDevicePage(c *gin.Context) {
+	var deviceChallenge string
+	if deviceChallenge = c.Query("device_challenge"); len(deviceChallenge) == 0 {
+		render(c, http.StatusBadRequest, "device.html", gin.H{"title": "title.device", "error": "error.device_failed"})
+		return
+	}
+
+	render(c, http.StatusOK, "device.html", gin.H{
+		"title":     "title.device",
+		"challenge": deviceChallenge,
+		"user_code": c.Query("user_code"),
+	})
+}
+
+// Device lets hydra verify the entered user code. The user continues with the login and consent
+// pages if the code is valid.
+func Device(svc *flow.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logger := log.Ctx(c.Request.Context())
+
+		var deviceData deviceForm
+		if err := c.ShouldBind(&deviceData); err != nil {
+			logger.Err(err).Msg("Failed to parse data from submitted device form")
+			render(c, http.StatusBadRequest, "device.html", gin.H{"title": "title.device", "error": "error.device_failed"})
+			return
+		}
+
+		redirectTo, err := svc.VerifyUserCode(c.Request.Context(), &flow.UserCode{
+			Challenge: deviceData.Challenge,
+			Code:      deviceData.UserCode,
+			ClientIP:  c.GetString(middleware.ClientIPKey),
+		})
+		if err != nil {
+			if handleUnavailable(c, err) {
+				return
+			}
+
+			status, message := http.StatusBadRequest, "error.device_failed"
+			switch {
+			case errors.Is(err, flow.ErrInvalidUserCode):
+				message = "error.invalid_user_code"
+			case errors.Is(err, flow.ErrTooManyAttempts):
+				status, message = http.StatusTooManyRequests, "error.too_many_attempts"
+			}
+			render(c, status, "device.html", gin.H{
+				"title":     "title.device",
+				"challenge": deviceData.Challenge,
+				"error":     message,
+			})
+			return
+		}
+
+		c.Redirect(302, redirectTo)
+	}
+}
+
+// DeviceDone shows the page users end up on after authorizing a device. Hydra's
+// "urls.device.success" has to point to it.
+func DeviceDone(c *gin.Context) {
+	render(c, http.StatusOK, "device_done.html", gin.H{"title": "title.device"})
+}
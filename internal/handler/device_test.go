@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const acceptUserCodeRequestPath = "/admin/oauth2/auth/requests/device/accept"
+
+// newDeviceHydra creates a fake hydra v2, which supports the device authorization grant
+func newDeviceHydra() *fakeHydra {
+	hydra := newFakeHydra()
+	hydra.respond("/version", map[string]string{"version": "v2.3.0"})
+	return hydra
+}
+
+func submitUserCode(t *testing.T, router http.Handler, userCode string) *httptest.ResponseRecorder {
+	form := url.Values{}
+	form.Set("challenge", "foo")
+	form.Set("user_code", userCode)
+
+	req, err := http.NewRequest(http.MethodPost, "/device", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return serve(router, req)
+}
+
+func TestShowDevicePagePrefillsUserCode(t *testing.T) {
+	// GIVEN
+	hydra := newDeviceHydra()
+	defer hydra.Close()
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL})
+	req := httptest.NewRequest(http.MethodGet, "/device?device_challenge=foo&user_code=ABCD-EFGH", nil)
+
+	// WHEN
+	w := serve(router, req)
+
+	// THEN
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `value="ABCD-EFGH"`)
+	assert.Contains(t, w.Body.String(), `name="challenge" value="foo"`)
+}
+
+func TestDeviceAcceptsNormalizedUserCode(t *testing.T) {
+	// GIVEN
+	hydra := newDeviceHydra()
+	defer hydra.Close()
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL})
+
+	// WHEN
+	w := submitUserCode(t, router, "abcd-efgh ")
+
+	// THEN
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://hydra.example.com"+acceptUserCodeRequestPath, w.Header().Get("Location"))
+	assert.Equal(t, "ABCDEFGH", hydra.receivedBody(acceptUserCodeRequestPath)["user_code"])
+}
+
+func TestDeviceRejectsInvalidUserCode(t *testing.T) {
+	// GIVEN
+	hydra := newDeviceHydra()
+	defer hydra.Close()
+	hydra.respond(acceptUserCodeRequestPath, http.StatusNotFound)
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL})
+
+	// WHEN
+	w := submitUserCode(t, router, "ABCDEFGH")
+
+	// THEN
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "The code is invalid or expired")
+	assert.Contains(t, w.Body.String(), `name="challenge" value="foo"`)
+}
+
+func TestDeviceLimitsAttempts(t *testing.T) {
+	// GIVEN
+	hydra := newDeviceHydra()
+	defer hydra.Close()
+	hydra.respond(acceptUserCodeRequestPath, http.StatusNotFound)
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL})
+	for i := 0; i < 3; i++ {
+		submitUserCode(t, router, "ABCDEFGH")
+	}
+	hydra.respond(acceptUserCodeRequestPath, map[string]string{})
+
+	// WHEN
+	w := submitUserCode(t, router, "BCDFGHJK")
+
+	// THEN
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "Too many attempts")
+}
+
+func TestDeviceKeepsAttemptsOfAddressAfterValidUserCode(t *testing.T) {
+	// GIVEN
+	hydra := newDeviceHydra()
+	defer hydra.Close()
+	hydra.respond(acceptUserCodeRequestPath, http.StatusNotFound)
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL})
+	for i := 0; i < 2; i++ {
+		submitUserCode(t, router, "ABCDEFGH")
+	}
+	// the attacker enters the code of a device flow it started itself
+	hydra.respond(acceptUserCodeRequestPath, map[string]string{"redirect_to": "https://hydra.example.com/login"})
+	valid := submitUserCode(t, router, "BCDFGHJK")
+
+	// WHEN
+	w := submitUserCode(t, router, "CDFGHJKL")
+
+	// THEN
+	assert.Equal(t, http.StatusFound, valid.Code)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestDeviceLimitsAttemptsPerForwardedAddressOfTrustedProxy(t *testing.T) {
+	// GIVEN
+	hydra := newDeviceHydra()
+	defer hydra.Close()
+	hydra.respond(acceptUserCodeRequestPath, http.StatusNotFound)
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL, trustedProxies: []*net.IPNet{proxies}})
+	submit := func(challenge, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/device", strings.NewReader(url.Values{
+			"challenge": {challenge},
+			"user_code": {"ABCDEFGH"},
+		}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		return serve(router, req)
+	}
+	// forged headers of clients connecting directly do not spread the attempts
+	for i := 0; i < 3; i++ {
+		submit("challenge"+strconv.Itoa(i), "192.0.2.1:4711", "198.51.100."+strconv.Itoa(i))
+	}
+
+	// WHEN
+	direct := submit("foo", "192.0.2.1:4711", "198.51.100.99")
+	proxied := submit("bar", "10.0.0.1:4711", "198.51.100.99")
+
+	// THEN
+	assert.Equal(t, http.StatusTooManyRequests, direct.Code)
+	assert.Equal(t, http.StatusBadRequest, proxied.Code, "Clients behind the trusted proxy have their own attempts")
+}
+
+func TestDeviceRequiresHydraV2(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL})
+
+	// WHEN
+	w := submitUserCode(t, router, "ABCDEFGH")
+
+	// THEN
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "The device could not be connected")
+}
+
+func TestDeviceDoneShowsSuccess(t *testing.T) {
+	// GIVEN
+	router := newTestRouter(&MockConfiguration{})
+
+	// WHEN
+	w := serve(router, httptest.NewRequest(http.MethodGet, "/device/done", nil))
+
+	// THEN
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Your device has been connected.")
+}
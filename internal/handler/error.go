@@ -1,13 +1,47 @@
 package handler
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"login-provider/internal/config"
+	"login-provider/internal/flow"
 	"net/http"
+	"net/url"
 )
 
+// retryAfter is the number of seconds users are asked to wait before trying again if hydra or
+// the authentication service is not available
+const retryAfter = "30"
+
+// registerUrl returns the url of the registration page, respectively an empty string if not configured.
+// The built-in registration returns to the given login challenge after the email address is verified.
+func registerUrl(conf config.Configuration, challenge string) string {
+	if conf.RegistrationConfig().Enabled {
+		if len(challenge) == 0 {
+			return "/register"
+		}
+		return "/register?" + url.Values{"login_challenge": {challenge}}.Encode()
+	}
+	if u, err := conf.RegisterUrl(); err == nil && u != nil {
+		return u.String()
+	}
+	return ""
+}
+
 func HandleBadRequest(c *gin.Context, conf config.Configuration) {
-	c.HTML(http.StatusBadRequest,
+	render(c, http.StatusBadRequest,
 		"login.html",
-		gin.H{"title": "Login", "register_url": conf.RegisterUrl()})
+		gin.H{"title": "title.login", "register_url": registerUrl(conf, "")})
+}
+
+// handleUnavailable renders the "temporarily unavailable" page if the given error was caused by an
+// unavailable upstream service. It returns false for all other errors.
+func handleUnavailable(c *gin.Context, err error) bool {
+	if !errors.Is(err, flow.ErrUnavailable) {
+		return false
+	}
+
+	c.Header("Retry-After", retryAfter)
+	render(c, http.StatusServiceUnavailable, "unavailable.html", gin.H{"title": "title.unavailable"})
+	return true
 }
@@ -1,26 +1,121 @@
 package handler
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
+	"io/fs"
+	"login-provider/internal/audit"
+	"login-provider/internal/backchannel"
+	"login-provider/internal/cert_manager"
 	"login-provider/internal/config"
+	"login-provider/internal/flow"
 	"login-provider/internal/hydra"
+	"login-provider/internal/mail"
+	"login-provider/internal/profile_api"
+	"login-provider/internal/sms"
+	"login-provider/internal/user_store"
+	"login-provider/web"
 )
 
-func RegisterRoutes(e *gin.Engine, conf config.Configuration) {
-	hf, err := hydra.NewClientFactory(conf)
+// newMailSender creates the sender of the emails. Tests replace it to capture the emails.
+var newMailSender = func(conf config.Configuration) mail.Sender {
+	return mail.NewSmtpSender(conf)
+}
+
+// newSmsGateway creates the gateway the codes are sent by SMS with. Tests replace it to capture the messages.
+var newSmsGateway = func(conf config.Configuration) (sms.Gateway, error) {
+	gateway, err := sms.NewConfiguredGateway(conf)
+	if err != nil {
+		return nil, err
+	}
+	config.OnChange(gateway.Reconfigure)
+	return gateway, nil
+}
+
+// RegisterRoutes registers all end points. certs is nil if the login provider serves plain HTTP.
+func RegisterRoutes(e *gin.Engine, conf config.Configuration, certs *cert_manager.Manager) {
+	admin, err := hydra.NewAdminClient(conf)
 	if err != nil {
 		l := log.With().Err(err).Logger()
-		l.Fatal().Msg("Failed to create hydra client factory")
+		l.Fatal().Msg("Failed to create hydra admin client")
 	}
 
-	e.GET("/login", ShowLoginPage(hf, conf))
-	e.POST("/login", Login(hf, conf))
-	e.GET("/consent", ShowConsentPage(hf, conf))
-	e.POST("/consent", Consent(hf, conf))
-	e.GET("/logout", ShowLogoutPage(hf, conf))
-	e.POST("/logout", Logout(hf, conf))
+	profiles, err := profile_api.NewClient(conf)
+	if err != nil {
+		l := log.With().Err(err).Logger()
+		l.Fatal().Msg("Failed to create authentication service client")
+	}
+
+	notifier, err := backchannel.NewNotifier(conf.LogoutConfig())
+	if err != nil {
+		l := log.With().Err(err).Logger()
+		l.Fatal().Msg("Failed to create back-channel logout notifier")
+	}
+
+	users, err := user_store.New(conf.UsersConfig())
+	if err != nil && !errors.Is(err, user_store.ErrDisabled) {
+		l := log.With().Err(err).Logger()
+		l.Fatal().Msg("Failed to create user store")
+	}
+
+	smsGateway, err := newSmsGateway(conf)
+	if err != nil {
+		l := log.With().Err(err).Logger()
+		l.Fatal().Msg("Failed to create SMS gateway")
+	}
+
+	auditor, err := audit.NewEmitter(conf)
+	if err != nil {
+		l := log.With().Err(err).Logger()
+		l.Fatal().Msg("Failed to create audit emitter")
+	}
+
+	// the upstream clients, the notifier and the audit sinks follow configuration changes
+	config.OnChange(admin.Reconfigure)
+	config.OnChange(profiles.Reconfigure)
+	config.OnChange(notifier.Reconfigure)
+	config.OnChange(auditor.Reconfigure)
+
+	svc := flow.NewService(admin, profiles, users, newMailSender(conf), smsGateway, notifier, auditor, conf)
+	notifier.Subscribe(svc.ClearLocalState)
+
+	e.GET("/login", ShowLoginPage(svc, conf))
+	e.POST("/login", Login(svc, conf))
+	e.POST("/login/account", ChooseAccount(svc, conf))
+	e.GET("/login/sms", ShowSmsPage(svc, conf))
+	e.POST("/login/sms", VerifySms(svc, conf))
+	e.POST("/login/sms/resend", ResendSms(svc, conf))
+	e.GET("/login/password", ShowPasswordChangePage(svc, conf))
+	e.POST("/login/password", ChangePassword(svc, conf))
+	e.GET("/login/email", ShowEmailLoginPage(svc, conf))
+	e.POST("/login/email", EmailLogin(svc, conf))
+	e.POST("/login/email/code", EmailLoginCode(svc, conf))
+	e.GET("/login/email/verify", EmailLoginLink(svc))
+	e.GET("/register", ShowRegisterPage(svc, conf))
+	e.POST("/register", Register(svc, conf))
+	e.GET("/register/verify", VerifyEmail(svc))
+	e.GET("/password/forgot", ShowForgotPasswordPage(svc, conf))
+	e.POST("/password/forgot", ForgotPassword(svc, conf))
+	e.GET("/password/reset", ShowResetPasswordPage(conf))
+	e.POST("/password/reset", ResetPassword(svc, conf))
+	e.GET("/consent", ShowConsentPage(svc, conf))
+	e.POST("/consent", Consent(svc, conf))
+	e.GET("/logout", ShowLogoutPage(svc, conf))
+	e.POST("/logout", Logout(svc, conf))
+	e.GET("/logout/done", LoggedOut)
+	e.GET("/.well-known/logout-keys.json", LogoutKeys(notifier))
+	e.GET("/device", ShowDevicePage)
+	e.POST("/device", Device(svc))
+	e.GET("/device/done", DeviceDone)
+
+	registerApiRoutes(e, svc, conf)
+
+	staticAssets, _ := fs.Sub(web.Static, "static")
+	static := Static(staticAssets, conf.StaticDirectory())
+	e.GET("/static/*filepath", static)
+	e.HEAD("/static/*filepath", static)
 
 	e.GET("/health/alive", Alive)
-	e.GET("/health/ready", Ready(hf))
+	e.GET("/health/ready", Ready(admin, certs))
 }
@@ -0,0 +1,348 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/mock"
+	"io/fs"
+	"login-provider/internal/config"
+	"login-provider/internal/i18n"
+	"login-provider/internal/mail"
+	"login-provider/internal/middleware"
+	"login-provider/internal/theme"
+	"login-provider/web"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type MockConfiguration struct {
+	mock.Mock
+	hydraAdminUrl  string
+	themes         map[string]*config.Theme
+	logout         *config.LogoutConfig
+	accounts       *config.AccountsConfig
+	registration   *config.RegistrationConfig
+	passwordReset  *config.PasswordResetConfig
+	passwordless   *config.PasswordlessConfig
+	sms            *config.SmsConfig
+	passwordPolicy *config.PasswordPolicyConfig
+	audit          *config.AuditConfig
+	trustedProxies []*net.IPNet
+}
+
+func (c *MockConfiguration) Address() string {
+	return ":8080"
+}
+
+func (c *MockConfiguration) MetricsAddress() string {
+	return ""
+}
+
+func (c *MockConfiguration) TlsConfig() (*config.TlsConfig, error) {
+	return nil, errors.New("no TLS configured")
+}
+
+func (c *MockConfiguration) LogLevel() (zerolog.Level, error) {
+	return zerolog.InfoLevel, nil
+}
+
+func (c *MockConfiguration) TlsTrustStore() (string, error) {
+	return "", errors.New("no trust store configured")
+}
+
+func (c *MockConfiguration) UpstreamTlsConfig(config.Upstream) *config.UpstreamTlsConfig {
+	return &config.UpstreamTlsConfig{}
+}
+
+func (c *MockConfiguration) UpstreamAuthConfig(config.Upstream) (*config.UpstreamAuthConfig, error) {
+	return &config.UpstreamAuthConfig{}, nil
+}
+
+func (c *MockConfiguration) ResilienceConfig(config.Upstream) *config.ResilienceConfig {
+	return &config.ResilienceConfig{}
+}
+
+func (c *MockConfiguration) RegisterUrl() (*url.URL, error) {
+	return nil, nil
+}
+
+func (c *MockConfiguration) PublicUrl() (*url.URL, error) {
+	return url.Parse("https://login.example.com/")
+}
+
+func (c *MockConfiguration) HydraAdminUrl() (*url.URL, error) {
+	return url.Parse(c.hydraAdminUrl)
+}
+
+// HydraApiVersion lets the login provider ask the fake hydra for its version
+func (c *MockConfiguration) HydraApiVersion() (config.HydraVersion, error) {
+	return config.HydraAuto, nil
+}
+
+// AuthenticateUrl points to the fake hydra, which rejects all credentials unless told otherwise
+func (c *MockConfiguration) AuthenticateUrl() (*url.URL, error) {
+	return url.Parse(c.hydraAdminUrl + "/authenticate")
+}
+
+func (c *MockConfiguration) ClaimsConfig() *config.ClaimsConfig {
+	return &config.ClaimsConfig{GroupsScope: "groups", RolesScope: "roles", AttributesScope: "attributes"}
+}
+
+func (c *MockConfiguration) ConsentConfig() *config.ConsentConfig {
+	return &config.ConsentConfig{}
+}
+
+func (c *MockConfiguration) LogoutConfig() *config.LogoutConfig {
+	if c.logout == nil {
+		return &config.LogoutConfig{AutoAcceptRpInitiated: true, BackChannel: &config.BackChannelConfig{}}
+	}
+	return c.logout
+}
+
+func (c *MockConfiguration) DeviceConfig() *config.DeviceConfig {
+	return &config.DeviceConfig{MaxAttempts: 3, AttemptWindow: time.Minute}
+}
+
+func (c *MockConfiguration) AccountsConfig() (*config.AccountsConfig, error) {
+	if c.accounts == nil {
+		return &config.AccountsConfig{Max: 5}, nil
+	}
+	return c.accounts, nil
+}
+
+// UsersConfig enables the in memory user store together with the registration, the password reset or
+// the passwordless login
+func (c *MockConfiguration) UsersConfig() *config.UsersConfig {
+	if c.registration == nil && c.passwordReset == nil && c.passwordless == nil {
+		return &config.UsersConfig{}
+	}
+	return &config.UsersConfig{Store: config.UsersStoreMemory}
+}
+
+func (c *MockConfiguration) RegistrationConfig() *config.RegistrationConfig {
+	if c.registration == nil {
+		return &config.RegistrationConfig{}
+	}
+	return c.registration
+}
+
+func (c *MockConfiguration) PasswordResetConfig() *config.PasswordResetConfig {
+	if c.passwordReset == nil {
+		return &config.PasswordResetConfig{}
+	}
+	return c.passwordReset
+}
+
+func (c *MockConfiguration) SmsConfig() *config.SmsConfig {
+	if c.sms == nil {
+		return &config.SmsConfig{}
+	}
+	return c.sms
+}
+
+func (c *MockConfiguration) TrustedProxies() ([]*net.IPNet, error) {
+	return c.trustedProxies, nil
+}
+
+func (c *MockConfiguration) AuditConfig() (*config.AuditConfig, error) {
+	if c.audit == nil {
+		return &config.AuditConfig{}, nil
+	}
+	return c.audit, nil
+}
+
+func (c *MockConfiguration) PasswordlessConfig() *config.PasswordlessConfig {
+	if c.passwordless == nil {
+		return &config.PasswordlessConfig{}
+	}
+	return c.passwordless
+}
+
+func (c *MockConfiguration) PasswordPolicyConfig() *config.PasswordPolicyConfig {
+	if c.passwordPolicy == nil {
+		return &config.PasswordPolicyConfig{MinLength: 8, RequireDigit: true}
+	}
+	return c.passwordPolicy
+}
+
+func (c *MockConfiguration) MailConfig() (*config.MailConfig, error) {
+	return &config.MailConfig{From: "login@example.com"}, nil
+}
+
+func (c *MockConfiguration) TemplatesDirectory() string {
+	return ""
+}
+
+func (c *MockConfiguration) StaticDirectory() string {
+	return ""
+}
+
+func (c *MockConfiguration) Themes() map[string]*config.Theme {
+	if c.themes == nil {
+		return map[string]*config.Theme{config.DefaultTheme: {Name: config.DefaultTheme}}
+	}
+	return c.themes
+}
+
+func (c *MockConfiguration) ClientThemes() map[string]string {
+	return map[string]string{}
+}
+
+// fakeHydra simulates the admin API of hydra. The responses for GET requests are configured
+// per path, the bodies of PUT requests and the query parameters of DELETE requests are recorded
+// per path.
+type fakeHydra struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	responses map[string]interface{}
+	received  map[string]map[string]interface{}
+}
+
+func newFakeHydra() *fakeHydra {
+	fh := &fakeHydra{
+		// the login provider detects the version of the admin API
+		responses: map[string]interface{}{"/version": map[string]string{"version": "v1.10.6"}},
+		received:  make(map[string]map[string]interface{}),
+	}
+	fh.Server = httptest.NewServer(http.HandlerFunc(fh.serveHTTP))
+	return fh
+}
+
+func (fh *fakeHydra) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// credentials are rejected unless a user is configured as response
+	if _, ok := fh.responses[r.URL.Path]; !ok && r.URL.Path == "/authenticate" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// an int configured as response is sent as status code
+	if status, ok := fh.responses[r.URL.Path].(int); ok {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": http.StatusText(status)})
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		body := make(map[string]interface{})
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		fh.received[r.URL.Path] = body
+		if strings.HasSuffix(r.URL.Path, "/logout/reject") {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"redirect_to": "https://hydra.example.com" + r.URL.Path})
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		params := make(map[string]interface{})
+		for name := range r.URL.Query() {
+			params[name] = r.URL.Query().Get(name)
+		}
+		fh.received[r.URL.Path] = params
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	response, ok := fh.responses[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Not Found"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func (fh *fakeHydra) respond(path string, response interface{}) {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+	fh.responses[path] = response
+}
+
+func (fh *fakeHydra) receivedBody(path string) map[string]interface{} {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+	return fh.received[path]
+}
+
+// mailbox captures the emails sent by the login provider
+type mailbox struct {
+	mutex    sync.Mutex
+	messages []*mail.Message
+}
+
+func (m *mailbox) Send(_ context.Context, message *mail.Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func (m *mailbox) received() []*mail.Message {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.messages
+}
+
+// captureMails lets the routers created afterwards send their emails to the returned mailbox. The
+// returned function restores the SMTP sender.
+func captureMails() (*mailbox, func()) {
+	box := &mailbox{}
+	previous := newMailSender
+	newMailSender = func(config.Configuration) mail.Sender { return box }
+	return box, func() { newMailSender = previous }
+}
+
+func newTestRouter(conf config.Configuration) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.ClientIP(conf))
+	router.Use(middleware.AuditOrigin())
+	router.Use(middleware.Locale())
+	router.Use(middleware.Theme(conf))
+	templates, _ := fs.Sub(web.Templates, "templates")
+	renderer, err := theme.NewRenderer(templates, conf.TemplatesDirectory(), conf.Themes(), i18n.FuncMap())
+	if err != nil {
+		panic(err)
+	}
+	router.HTMLRender = renderer
+	RegisterRoutes(router, conf, nil)
+	return router
+}
+
+// browser keeps the cookies set by the router and sends them with the following requests
+type browser struct {
+	router  http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func newBrowser(router http.Handler) *browser {
+	return &browser{router: router, cookies: make(map[string]*http.Cookie)}
+}
+
+func (b *browser) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, cookie := range b.cookies {
+		req.AddCookie(cookie)
+	}
+	b.router.ServeHTTP(w, req)
+	for _, cookie := range (&http.Response{Header: w.Header()}).Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(b.cookies, cookie.Name)
+		} else {
+			b.cookies[cookie.Name] = cookie
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package handler
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/cert_manager"
 	"login-provider/internal/hydra"
 	"net/http"
+	"time"
 )
 
 type healthStatus struct {
@@ -13,15 +17,43 @@ type healthStatus struct {
 
 type readyStatus struct {
 	healthStatus
-	Errors map[string]string `json:"errors,omitempty""`
+	Errors map[string]string `json:"errors,omitempty"`
+	// Certificates lists the served TLS certificates together with their expiry
+	Certificates []cert_manager.CertificateInfo `json:"certificates,omitempty"`
 }
 
 func Alive(c *gin.Context) {
 	c.JSON(http.StatusOK, &healthStatus{Status: "Ok"})
 }
 
-func Ready(hf *hydra.ClientFactory) gin.HandlerFunc {
+// Ready reports the served TLS certificates. The login provider is not ready if one of them expired
+// or hydra is not ready.
+func Ready(admin hydra.Admin, certs *cert_manager.Manager) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// TODO: implement readyness probe. use hydras /health/ready end point for that
+		status := &readyStatus{healthStatus: healthStatus{Status: "Ok"}, Certificates: certs.Certificates()}
+
+		if admin != nil {
+			if err := admin.Ready(c.Request.Context()); err != nil {
+				// the details may reveal the internal address of hydra, so they are only logged
+				log.Ctx(c.Request.Context()).Warn().Err(err).Msg("Hydra is not ready")
+				status.Errors = map[string]string{"hydra": "not ready"}
+			}
+		}
+
+		now := time.Now()
+		for _, certificate := range status.Certificates {
+			if certificate.Expired(now) {
+				if status.Errors == nil {
+					status.Errors = make(map[string]string)
+				}
+				status.Errors["tls:"+certificate.Subject] = fmt.Sprintf("certificate expired at %s", certificate.NotAfter.Format(time.RFC3339))
+			}
+		}
+
+		if len(status.Errors) != 0 {
+			c.JSON(http.StatusServiceUnavailable, status)
+			return
+		}
+		c.JSON(http.StatusOK, status)
 	}
 }
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"login-provider/internal/cert_manager"
+	"login-provider/internal/config"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// expiredCertificates creates a certificate manager serving an expired certificate
+func expiredCertificates(t *testing.T) *cert_manager.Manager {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "login.example.com"},
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     time.Now().Add(-time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	certs, err := cert_manager.NewManager(&config.TlsConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2"})
+	require.NoError(t, err)
+	return certs
+}
+
+func TestReadyWithoutTls(t *testing.T) {
+	// GIVEN
+	router := gin.New()
+	router.GET("/health/ready", Ready(nil, nil))
+
+	// WHEN
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/health/ready", nil))
+
+	// THEN
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"Ok"}`, w.Body.String())
+}
+
+func TestReadyReportsExpiredCertificates(t *testing.T) {
+	// GIVEN
+	router := gin.New()
+	router.GET("/health/ready", Ready(nil, expiredCertificates(t)))
+
+	// WHEN
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/health/ready", nil))
+
+	// THEN
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"subject":"CN=login.example.com"`)
+	assert.Contains(t, w.Body.String(), `"tls:CN=login.example.com":"certificate expired at`)
+}
+
+func TestReadyChecksHydra(t *testing.T) {
+	for status, expected := range map[int]int{
+		http.StatusOK:                 http.StatusOK,
+		http.StatusServiceUnavailable: http.StatusServiceUnavailable,
+	} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			// GIVEN
+			hydra := newFakeHydra()
+			defer hydra.Close()
+			if status == http.StatusOK {
+				hydra.respond("/health/ready", map[string]string{"status": "ok"})
+			} else {
+				hydra.respond("/health/ready", status)
+			}
+			router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL})
+
+			// WHEN
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/health/ready", nil))
+
+			// THEN
+			assert.Equal(t, expected, w.Code)
+			if expected != http.StatusOK {
+				assert.JSONEq(t, `{"status":"Ok","errors":{"hydra":"not ready"}}`, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMetricsAreNotServedPublicly(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL})
+
+	// WHEN
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+
+	// THEN
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
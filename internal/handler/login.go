@@ -1,20 +1,15 @@
 package handler
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
-	"github.com/ory/hydra-client-go/client/admin"
-	"github.com/ory/hydra-client-go/models"
 	"github.com/rs/zerolog/log"
 	"login-provider/internal/config"
-	"login-provider/internal/hydra"
-	"login-provider/internal/profile_api"
+	"login-provider/internal/flow"
 	"net/http"
 	"net/url"
-	"strconv"
 )
 
-// TODO annotate the handlers for generating OpenAPI spec out of it, e.g. by using https://github.com/go-swagger/go-swagger
-
 type loginForm struct {
 	Challenge string `form:"challenge" binding:"required"`
 	Email     string `form:"email" binding:"required"`
@@ -22,7 +17,7 @@ type loginForm struct {
 	Remember  bool   `form:"remember"`
 }
 
-func ShowLoginPage(hf *hydra.ClientFactory, conf config.Configuration) gin.HandlerFunc {
+func ShowLoginPage(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		logger := log.Ctx(c.Request.Context())
 
@@ -38,95 +33,142 @@ func ShowLoginPage(hf *hydra.ClientFactory, conf config.Configuration) gin.Handl
 
 		errorMessage := c.Query("error")
 
-		client := hf.NewClient(c.Request.Context())
 		// get info about the login request for the given challenge
-		response, err := client.Admin.GetLoginRequest(admin.NewGetLoginRequestParams().
-			WithLoginChallenge(loginChallenge))
+		login, err := svc.GetLogin(c.Request.Context(), loginChallenge)
 		if err != nil {
-			logger.Err(err).Msg("Error while communicating with hydra to get new login request")
+			if handleUnavailable(c, err) {
+				return
+			}
 			// TODO: This is an internal error (hydra not available, the request is malformed, etc)
 			// So we have to redirect to "something went wrong page - please contact the admin"
 			HandleBadRequest(c, conf)
 			return
 		}
 
+		negotiateLocale(c, login.UILocales())
+		if login.Request.Client != nil {
+			selectTheme(c, conf, login.Request.Client.ClientID, login.ClientMeta)
+		}
+
+		// users choose an account if requested by the client or configured, but not again after a
+		// failed login attempt
+		if jar, accountsConf := accountJar(c, conf); jar != nil && len(errorMessage) == 0 {
+			remembered := jar.Read(c.Request)
+			if login.SelectAccount() || (accountsConf.AlwaysChoose && len(remembered) != 0) {
+				renderAccountChooser(c, login, remembered)
+				return
+			}
+		}
+
 		// if hydra was already able to authenticate the user, Skip will be true
 		// and we don't need to authenticate the user again
-		if response.Payload.Skip {
-			logger.Debug().Msg("User authentication skipped")
-
-			// grant login request
-			response, err := client.Admin.AcceptLoginRequest(
-				admin.NewAcceptLoginRequestParams().
-					WithLoginChallenge(loginChallenge).
-					WithBody(&models.AcceptLoginRequest{Subject: &response.Payload.Subject}))
+		if login.Request.Skip {
+			redirectTo, err := svc.AcceptSkippedLogin(c.Request.Context(), login)
 			if err != nil {
-				logger.Err(err).Msg("Error while communicating with hydra to accept login request")
+				if handleUnavailable(c, err) {
+					return
+				}
 				// TODO: This is an internal error (hydra not available, the request is malformed, etc)
 				// So we have to redirect to "something went wrong page - please contact the admin"
 				HandleBadRequest(c, conf)
 				return
 			}
 
-			c.Redirect(302, response.Payload.RedirectTo)
+			c.Redirect(302, redirectTo)
 			return
 		}
 
 		// If we are here render Login page
-		c.HTML(http.StatusOK, "login.html", gin.H{
-			"title":        "Login",
-			"challenge":    loginChallenge,
-			"register_url": conf.RegisterUrl(),
-			"error":        errorMessage,
-		})
+		renderLoginForm(c, conf, loginChallenge, login.LoginHint(), errorMessage)
 	}
 }
 
-func Login(hf *hydra.ClientFactory, conf config.Configuration) gin.HandlerFunc {
+// renderLoginForm renders the login page with the email address prefilled. Pages redirecting to the
+// login page pass the key of a message to show with the "notice" parameter.
+func renderLoginForm(c *gin.Context, conf config.Configuration, challenge, email, errorMessage string) {
+	render(c, http.StatusOK, "login.html", gin.H{
+		"title":           "title.login",
+		"challenge":       challenge,
+		"email":           email,
+		"register_url":    registerUrl(conf, challenge),
+		"reset_url":       resetUrl(conf, challenge),
+		"email_login_url": emailLoginUrl(conf, challenge),
+		"notice":          c.Query("notice"),
+		"error":           errorMessage,
+	})
+}
+
+func Login(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		logger := log.Ctx(c.Request.Context())
 
 		var loginData loginForm
 		if err := c.ShouldBind(&loginData); err != nil {
 			logger.Err(err).Msg("Failed to parse data from submitted login form")
-			c.HTML(http.StatusBadRequest, "login.html", gin.H{"title": "Login", "register_url": conf.RegisterUrl()})
+			render(c, http.StatusBadRequest, "login.html", gin.H{"title": "title.login", "register_url": registerUrl(conf, "")})
 			return
 		}
 
-		client := hf.NewClient(c.Request.Context())
-		authResponse, err := profile_api.AuthenticateUser(conf.AuthenticateUrl(), loginData.Email, loginData.Password)
-		if err != nil {
-			l := logger.With().Err(err).Logger()
-			l.Warn().Msg("User authentication failed")
+		key, err := browserKey(c)
+		var result *flow.LoginResult
+		if err == nil {
+			result, err = svc.Login(c.Request.Context(), &flow.Credentials{
+				Challenge:  loginData.Challenge,
+				Email:      loginData.Email,
+				Password:   loginData.Password,
+				Remember:   loginData.Remember,
+				Locale:     negotiateLocale(c, nil),
+				BrowserKey: key,
+			})
+		}
+		if errors.Is(err, flow.ErrInvalidCredentials) || errors.Is(err, flow.ErrEmailNotVerified) ||
+			errors.Is(err, flow.ErrTooManyAttempts) {
+			message := "error.invalid_credentials"
+			if errors.Is(err, flow.ErrEmailNotVerified) {
+				message = "error.email_not_verified"
+			} else if errors.Is(err, flow.ErrTooManyAttempts) {
+				// too many codes have been sent by SMS
+				message = "error.too_many_attempts"
+			}
 			params := url.Values{}
 			params.Add("login_challenge", loginData.Challenge)
-			params.Add("error", "Invalid user name or password")
-			c.Redirect(302, "/login?" + params.Encode())
+			params.Add("error", message)
+			c.Redirect(302, "/login?"+params.Encode())
 			return
-		}
-
-		subjectId := strconv.Itoa(authResponse.User.ID)
-
-		// login successful
-		response, err := client.Admin.AcceptLoginRequest(admin.NewAcceptLoginRequestParams().
-			WithLoginChallenge(loginData.Challenge).
-			WithBody(&models.AcceptLoginRequest{
-				Acr:         "0",
-				Context:     authResponse,
-				Remember:    loginData.Remember,
-				RememberFor: 3600,
-				Subject:     &subjectId,
-			}))
-		if err != nil {
-			logger.Err(err).Msg("Error while communicating with hydra to accept login request")
+		} else if err != nil {
+			if handleUnavailable(c, err) {
+				return
+			}
 			// TODO: This is an internal error (hydra not available, the request is malformed, etc)
 			// So we have to redirect to "something went wrong page - please contact the admin"
-			c.HTML(http.StatusBadRequest,
+			render(c, http.StatusBadRequest,
 				"login.html",
-				gin.H{"title": "Login", "error": "Login failed", "register_url": conf.RegisterUrl()})
+				gin.H{
+					"title":           "title.login",
+					"error":           "error.login_failed",
+					"register_url":    registerUrl(conf, loginData.Challenge),
+					"reset_url":       resetUrl(conf, loginData.Challenge),
+					"email_login_url": emailLoginUrl(conf, loginData.Challenge),
+				})
 			return
 		}
 
-		c.Redirect(302, response.Payload.RedirectTo)
+		if jar, _ := accountJar(c, conf); jar != nil {
+			jar.Remember(c.Writer, c.Request, result.Subject, loginData.Email)
+		}
+
+		c.Redirect(302, loginResultUrl(result, loginData.Challenge))
+	}
+}
+
+// loginResultUrl returns the url to continue the login with: the next step or the redirect of hydra
+func loginResultUrl(result *flow.LoginResult, challenge string) string {
+	switch {
+	case result.Sms != nil:
+		return smsUrl(challenge)
+	case result.PasswordChange != nil:
+		return passwordChangeUrl(challenge)
+	default:
+		return result.RedirectTo
 	}
-}
\ No newline at end of file
+}
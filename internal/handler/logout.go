@@ -2,10 +2,10 @@ package handler
 
 import (
 	"github.com/gin-gonic/gin"
-	"github.com/ory/hydra-client-go/client/admin"
 	"github.com/rs/zerolog/log"
+	"login-provider/internal/backchannel"
 	"login-provider/internal/config"
-	"login-provider/internal/hydra"
+	"login-provider/internal/flow"
 	"net/http"
 )
 
@@ -14,10 +14,8 @@ type logoutForm struct {
 	LogoutApproved bool   `form:"logout_approved"`
 }
 
-func ShowLogoutPage(h *hydra.ClientFactory, conf config.Configuration) gin.HandlerFunc {
+func ShowLogoutPage(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		logger := log.Ctx(c.Request.Context())
-
 		var logoutChallenge string
 		// the challenge is used to fetch information about consent requests in hydra
 		if logoutChallenge = c.Query("logout_challenge"); len(logoutChallenge) == 0 {
@@ -25,63 +23,119 @@ func ShowLogoutPage(h *hydra.ClientFactory, conf config.Configuration) gin.Handl
 			return
 		}
 
-		client := h.NewClient(c.Request.Context())
-		_, err := client.Admin.GetLogoutRequest(admin.NewGetLogoutRequestParams().
-			WithLogoutChallenge(logoutChallenge))
+		logout, err := svc.GetLogout(c.Request.Context(), logoutChallenge)
 		if err != nil {
-			logger.Err(err).Msg("Error while communicating with hydra to get logout request")
+			if handleUnavailable(c, err) {
+				return
+			}
 			// TODO: This is an internal error (hydra not available, the request is malformed, etc)
 			// So we have to redirect to "something went wrong page - please contact the admin"
-			c.HTML(http.StatusBadRequest, "logout.html", gin.H{"title": "Logout"})
+			render(c, http.StatusBadRequest, "logout.html", gin.H{"title": "title.logout"})
+			return
+		}
+
+		if logout.Client != nil {
+			selectTheme(c, conf, logout.Client.ClientID, logout.ClientMeta)
+		}
+
+		if !svc.RequiresConfirmation(logout) {
+			log.Ctx(c.Request.Context()).Debug().Msg("Logout initiated by relying party accepted without confirmation")
+			decideLogout(c, svc, conf, logout, true)
 			return
 		}
 
-		c.HTML(http.StatusOK, "logout.html", gin.H{
-			"title":     "Logout",
+		render(c, http.StatusOK, "logout.html", gin.H{
+			"title":     "title.logout",
 			"challenge": logoutChallenge,
+			"user":      logout.User,
+			"client":    logout.ClientName(),
 		})
 	}
 }
 
-func Logout(hf *hydra.ClientFactory, _ config.Configuration) gin.HandlerFunc {
+func Logout(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		logger := log.Ctx(c.Request.Context())
 
 		var logoutData logoutForm
 		if err := c.ShouldBind(&logoutData); err != nil {
 			logger.Err(err).Msg("Failed to parse data from submitted logout form")
-			c.HTML(http.StatusBadRequest, "logout.html", gin.H{"title": "Logout"})
+			render(c, http.StatusBadRequest, "logout.html", gin.H{"title": "title.logout"})
 			return
 		}
 
-		client := hf.NewClient(c.Request.Context())
-
-		if !logoutData.LogoutApproved {
-			_, err := client.Admin.RejectLogoutRequest(admin.NewRejectLogoutRequestParams().
-				WithLogoutChallenge(logoutData.Challenge))
-			if err != nil {
-				logger.Err(err).Msg("Error while communicating with hydra to reject logout request")
-				// TODO: This is an internal error (hydra not available, the request is malformed, etc)
-				// So we have to redirect to "something went wrong page - please contact the admin"
-				c.HTML(http.StatusBadRequest, "logout.html", gin.H{"title": "Logout"})
+		logout, err := svc.GetLogout(c.Request.Context(), logoutData.Challenge)
+		if err != nil {
+			if handleUnavailable(c, err) {
 				return
 			}
+			// TODO: This is an internal error (hydra not available, the request is malformed, etc)
+			// So we have to redirect to "something went wrong page - please contact the admin"
+			render(c, http.StatusBadRequest, "logout.html", gin.H{"title": "title.logout"})
+			return
+		}
+
+		if logout.Client != nil {
+			selectTheme(c, conf, logout.Client.ClientID, logout.ClientMeta)
+		}
 
-			// TODO: where to redirect
-			c.Redirect(302, "where to redirect???")
+		decideLogout(c, svc, conf, logout, logoutData.LogoutApproved)
+	}
+}
+
+// LoggedOut shows the page users end up on after logging out, if the relying party did not
+// request a redirect. Hydra's "urls.post_logout_redirect" has to point to it.
+func LoggedOut(c *gin.Context) {
+	render(c, http.StatusOK, "logout_done.html", gin.H{
+		"title":   "title.logout",
+		"message": "logout.done",
+	})
+}
+
+// LogoutKeys publishes the keys the back-channel logout tokens can be verified with
+func LogoutKeys(notifier *backchannel.Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, notifier.Keys())
+	}
+}
+
+func decideLogout(c *gin.Context, svc *flow.Service, conf config.Configuration, logout *flow.Logout, approved bool) {
+	redirectTo, err := svc.DecideLogout(c.Request.Context(), logout, approved)
+	if err != nil {
+		if handleUnavailable(c, err) {
 			return
 		}
+		// TODO: This is an internal error (hydra not available, the request is malformed, etc)
+		// So we have to redirect to "something went wrong page - please contact the admin"
+		render(c, http.StatusBadRequest, "logout.html", gin.H{"title": "title.logout"})
+		return
+	}
 
-		response, err := client.Admin.AcceptLogoutRequest(admin.NewAcceptLogoutRequestParams().
-			WithLogoutChallenge(logoutData.Challenge))
-		if err != nil {
-			logger.Err(err).Msg("Error while communicating with hydra to accept logout request")
-			// TODO: This is an internal error (hydra not available, the request is malformed, etc)
-			// So we have to redirect to "something went wrong page - please contact the admin"
-			c.HTML(http.StatusBadRequest, "logout.html", gin.H{"title": "Logout"})
+	if approved {
+		// the account chooser must not offer to continue with the account logged out of
+		if jar, _ := accountJar(c, conf); jar != nil {
+			jar.Forget(c.Writer, c.Request, logout.Request.Subject)
+		}
+
+		// let the clients of the session clear their state before leaving
+		if urls := svc.FrontChannelLogoutUrls(logout); len(urls) != 0 {
+			render(c, http.StatusOK, "logout_frontchannel.html", gin.H{
+				"title":        "title.logout",
+				"logout_urls":  urls,
+				"redirect_url": redirectTo,
+			})
 			return
 		}
+	}
 
-		c.Redirect(302, response.Payload.RedirectTo)
+	if len(redirectTo) == 0 {
+		// the user declined to log out, but there is nowhere to send the user to
+		render(c, http.StatusOK, "logout_done.html", gin.H{
+			"title":   "title.logout",
+			"message": "logout.cancelled",
+		})
+		return
 	}
+
+	c.Redirect(302, redirectTo)
 }
@@ -0,0 +1,337 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"login-provider/internal/accounts"
+	"login-provider/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const rejectLogoutRequestPath = "/oauth2/auth/requests/logout/reject"
+const acceptLogoutRequestPath = "/oauth2/auth/requests/logout/accept"
+
+// idTokenHint creates an unsigned id token for the given audience. Hydra verifies the signature,
+// the login provider only reads the audience
+func idTokenHint(audience string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(`{"aud":["`+audience+`"]}`)) + "."
+}
+
+func logoutRequest(rpInitiated bool, query url.Values) map[string]interface{} {
+	return map[string]interface{}{
+		"subject":      "1",
+		"sid":          "baz",
+		"rp_initiated": rpInitiated,
+		"request_url":  "https://hydra.example.com/oauth2/sessions/logout?" + query.Encode(),
+	}
+}
+
+func logoutClient(metadata map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"client_id":                 "bar",
+		"client_name":               "Bar App",
+		"client_uri":                "https://bar.example.com",
+		"post_logout_redirect_uris": []string{"https://bar.example.com/logged-out"},
+		"metadata":                  metadata,
+	}
+}
+
+func serve(router http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func submitLogout(t *testing.T, conf *MockConfiguration, approved bool) *httptest.ResponseRecorder {
+	form := url.Values{}
+	form.Set("challenge", "foo")
+	if approved {
+		form.Set("logout_approved", "true")
+	} else {
+		form.Set("logout_approved", "false")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/logout", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return serve(newTestRouter(conf), req)
+}
+
+func TestShowLogoutPageShowsUserAndRelyingParty(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(logoutRequestPath, logoutRequest(true, url.Values{"client_id": {"bar"}}))
+	hydra.respond("/clients/bar", logoutClient(nil))
+	hydra.respond("/oauth2/auth/sessions/consent", []interface{}{
+		map[string]interface{}{"consent_request": map[string]interface{}{
+			"context": map[string]interface{}{"user": map[string]interface{}{"id": 1, "user_name": "jdoe"}},
+		}},
+	})
+	req, _ := http.NewRequest(http.MethodGet, "/logout?logout_challenge=foo", nil)
+
+	// WHEN
+	w := serve(newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL}), req)
+
+	// THEN
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "You are signed in as jdoe.")
+	assert.Contains(t, w.Body.String(), "Bar App asks to log you out.")
+	assert.Nil(t, hydra.receivedBody(acceptLogoutRequestPath))
+}
+
+func TestShowLogoutPageAcceptsRpInitiatedLogoutWithIdTokenHint(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(logoutRequestPath, logoutRequest(true, url.Values{"id_token_hint": {idTokenHint("bar")}}))
+	hydra.respond("/clients/bar", logoutClient(nil))
+	req, _ := http.NewRequest(http.MethodGet, "/logout?logout_challenge=foo", nil)
+
+	// WHEN
+	w := serve(newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL}), req)
+
+	// THEN
+	require.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://hydra.example.com"+acceptLogoutRequestPath, w.Header().Get("Location"))
+}
+
+func TestShowLogoutPageAsksForConfirmationIfEnforcedByClient(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(logoutRequestPath, logoutRequest(true, url.Values{"id_token_hint": {idTokenHint("bar")}}))
+	hydra.respond("/clients/bar", logoutClient(map[string]interface{}{"confirm_logout": true}))
+	req, _ := http.NewRequest(http.MethodGet, "/logout?logout_challenge=foo", nil)
+
+	// WHEN
+	w := serve(newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL}), req)
+
+	// THEN
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, hydra.receivedBody(acceptLogoutRequestPath))
+}
+
+func TestDeclinedLogoutRedirectsToPostLogoutRedirectUri(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(logoutRequestPath, logoutRequest(true, url.Values{
+		"id_token_hint":            {idTokenHint("bar")},
+		"post_logout_redirect_uri": {"https://bar.example.com/logged-out"},
+		"state":                    {"xyz"},
+	}))
+	hydra.respond("/clients/bar", logoutClient(nil))
+
+	// WHEN
+	w := submitLogout(t, &MockConfiguration{hydraAdminUrl: hydra.URL}, false)
+
+	// THEN
+	require.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://bar.example.com/logged-out?state=xyz", w.Header().Get("Location"))
+	assert.NotNil(t, hydra.receivedBody(rejectLogoutRequestPath))
+}
+
+func TestDeclinedLogoutIgnoresUnregisteredPostLogoutRedirectUri(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(logoutRequestPath, logoutRequest(true, url.Values{
+		"id_token_hint":            {idTokenHint("bar")},
+		"post_logout_redirect_uri": {"https://evil.example.com"},
+	}))
+	hydra.respond("/clients/bar", logoutClient(nil))
+
+	// WHEN
+	w := submitLogout(t, &MockConfiguration{hydraAdminUrl: hydra.URL}, false)
+
+	// THEN
+	require.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://bar.example.com", w.Header().Get("Location"))
+}
+
+func TestDeclinedLogoutRedirectsToConfiguredDefault(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(logoutRequestPath, logoutRequest(false, url.Values{}))
+	conf := &MockConfiguration{
+		hydraAdminUrl: hydra.URL,
+		logout: &config.LogoutConfig{
+			DefaultRedirectUrl: "https://www.example.com",
+			BackChannel:        &config.BackChannelConfig{},
+		},
+	}
+
+	// WHEN
+	w := submitLogout(t, conf, false)
+
+	// THEN
+	require.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://www.example.com", w.Header().Get("Location"))
+}
+
+func TestDeclinedLogoutWithoutRedirectTargetShowsPage(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(logoutRequestPath, logoutRequest(false, url.Values{}))
+
+	// WHEN
+	w := submitLogout(t, &MockConfiguration{hydraAdminUrl: hydra.URL}, false)
+
+	// THEN
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "You are still logged in.")
+}
+
+func TestLoggedOutPage(t *testing.T) {
+	// GIVEN
+	req, _ := http.NewRequest(http.MethodGet, "/logout/done", nil)
+
+	// WHEN
+	w := serve(newTestRouter(&MockConfiguration{}), req)
+
+	// THEN
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "You have been logged out.")
+}
+
+func TestApprovedLogoutNotifiesClientsOfTheSession(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(logoutRequestPath, logoutRequest(false, url.Values{}))
+	session := func(clientID, sid string) map[string]interface{} {
+		return map[string]interface{}{"consent_request": map[string]interface{}{
+			"login_session_id": sid,
+			"client": map[string]interface{}{
+				"client_id":                            clientID,
+				"frontchannel_logout_uri":              "https://" + clientID + ".example.com/logout",
+				"frontchannel_logout_session_required": true,
+			},
+		}}
+	}
+	hydra.respond("/oauth2/auth/sessions/consent", []interface{}{
+		session("bar", "baz"), session("bar", "baz"), session("other", "another-session"),
+	})
+
+	var mutex sync.Mutex
+	var logoutTokens []string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		logoutTokens = append(logoutTokens, r.PostFormValue("logout_token"))
+	}))
+	defer webhook.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, ioutil.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600))
+
+	conf := &MockConfiguration{
+		hydraAdminUrl: hydra.URL,
+		logout: &config.LogoutConfig{
+			Issuer:       "https://hydra.example.com/",
+			FrontChannel: true,
+			BackChannel: &config.BackChannelConfig{
+				SigningKeyFile: keyFile,
+				Timeout:        time.Second,
+				Webhooks:       []config.Webhook{{Url: webhook.URL, Audience: "sessions"}},
+			},
+		},
+	}
+
+	// WHEN
+	w := submitLogout(t, conf, true)
+
+	// THEN
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Equal(t, 1, strings.Count(body, "<iframe"))
+	assert.Contains(t, body, `src="https://bar.example.com/logout?iss=https%3A%2F%2Fhydra.example.com%2F&amp;sid=baz"`)
+	assert.Contains(t, body, "https://hydra.example.com"+acceptLogoutRequestPath)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Len(t, logoutTokens, 1)
+}
+
+func TestApprovedLogoutRemovesPendingLoginsOfTheUser(t *testing.T) {
+	// GIVEN
+	box, restoreMails := captureMails()
+	defer restoreMails()
+	messages, restoreSms := captureSms()
+	defer restoreSms()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := smsConfig(true, false)
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	userWithPhone(t, router, box)
+	subject := hydra.receivedBody(acceptLoginRequestPath)["subject"].(string)
+	logIn(t, router, "secret123")
+	code := messages.lastCode(t)
+	logout := logoutRequest(false, url.Values{})
+	logout["subject"] = subject
+	hydra.respond(logoutRequestPath, logout)
+
+	// WHEN
+	loggedOut := postForm(t, router, "/logout", url.Values{"challenge": {"foo"}, "logout_approved": {"true"}})
+	w := enterSmsCode(t, router, code)
+
+	// THEN
+	require.Equal(t, http.StatusFound, loggedOut.Code)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/login?login_challenge=foo", w.Header().Get("Location"), "The user must start over after logging out")
+}
+
+func TestApprovedLogoutForgetsAccount(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(logoutRequestPath, logoutRequest(false, url.Values{}))
+	router := newTestRouter(&MockConfiguration{hydraAdminUrl: hydra.URL, accounts: accountsConfig()})
+	req, err := http.NewRequest(http.MethodPost, "/logout", strings.NewReader(url.Values{
+		"challenge":       {"foo"},
+		"logout_approved": {"true"},
+	}.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range rememberAccounts(t, "1", "2") {
+		req.AddCookie(cookie)
+	}
+
+	// WHEN
+	w := serve(router, req)
+
+	// THEN
+	require.Equal(t, http.StatusFound, w.Code)
+	remaining := httptest.NewRequest(http.MethodGet, "/login", nil)
+	for _, cookie := range w.Result().Cookies() {
+		remaining.AddCookie(cookie)
+	}
+	jar, err := accounts.NewJar(accountsConfig())
+	require.NoError(t, err)
+	remembered := jar.Read(remaining)
+	require.Len(t, remembered, 1)
+	assert.Equal(t, "2", remembered[0].Subject)
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/config"
+	"login-provider/internal/flow"
+	"net/http"
+	"net/url"
+)
+
+// passwordChangeUrl returns the url of the page to choose a new password for the given login challenge
+func passwordChangeUrl(challenge string) string {
+	return "/login/password?" + url.Values{"login_challenge": {challenge}}.Encode()
+}
+
+// ShowPasswordChangePage asks users logging in with a password, which does not follow the password
+// policy, to choose a new one
+func ShowPasswordChangePage(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		challenge := c.Query("login_challenge")
+		if len(challenge) == 0 {
+			log.Ctx(c.Request.Context()).Warn().Msg("No login challenge provided")
+			HandleBadRequest(c, conf)
+			return
+		}
+
+		passwordChange, err := svc.GetPasswordChange(challenge, currentBrowserKey(c))
+		if err != nil {
+			c.Redirect(302, loginPageUrl(challenge))
+			return
+		}
+		if !prepareChallengePage(c, svc, conf, challenge) {
+			return
+		}
+		renderPasswordChange(c, conf, http.StatusOK, passwordChange, nil, "")
+	}
+}
+
+// ChangePassword sets the new password and completes the login. Changing it can be skipped, unless
+// the password policy requires it.
+func ChangePassword(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		change := &flow.PasswordChange{
+			Challenge:            c.PostForm("challenge"),
+			Password:             c.PostForm("password"),
+			PasswordConfirmation: c.PostForm("password_confirmation"),
+			Skip:                 c.PostForm("action") == "skip",
+			BrowserKey:           currentBrowserKey(c),
+		}
+		if len(change.Challenge) == 0 {
+			log.Ctx(c.Request.Context()).Warn().Msg("No login challenge provided")
+			HandleBadRequest(c, conf)
+			return
+		}
+
+		result, err := svc.ChangePassword(c.Request.Context(), change)
+		if err != nil {
+			if handleUnavailable(c, err) {
+				return
+			}
+			passwordChange, pendingErr := svc.GetPasswordChange(change.Challenge, change.BrowserKey)
+			if pendingErr != nil {
+				// the login expired, the user has to start over
+				c.Redirect(302, loginPageUrl(change.Challenge))
+				return
+			}
+
+			var validationError *flow.ValidationError
+			switch {
+			case errors.As(err, &validationError):
+				renderPasswordChange(c, conf, http.StatusBadRequest, passwordChange, validationError.Problems, "")
+			case errors.Is(err, flow.ErrSkipNotAllowed):
+				renderPasswordChange(c, conf, http.StatusBadRequest, passwordChange, nil, "error.password_change_required")
+			default:
+				log.Ctx(c.Request.Context()).Err(err).Msg("Password change on login failed")
+				renderPasswordChange(c, conf, http.StatusBadRequest, passwordChange, nil, "error.password_change_failed")
+			}
+			return
+		}
+
+		c.Redirect(302, result.RedirectTo)
+	}
+}
+
+func renderPasswordChange(c *gin.Context, conf config.Configuration, code int, passwordChange *flow.PasswordChangeChallenge,
+	problems map[string]error, errorMessage string) {
+	render(c, code, "password_change.html", gin.H{
+		"title":              "title.password_change",
+		"challenge":          passwordChange.Challenge,
+		"reason":             problemMessage(passwordChange.Reason),
+		"optional":           passwordChange.Optional,
+		"min_length":         conf.PasswordPolicyConfig().MinLength,
+		"error":              errorMessage,
+		"password_error":     problemMessage(problems["password"]),
+		"confirmation_error": problemMessage(problems["password_confirmation"]),
+	})
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"login-provider/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// deniedWordPolicy denies the password used by registeredUser
+func deniedWordPolicy() *config.PasswordPolicyConfig {
+	return &config.PasswordPolicyConfig{MinLength: 8, RequireDigit: true, DeniedWords: []string{"secret"}, CheckOnLogin: true}
+}
+
+func changePassword(t *testing.T, router http.Handler, form url.Values) *httptest.ResponseRecorder {
+	form.Set("challenge", "foo")
+	return postForm(t, router, "/login/password", form)
+}
+
+func TestWeakPasswordIsChangedOnLogin(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := registrationConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newBrowser(newTestRouter(conf))
+	registeredUser(t, router, box, "secret123")
+	conf.passwordPolicy = deniedWordPolicy()
+
+	// WHEN
+	login := logIn(t, router, "secret123")
+	page := serve(router, httptest.NewRequest(http.MethodGet, login.Header().Get("Location"), nil))
+	mismatch := changePassword(t, router, url.Values{"password": {"changed123"}, "password_confirmation": {"changed321"}})
+	changed := changePassword(t, router, url.Values{"password": {"changed123"}, "password_confirmation": {"changed123"}})
+
+	// THEN
+	assert.Equal(t, "/login/password?login_challenge=foo", login.Header().Get("Location"))
+	assert.Equal(t, http.StatusOK, page.Code)
+	assert.Contains(t, page.Body.String(), "The password contains a word, which is not allowed")
+	assert.Contains(t, page.Body.String(), `value="skip"`)
+	assert.Equal(t, http.StatusBadRequest, mismatch.Code)
+	assert.Equal(t, http.StatusFound, changed.Code)
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, changed.Header().Get("Location"))
+	assert.NotEmpty(t, hydra.receivedBody(revokeLoginSessionsPath)["subject"])
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, logIn(t, router, "changed123").Header().Get("Location"))
+}
+
+func TestPasswordChangeCanOnlyBeSkippedIfOptional(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := registrationConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newBrowser(newTestRouter(conf))
+	registeredUser(t, router, box, "secret123")
+	conf.passwordPolicy = deniedWordPolicy()
+
+	// WHEN
+	logIn(t, router, "secret123")
+	skipped := changePassword(t, router, url.Values{"action": {"skip"}})
+	conf.passwordPolicy.RequireChange = true
+	logIn(t, router, "secret123")
+	required := changePassword(t, router, url.Values{"action": {"skip"}})
+
+	// THEN
+	assert.Equal(t, http.StatusFound, skipped.Code)
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, skipped.Header().Get("Location"))
+	assert.Equal(t, http.StatusBadRequest, required.Code)
+	assert.NotContains(t, required.Body.String(), `value="skip"`)
+}
+
+func TestRegistrationRejectsBreachedPassword(t *testing.T) {
+	// GIVEN
+	dir, err := ioutil.TempDir("", "breached")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sum := sha1.Sum([]byte("secret123"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(hash[5:]+":42\r\n"), 0600))
+	_, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := registrationConfig()
+	conf.hydraAdminUrl = hydra.URL
+	conf.passwordPolicy = &config.PasswordPolicyConfig{MinLength: 8, BreachedDirectory: dir}
+	router := newTestRouter(conf)
+
+	// WHEN
+	w := register(t, router, "secret123")
+
+	// THEN
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "The password appeared in a data breach")
+}
+
+func TestApiAsksForNewPassword(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := registrationConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newBrowser(newTestRouter(conf))
+	registeredUser(t, router, box, "secret123")
+	conf.passwordPolicy = deniedWordPolicy()
+
+	// WHEN
+	loginStatus, login := postJson(t, router, "/api/v1/login", map[string]interface{}{
+		"challenge": "foo",
+		"email":     "foo@example.com",
+		"password":  "secret123",
+	})
+	invalidStatus, invalid := postJson(t, router, "/api/v1/login/password", map[string]interface{}{
+		"challenge": "foo", "password": "short", "password_confirmation": "short",
+	})
+	changedStatus, changed := postJson(t, router, "/api/v1/login/password", map[string]interface{}{
+		"challenge": "foo", "password": "changed123", "password_confirmation": "changed123",
+	})
+
+	// THEN
+	assert.Equal(t, http.StatusOK, loginStatus)
+	assert.Equal(t, map[string]interface{}{"reason": "denied_word", "optional": true}, login["password_change"])
+	assert.Equal(t, http.StatusBadRequest, invalidStatus)
+	assert.Equal(t, "invalid_password", invalid["error"])
+	assert.Equal(t, http.StatusOK, changedStatus)
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, changed["redirect_to"])
+}
+
+func TestApiPasswordChangeIsOnlyAcceptedFromBrowserOfLogin(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := registrationConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	userBrowser := newBrowser(router)
+	registeredUser(t, userBrowser, box, "secret123")
+	conf.passwordPolicy = deniedWordPolicy()
+	loginStatus, _ := postJson(t, userBrowser, "/api/v1/login", map[string]interface{}{
+		"challenge": "foo",
+		"email":     "foo@example.com",
+		"password":  "secret123",
+	})
+	require.Equal(t, http.StatusOK, loginStatus)
+
+	// WHEN
+	otherStatus, other := postJson(t, router, "/api/v1/login/password", map[string]interface{}{
+		"challenge": "foo", "password": "changed123", "password_confirmation": "changed123",
+	})
+	ownStatus, _ := postJson(t, userBrowser, "/api/v1/login/password", map[string]interface{}{
+		"challenge": "foo", "skip": true,
+	})
+
+	// THEN
+	assert.Equal(t, http.StatusBadRequest, otherStatus)
+	assert.Equal(t, "invalid_request", other["error"])
+	assert.Equal(t, http.StatusOK, ownStatus)
+}
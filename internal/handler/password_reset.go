@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/config"
+	"login-provider/internal/flow"
+	"net/http"
+	"net/url"
+)
+
+// resetUrl returns the url of the page to request a password reset, respectively an empty string if
+// not enabled. Users return to the given login challenge after changing the password.
+func resetUrl(conf config.Configuration, challenge string) string {
+	if !conf.PasswordResetConfig().Enabled {
+		return ""
+	}
+	if len(challenge) == 0 {
+		return "/password/forgot"
+	}
+	return "/password/forgot?" + url.Values{"login_challenge": {challenge}}.Encode()
+}
+
+// ShowForgotPasswordPage asks users who forgot their password for their email address
+func ShowForgotPasswordPage(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !conf.PasswordResetConfig().Enabled {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		challenge := c.Query("login_challenge")
+		if !prepareChallengePage(c, svc, conf, challenge) {
+			return
+		}
+		renderForgotPassword(c, http.StatusOK, challenge, "", "")
+	}
+}
+
+// ForgotPassword sends the link to choose a new password. The same page is shown whether the email
+// address is registered or not.
+func ForgotPassword(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !conf.PasswordResetConfig().Enabled {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		request := &flow.ResetRequest{
+			Challenge: c.PostForm("challenge"),
+			Email:     c.PostForm("email"),
+		}
+		if !prepareChallengePage(c, svc, conf, request.Challenge) {
+			return
+		}
+		request.Locale = c.GetString(localeKey)
+
+		err := svc.RequestPasswordReset(c.Request.Context(), request)
+		var validationError *flow.ValidationError
+		switch {
+		case err == nil:
+			render(c, http.StatusOK, "password_forgot.html", gin.H{"title": "title.password_reset", "sent": true})
+		case errors.As(err, &validationError):
+			renderForgotPassword(c, http.StatusBadRequest, request.Challenge, request.Email, problemMessage(validationError.Problems["email"]))
+		case errors.Is(err, flow.ErrPasswordResetDisabled):
+			c.AbortWithStatus(http.StatusNotFound)
+		case errors.Is(err, flow.ErrTooManyAttempts):
+			renderForgotPassword(c, http.StatusTooManyRequests, request.Challenge, request.Email, "error.too_many_attempts")
+		default:
+			log.Ctx(c.Request.Context()).Err(err).Msg("Password reset request failed")
+			renderForgotPassword(c, http.StatusBadRequest, request.Challenge, request.Email, "error.password_reset_failed")
+		}
+	}
+}
+
+// ShowResetPasswordPage renders the form to choose a new password. Users get to it with the link
+// sent by email.
+func ShowResetPasswordPage(conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !conf.PasswordResetConfig().Enabled {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		token := c.Query("token")
+		if len(token) == 0 {
+			render(c, http.StatusBadRequest, "password_reset.html", gin.H{"title": "title.password_reset", "failed": "error.invalid_token"})
+			return
+		}
+		renderResetPassword(c, conf, http.StatusOK, token, nil)
+	}
+}
+
+// ResetPassword changes the password. Users who started from a login page return to it.
+func ResetPassword(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reset := &flow.PasswordReset{
+			Token:                c.PostForm("token"),
+			Password:             c.PostForm("password"),
+			PasswordConfirmation: c.PostForm("password_confirmation"),
+		}
+
+		challenge, err := svc.ResetPassword(c.Request.Context(), reset)
+		var validationError *flow.ValidationError
+		switch {
+		case err == nil && len(challenge) != 0:
+			c.Redirect(302, "/login?"+url.Values{"login_challenge": {challenge}, "notice": {"notice.password_changed"}}.Encode())
+		case err == nil:
+			render(c, http.StatusOK, "password_reset.html", gin.H{"title": "title.password_reset", "done": true})
+		case errors.As(err, &validationError):
+			renderResetPassword(c, conf, http.StatusBadRequest, reset.Token, validationError.Problems)
+		case errors.Is(err, flow.ErrPasswordResetDisabled):
+			c.AbortWithStatus(http.StatusNotFound)
+		case errors.Is(err, flow.ErrInvalidToken):
+			render(c, http.StatusBadRequest, "password_reset.html", gin.H{"title": "title.password_reset", "failed": "error.invalid_token"})
+		default:
+			log.Ctx(c.Request.Context()).Err(err).Msg("Password reset failed")
+			render(c, http.StatusBadRequest, "password_reset.html", gin.H{"title": "title.password_reset", "failed": "error.password_reset_failed"})
+		}
+	}
+}
+
+func renderForgotPassword(c *gin.Context, code int, challenge, email, errorMessage string) {
+	loginUrl := ""
+	if len(challenge) != 0 {
+		loginUrl = "/login?" + url.Values{"login_challenge": {challenge}}.Encode()
+	}
+
+	render(c, code, "password_forgot.html", gin.H{
+		"title":     "title.password_reset",
+		"challenge": challenge,
+		"email":     email,
+		"login_url": loginUrl,
+		"error":     errorMessage,
+	})
+}
+
+func renderResetPassword(c *gin.Context, conf config.Configuration, code int, token string, problems map[string]error) {
+	render(c, code, "password_reset.html", gin.H{
+		"title":              "title.password_reset",
+		"token":              token,
+		"min_length":         conf.PasswordPolicyConfig().MinLength,
+		"password_error":     problemMessage(problems["password"]),
+		"confirmation_error": problemMessage(problems["password_confirmation"]),
+	})
+}
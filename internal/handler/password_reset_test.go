@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"login-provider/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+const revokeLoginSessionsPath = "/oauth2/auth/sessions/login"
+
+var resetLink = regexp.MustCompile(`https://login\.example\.com/password/reset\?token=(\S+)`)
+
+// passwordResetConfig enables the registration as well, so tests can create a verified user
+func passwordResetConfig() *MockConfiguration {
+	conf := registrationConfig()
+	conf.passwordReset = &config.PasswordResetConfig{
+		Enabled:       true,
+		TokenTtl:      time.Hour,
+		MaxPerAddress: 3,
+		AddressWindow: time.Hour,
+	}
+	return conf
+}
+
+// registeredUser creates the verified user foo@example.com with the given password
+func registeredUser(t *testing.T, router http.Handler, box *mailbox, password string) {
+	require.Equal(t, http.StatusOK, register(t, router, password).Code)
+	serve(router, httptest.NewRequest(http.MethodGet, verificationPath(t, box), nil))
+}
+
+// resetToken requests a password reset for foo@example.com and returns the token sent by email
+func resetToken(t *testing.T, router http.Handler, box *mailbox) string {
+	before := len(box.received())
+	w := postForm(t, router, "/password/forgot", url.Values{"challenge": {"foo"}, "email": {"foo@example.com"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, box.received(), before+1)
+	match := resetLink.FindStringSubmatch(box.received()[before].Body)
+	require.NotNil(t, match)
+	token, err := url.QueryUnescape(match[1])
+	require.NoError(t, err)
+	return token
+}
+
+func TestLoginPageLinksPasswordReset(t *testing.T) {
+	// GIVEN
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := passwordResetConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+
+	// WHEN
+	w := serve(router, httptest.NewRequest(http.MethodGet, "/login?login_challenge=foo", nil))
+
+	// THEN
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `href="/password/forgot?login_challenge=foo"`)
+}
+
+func TestResetPasswordRevokesSessionsAndReturnsToLogin(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := passwordResetConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	registeredUser(t, router, box, "secret123")
+	subject := hydra.receivedBody(acceptLoginRequestPath)["subject"]
+	token := resetToken(t, router, box)
+
+	// WHEN
+	w := postForm(t, router, "/password/reset", url.Values{
+		"token":                 {token},
+		"password":              {"changed123"},
+		"password_confirmation": {"changed123"},
+	})
+
+	// THEN
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/login?login_challenge=foo&notice=notice.password_changed", w.Header().Get("Location"))
+	assert.Equal(t, subject, hydra.receivedBody(revokeLoginSessionsPath)["subject"])
+	assert.Contains(t, logIn(t, router, "secret123").Header().Get("Location"), "error=error.invalid_credentials")
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, logIn(t, router, "changed123").Header().Get("Location"))
+}
+
+func TestResetPasswordEnforcesPolicyWithoutUsingUpToken(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := passwordResetConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	registeredUser(t, router, box, "secret123")
+	token := resetToken(t, router, box)
+	reset := func(password string) *httptest.ResponseRecorder {
+		return postForm(t, router, "/password/reset", url.Values{
+			"token":                 {token},
+			"password":              {password},
+			"password_confirmation": {password},
+		})
+	}
+
+	// WHEN
+	weak := reset("changedpassword")
+	valid := reset("changed123")
+	again := reset("changed456")
+
+	// THEN
+	assert.Equal(t, http.StatusBadRequest, weak.Code)
+	assert.Contains(t, weak.Body.String(), "The password must contain a digit")
+	assert.Equal(t, http.StatusFound, valid.Code)
+	assert.Equal(t, http.StatusBadRequest, again.Code)
+	assert.Contains(t, again.Body.String(), "The link is invalid or expired")
+}
+
+func TestResetPasswordInvalidatesOtherLinks(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := passwordResetConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	registeredUser(t, router, box, "secret123")
+	first := resetToken(t, router, box)
+	second := resetToken(t, router, box)
+	reset := func(token, password string) *httptest.ResponseRecorder {
+		return postForm(t, router, "/password/reset", url.Values{
+			"token":                 {token},
+			"password":              {password},
+			"password_confirmation": {password},
+		})
+	}
+
+	// WHEN
+	used := reset(second, "changed123")
+	other := reset(first, "changed456")
+
+	// THEN
+	assert.Equal(t, http.StatusFound, used.Code)
+	assert.Equal(t, http.StatusBadRequest, other.Code)
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, logIn(t, router, "changed123").Header().Get("Location"))
+}
+
+func TestForgotPasswordLimitsEmailsPerAddress(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := passwordResetConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	registeredUser(t, router, box, "secret123")
+	for i := 0; i < conf.passwordReset.MaxPerAddress; i++ {
+		resetToken(t, router, box)
+	}
+	before := len(box.received())
+
+	// WHEN
+	w := postForm(t, router, "/password/forgot", url.Values{"challenge": {"foo"}, "email": {"Foo@example.com"}})
+
+	// THEN
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Len(t, box.received(), before)
+}
+
+func TestForgotPasswordDoesNotRevealUnknownEmailAddresses(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	router := newTestRouter(passwordResetConfig())
+
+	// WHEN
+	w := postForm(t, router, "/password/forgot", url.Values{"email": {"nobody@example.com"}})
+
+	// THEN
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Please check your email")
+	assert.Empty(t, box.received())
+}
+
+func TestPasswordResetIsDisabledByDefault(t *testing.T) {
+	// GIVEN
+	router := newTestRouter(&MockConfiguration{})
+
+	// WHEN
+	w := serve(router, httptest.NewRequest(http.MethodGet, "/password/forgot", nil))
+
+	// THEN
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
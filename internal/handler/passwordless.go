@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/config"
+	"login-provider/internal/flow"
+	"net/http"
+	"net/url"
+)
+
+// emailLoginUrl returns the url of the page to log in without a password, respectively an empty
+// string if not enabled
+func emailLoginUrl(conf config.Configuration, challenge string) string {
+	if !conf.PasswordlessConfig().Enabled || len(challenge) == 0 {
+		return ""
+	}
+	return "/login/email?" + url.Values{"login_challenge": {challenge}}.Encode()
+}
+
+// ShowEmailLoginPage asks for the email address to send the link and the code to log in to
+func ShowEmailLoginPage(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !conf.PasswordlessConfig().Enabled {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		challenge := c.Query("login_challenge")
+		if len(challenge) == 0 {
+			log.Ctx(c.Request.Context()).Warn().Msg("No login challenge provided")
+			HandleBadRequest(c, conf)
+			return
+		}
+		if !prepareChallengePage(c, svc, conf, challenge) {
+			return
+		}
+		renderEmailLogin(c, http.StatusOK, gin.H{"challenge": challenge})
+	}
+}
+
+// EmailLogin sends the link and the code to log in. The form to enter the code is shown whether the
+// email address is registered or not.
+func EmailLogin(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !conf.PasswordlessConfig().Enabled {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		request := &flow.EmailLogin{
+			Challenge: c.PostForm("challenge"),
+			Email:     c.PostForm("email"),
+		}
+		if len(request.Challenge) == 0 {
+			log.Ctx(c.Request.Context()).Warn().Msg("No login challenge provided")
+			HandleBadRequest(c, conf)
+			return
+		}
+		if !prepareChallengePage(c, svc, conf, request.Challenge) {
+			return
+		}
+		request.Locale = c.GetString(localeKey)
+
+		err := svc.RequestLoginEmail(c.Request.Context(), request)
+		var validationError *flow.ValidationError
+		switch {
+		case err == nil:
+			renderEmailLogin(c, http.StatusOK, gin.H{"challenge": request.Challenge, "sent": true})
+		case errors.As(err, &validationError):
+			renderEmailLogin(c, http.StatusBadRequest, gin.H{
+				"challenge": request.Challenge,
+				"email":     request.Email,
+				"error":     problemMessage(validationError.Problems["email"]),
+			})
+		case errors.Is(err, flow.ErrPasswordlessDisabled):
+			c.AbortWithStatus(http.StatusNotFound)
+		case errors.Is(err, flow.ErrTooManyAttempts):
+			renderEmailLogin(c, http.StatusTooManyRequests, gin.H{
+				"challenge": request.Challenge,
+				"email":     request.Email,
+				"error":     "error.too_many_attempts",
+			})
+		default:
+			log.Ctx(c.Request.Context()).Err(err).Msg("Failed to send login email")
+			renderEmailLogin(c, http.StatusBadRequest, gin.H{
+				"challenge": request.Challenge,
+				"email":     request.Email,
+				"error":     "error.email_login_failed",
+			})
+		}
+	}
+}
+
+// EmailLoginCode logs in the user with the code sent by email
+func EmailLoginCode(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		loginCode := &flow.LoginCode{
+			Challenge: c.PostForm("challenge"),
+			Code:      c.PostForm("code"),
+		}
+		if len(loginCode.Challenge) == 0 {
+			log.Ctx(c.Request.Context()).Warn().Msg("No login challenge provided")
+			HandleBadRequest(c, conf)
+			return
+		}
+
+		result, err := svc.VerifyLoginCode(c.Request.Context(), loginCode)
+		if err != nil {
+			if handleUnavailable(c, err) {
+				return
+			}
+
+			status, message := http.StatusBadRequest, "error.email_login_failed"
+			switch {
+			case errors.Is(err, flow.ErrPasswordlessDisabled):
+				c.AbortWithStatus(http.StatusNotFound)
+				return
+			case errors.Is(err, flow.ErrInvalidCode):
+				message = "error.invalid_code"
+			case errors.Is(err, flow.ErrTooManyAttempts):
+				status, message = http.StatusTooManyRequests, "error.too_many_attempts"
+			default:
+				log.Ctx(c.Request.Context()).Err(err).Msg("Login by email failed")
+			}
+			renderEmailLogin(c, status, gin.H{"challenge": loginCode.Challenge, "sent": true, "error": message})
+			return
+		}
+
+		c.Redirect(302, result.RedirectTo)
+	}
+}
+
+// EmailLoginLink logs in the user with the link sent by email
+func EmailLoginLink(svc *flow.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := svc.VerifyLoginLink(c.Request.Context(), c.Query("token"))
+		switch {
+		case err == nil:
+			c.Redirect(302, result.RedirectTo)
+		case handleUnavailable(c, err):
+		case errors.Is(err, flow.ErrPasswordlessDisabled):
+			c.AbortWithStatus(http.StatusNotFound)
+		case errors.Is(err, flow.ErrInvalidToken):
+			renderEmailLogin(c, http.StatusBadRequest, gin.H{"failed": "error.invalid_token"})
+		default:
+			log.Ctx(c.Request.Context()).Err(err).Msg("Login by email failed")
+			renderEmailLogin(c, http.StatusBadRequest, gin.H{"failed": "error.email_login_failed"})
+		}
+	}
+}
+
+// renderEmailLogin renders the form to enter the email address, respectively the code once it has
+// been sent
+func renderEmailLogin(c *gin.Context, code int, data gin.H) {
+	data["title"] = "title.email_login"
+	if challenge, ok := data["challenge"].(string); ok {
+		data["login_url"] = "/login?" + url.Values{"login_challenge": {challenge}}.Encode()
+	}
+	render(c, code, "email_login.html", data)
+}
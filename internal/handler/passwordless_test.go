@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"login-provider/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var (
+	loginLink = regexp.MustCompile(`https://login\.example\.com/login/email/verify\?token=\S+`)
+	loginCode = regexp.MustCompile(`\b\d{6}\b`)
+)
+
+// passwordlessConfig enables the registration as well, so tests can create a verified user
+func passwordlessConfig() *MockConfiguration {
+	conf := registrationConfig()
+	conf.passwordless = &config.PasswordlessConfig{
+		Enabled:       true,
+		Ttl:           time.Hour,
+		MaxAttempts:   3,
+		MaxPerAddress: 3,
+		AddressWindow: time.Hour,
+	}
+	return conf
+}
+
+// requestLoginEmail asks for the email to log in foo@example.com and returns its body
+func requestLoginEmail(t *testing.T, router http.Handler, box *mailbox) string {
+	before := len(box.received())
+	w := postForm(t, router, "/login/email", url.Values{"challenge": {"foo"}, "email": {"foo@example.com"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, box.received(), before+1)
+	return box.received()[before].Body
+}
+
+func enterLoginCode(t *testing.T, router http.Handler, code string) *httptest.ResponseRecorder {
+	return postForm(t, router, "/login/email/code", url.Values{"challenge": {"foo"}, "code": {code}})
+}
+
+func TestLoginLinkAcceptsLoginRequestOnce(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	// hydra v1 does not support the authentication method references
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond("/version", map[string]string{"version": "v2.3.0"})
+	hydra.respond("/admin"+loginRequestPath, loginRequest(false, "", ""))
+	conf := passwordlessConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	registeredUser(t, router, box, "secret123")
+	link, err := url.Parse(loginLink.FindString(requestLoginEmail(t, router, box)))
+	require.NoError(t, err)
+
+	// WHEN
+	first := serve(router, httptest.NewRequest(http.MethodGet, link.RequestURI(), nil))
+	accepted := hydra.receivedBody("/admin" + acceptLoginRequestPath)
+	second := serve(router, httptest.NewRequest(http.MethodGet, link.RequestURI(), nil))
+
+	// THEN
+	assert.Equal(t, http.StatusFound, first.Code)
+	assert.Equal(t, "https://hydra.example.com/admin"+acceptLoginRequestPath, first.Header().Get("Location"))
+	assert.Equal(t, []interface{}{"email"}, accepted["amr"])
+	assert.Equal(t, http.StatusBadRequest, second.Code)
+	assert.Contains(t, second.Body.String(), "The link is invalid or expired")
+}
+
+func TestLoginCodeAcceptsLoginRequest(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := passwordlessConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	registeredUser(t, router, box, "secret123")
+	code := loginCode.FindString(requestLoginEmail(t, router, box))
+	require.NotEmpty(t, code)
+	wrongCode := "000000"
+	if code == wrongCode {
+		wrongCode = "111111"
+	}
+
+	// WHEN
+	wrong := enterLoginCode(t, router, wrongCode)
+	right := enterLoginCode(t, router, code)
+
+	// THEN
+	assert.Equal(t, http.StatusBadRequest, wrong.Code)
+	assert.Contains(t, wrong.Body.String(), "The code is invalid or expired")
+	assert.Equal(t, http.StatusFound, right.Code)
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, right.Header().Get("Location"))
+}
+
+func TestLoginCodeLimitsAttemptsPerChallenge(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := passwordlessConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	registeredUser(t, router, box, "secret123")
+	code := loginCode.FindString(requestLoginEmail(t, router, box))
+	for i := 0; i < conf.passwordless.MaxAttempts; i++ {
+		enterLoginCode(t, router, "abc")
+	}
+
+	// WHEN
+	w := enterLoginCode(t, router, code)
+
+	// THEN
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestLoginEmailDoesNotRevealUnknownEmailAddresses(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := passwordlessConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+
+	// WHEN
+	w := postForm(t, router, "/login/email", url.Values{"challenge": {"foo"}, "email": {"nobody@example.com"}})
+
+	// THEN
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `name="code"`)
+	assert.Empty(t, box.received())
+}
+
+func TestLoginEmailInvalidatesPreviousLinkAndCode(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := passwordlessConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	registeredUser(t, router, box, "secret123")
+	first := requestLoginEmail(t, router, box)
+	link, err := url.Parse(loginLink.FindString(first))
+	require.NoError(t, err)
+	code := loginCode.FindString(first)
+
+	// WHEN
+	second := loginCode.FindString(requestLoginEmail(t, router, box))
+	oldLink := serve(router, httptest.NewRequest(http.MethodGet, link.RequestURI(), nil))
+	oldCode := enterLoginCode(t, router, code)
+
+	// THEN
+	assert.Equal(t, http.StatusBadRequest, oldLink.Code)
+	if code != second {
+		assert.Equal(t, http.StatusBadRequest, oldCode.Code)
+	}
+	assert.Equal(t, http.StatusFound, enterLoginCode(t, router, second).Code)
+}
+
+func TestLoginEmailLimitsEmailsPerAddress(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := passwordlessConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	registeredUser(t, router, box, "secret123")
+	for i := 0; i < conf.passwordless.MaxPerAddress; i++ {
+		requestLoginEmail(t, router, box)
+	}
+	before := len(box.received())
+
+	// WHEN
+	w := postForm(t, router, "/login/email", url.Values{"challenge": {"foo"}, "email": {"FOO@example.com"}})
+
+	// THEN
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Len(t, box.received(), before)
+}
+
+func TestLoginEmailIsNotSentToUnverifiedAddress(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := passwordlessConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	require.Equal(t, http.StatusOK, register(t, router, "secret123").Code)
+	before := len(box.received())
+
+	// WHEN
+	w := postForm(t, router, "/login/email", url.Values{"challenge": {"foo"}, "email": {"foo@example.com"}})
+
+	// THEN
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `name="code"`)
+	assert.Len(t, box.received(), before)
+}
+
+func TestPasswordlessLoginIsDisabledByDefault(t *testing.T) {
+	// GIVEN
+	router := newTestRouter(&MockConfiguration{})
+
+	// WHEN
+	w := serve(router, httptest.NewRequest(http.MethodGet, "/login/email?login_challenge=foo", nil))
+
+	// THEN
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
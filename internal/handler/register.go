@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/config"
+	"login-provider/internal/flow"
+	"login-provider/internal/password_policy"
+	"net/http"
+	"net/url"
+)
+
+// registrationField is a configured field of the registration form
+type registrationField struct {
+	Name     string
+	Required bool
+	Value    string
+	// Error is the key of the message explaining what is wrong with the value
+	Error string
+}
+
+// ShowRegisterPage renders the registration form of the built-in registration. Users coming from
+// the login page return to the pending login request after verifying their email address.
+func ShowRegisterPage(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !conf.RegistrationConfig().Enabled {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		registration := &flow.Registration{Challenge: c.Query("login_challenge")}
+		if !prepareChallengePage(c, svc, conf, registration.Challenge) {
+			return
+		}
+		renderRegistration(c, conf, http.StatusOK, registration, nil)
+	}
+}
+
+// Register creates the user and sends the link to verify the email address
+func Register(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logger := log.Ctx(c.Request.Context())
+
+		registrationConf := conf.RegistrationConfig()
+		if !registrationConf.Enabled {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		registration := &flow.Registration{
+			Challenge:            c.PostForm("challenge"),
+			Email:                c.PostForm("email"),
+			Password:             c.PostForm("password"),
+			PasswordConfirmation: c.PostForm("password_confirmation"),
+			Fields:               make(map[string]string),
+		}
+		for _, field := range registrationConf.Fields {
+			registration.Fields[field.Name] = c.PostForm(field.Name)
+		}
+		if !prepareChallengePage(c, svc, conf, registration.Challenge) {
+			return
+		}
+		registration.Locale = c.GetString(localeKey)
+
+		err := svc.Register(c.Request.Context(), registration)
+		var validationError *flow.ValidationError
+		switch {
+		case err == nil:
+			render(c, http.StatusOK, "register_sent.html", gin.H{"title": "title.register"})
+		case errors.As(err, &validationError):
+			renderRegistration(c, conf, http.StatusBadRequest, registration, validationError.Problems)
+		case errors.Is(err, flow.ErrRegistrationDisabled):
+			c.AbortWithStatus(http.StatusNotFound)
+		default:
+			logger.Err(err).Msg("Registration failed")
+			renderRegistration(c, conf, http.StatusBadRequest, registration, map[string]error{"": err})
+		}
+	}
+}
+
+// VerifyEmail verifies the email address with the token sent to the user. The user continues with
+// the pending login request, if any.
+func VerifyEmail(svc *flow.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := svc.VerifyEmail(c.Request.Context(), c.Query("token"))
+		switch {
+		case errors.Is(err, flow.ErrRegistrationDisabled):
+			c.AbortWithStatus(http.StatusNotFound)
+		case errors.Is(err, flow.ErrInvalidToken):
+			render(c, http.StatusBadRequest, "register_verified.html", gin.H{"title": "title.register", "error": "error.invalid_token"})
+		case err != nil:
+			log.Ctx(c.Request.Context()).Err(err).Msg("Failed to verify email address")
+			render(c, http.StatusBadRequest, "register_verified.html", gin.H{"title": "title.register", "error": "error.registration_failed"})
+		case len(result.RedirectTo) != 0:
+			c.Redirect(302, result.RedirectTo)
+		default:
+			render(c, http.StatusOK, "register_verified.html", gin.H{"title": "title.register"})
+		}
+	}
+}
+
+// prepareChallengePage selects the locale and the theme of the client of the login request a page
+// has been opened from, if any. It returns false if the response has been rendered already, because
+// the login request is invalid.
+func prepareChallengePage(c *gin.Context, svc *flow.Service, conf config.Configuration, challenge string) bool {
+	if len(challenge) == 0 {
+		return true
+	}
+
+	login, err := svc.GetLogin(c.Request.Context(), challenge)
+	if err != nil {
+		if handleUnavailable(c, err) {
+			return false
+		}
+		HandleBadRequest(c, conf)
+		return false
+	}
+
+	negotiateLocale(c, login.UILocales())
+	if login.Request.Client != nil {
+		selectTheme(c, conf, login.Request.Client.ClientID, login.ClientMeta)
+	}
+	return true
+}
+
+// renderRegistration renders the registration form with the entered values, except for the
+// passwords, and the problems found. The problem of the whole form is keyed with an empty string.
+func renderRegistration(c *gin.Context, conf config.Configuration, code int, registration *flow.Registration, problems map[string]error) {
+	var fields []registrationField
+	for _, field := range conf.RegistrationConfig().Fields {
+		fields = append(fields, registrationField{
+			Name:     field.Name,
+			Required: field.Required,
+			Value:    registration.Fields[field.Name],
+			Error:    problemMessage(problems[field.Name]),
+		})
+	}
+
+	loginUrl := ""
+	if len(registration.Challenge) != 0 {
+		loginUrl = "/login?" + url.Values{"login_challenge": {registration.Challenge}}.Encode()
+	}
+
+	render(c, code, "register.html", gin.H{
+		"title":              "title.register",
+		"challenge":          registration.Challenge,
+		"email":              registration.Email,
+		"fields":             fields,
+		"min_length":         conf.PasswordPolicyConfig().MinLength,
+		"login_url":          loginUrl,
+		"error":              problemMessage(problems[""]),
+		"email_error":        problemMessage(problems["email"]),
+		"password_error":     problemMessage(problems["password"]),
+		"confirmation_error": problemMessage(problems["password_confirmation"]),
+	})
+}
+
+// problemMessage returns the key of the message explaining the given problem of a form field
+func problemMessage(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, flow.ErrRequired):
+		return "error.required"
+	case errors.Is(err, flow.ErrInvalidEmail):
+		return "error.invalid_email"
+	case errors.Is(err, flow.ErrPasswordMismatch):
+		return "error.password_mismatch"
+	case errors.Is(err, password_policy.ErrTooShort):
+		return "error.password_too_short"
+	case errors.Is(err, password_policy.ErrMissingLower):
+		return "error.password_missing_lower"
+	case errors.Is(err, password_policy.ErrMissingUpper):
+		return "error.password_missing_upper"
+	case errors.Is(err, password_policy.ErrMissingDigit):
+		return "error.password_missing_digit"
+	case errors.Is(err, password_policy.ErrMissingSymbol):
+		return "error.password_missing_symbol"
+	case errors.Is(err, password_policy.ErrDeniedWord):
+		return "error.password_denied_word"
+	case errors.Is(err, password_policy.ErrBreached):
+		return "error.password_breached"
+	default:
+		return "error.registration_failed"
+	}
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"login-provider/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var verificationLink = regexp.MustCompile(`https://login\.example\.com/register/verify\?token=\S+`)
+
+func registrationConfig() *MockConfiguration {
+	return &MockConfiguration{registration: &config.RegistrationConfig{
+		Enabled:         true,
+		Fields:          []config.RegistrationField{{Name: "first_name", Required: true}},
+		VerificationTtl: time.Hour,
+	}}
+}
+
+func postForm(t *testing.T, router http.Handler, path string, form url.Values) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return serve(router, req)
+}
+
+func register(t *testing.T, router http.Handler, password string) *httptest.ResponseRecorder {
+	return postForm(t, router, "/register", url.Values{
+		"challenge":             {"foo"},
+		"email":                 {"foo@example.com"},
+		"first_name":            {"Foo"},
+		"password":              {password},
+		"password_confirmation": {password},
+	})
+}
+
+func logIn(t *testing.T, router http.Handler, password string) *httptest.ResponseRecorder {
+	return postForm(t, router, "/login", url.Values{
+		"challenge": {"foo"},
+		"email":     {"foo@example.com"},
+		"password":  {password},
+	})
+}
+
+// verificationPath returns the path of the link sent to verify the email address
+func verificationPath(t *testing.T, box *mailbox) string {
+	require.Len(t, box.received(), 1)
+	link, err := url.Parse(verificationLink.FindString(box.received()[0].Body))
+	require.NoError(t, err)
+	return link.RequestURI()
+}
+
+func TestRegisterSendsVerificationLink(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := registrationConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+
+	// WHEN
+	w := register(t, router, "secret123")
+
+	// THEN
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Please check your email")
+	require.Len(t, box.received(), 1)
+	assert.Equal(t, "foo@example.com", box.received()[0].To)
+	assert.Regexp(t, verificationLink, box.received()[0].Body)
+}
+
+func TestRegisterShowsPolicyViolations(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := registrationConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+
+	// WHEN
+	w := register(t, router, "secretpassword")
+
+	// THEN
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "The password must contain a digit")
+	assert.Contains(t, w.Body.String(), `value="foo@example.com"`, "The entered values must be kept")
+	assert.Empty(t, box.received())
+}
+
+func TestRegisteredUserLogsInAfterVerification(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := registrationConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	require.Equal(t, http.StatusOK, register(t, router, "secret123").Code)
+
+	// WHEN
+	beforeVerification := logIn(t, router, "secret123")
+	verification := serve(router, httptest.NewRequest(http.MethodGet, verificationPath(t, box), nil))
+	subject := hydra.receivedBody(acceptLoginRequestPath)["subject"]
+	afterVerification := logIn(t, router, "secret123")
+
+	// THEN
+	assert.Contains(t, beforeVerification.Header().Get("Location"), "error=error.email_not_verified")
+	assert.Equal(t, http.StatusFound, verification.Code, "The user must return to the pending login request")
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, verification.Header().Get("Location"))
+	assert.NotEmpty(t, subject)
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, afterVerification.Header().Get("Location"))
+	assert.Equal(t, subject, hydra.receivedBody(acceptLoginRequestPath)["subject"])
+}
+
+func TestRegistrationOfUnverifiedAddressReplacesPassword(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := registrationConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	// somebody else registered the address before its owner
+	require.Equal(t, http.StatusOK, register(t, router, "attacker1").Code)
+	require.Equal(t, http.StatusOK, register(t, router, "secret123").Code)
+	require.Len(t, box.received(), 2)
+	links := make([]string, 0, 2)
+	for _, message := range box.received() {
+		link, err := url.Parse(verificationLink.FindString(message.Body))
+		require.NoError(t, err)
+		links = append(links, link.RequestURI())
+	}
+
+	// WHEN
+	previous := serve(router, httptest.NewRequest(http.MethodGet, links[0], nil))
+	latest := serve(router, httptest.NewRequest(http.MethodGet, links[1], nil))
+
+	// THEN
+	assert.Equal(t, http.StatusBadRequest, previous.Code, "Previous links must become invalid")
+	assert.Equal(t, http.StatusFound, latest.Code)
+	assert.Contains(t, logIn(t, router, "attacker1").Header().Get("Location"), "error=error.invalid_credentials")
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, logIn(t, router, "secret123").Header().Get("Location"))
+}
+
+func TestUnverifiedRegistrationDoesNotLockOutUserOfAuthenticationService(t *testing.T) {
+	// GIVEN
+	_, restore := captureMails()
+	defer restore()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	hydra.respond("/authenticate", map[string]interface{}{"user": map[string]interface{}{"id": 42, "email": "foo@example.com"}})
+	conf := registrationConfig()
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	// somebody else registered the address of the user
+	require.Equal(t, http.StatusOK, register(t, router, "attacker1").Code)
+
+	// WHEN
+	w := logIn(t, router, "secret123")
+
+	// THEN
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, w.Header().Get("Location"))
+	assert.Equal(t, "42", hydra.receivedBody(acceptLoginRequestPath)["subject"])
+}
+
+func TestVerifyEmailRejectsUsedLink(t *testing.T) {
+	// GIVEN
+	box, restore := captureMails()
+	defer restore()
+	router := newTestRouter(registrationConfig())
+	require.Equal(t, http.StatusOK, postForm(t, router, "/register", url.Values{
+		"email":                 {"foo@example.com"},
+		"first_name":            {"Foo"},
+		"password":              {"secret123"},
+		"password_confirmation": {"secret123"},
+	}).Code)
+	path := verificationPath(t, box)
+
+	// WHEN
+	first := serve(router, httptest.NewRequest(http.MethodGet, path, nil))
+	second := serve(router, httptest.NewRequest(http.MethodGet, path, nil))
+
+	// THEN
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Contains(t, first.Body.String(), "Your email address has been confirmed")
+	assert.Equal(t, http.StatusBadRequest, second.Code)
+	assert.Contains(t, second.Body.String(), "The link is invalid or expired")
+}
+
+func TestRegisterIsDisabledByDefault(t *testing.T) {
+	// GIVEN
+	router := newTestRouter(&MockConfiguration{})
+
+	// WHEN
+	w := serve(router, httptest.NewRequest(http.MethodGet, "/register", nil))
+
+	// THEN
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
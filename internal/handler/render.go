@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"login-provider/internal/client_meta"
+	"login-provider/internal/config"
+	"login-provider/internal/i18n"
+	"login-provider/internal/theme"
+	"net/http"
+	"net/url"
+)
+
+// localeKey is the key the negotiated locale is stored with in the gin context
+const localeKey = "locale"
+
+type languageLink struct {
+	Name   string
+	Url    string
+	Active bool
+}
+
+// negotiateLocale determines the locale to render the pages in. The ui_locales requested by the
+// client are taken into account if the user did not select a locale explicitly.
+func negotiateLocale(c *gin.Context, uiLocales []string) string {
+	locale := i18n.DefaultLocale
+	if value, ok := c.Get(i18n.ContextKey); ok {
+		locale = value.(*i18n.Preferences).Locale(uiLocales)
+	}
+	c.Set(localeKey, locale)
+	return locale
+}
+
+// selectTheme selects the theme to render the pages for the given client in
+func selectTheme(c *gin.Context, conf config.Configuration, clientID string, info *client_meta.ClientMetaInfo) {
+	c.Set(theme.DataKey, theme.Select(conf.Themes(), conf.ClientThemes(), clientID, info.Theme))
+}
+
+// render renders the given template adding the data required by all templates, like the locale
+// to render the page in, the selected theme and the links of the language switcher
+func render(c *gin.Context, code int, name string, data gin.H) {
+	locale := c.GetString(localeKey)
+	if len(locale) == 0 {
+		locale = negotiateLocale(c, nil)
+	}
+
+	data["locale"] = locale
+	if selected, ok := c.Get(theme.DataKey); ok {
+		data[theme.DataKey] = selected
+	}
+	// switching the language is only possible for pages, which can be requested again
+	if c.Request.Method == http.MethodGet {
+		data["languages"] = languageLinks(c.Request.URL, locale)
+	}
+
+	c.HTML(code, name, data)
+}
+
+func languageLinks(requestUrl *url.URL, current string) []languageLink {
+	var links []languageLink
+	for _, locale := range i18n.SupportedLocales() {
+		query := requestUrl.Query()
+		query.Set("lang", locale)
+		links = append(links, languageLink{
+			Name:   i18n.Translate(locale, "language"),
+			Url:    requestUrl.Path + "?" + query.Encode(),
+			Active: locale == current,
+		})
+	}
+	return links
+}
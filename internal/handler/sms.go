@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/config"
+	"login-provider/internal/flow"
+	"net/http"
+	"net/url"
+)
+
+// smsUrl returns the url of the page to enter the code sent by SMS for the given login challenge
+func smsUrl(challenge string) string {
+	return "/login/sms?" + url.Values{"login_challenge": {challenge}}.Encode()
+}
+
+// loginPageUrl returns the url of the login page of the given login challenge
+func loginPageUrl(challenge string) string {
+	return "/login?" + url.Values{"login_challenge": {challenge}}.Encode()
+}
+
+// ShowSmsPage asks for the code sent by SMS after the user entered the password. Users start over
+// on the login page if the code expired.
+func ShowSmsPage(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		challenge := c.Query("login_challenge")
+		if len(challenge) == 0 {
+			log.Ctx(c.Request.Context()).Warn().Msg("No login challenge provided")
+			HandleBadRequest(c, conf)
+			return
+		}
+
+		smsChallenge, err := svc.GetSmsChallenge(challenge, currentBrowserKey(c))
+		if err != nil {
+			c.Redirect(302, loginPageUrl(challenge))
+			return
+		}
+		if !prepareChallengePage(c, svc, conf, challenge) {
+			return
+		}
+		renderSms(c, http.StatusOK, smsChallenge, "", "")
+	}
+}
+
+// VerifySms completes the login with the code sent by SMS. Verifying the phone number can be skipped.
+func VerifySms(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		smsCode := &flow.SmsCode{
+			Challenge:  c.PostForm("challenge"),
+			Code:       c.PostForm("code"),
+			Skip:       c.PostForm("action") == "skip",
+			BrowserKey: currentBrowserKey(c),
+		}
+		if len(smsCode.Challenge) == 0 {
+			log.Ctx(c.Request.Context()).Warn().Msg("No login challenge provided")
+			HandleBadRequest(c, conf)
+			return
+		}
+
+		result, err := svc.VerifySmsCode(c.Request.Context(), smsCode)
+		if err != nil {
+			if handleUnavailable(c, err) {
+				return
+			}
+			smsChallenge, pendingErr := svc.GetSmsChallenge(smsCode.Challenge, smsCode.BrowserKey)
+			if pendingErr != nil {
+				// the code expired, the user has to start over
+				c.Redirect(302, loginPageUrl(smsCode.Challenge))
+				return
+			}
+
+			status, message := http.StatusBadRequest, "error.login_failed"
+			switch {
+			case errors.Is(err, flow.ErrInvalidCode), errors.Is(err, flow.ErrSkipNotAllowed):
+				message = "error.invalid_code"
+			case errors.Is(err, flow.ErrTooManyAttempts):
+				status, message = http.StatusTooManyRequests, "error.too_many_attempts"
+			default:
+				log.Ctx(c.Request.Context()).Err(err).Msg("Login with code sent by SMS failed")
+			}
+			renderSms(c, status, smsChallenge, message, "")
+			return
+		}
+
+		c.Redirect(302, loginResultUrl(result, smsCode.Challenge))
+	}
+}
+
+// ResendSms sends a new code by SMS
+func ResendSms(svc *flow.Service, conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		challenge := c.PostForm("challenge")
+		if len(challenge) == 0 {
+			log.Ctx(c.Request.Context()).Warn().Msg("No login challenge provided")
+			HandleBadRequest(c, conf)
+			return
+		}
+
+		smsChallenge, err := svc.GetSmsChallenge(challenge, currentBrowserKey(c))
+		if err != nil {
+			c.Redirect(302, loginPageUrl(challenge))
+			return
+		}
+		if !prepareChallengePage(c, svc, conf, challenge) {
+			return
+		}
+
+		err = svc.ResendSmsCode(c.Request.Context(), challenge, currentBrowserKey(c), c.GetString(localeKey))
+		switch {
+		case err == nil:
+			renderSms(c, http.StatusOK, smsChallenge, "", "sms.resent")
+		case errors.Is(err, flow.ErrTooManyAttempts):
+			renderSms(c, http.StatusTooManyRequests, smsChallenge, "error.too_many_attempts", "")
+		default:
+			renderSms(c, http.StatusBadRequest, smsChallenge, "error.sms_failed", "")
+		}
+	}
+}
+
+func renderSms(c *gin.Context, code int, smsChallenge *flow.SmsChallenge, errorMessage, notice string) {
+	render(c, code, "sms.html", gin.H{
+		"title":     "title.sms",
+		"challenge": smsChallenge.Challenge,
+		"number":    smsChallenge.Number,
+		"optional":  smsChallenge.Optional,
+		"error":     errorMessage,
+		"notice":    notice,
+	})
+}
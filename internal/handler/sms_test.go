@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"login-provider/internal/config"
+	"login-provider/internal/sms"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// outbox captures the text messages sent by the login provider
+type outbox struct {
+	mutex    sync.Mutex
+	messages []*sms.Message
+}
+
+func (o *outbox) Send(_ context.Context, message *sms.Message) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.messages = append(o.messages, message)
+	return nil
+}
+
+// lastCode returns the code of the last message
+func (o *outbox) lastCode(t *testing.T) string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	require.NotEmpty(t, o.messages)
+	code := loginCode.FindString(o.messages[len(o.messages)-1].Body)
+	require.NotEmpty(t, code)
+	return code
+}
+
+func (o *outbox) sent() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return len(o.messages)
+}
+
+// captureSms lets the routers created afterwards send their text messages to the returned outbox.
+// The returned function restores the configured gateway.
+func captureSms() (*outbox, func()) {
+	box := &outbox{}
+	previous := newSmsGateway
+	newSmsGateway = func(config.Configuration) (sms.Gateway, error) { return box, nil }
+	return box, func() { newSmsGateway = previous }
+}
+
+// smsConfig enables the registration with a phone number field, so tests can create a user with a phone number
+func smsConfig(secondFactor, verifyPhone bool) *MockConfiguration {
+	conf := registrationConfig()
+	conf.registration.Fields = append(conf.registration.Fields, config.RegistrationField{Name: "phone"})
+	conf.sms = &config.SmsConfig{
+		Gateway:      config.SmsGatewayFile,
+		CodeTtl:      time.Minute,
+		MaxPerNumber: 3,
+		NumberWindow: time.Hour,
+		MaxAttempts:  3,
+		SecondFactor: secondFactor,
+		VerifyPhone:  verifyPhone,
+	}
+	return conf
+}
+
+// userWithPhone registers foo@example.com with a phone number and verifies the email address
+func userWithPhone(t *testing.T, router http.Handler, box *mailbox) {
+	w := postForm(t, router, "/register", url.Values{
+		"challenge":             {"foo"},
+		"email":                 {"foo@example.com"},
+		"first_name":            {"Foo"},
+		"phone":                 {"+49 170 1234567"},
+		"password":              {"secret123"},
+		"password_confirmation": {"secret123"},
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	serve(router, httptest.NewRequest(http.MethodGet, verificationPath(t, box), nil))
+}
+
+func enterSmsCode(t *testing.T, router http.Handler, code string) *httptest.ResponseRecorder {
+	return postForm(t, router, "/login/sms", url.Values{"challenge": {"foo"}, "code": {code}, "action": {"verify"}})
+}
+
+func TestSmsSecondFactorCompletesLogin(t *testing.T) {
+	// GIVEN
+	box, restoreMails := captureMails()
+	defer restoreMails()
+	messages, restoreSms := captureSms()
+	defer restoreSms()
+	// hydra v1 does not support the authentication method references
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond("/version", map[string]string{"version": "v2.3.0"})
+	hydra.respond("/admin"+loginRequestPath, loginRequest(false, "", ""))
+	conf := smsConfig(true, false)
+	conf.hydraAdminUrl = hydra.URL
+	router := newBrowser(newTestRouter(conf))
+	userWithPhone(t, router, box)
+
+	// WHEN
+	login := logIn(t, router, "secret123")
+	page := serve(router, httptest.NewRequest(http.MethodGet, login.Header().Get("Location"), nil))
+	code := messages.lastCode(t)
+	skipped := postForm(t, router, "/login/sms", url.Values{"challenge": {"foo"}, "action": {"skip"}})
+	verified := enterSmsCode(t, router, code)
+	accepted := hydra.receivedBody("/admin" + acceptLoginRequestPath)
+
+	// THEN
+	assert.Equal(t, http.StatusFound, login.Code)
+	assert.Equal(t, "/login/sms?login_challenge=foo", login.Header().Get("Location"))
+	assert.Equal(t, http.StatusOK, page.Code)
+	assert.Contains(t, page.Body.String(), "••••••••••567")
+	assert.NotContains(t, page.Body.String(), `value="skip"`)
+	assert.Equal(t, http.StatusBadRequest, skipped.Code)
+	assert.Equal(t, http.StatusFound, verified.Code)
+	assert.Equal(t, "https://hydra.example.com/admin"+acceptLoginRequestPath, verified.Header().Get("Location"))
+	assert.Equal(t, []interface{}{"pwd", "sms", "mfa"}, accepted["amr"])
+}
+
+func TestSmsCodeIsOnlyAcceptedFromBrowserOfLogin(t *testing.T) {
+	// GIVEN
+	box, restoreMails := captureMails()
+	defer restoreMails()
+	messages, restoreSms := captureSms()
+	defer restoreSms()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := smsConfig(true, false)
+	conf.hydraAdminUrl = hydra.URL
+	router := newTestRouter(conf)
+	userBrowser := newBrowser(router)
+	userWithPhone(t, userBrowser, box)
+	require.Equal(t, "/login/sms?login_challenge=foo", logIn(t, userBrowser, "secret123").Header().Get("Location"))
+
+	// WHEN
+	page := serve(router, httptest.NewRequest(http.MethodGet, "/login/sms?login_challenge=foo", nil))
+	other := enterSmsCode(t, router, messages.lastCode(t))
+	own := enterSmsCode(t, userBrowser, messages.lastCode(t))
+
+	// THEN
+	assert.Equal(t, "/login?login_challenge=foo", page.Header().Get("Location"))
+	assert.Equal(t, http.StatusFound, other.Code)
+	assert.Equal(t, "/login?login_challenge=foo", other.Header().Get("Location"))
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, own.Header().Get("Location"))
+}
+
+func TestSmsVerifiesPhoneNumberOnce(t *testing.T) {
+	// GIVEN
+	box, restoreMails := captureMails()
+	defer restoreMails()
+	messages, restoreSms := captureSms()
+	defer restoreSms()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := smsConfig(false, true)
+	conf.hydraAdminUrl = hydra.URL
+	router := newBrowser(newTestRouter(conf))
+	userWithPhone(t, router, box)
+
+	// WHEN
+	first := logIn(t, router, "secret123")
+	skipped := postForm(t, router, "/login/sms", url.Values{"challenge": {"foo"}, "action": {"skip"}})
+	second := logIn(t, router, "secret123")
+	verified := enterSmsCode(t, router, messages.lastCode(t))
+	third := logIn(t, router, "secret123")
+
+	// THEN
+	assert.Equal(t, "/login/sms?login_challenge=foo", first.Header().Get("Location"))
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, skipped.Header().Get("Location"))
+	assert.Equal(t, "/login/sms?login_challenge=foo", second.Header().Get("Location"))
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, verified.Header().Get("Location"))
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, third.Header().Get("Location"))
+	assert.Equal(t, 2, messages.sent())
+}
+
+func TestSmsCodesAreLimitedPerNumber(t *testing.T) {
+	// GIVEN
+	box, restoreMails := captureMails()
+	defer restoreMails()
+	messages, restoreSms := captureSms()
+	defer restoreSms()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := smsConfig(true, false)
+	conf.hydraAdminUrl = hydra.URL
+	router := newBrowser(newTestRouter(conf))
+	userWithPhone(t, router, box)
+	logIn(t, router, "secret123")
+	for i := 1; i < conf.sms.MaxPerNumber; i++ {
+		require.Equal(t, http.StatusOK, postForm(t, router, "/login/sms/resend", url.Values{"challenge": {"foo"}}).Code)
+	}
+
+	// WHEN
+	w := postForm(t, router, "/login/sms/resend", url.Values{"challenge": {"foo"}})
+
+	// THEN
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, conf.sms.MaxPerNumber, messages.sent())
+}
+
+func TestApiAsksForSmsCode(t *testing.T) {
+	// GIVEN
+	box, restoreMails := captureMails()
+	defer restoreMails()
+	messages, restoreSms := captureSms()
+	defer restoreSms()
+	hydra := newFakeHydra()
+	defer hydra.Close()
+	hydra.respond(loginRequestPath, loginRequest(false, "", ""))
+	conf := smsConfig(true, false)
+	conf.hydraAdminUrl = hydra.URL
+	router := newBrowser(newTestRouter(conf))
+	userWithPhone(t, router, box)
+
+	// WHEN
+	loginStatus, login := postJson(t, router, "/api/v1/login", map[string]interface{}{
+		"challenge": "foo",
+		"email":     "foo@example.com",
+		"password":  "secret123",
+	})
+	skipStatus, skip := postJson(t, router, "/api/v1/login/sms", map[string]interface{}{"challenge": "foo", "skip": true})
+	codeStatus, code := postJson(t, router, "/api/v1/login/sms", map[string]interface{}{"challenge": "foo", "code": messages.lastCode(t)})
+
+	// THEN
+	assert.Equal(t, http.StatusOK, loginStatus)
+	assert.Equal(t, map[string]interface{}{"phone_number": "••••••••••567", "optional": false}, login["sms"])
+	assert.Nil(t, login["redirect_to"])
+	assert.Equal(t, http.StatusBadRequest, skipStatus)
+	assert.Equal(t, "invalid_code", skip["error"])
+	assert.Equal(t, http.StatusOK, codeStatus)
+	assert.Equal(t, "https://hydra.example.com"+acceptLoginRequestPath, code["redirect_to"])
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// staticCacheMaxAge is the period of time in seconds browsers may cache static assets without
+// revalidating these
+const staticCacheMaxAge = 24 * 60 * 60
+
+// overlayFS serves files from the primary file system if present there and from the fallback
+// file system otherwise
+type overlayFS struct {
+	primary  fs.FS
+	fallback fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if o.primary != nil {
+		if f, err := o.primary.Open(name); err == nil {
+			return f, nil
+		}
+	}
+	return o.fallback.Open(name)
+}
+
+// Static serves the embedded static assets overridden by the ones from the given directory if
+// configured. The responses allow browsers to cache the assets and carry an ETag to let browsers
+// revalidate these cheaply. Directories are not listed.
+func Static(embedded fs.FS, overrideDir string) gin.HandlerFunc {
+	assets := overlayFS{fallback: embedded}
+	if len(overrideDir) != 0 {
+		assets.primary = os.DirFS(overrideDir)
+	}
+
+	etags := make(map[string]string)
+	for _, layer := range []fs.FS{assets.fallback, assets.primary} {
+		if layer == nil {
+			continue
+		}
+		err := fs.WalkDir(layer, ".", func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			etag, err := computeEtag(assets, path)
+			if err == nil {
+				etags[path] = etag
+			}
+			return err
+		})
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to index static assets")
+		}
+	}
+
+	fileServer := http.StripPrefix("/static", http.FileServer(http.FS(assets)))
+
+	return func(c *gin.Context) {
+		etag, ok := etags[strings.TrimPrefix(c.Param("filepath"), "/")]
+		if !ok {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		c.Header("Cache-Control", "public, max-age="+strconv.Itoa(staticCacheMaxAge))
+		// the file server answers conditional requests based on this header
+		c.Header("ETag", etag)
+		fileServer.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+func computeEtag(assets fs.FS, path string) (string, error) {
+	f, err := assets.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(hash[:16]) + `"`, nil
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+var embeddedAssets = fstest.MapFS{
+	"css/site.css": {Data: []byte("body {}")},
+	"img/logo.svg": {Data: []byte("<svg/>")},
+}
+
+func serveStatic(router *gin.Engine, path string, header http.Header) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(http.MethodGet, path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestStaticAssetsAreServedWithCacheHeaders(t *testing.T) {
+	// GIVEN
+	router := gin.New()
+	router.GET("/static/*filepath", Static(embeddedAssets, ""))
+
+	// WHEN
+	w := serveStatic(router, "/static/css/site.css", nil)
+	revalidated := serveStatic(router, "/static/css/site.css", http.Header{"If-None-Match": {w.Header().Get("ETag")}})
+
+	// THEN
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "body {}", w.Body.String())
+	assert.Contains(t, w.Header().Get("Cache-Control"), "max-age=")
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+	assert.Equal(t, http.StatusNotModified, revalidated.Code)
+}
+
+func TestStaticAssetsCanBeOverridden(t *testing.T) {
+	// GIVEN
+	overrideDir, err := ioutil.TempDir("", "static")
+	require.NoError(t, err)
+	defer os.RemoveAll(overrideDir)
+	require.NoError(t, os.Mkdir(filepath.Join(overrideDir, "img"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(overrideDir, "img", "logo.svg"), []byte("<svg id=\"acme\"/>"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(overrideDir, "acme.css"), []byte(".acme {}"), 0600))
+
+	router := gin.New()
+	router.GET("/static/*filepath", Static(embeddedAssets, overrideDir))
+
+	// WHEN
+	logo := serveStatic(router, "/static/img/logo.svg", nil)
+	css := serveStatic(router, "/static/css/site.css", nil)
+	extension := serveStatic(router, "/static/acme.css", nil)
+
+	// THEN
+	assert.Equal(t, "<svg id=\"acme\"/>", logo.Body.String())
+	assert.Equal(t, "body {}", css.Body.String())
+	assert.Equal(t, ".acme {}", extension.Body.String())
+}
+
+func TestStaticDirectoriesAreNotListed(t *testing.T) {
+	// GIVEN
+	router := gin.New()
+	router.GET("/static/*filepath", Static(embeddedAssets, ""))
+
+	// WHEN
+	w := serveStatic(router, "/static/css/", nil)
+
+	// THEN
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,190 @@
+package hydra
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// detectingAdmin asks hydra for its version on first use and delegates to the matching adapter.
+// If hydra can't be asked, the call fails and the detection is repeated with the next one.
+// Concurrent calls share a running detection instead of asking hydra again.
+type detectingAdmin struct {
+	v1 *v1Admin
+	v2 *v2Admin
+
+	mutex     sync.Mutex
+	detected  Admin
+	detecting *detection
+}
+
+// detection is the result of asking hydra for its version. done is closed once it is available.
+type detection struct {
+	done  chan struct{}
+	admin Admin
+	err   error
+}
+
+func (a *detectingAdmin) admin(ctx context.Context) (Admin, error) {
+	a.mutex.Lock()
+	if a.detected != nil {
+		defer a.mutex.Unlock()
+		return a.detected, nil
+	}
+	if d := a.detecting; d != nil {
+		a.mutex.Unlock()
+		select {
+		case <-d.done:
+			return d.admin, d.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	d := &detection{done: make(chan struct{})}
+	a.detecting = d
+	a.mutex.Unlock()
+
+	// hydra is asked without holding the lock, which would block all other calls meanwhile
+	d.admin, d.err = a.detect(ctx)
+
+	a.mutex.Lock()
+	a.detecting = nil
+	if d.err == nil {
+		a.detected = d.admin
+	}
+	a.mutex.Unlock()
+	close(d.done)
+	return d.admin, d.err
+}
+
+func (a *detectingAdmin) detect(ctx context.Context) (Admin, error) {
+	version, err := a.v2.version(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect the version of hydra: %w", err)
+	}
+
+	major, err := majorVersion(version)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Ctx(ctx).Info().Str("_version", version).Msg("Detected version of the hydra admin API")
+	if major < 2 {
+		return a.v1, nil
+	}
+	return a.v2, nil
+}
+
+// majorVersion parses versions like "v1.10.6" or "2.2.0"
+func majorVersion(version string) (int, error) {
+	major := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 2)[0]
+	value, err := strconv.Atoi(major)
+	if err != nil {
+		return 0, fmt.Errorf("unsupported version of hydra %q. Configure the version of the admin API explicitly", version)
+	}
+	return value, nil
+}
+
+func (a *detectingAdmin) GetLoginRequest(ctx context.Context, challenge string) (*LoginRequest, error) {
+	admin, err := a.admin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return admin.GetLoginRequest(ctx, challenge)
+}
+
+func (a *detectingAdmin) AcceptLoginRequest(ctx context.Context, challenge string, accept *AcceptLogin) (string, error) {
+	admin, err := a.admin(ctx)
+	if err != nil {
+		return "", err
+	}
+	return admin.AcceptLoginRequest(ctx, challenge, accept)
+}
+
+func (a *detectingAdmin) GetConsentRequest(ctx context.Context, challenge string) (*ConsentRequest, error) {
+	admin, err := a.admin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return admin.GetConsentRequest(ctx, challenge)
+}
+
+func (a *detectingAdmin) AcceptConsentRequest(ctx context.Context, challenge string, accept *AcceptConsent) (string, error) {
+	admin, err := a.admin(ctx)
+	if err != nil {
+		return "", err
+	}
+	return admin.AcceptConsentRequest(ctx, challenge, accept)
+}
+
+func (a *detectingAdmin) RejectConsentRequest(ctx context.Context, challenge string, reject *Rejection) (string, error) {
+	admin, err := a.admin(ctx)
+	if err != nil {
+		return "", err
+	}
+	return admin.RejectConsentRequest(ctx, challenge, reject)
+}
+
+func (a *detectingAdmin) ListConsentSessions(ctx context.Context, subject string) ([]*ConsentSession, error) {
+	admin, err := a.admin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return admin.ListConsentSessions(ctx, subject)
+}
+
+func (a *detectingAdmin) GetLogoutRequest(ctx context.Context, challenge string) (*LogoutRequest, error) {
+	admin, err := a.admin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return admin.GetLogoutRequest(ctx, challenge)
+}
+
+func (a *detectingAdmin) AcceptLogoutRequest(ctx context.Context, challenge string) (string, error) {
+	admin, err := a.admin(ctx)
+	if err != nil {
+		return "", err
+	}
+	return admin.AcceptLogoutRequest(ctx, challenge)
+}
+
+func (a *detectingAdmin) RejectLogoutRequest(ctx context.Context, challenge string) error {
+	admin, err := a.admin(ctx)
+	if err != nil {
+		return err
+	}
+	return admin.RejectLogoutRequest(ctx, challenge)
+}
+
+func (a *detectingAdmin) GetClient(ctx context.Context, id string) (*Client, error) {
+	admin, err := a.admin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return admin.GetClient(ctx, id)
+}
+
+func (a *detectingAdmin) AcceptUserCodeRequest(ctx context.Context, challenge, userCode string) (string, error) {
+	admin, err := a.admin(ctx)
+	if err != nil {
+		return "", err
+	}
+	return admin.AcceptUserCodeRequest(ctx, challenge, userCode)
+}
+
+func (a *detectingAdmin) RevokeLoginSessions(ctx context.Context, subject string) error {
+	admin, err := a.admin(ctx)
+	if err != nil {
+		return err
+	}
+	return admin.RevokeLoginSessions(ctx, subject)
+}
+
+// Ready does not need the version, hydra serves the health end points under the same path in all versions
+func (a *detectingAdmin) Ready(ctx context.Context) error {
+	return a.v2.Ready(ctx)
+}
@@ -1,73 +1,285 @@
+// Package hydra provides access to the admin API of hydra. The flows only use the Admin interface
+// and the types of this package, so they don't depend on a specific version of the API. Adapters
+// exist for hydra v1.x and v2.x, which serves the login, consent and logout requests under
+// /admin/oauth2.
 package hydra
 
 import (
 	"context"
-	"fmt"
-	httptransport "github.com/go-openapi/runtime/client"
-	"github.com/ory/hydra-client-go/client"
+	"errors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"login-provider/internal/config"
-	"net/url"
+	"login-provider/internal/upstream"
+	"sync"
+	"time"
 )
 
-type ClientFactory struct {
-	transport *httptransport.Runtime
+// Admin are the operations of the hydra admin API used by the login provider
+type Admin interface {
+	GetLoginRequest(ctx context.Context, challenge string) (*LoginRequest, error)
+	// AcceptLoginRequest returns the url to redirect the user to
+	AcceptLoginRequest(ctx context.Context, challenge string, accept *AcceptLogin) (string, error)
+	GetConsentRequest(ctx context.Context, challenge string) (*ConsentRequest, error)
+	// AcceptConsentRequest returns the url to redirect the user to
+	AcceptConsentRequest(ctx context.Context, challenge string, accept *AcceptConsent) (string, error)
+	// RejectConsentRequest returns the url to redirect the user to
+	RejectConsentRequest(ctx context.Context, challenge string, reject *Rejection) (string, error)
+	// ListConsentSessions returns the consent sessions of the given subject
+	ListConsentSessions(ctx context.Context, subject string) ([]*ConsentSession, error)
+	GetLogoutRequest(ctx context.Context, challenge string) (*LogoutRequest, error)
+	// AcceptLogoutRequest returns the url to redirect the user to
+	AcceptLogoutRequest(ctx context.Context, challenge string) (string, error)
+	RejectLogoutRequest(ctx context.Context, challenge string) error
+	GetClient(ctx context.Context, id string) (*Client, error)
+	// AcceptUserCodeRequest verifies the user code entered for a device challenge of the device
+	// authorization grant and returns the url to redirect the user to. Returns ErrUnsupported for hydra v1
+	AcceptUserCodeRequest(ctx context.Context, challenge, userCode string) (string, error)
+	// RevokeLoginSessions invalidates all login sessions of the subject, so hydra does not skip the
+	// login anymore
+	RevokeLoginSessions(ctx context.Context, subject string) error
+	// Ready returns an error unless hydra reports to be ready, i.e. it reaches its database
+	Ready(ctx context.Context) error
 }
 
-func NewClientFactory(conf config.Configuration) (*ClientFactory, error) {
-	url, err := url.Parse(conf.HydraAdminUrl())
+// ErrUnsupported is returned if the operation is not supported by the version of hydra
+var ErrUnsupported = errors.New("not supported by this version of hydra")
+
+// LoginRequest is the request of a client to authenticate a user
+type LoginRequest struct {
+	Challenge                    string       `json:"challenge,omitempty"`
+	Client                       *Client      `json:"client,omitempty"`
+	OidcContext                  *OidcContext `json:"oidc_context,omitempty"`
+	RequestURL                   string       `json:"request_url,omitempty"`
+	RequestedAccessTokenAudience []string     `json:"requested_access_token_audience,omitempty"`
+	RequestedScope               []string     `json:"requested_scope,omitempty"`
+	SessionID                    string       `json:"session_id,omitempty"`
+	// Skip is true if hydra authenticated the user already. The login request must be accepted
+	// for Subject then.
+	Skip    bool   `json:"skip,omitempty"`
+	Subject string `json:"subject,omitempty"`
+}
+
+// ConsentRequest is the request of a client to get access on behalf of a user
+type ConsentRequest struct {
+	Acr       string  `json:"acr,omitempty"`
+	Challenge string  `json:"challenge,omitempty"`
+	Client    *Client `json:"client,omitempty"`
+	// Context is the context set when the login request has been accepted
+	Context                      interface{}  `json:"context,omitempty"`
+	LoginChallenge               string       `json:"login_challenge,omitempty"`
+	LoginSessionID               string       `json:"login_session_id,omitempty"`
+	OidcContext                  *OidcContext `json:"oidc_context,omitempty"`
+	RequestURL                   string       `json:"request_url,omitempty"`
+	RequestedAccessTokenAudience []string     `json:"requested_access_token_audience,omitempty"`
+	RequestedScope               []string     `json:"requested_scope,omitempty"`
+	// Skip is true if hydra remembers a previous consent of the user
+	Skip    bool   `json:"skip,omitempty"`
+	Subject string `json:"subject,omitempty"`
+}
+
+// LogoutRequest is the request to terminate the session of a user
+type LogoutRequest struct {
+	Challenge   string `json:"challenge,omitempty"`
+	RequestURL  string `json:"request_url,omitempty"`
+	RpInitiated bool   `json:"rp_initiated,omitempty"`
+	Sid         string `json:"sid,omitempty"`
+	Subject     string `json:"subject,omitempty"`
+	// Client is the relying party, which initiated the logout. Only reported by hydra v2
+	Client *Client `json:"client,omitempty"`
+}
+
+// OidcContext contains the OpenID Connect specific parameters of a login or consent request
+type OidcContext struct {
+	AcrValues         []string    `json:"acr_values,omitempty"`
+	Display           string      `json:"display,omitempty"`
+	IDTokenHintClaims interface{} `json:"id_token_hint_claims,omitempty"`
+	LoginHint         string      `json:"login_hint,omitempty"`
+	UILocales         []string    `json:"ui_locales,omitempty"`
+}
+
+// Client is an OAuth2 client registered in hydra
+type Client struct {
+	ClientID                          string      `json:"client_id,omitempty"`
+	ClientName                        string      `json:"client_name,omitempty"`
+	ClientURI                         string      `json:"client_uri,omitempty"`
+	FrontchannelLogoutSessionRequired bool        `json:"frontchannel_logout_session_required,omitempty"`
+	FrontchannelLogoutURI             string      `json:"frontchannel_logout_uri,omitempty"`
+	LogoURI                           string      `json:"logo_uri,omitempty"`
+	Metadata                          interface{} `json:"metadata,omitempty"`
+	PolicyURI                         string      `json:"policy_uri,omitempty"`
+	PostLogoutRedirectUris            []string    `json:"post_logout_redirect_uris,omitempty"`
+	TosURI                            string      `json:"tos_uri,omitempty"`
+}
+
+// ConsentSession is a consent granted by a user previously
+type ConsentSession struct {
+	ConsentRequest           *ConsentRequest `json:"consent_request,omitempty"`
+	GrantAccessTokenAudience []string        `json:"grant_access_token_audience,omitempty"`
+	GrantScope               []string        `json:"grant_scope,omitempty"`
+	HandledAt                time.Time       `json:"handled_at,omitempty"`
+}
+
+// AcceptLogin confirms the authentication of a user
+type AcceptLogin struct {
+	Acr string `json:"acr,omitempty"`
+	// Amr lists the authentication methods used. It is ignored by hydra v1
+	Amr         []string    `json:"amr,omitempty"`
+	Context     interface{} `json:"context,omitempty"`
+	Remember    bool        `json:"remember,omitempty"`
+	RememberFor int64       `json:"remember_for,omitempty"`
+	Subject     string      `json:"subject"`
+}
+
+// AcceptConsent grants the given scopes and audiences to the client
+type AcceptConsent struct {
+	GrantAccessTokenAudience []string       `json:"grant_access_token_audience,omitempty"`
+	GrantScope               []string       `json:"grant_scope,omitempty"`
+	HandledAt                time.Time      `json:"handled_at,omitempty"`
+	Remember                 bool           `json:"remember,omitempty"`
+	RememberFor              int64          `json:"remember_for,omitempty"`
+	Session                  *ConsentClaims `json:"session,omitempty"`
+}
+
+// ConsentClaims are the claims added to the access and id tokens
+type ConsentClaims struct {
+	AccessToken interface{} `json:"access_token,omitempty"`
+	IDToken     interface{} `json:"id_token,omitempty"`
+}
+
+// Rejection tells the client why a request has been rejected
+type Rejection struct {
+	Error            string `json:"error,omitempty"`
+	ErrorDescription string `json:"error_description,omitempty"`
+	ErrorHint        string `json:"error_hint,omitempty"`
+	StatusCode       int64  `json:"status_code,omitempty"`
+}
+
+// AdminClient implements Admin with the adapter for the configured version of the admin API. The
+// adapter is replaced if the configuration changes.
+type AdminClient struct {
+	mutex sync.RWMutex
+	admin Admin
+}
+
+func NewAdminClient(conf config.Configuration) (*AdminClient, error) {
+	admin, err := newAdmin(conf)
 	if err != nil {
 		return nil, err
 	}
 
-	factory := &ClientFactory{}
+	return &AdminClient{admin: admin}, nil
+}
 
-	if caFile, err := conf.TlsTrustStore(); err != nil {
-		log.Info().Msg("No explicit trust store configured. Falling back to a system-wide one")
-		// if a specific trust store is not specified, we'll rely on the system-wide trust store
-		factory.transport = httptransport.New(url.Host, url.Path, []string{url.Scheme})
-	} else {
-		log.Info().Msg("Explicit trust store configured. Using it")
-		// if a specific trust store has been specified use it instead fo the the system wide one
-		tlsClient, err := httptransport.TLSClient(httptransport.TLSClientOptions{
-			CA: caFile,
-		})
-
-		if err != nil {
-			return nil, err
-		}
-
-		factory.transport = httptransport.NewWithClient(url.Host, url.Path, []string{url.Scheme}, tlsClient)
+// Reconfigure creates the adapter for the given configuration. It replaces the current one
+// when the returned function is called. Calls in progress keep using the old adapter.
+func (ac *AdminClient) Reconfigure(conf config.Configuration) (func(), error) {
+	admin, err := newAdmin(conf)
+	if err != nil {
+		return nil, err
 	}
 
-	factory.transport.SetDebug(conf.LogLevel() == zerolog.DebugLevel)
+	return func() {
+		ac.mutex.Lock()
+		defer ac.mutex.Unlock()
+		ac.admin = admin
+	}, nil
+}
+
+func (ac *AdminClient) current() Admin {
+	ac.mutex.RLock()
+	defer ac.mutex.RUnlock()
+	return ac.admin
+}
 
-	return factory, nil
+func (ac *AdminClient) GetLoginRequest(ctx context.Context, challenge string) (*LoginRequest, error) {
+	return ac.current().GetLoginRequest(ctx, challenge)
 }
 
-func (cf *ClientFactory) NewClient(ctx context.Context) *client.OryHydra {
-	logger := log.Ctx(ctx)
-	cf.transport.SetLogger(zeroLogLogger{logger})
-	return client.New(cf.transport, nil)
+func (ac *AdminClient) AcceptLoginRequest(ctx context.Context, challenge string, accept *AcceptLogin) (string, error) {
+	return ac.current().AcceptLoginRequest(ctx, challenge, accept)
 }
 
-type zeroLogLogger struct{
-	logger *zerolog.Logger
+func (ac *AdminClient) GetConsentRequest(ctx context.Context, challenge string) (*ConsentRequest, error) {
+	return ac.current().GetConsentRequest(ctx, challenge)
 }
 
-func (l zeroLogLogger) Printf(format string, args ...interface{}) {
-	if len(format) == 0 || format[len(format)-1] != '\n' {
-		format += "\n"
-	}
+func (ac *AdminClient) AcceptConsentRequest(ctx context.Context, challenge string, accept *AcceptConsent) (string, error) {
+	return ac.current().AcceptConsentRequest(ctx, challenge, accept)
+}
 
-	l.logger.Info().Msg(fmt.Sprintf(format, args))
+func (ac *AdminClient) RejectConsentRequest(ctx context.Context, challenge string, reject *Rejection) (string, error) {
+	return ac.current().RejectConsentRequest(ctx, challenge, reject)
 }
 
-func (l zeroLogLogger) Debugf(format string, args ...interface{}) {
-	if len(format) == 0 || format[len(format)-1] != '\n' {
-		format += "\n"
+func (ac *AdminClient) ListConsentSessions(ctx context.Context, subject string) ([]*ConsentSession, error) {
+	return ac.current().ListConsentSessions(ctx, subject)
+}
+
+func (ac *AdminClient) GetLogoutRequest(ctx context.Context, challenge string) (*LogoutRequest, error) {
+	return ac.current().GetLogoutRequest(ctx, challenge)
+}
+
+func (ac *AdminClient) AcceptLogoutRequest(ctx context.Context, challenge string) (string, error) {
+	return ac.current().AcceptLogoutRequest(ctx, challenge)
+}
+
+func (ac *AdminClient) RejectLogoutRequest(ctx context.Context, challenge string) error {
+	return ac.current().RejectLogoutRequest(ctx, challenge)
+}
+
+func (ac *AdminClient) GetClient(ctx context.Context, id string) (*Client, error) {
+	return ac.current().GetClient(ctx, id)
+}
+
+func (ac *AdminClient) AcceptUserCodeRequest(ctx context.Context, challenge, userCode string) (string, error) {
+	return ac.current().AcceptUserCodeRequest(ctx, challenge, userCode)
+}
+
+func (ac *AdminClient) RevokeLoginSessions(ctx context.Context, subject string) error {
+	return ac.current().RevokeLoginSessions(ctx, subject)
+}
+
+func (ac *AdminClient) Ready(ctx context.Context) error {
+	return ac.current().Ready(ctx)
+}
+
+// newAdmin creates the adapter for the configured version of the admin API. All adapters share
+// the same http client, i.e. the same circuit breaker.
+func newAdmin(conf config.Configuration) (Admin, error) {
+	version, err := conf.HydraApiVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := conf.HydraAdminUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := conf.UpstreamTlsConfig(config.HydraAdmin)
+	if len(tlsConfig.TrustStoreFile) == 0 {
+		log.Info().Msg("No explicit trust store configured. Falling back to a system-wide one")
+	}
+	if tlsConfig.ClientCertificate != nil {
+		log.Info().Msg("Authenticating to hydra with a client certificate")
+	}
+
+	httpClient, err := upstream.NewClient(conf, config.HydraAdmin)
+	if err != nil {
+		return nil, err
 	}
 
-	l.logger.Debug().Msg(fmt.Sprintf(format, args))
+	level, _ := conf.LogLevel()
+	v1 := newV1Admin(url, httpClient, level == zerolog.DebugLevel)
+	v2 := newV2Admin(url, httpClient)
+
+	switch version {
+	case config.HydraV1:
+		return v1, nil
+	case config.HydraV2:
+		return v2, nil
+	default:
+		return &detectingAdmin{v1: v1, v2: v2}, nil
+	}
 }
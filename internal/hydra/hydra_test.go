@@ -0,0 +1,257 @@
+package hydra
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAdmin simulates the hydra admin API. The responses are configured per path, the received
+// requests are recorded
+type fakeAdmin struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	responses map[string]interface{}
+	requests  []*http.Request
+	bodies    map[string]map[string]interface{}
+}
+
+func newFakeAdmin(t *testing.T, responses map[string]interface{}) *fakeAdmin {
+	fa := &fakeAdmin{responses: responses, bodies: make(map[string]map[string]interface{})}
+	fa.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fa.mutex.Lock()
+		defer fa.mutex.Unlock()
+
+		fa.requests = append(fa.requests, r)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			body := make(map[string]interface{})
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			fa.bodies[r.URL.Path] = body
+		}
+
+		response, ok := fa.responses[r.URL.Path]
+		switch {
+		case !ok:
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Not Found", "error_description": "Unable to locate the resource"})
+		case response == nil:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			_ = json.NewEncoder(w).Encode(response)
+		}
+	}))
+	t.Cleanup(fa.Close)
+	return fa
+}
+
+func (fa *fakeAdmin) url(t *testing.T) *url.URL {
+	u, err := url.Parse(fa.URL)
+	require.NoError(t, err)
+	return u
+}
+
+func (fa *fakeAdmin) paths() []string {
+	fa.mutex.Lock()
+	defer fa.mutex.Unlock()
+	var paths []string
+	for _, r := range fa.requests {
+		paths = append(paths, r.Method+" "+r.URL.RequestURI())
+	}
+	return paths
+}
+
+func TestV2AdminUsesAdminPaths(t *testing.T) {
+	// GIVEN
+	fa := newFakeAdmin(t, map[string]interface{}{
+		"/admin/oauth2/auth/requests/login": map[string]interface{}{
+			"challenge": "foo",
+			"skip":      true,
+			"subject":   "1",
+			"client":    map[string]interface{}{"client_id": "bar", "metadata": map[string]interface{}{"ask_consent": true}},
+		},
+		"/admin/oauth2/auth/requests/login/accept": map[string]string{"redirect_to": "https://hydra.example.com/done"},
+	})
+	admin := newV2Admin(fa.url(t), http.DefaultClient)
+
+	// WHEN
+	lr, getErr := admin.GetLoginRequest(context.Background(), "foo")
+	redirectTo, acceptErr := admin.AcceptLoginRequest(context.Background(), "foo", &AcceptLogin{Subject: "1", Amr: []string{"pwd"}})
+
+	// THEN
+	require.NoError(t, getErr)
+	require.NoError(t, acceptErr)
+	assert.True(t, lr.Skip)
+	assert.Equal(t, "bar", lr.Client.ClientID)
+	assert.Equal(t, map[string]interface{}{"ask_consent": true}, lr.Client.Metadata)
+	assert.Equal(t, "https://hydra.example.com/done", redirectTo)
+	assert.Equal(t, []string{
+		"GET /admin/oauth2/auth/requests/login?login_challenge=foo",
+		"PUT /admin/oauth2/auth/requests/login/accept?login_challenge=foo",
+	}, fa.paths())
+	assert.Equal(t, map[string]interface{}{"subject": "1", "amr": []interface{}{"pwd"}}, fa.bodies["/admin/oauth2/auth/requests/login/accept"])
+}
+
+func TestV2AdminAcceptsResponsesWithoutContent(t *testing.T) {
+	// GIVEN
+	fa := newFakeAdmin(t, map[string]interface{}{"/admin/oauth2/auth/requests/logout/reject": nil})
+	admin := newV2Admin(fa.url(t), http.DefaultClient)
+
+	// WHEN
+	err := admin.RejectLogoutRequest(context.Background(), "foo")
+
+	// THEN
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PUT /admin/oauth2/auth/requests/logout/reject?logout_challenge=foo"}, fa.paths())
+}
+
+func TestV2AdminReportsErrorsOfHydra(t *testing.T) {
+	// GIVEN
+	fa := newFakeAdmin(t, map[string]interface{}{})
+	admin := newV2Admin(fa.url(t), http.DefaultClient)
+
+	// WHEN
+	_, err := admin.GetClient(context.Background(), "bar")
+
+	// THEN
+	var hydraErr *Error
+	require.True(t, errors.As(err, &hydraErr))
+	assert.Equal(t, http.StatusNotFound, hydraErr.StatusCode)
+	assert.Equal(t, "Not Found", hydraErr.Name)
+	assert.Equal(t, []string{"GET /admin/clients/bar"}, fa.paths())
+}
+
+func TestDetectingAdminSelectsAdapterByVersion(t *testing.T) {
+	for version, path := range map[string]string{
+		"v1.10.6": "/oauth2/auth/requests/logout",
+		"v2.2.0":  "/admin/oauth2/auth/requests/logout",
+	} {
+		t.Run(version, func(t *testing.T) {
+			// GIVEN
+			fa := newFakeAdmin(t, map[string]interface{}{
+				"/version": map[string]string{"version": version},
+				path:       map[string]interface{}{"subject": "1", "sid": "baz"},
+			})
+			admin := &detectingAdmin{v1: newV1Admin(fa.url(t), http.DefaultClient, false), v2: newV2Admin(fa.url(t), http.DefaultClient)}
+
+			// WHEN
+			lr, err := admin.GetLogoutRequest(context.Background(), "foo")
+
+			// THEN
+			require.NoError(t, err)
+			assert.Equal(t, "baz", lr.Sid)
+			assert.Equal(t, []string{"GET /version", "GET " + path + "?logout_challenge=foo"}, fa.paths())
+		})
+	}
+}
+
+func TestDetectingAdminRepeatsFailedDetection(t *testing.T) {
+	// GIVEN
+	fa := newFakeAdmin(t, map[string]interface{}{
+		"/version":                            map[string]string{"version": "master"},
+		"/admin/oauth2/auth/requests/consent": map[string]interface{}{"subject": "1"},
+	})
+	admin := &detectingAdmin{v1: newV1Admin(fa.url(t), http.DefaultClient, false), v2: newV2Admin(fa.url(t), http.DefaultClient)}
+	_, failedErr := admin.GetConsentRequest(context.Background(), "foo")
+	fa.mutex.Lock()
+	fa.responses["/version"] = map[string]string{"version": "2.2.0"}
+	fa.mutex.Unlock()
+
+	// WHEN
+	cr, err := admin.GetConsentRequest(context.Background(), "foo")
+
+	// THEN
+	assert.EqualError(t, failedErr, `unsupported version of hydra "master". Configure the version of the admin API explicitly`)
+	require.NoError(t, err)
+	assert.Equal(t, "1", cr.Subject)
+}
+
+func TestDetectingAdminSharesRunningDetection(t *testing.T) {
+	// GIVEN
+	fa := newFakeAdmin(t, map[string]interface{}{
+		"/version":                            map[string]string{"version": "2.2.0"},
+		"/admin/oauth2/auth/requests/consent": map[string]interface{}{"subject": "1"},
+	})
+	asked, release := make(chan struct{}), make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/version" {
+			close(asked)
+			<-release
+		}
+		fa.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+	u, err := url.Parse(slow.URL)
+	require.NoError(t, err)
+	admin := &detectingAdmin{v1: newV1Admin(u, http.DefaultClient, false), v2: newV2Admin(u, http.DefaultClient)}
+
+	var wg sync.WaitGroup
+	subjects := make([]string, 3)
+	for i := range subjects {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if cr, err := admin.GetConsentRequest(context.Background(), "foo"); err == nil {
+				subjects[i] = cr.Subject
+			}
+		}(i)
+	}
+	<-asked
+
+	// WHEN
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, canceledErr := admin.GetConsentRequest(ctx, "foo")
+	close(release)
+	wg.Wait()
+
+	// THEN
+	assert.True(t, errors.Is(canceledErr, context.DeadlineExceeded), "Waiting for the detection must not block beyond the context")
+	assert.Equal(t, []string{"1", "1", "1"}, subjects)
+	assert.Equal(t, 1, strings.Count(strings.Join(fa.paths(), ","), "GET /version"))
+}
+
+func TestDetectingAdminChecksReadinessWithoutDetection(t *testing.T) {
+	// GIVEN
+	fa := newFakeAdmin(t, map[string]interface{}{"/health/ready": map[string]string{"status": "ok"}})
+	admin := &detectingAdmin{v1: newV1Admin(fa.url(t), http.DefaultClient, false), v2: newV2Admin(fa.url(t), http.DefaultClient)}
+
+	// WHEN
+	err := admin.Ready(context.Background())
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GET /health/ready"}, fa.paths())
+}
+
+func TestRevokeLoginSessionsOfSubject(t *testing.T) {
+	for version, path := range map[string]string{
+		"v1.10.6": "/oauth2/auth/sessions/login",
+		"v2.2.0":  "/admin/oauth2/auth/sessions/login",
+	} {
+		t.Run(version, func(t *testing.T) {
+			// GIVEN
+			fa := newFakeAdmin(t, map[string]interface{}{
+				"/version": map[string]string{"version": version},
+				path:       nil,
+			})
+			admin := &detectingAdmin{v1: newV1Admin(fa.url(t), http.DefaultClient, false), v2: newV2Admin(fa.url(t), http.DefaultClient)}
+
+			// WHEN
+			err := admin.RevokeLoginSessions(context.Background(), "1")
+
+			// THEN
+			require.NoError(t, err)
+			assert.Equal(t, []string{"GET /version", "DELETE " + path + "?subject=1"}, fa.paths())
+		})
+	}
+}
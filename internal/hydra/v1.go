@@ -0,0 +1,265 @@
+package hydra
+
+import (
+	"context"
+	"fmt"
+	httptransport "github.com/go-openapi/runtime/client"
+	"github.com/ory/hydra-client-go/client"
+	"github.com/ory/hydra-client-go/client/admin"
+	"github.com/ory/hydra-client-go/client/public"
+	"github.com/ory/hydra-client-go/models"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// v1Admin uses the generated client of the hydra v1.x admin API
+type v1Admin struct {
+	transport *httptransport.Runtime
+}
+
+func newV1Admin(url *url.URL, httpClient *http.Client, debug bool) *v1Admin {
+	transport := httptransport.NewWithClient(url.Host, url.Path, []string{url.Scheme}, httpClient)
+	transport.SetDebug(debug)
+	return &v1Admin{transport: transport}
+}
+
+func (a *v1Admin) client(ctx context.Context) *client.OryHydra {
+	// the transport is shared, so the logger of the latest call wins
+	a.transport.SetLogger(zeroLogLogger{log.Ctx(ctx)})
+	return client.New(a.transport, nil)
+}
+
+func (a *v1Admin) GetLoginRequest(ctx context.Context, challenge string) (*LoginRequest, error) {
+	response, err := a.client(ctx).Admin.GetLoginRequest(admin.NewGetLoginRequestParams().WithContext(ctx).
+		WithLoginChallenge(challenge))
+	if err != nil {
+		return nil, err
+	}
+
+	lr := response.Payload
+	return &LoginRequest{
+		Challenge:                    lr.Challenge,
+		Client:                       fromV1Client(lr.Client),
+		OidcContext:                  fromV1OidcContext(lr.OidcContext),
+		RequestURL:                   lr.RequestURL,
+		RequestedAccessTokenAudience: lr.RequestedAccessTokenAudience,
+		RequestedScope:               lr.RequestedScope,
+		SessionID:                    lr.SessionID,
+		Skip:                         lr.Skip,
+		Subject:                      lr.Subject,
+	}, nil
+}
+
+func (a *v1Admin) AcceptLoginRequest(ctx context.Context, challenge string, accept *AcceptLogin) (string, error) {
+	response, err := a.client(ctx).Admin.AcceptLoginRequest(admin.NewAcceptLoginRequestParams().WithContext(ctx).
+		WithLoginChallenge(challenge).
+		WithBody(&models.AcceptLoginRequest{
+			Acr:         accept.Acr,
+			Context:     accept.Context,
+			Remember:    accept.Remember,
+			RememberFor: accept.RememberFor,
+			Subject:     &accept.Subject,
+		}))
+	if err != nil {
+		return "", err
+	}
+	return response.Payload.RedirectTo, nil
+}
+
+func (a *v1Admin) GetConsentRequest(ctx context.Context, challenge string) (*ConsentRequest, error) {
+	response, err := a.client(ctx).Admin.GetConsentRequest(admin.NewGetConsentRequestParams().WithContext(ctx).
+		WithConsentChallenge(challenge))
+	if err != nil {
+		return nil, err
+	}
+	return fromV1ConsentRequest(response.Payload), nil
+}
+
+func (a *v1Admin) AcceptConsentRequest(ctx context.Context, challenge string, accept *AcceptConsent) (string, error) {
+	body := &models.AcceptConsentRequest{
+		GrantAccessTokenAudience: accept.GrantAccessTokenAudience,
+		GrantScope:               accept.GrantScope,
+		HandledAt:                models.NullTime(accept.HandledAt),
+		Remember:                 accept.Remember,
+		RememberFor:              accept.RememberFor,
+	}
+	if accept.Session != nil {
+		body.Session = &models.ConsentRequestSession{
+			AccessToken: accept.Session.AccessToken,
+			IDToken:     accept.Session.IDToken,
+		}
+	}
+
+	response, err := a.client(ctx).Admin.AcceptConsentRequest(admin.NewAcceptConsentRequestParams().WithContext(ctx).
+		WithConsentChallenge(challenge).
+		WithBody(body))
+	if err != nil {
+		return "", err
+	}
+	return response.Payload.RedirectTo, nil
+}
+
+func (a *v1Admin) RejectConsentRequest(ctx context.Context, challenge string, reject *Rejection) (string, error) {
+	response, err := a.client(ctx).Admin.RejectConsentRequest(admin.NewRejectConsentRequestParams().WithContext(ctx).
+		WithConsentChallenge(challenge).
+		WithBody(&models.RejectRequest{
+			Error:            reject.Error,
+			ErrorDescription: reject.ErrorDescription,
+			ErrorHint:        reject.ErrorHint,
+			StatusCode:       reject.StatusCode,
+		}))
+	if err != nil {
+		return "", err
+	}
+	return response.Payload.RedirectTo, nil
+}
+
+func (a *v1Admin) ListConsentSessions(ctx context.Context, subject string) ([]*ConsentSession, error) {
+	response, err := a.client(ctx).Admin.ListSubjectConsentSessions(admin.NewListSubjectConsentSessionsParams().WithContext(ctx).
+		WithSubject(subject))
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*ConsentSession, 0, len(response.Payload))
+	for _, session := range response.Payload {
+		sessions = append(sessions, &ConsentSession{
+			ConsentRequest:           fromV1ConsentRequest(session.ConsentRequest),
+			GrantAccessTokenAudience: session.GrantAccessTokenAudience,
+			GrantScope:               session.GrantScope,
+			HandledAt:                time.Time(session.HandledAt),
+		})
+	}
+	return sessions, nil
+}
+
+func (a *v1Admin) GetLogoutRequest(ctx context.Context, challenge string) (*LogoutRequest, error) {
+	response, err := a.client(ctx).Admin.GetLogoutRequest(admin.NewGetLogoutRequestParams().WithContext(ctx).
+		WithLogoutChallenge(challenge))
+	if err != nil {
+		return nil, err
+	}
+
+	lr := response.Payload
+	return &LogoutRequest{
+		Challenge:   challenge,
+		RequestURL:  lr.RequestURL,
+		RpInitiated: lr.RpInitiated,
+		Sid:         lr.Sid,
+		Subject:     lr.Subject,
+	}, nil
+}
+
+func (a *v1Admin) AcceptLogoutRequest(ctx context.Context, challenge string) (string, error) {
+	response, err := a.client(ctx).Admin.AcceptLogoutRequest(admin.NewAcceptLogoutRequestParams().WithContext(ctx).
+		WithLogoutChallenge(challenge))
+	if err != nil {
+		return "", err
+	}
+	return response.Payload.RedirectTo, nil
+}
+
+func (a *v1Admin) RejectLogoutRequest(ctx context.Context, challenge string) error {
+	_, err := a.client(ctx).Admin.RejectLogoutRequest(admin.NewRejectLogoutRequestParams().WithContext(ctx).
+		WithLogoutChallenge(challenge))
+	return err
+}
+
+func (a *v1Admin) GetClient(ctx context.Context, id string) (*Client, error) {
+	response, err := a.client(ctx).Admin.GetOAuth2Client(admin.NewGetOAuth2ClientParams().WithContext(ctx).WithID(id))
+	if err != nil {
+		return nil, err
+	}
+	return fromV1Client(response.Payload), nil
+}
+
+// AcceptUserCodeRequest is not supported, hydra v1 does not implement the device authorization grant
+func (a *v1Admin) AcceptUserCodeRequest(context.Context, string, string) (string, error) {
+	return "", ErrUnsupported
+}
+
+func (a *v1Admin) RevokeLoginSessions(ctx context.Context, subject string) error {
+	_, err := a.client(ctx).Admin.RevokeAuthenticationSession(admin.NewRevokeAuthenticationSessionParams().WithContext(ctx).
+		WithSubject(subject))
+	return err
+}
+
+func (a *v1Admin) Ready(ctx context.Context) error {
+	_, err := a.client(ctx).Public.IsInstanceReady(public.NewIsInstanceReadyParams().WithContext(ctx))
+	return err
+}
+
+func fromV1ConsentRequest(cr *models.ConsentRequest) *ConsentRequest {
+	if cr == nil {
+		return nil
+	}
+	return &ConsentRequest{
+		Acr:                          cr.Acr,
+		Challenge:                    cr.Challenge,
+		Client:                       fromV1Client(cr.Client),
+		Context:                      cr.Context,
+		LoginChallenge:               cr.LoginChallenge,
+		LoginSessionID:               cr.LoginSessionID,
+		OidcContext:                  fromV1OidcContext(cr.OidcContext),
+		RequestURL:                   cr.RequestURL,
+		RequestedAccessTokenAudience: cr.RequestedAccessTokenAudience,
+		RequestedScope:               cr.RequestedScope,
+		Skip:                         cr.Skip,
+		Subject:                      cr.Subject,
+	}
+}
+
+func fromV1Client(client *models.OAuth2Client) *Client {
+	if client == nil {
+		return nil
+	}
+	return &Client{
+		ClientID:                          client.ClientID,
+		ClientName:                        client.ClientName,
+		ClientURI:                         client.ClientURI,
+		FrontchannelLogoutSessionRequired: client.FrontchannelLogoutSessionRequired,
+		FrontchannelLogoutURI:             client.FrontchannelLogoutURI,
+		LogoURI:                           client.LogoURI,
+		Metadata:                          client.Metadata,
+		PolicyURI:                         client.PolicyURI,
+		PostLogoutRedirectUris:            client.PostLogoutRedirectUris,
+		TosURI:                            client.TosURI,
+	}
+}
+
+func fromV1OidcContext(oc *models.OpenIDConnectContext) *OidcContext {
+	if oc == nil {
+		return nil
+	}
+	return &OidcContext{
+		AcrValues:         oc.AcrValues,
+		Display:           oc.Display,
+		IDTokenHintClaims: oc.IDTokenHintClaims,
+		LoginHint:         oc.LoginHint,
+		UILocales:         oc.UILocales,
+	}
+}
+
+// zeroLogLogger passes the debug output of the generated client to the logger of the request
+type zeroLogLogger struct {
+	logger *zerolog.Logger
+}
+
+func (l zeroLogLogger) Printf(format string, args ...interface{}) {
+	if len(format) == 0 || format[len(format)-1] != '\n' {
+		format += "\n"
+	}
+
+	l.logger.Info().Msg(fmt.Sprintf(format, args))
+}
+
+func (l zeroLogLogger) Debugf(format string, args ...interface{}) {
+	if len(format) == 0 || format[len(format)-1] != '\n' {
+		format += "\n"
+	}
+
+	l.logger.Debug().Msg(fmt.Sprintf(format, args))
+}
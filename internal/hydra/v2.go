@@ -0,0 +1,200 @@
+package hydra
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Error is returned if hydra v2 rejected a call
+type Error struct {
+	StatusCode  int
+	Name        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Name) == 0 {
+		return fmt.Sprintf("hydra responded with status code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("hydra responded with status code %d: %s: %s", e.StatusCode, e.Name, e.Description)
+}
+
+// v2Admin calls the hydra v2.x admin API, which serves the OAuth2 flows under /admin/oauth2 and
+// the clients under /admin/clients
+type v2Admin struct {
+	baseUrl    *url.URL
+	httpClient *http.Client
+}
+
+type completedRequest struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+func newV2Admin(baseUrl *url.URL, httpClient *http.Client) *v2Admin {
+	return &v2Admin{baseUrl: baseUrl, httpClient: httpClient}
+}
+
+func (a *v2Admin) GetLoginRequest(ctx context.Context, challenge string) (*LoginRequest, error) {
+	var lr LoginRequest
+	err := a.call(ctx, http.MethodGet, "/admin/oauth2/auth/requests/login", url.Values{"login_challenge": {challenge}}, nil, &lr)
+	if err != nil {
+		return nil, err
+	}
+	return &lr, nil
+}
+
+func (a *v2Admin) AcceptLoginRequest(ctx context.Context, challenge string, accept *AcceptLogin) (string, error) {
+	var completed completedRequest
+	err := a.call(ctx, http.MethodPut, "/admin/oauth2/auth/requests/login/accept", url.Values{"login_challenge": {challenge}}, accept, &completed)
+	return completed.RedirectTo, err
+}
+
+func (a *v2Admin) GetConsentRequest(ctx context.Context, challenge string) (*ConsentRequest, error) {
+	var cr ConsentRequest
+	err := a.call(ctx, http.MethodGet, "/admin/oauth2/auth/requests/consent", url.Values{"consent_challenge": {challenge}}, nil, &cr)
+	if err != nil {
+		return nil, err
+	}
+	return &cr, nil
+}
+
+func (a *v2Admin) AcceptConsentRequest(ctx context.Context, challenge string, accept *AcceptConsent) (string, error) {
+	var completed completedRequest
+	err := a.call(ctx, http.MethodPut, "/admin/oauth2/auth/requests/consent/accept", url.Values{"consent_challenge": {challenge}}, accept, &completed)
+	return completed.RedirectTo, err
+}
+
+func (a *v2Admin) RejectConsentRequest(ctx context.Context, challenge string, reject *Rejection) (string, error) {
+	var completed completedRequest
+	err := a.call(ctx, http.MethodPut, "/admin/oauth2/auth/requests/consent/reject", url.Values{"consent_challenge": {challenge}}, reject, &completed)
+	return completed.RedirectTo, err
+}
+
+func (a *v2Admin) ListConsentSessions(ctx context.Context, subject string) ([]*ConsentSession, error) {
+	var sessions []*ConsentSession
+	err := a.call(ctx, http.MethodGet, "/admin/oauth2/auth/sessions/consent", url.Values{"subject": {subject}}, nil, &sessions)
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (a *v2Admin) GetLogoutRequest(ctx context.Context, challenge string) (*LogoutRequest, error) {
+	var lr LogoutRequest
+	err := a.call(ctx, http.MethodGet, "/admin/oauth2/auth/requests/logout", url.Values{"logout_challenge": {challenge}}, nil, &lr)
+	if err != nil {
+		return nil, err
+	}
+	lr.Challenge = challenge
+	return &lr, nil
+}
+
+func (a *v2Admin) AcceptLogoutRequest(ctx context.Context, challenge string) (string, error) {
+	var completed completedRequest
+	err := a.call(ctx, http.MethodPut, "/admin/oauth2/auth/requests/logout/accept", url.Values{"logout_challenge": {challenge}}, nil, &completed)
+	return completed.RedirectTo, err
+}
+
+func (a *v2Admin) RejectLogoutRequest(ctx context.Context, challenge string) error {
+	return a.call(ctx, http.MethodPut, "/admin/oauth2/auth/requests/logout/reject", url.Values{"logout_challenge": {challenge}}, nil, nil)
+}
+
+func (a *v2Admin) GetClient(ctx context.Context, id string) (*Client, error) {
+	var client Client
+	err := a.call(ctx, http.MethodGet, "/admin/clients/"+url.PathEscape(id), nil, nil, &client)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (a *v2Admin) AcceptUserCodeRequest(ctx context.Context, challenge, userCode string) (string, error) {
+	var completed completedRequest
+	body := map[string]string{"user_code": userCode}
+	err := a.call(ctx, http.MethodPut, "/admin/oauth2/auth/requests/device/accept", url.Values{"device_challenge": {challenge}}, body, &completed)
+	return completed.RedirectTo, err
+}
+
+func (a *v2Admin) RevokeLoginSessions(ctx context.Context, subject string) error {
+	return a.call(ctx, http.MethodDelete, "/admin/oauth2/auth/sessions/login", url.Values{"subject": {subject}}, nil, nil)
+}
+
+// Ready asks the health end point, which is not prefixed with /admin
+func (a *v2Admin) Ready(ctx context.Context) error {
+	return a.call(ctx, http.MethodGet, "/health/ready", nil, nil, nil)
+}
+
+// version returns the version reported by hydra. The end point exists in hydra v1.x as well.
+func (a *v2Admin) version(ctx context.Context) (string, error) {
+	var version struct {
+		Version string `json:"version"`
+	}
+	err := a.call(ctx, http.MethodGet, "/version", nil, nil, &version)
+	return version.Version, err
+}
+
+// call sends the body encoded as JSON and decodes the response into result. Responses without
+// content are accepted as well.
+func (a *v2Admin) call(ctx context.Context, method, path string, query url.Values, body, result interface{}) error {
+	target := *a.baseUrl
+	target.Path = strings.TrimSuffix(target.Path, "/") + path
+	target.RawPath = ""
+	target.RawQuery = query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	log.Ctx(ctx).Debug().
+		Str("_method", method).
+		Str("_path", target.Path).
+		Int("_status", resp.StatusCode).
+		Msg("Called hydra admin API")
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		hydraErr := &Error{StatusCode: resp.StatusCode}
+		_ = json.Unmarshal(data, hydraErr)
+		return hydraErr
+	}
+
+	if result == nil || resp.StatusCode == http.StatusNoContent || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("failed to parse response of hydra: %w", err)
+	}
+	return nil
+}
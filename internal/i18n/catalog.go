@@ -0,0 +1,335 @@
+package i18n
+
+// catalogs holds the messages used by the templates per locale. The "language" key holds the
+// name of the language in that language and is used by the language switcher. The "scope.*"
+// keys hold the default descriptions of well known OIDC scopes.
+var catalogs = map[string]map[string]string{
+	"en": {
+		"language": "English",
+
+		"title.login":           "Login",
+		"title.consent":         "Consent",
+		"title.logout":          "Logout",
+		"title.unavailable":     "Temporarily unavailable",
+		"title.device":          "Connect a device",
+		"title.register":        "Sign up",
+		"title.password_reset":  "Reset password",
+		"title.email_login":     "Sign in by email",
+		"title.sms":             "Enter code",
+		"title.password_change": "Change password",
+
+		"footer.powered_by": "Powered by",
+
+		"login.heading":             "Please sign in",
+		"login.email":               "Email address",
+		"login.password":            "Password",
+		"login.remember":            "Remember me",
+		"login.submit":              "Sign in",
+		"login.new_here":            "New here?",
+		"login.register":            "Sign up",
+		"login.forgot_password":     "Forgot password?",
+		"login.email_login":         "Sign in with a code sent by email instead",
+		"error.invalid_credentials": "Invalid user name or password",
+		"error.login_failed":        "Login failed",
+
+		"consent.heading":           "Authorize %s",
+		"consent.subheading":        "%s is requesting the following information",
+		"consent.review":            "Review Permissions",
+		"consent.audiences":         "Services allowed to act on your behalf",
+		"consent.terms_intro":       "Accepting these permissions means that you allow this app to use your data as specified in their",
+		"consent.terms_of_service":  "terms of service",
+		"consent.and":               "and",
+		"consent.privacy_statement": "privacy statement",
+		"consent.logged_in_as":      "You're logged in as:",
+		"consent.remember":          "Remember decision",
+		"consent.allow":             "Allow",
+		"consent.deny":              "Deny",
+
+		"logout.heading":      "Do you wish to log out?",
+		"logout.yes":          "Yes",
+		"logout.no":           "No",
+		"logout.signed_in_as": "You are signed in as %s.",
+		"logout.requested_by": "%s asks to log you out.",
+		"logout.done":         "You have been logged out.",
+		"logout.cancelled":    "You are still logged in.",
+		"logout.close_window": "You can close this window now.",
+		"logout.continue":     "Continue",
+
+		"unavailable.heading": "The service is temporarily unavailable",
+		"unavailable.retry":   "Please try again in a moment.",
+
+		"device.heading":          "Connect a device",
+		"device.instructions":     "Enter the code shown on your device.",
+		"device.user_code":        "Code",
+		"device.submit":           "Continue",
+		"device.done":             "Your device has been connected.",
+		"device.return_to_device": "You can return to your device now.",
+
+		"accounts.heading":   "Choose an account",
+		"accounts.signed_in": "Signed in",
+		"accounts.continue":  "Continue",
+		"accounts.another":   "Use another account",
+		"accounts.forget":    "Forget",
+
+		"error.invalid_user_code": "The code is invalid or expired",
+		"error.too_many_attempts": "Too many attempts. Please try again later",
+		"error.device_failed":     "The device could not be connected",
+
+		"register.heading":               "Create an account",
+		"register.email":                 "Email address",
+		"register.password":              "Password",
+		"register.password_confirmation": "Repeat password",
+		"register.password_hint":         "At least %d characters",
+		"register.submit":                "Sign up",
+		"register.have_account":          "Already have an account?",
+		"register.sign_in":               "Sign in",
+		"register.field.first_name":      "First name",
+		"register.field.last_name":       "Last name",
+		"register.field.user_name":       "User name",
+		"register.field.phone":           "Phone number",
+		"register.sent":                  "Please check your email",
+		"register.open_link":             "We sent you a link to confirm your email address. Open it to complete the registration.",
+		"register.verified":              "Your email address has been confirmed",
+		"register.sign_in_now":           "You can sign in now.",
+
+		"mail.verify.subject": "Confirm your email address",
+		"mail.verify.body":    "Hello,\n\nplease confirm your email address by opening the following link:\n\n%s\n\nIf you did not sign up, you can ignore this email.\n",
+
+		"password_forgot.heading":   "Forgot your password?",
+		"password_forgot.hint":      "Enter your email address and we will send you a link to choose a new password.",
+		"password_forgot.email":     "Email address",
+		"password_forgot.submit":    "Send link",
+		"password_forgot.back":      "Back to sign in",
+		"password_forgot.sent":      "Please check your email",
+		"password_forgot.open_link": "If an account exists for this address, we sent you a link to choose a new password.",
+
+		"password_reset.heading":               "Choose a new password",
+		"password_reset.password":              "New password",
+		"password_reset.password_confirmation": "Repeat new password",
+		"password_reset.submit":                "Change password",
+		"password_reset.done":                  "Your password has been changed",
+		"password_reset.sign_in_now":           "You can sign in with your new password now.",
+		"password_change.heading":              "Please choose a new password",
+		"password_change.hint":                 "Your password does not meet our password requirements anymore.",
+		"password_change.skip":                 "Not now",
+		"notice.password_changed":              "Your password has been changed. Please sign in with your new password.",
+
+		"mail.reset.subject": "Reset your password",
+		"mail.reset.body":    "Hello,\n\nsomebody asked to reset the password of your account. Open the following link to choose a new password:\n\n%s\n\nIf you did not ask for it, you can ignore this email. Your password stays unchanged.\n",
+
+		"email_login.heading":    "Sign in by email",
+		"email_login.hint":       "Enter your email address and we will send you a link and a code to sign in.",
+		"email_login.email":      "Email address",
+		"email_login.submit":     "Send code",
+		"email_login.sent":       "Please check your email",
+		"email_login.enter_code": "If an account exists for this address, we sent you a link and a code. Open the link or enter the code.",
+		"email_login.code":       "Code",
+		"email_login.verify":     "Sign in",
+		"email_login.back":       "Sign in with password",
+
+		"mail.login.subject": "Your sign in code",
+		"mail.login.body":    "Hello,\n\nopen the following link to sign in:\n\n%s\n\nOr enter the code %s on the sign in page.\n\nIf you did not try to sign in, you can ignore this email.\n",
+
+		"sms.heading_second_factor": "Confirm it is you",
+		"sms.heading_verify":        "Verify your phone number",
+		"sms.sent":                  "We sent a code by SMS to %s.",
+		"sms.code":                  "Code",
+		"sms.submit":                "Continue",
+		"sms.resend":                "Send a new code",
+		"sms.resent":                "We sent you a new code.",
+		"sms.skip":                  "Not now",
+		"sms.message":               "%s is your code for signing in. Do not share it with anyone.",
+
+		"error.required":                 "Please fill in this field",
+		"error.invalid_email":            "Please enter a valid email address",
+		"error.password_mismatch":        "The passwords do not match",
+		"error.password_too_short":       "The password is too short",
+		"error.password_missing_lower":   "The password must contain a lower case letter",
+		"error.password_missing_upper":   "The password must contain an upper case letter",
+		"error.password_missing_digit":   "The password must contain a digit",
+		"error.password_missing_symbol":  "The password must contain a special character",
+		"error.password_denied_word":     "The password contains a word, which is not allowed",
+		"error.password_breached":        "The password appeared in a data breach and is not safe anymore",
+		"error.registration_failed":      "The registration failed. Please try again later",
+		"error.invalid_token":            "The link is invalid or expired",
+		"error.password_reset_failed":    "The password could not be changed. Please try again later",
+		"error.invalid_code":             "The code is invalid or expired",
+		"error.email_login_failed":       "The sign in failed. Please try again",
+		"error.sms_failed":               "The SMS could not be sent. Please try again later",
+		"error.password_change_required": "Please choose a new password",
+		"error.password_change_failed":   "The password could not be changed. Please try again later",
+		"error.email_not_verified":       "Please confirm your email address first",
+
+		"scope.openid":         "Your identity",
+		"scope.profile":        "Your basic profile information, like your name",
+		"scope.email":          "Your email address",
+		"scope.address":        "Your postal address",
+		"scope.phone":          "Your phone number",
+		"scope.offline_access": "Access to your data while you are not present",
+		"scope.offline":        "Access to your data while you are not present",
+	},
+	"de": {
+		"language": "Deutsch",
+
+		"title.login":           "Anmeldung",
+		"title.consent":         "Einwilligung",
+		"title.logout":          "Abmeldung",
+		"title.unavailable":     "Vorübergehend nicht verfügbar",
+		"title.device":          "Gerät verbinden",
+		"title.register":        "Registrierung",
+		"title.password_reset":  "Passwort zurücksetzen",
+		"title.email_login":     "Anmeldung per E-Mail",
+		"title.sms":             "Code eingeben",
+		"title.password_change": "Passwort ändern",
+
+		"footer.powered_by": "Betrieben mit",
+
+		"login.heading":             "Bitte melden Sie sich an",
+		"login.email":               "E-Mail-Adresse",
+		"login.password":            "Passwort",
+		"login.remember":            "Angemeldet bleiben",
+		"login.submit":              "Anmelden",
+		"login.new_here":            "Neu hier?",
+		"login.register":            "Registrieren",
+		"login.forgot_password":     "Passwort vergessen?",
+		"login.email_login":         "Stattdessen mit einem Code per E-Mail anmelden",
+		"error.invalid_credentials": "Ungültiger Benutzername oder ungültiges Passwort",
+		"error.login_failed":        "Anmeldung fehlgeschlagen",
+
+		"consent.heading":           "%s autorisieren",
+		"consent.subheading":        "%s fordert die folgenden Informationen an",
+		"consent.review":            "Berechtigungen prüfen",
+		"consent.audiences":         "Dienste, die in Ihrem Namen handeln dürfen",
+		"consent.terms_intro":       "Mit dem Akzeptieren dieser Berechtigungen erlauben Sie dieser Anwendung, Ihre Daten gemäß ihren",
+		"consent.terms_of_service":  "Nutzungsbedingungen",
+		"consent.and":               "und ihrer",
+		"consent.privacy_statement": "Datenschutzerklärung zu verwenden",
+		"consent.logged_in_as":      "Sie sind angemeldet als:",
+		"consent.remember":          "Entscheidung merken",
+		"consent.allow":             "Erlauben",
+		"consent.deny":              "Ablehnen",
+
+		"logout.heading":      "Möchten Sie sich abmelden?",
+		"logout.yes":          "Ja",
+		"logout.no":           "Nein",
+		"logout.signed_in_as": "Sie sind als %s angemeldet.",
+		"logout.requested_by": "%s möchte Sie abmelden.",
+		"logout.done":         "Sie wurden abgemeldet.",
+		"logout.cancelled":    "Sie sind weiterhin angemeldet.",
+		"logout.close_window": "Sie können dieses Fenster jetzt schließen.",
+		"logout.continue":     "Weiter",
+
+		"unavailable.heading": "Der Dienst ist vorübergehend nicht verfügbar",
+		"unavailable.retry":   "Bitte versuchen Sie es in einem Moment erneut.",
+
+		"device.heading":          "Gerät verbinden",
+		"device.instructions":     "Geben Sie den Code ein, der auf Ihrem Gerät angezeigt wird.",
+		"device.user_code":        "Code",
+		"device.submit":           "Weiter",
+		"device.done":             "Ihr Gerät wurde verbunden.",
+		"device.return_to_device": "Sie können jetzt zu Ihrem Gerät zurückkehren.",
+
+		"accounts.heading":   "Konto auswählen",
+		"accounts.signed_in": "Angemeldet",
+		"accounts.continue":  "Weiter",
+		"accounts.another":   "Anderes Konto verwenden",
+		"accounts.forget":    "Entfernen",
+
+		"error.invalid_user_code": "Der Code ist ungültig oder abgelaufen",
+		"error.too_many_attempts": "Zu viele Versuche. Bitte versuchen Sie es später erneut",
+		"error.device_failed":     "Das Gerät konnte nicht verbunden werden",
+
+		"register.heading":               "Konto erstellen",
+		"register.email":                 "E-Mail-Adresse",
+		"register.password":              "Passwort",
+		"register.password_confirmation": "Passwort wiederholen",
+		"register.password_hint":         "Mindestens %d Zeichen",
+		"register.submit":                "Registrieren",
+		"register.have_account":          "Sie haben bereits ein Konto?",
+		"register.sign_in":               "Anmelden",
+		"register.field.first_name":      "Vorname",
+		"register.field.last_name":       "Nachname",
+		"register.field.user_name":       "Benutzername",
+		"register.field.phone":           "Telefonnummer",
+		"register.sent":                  "Bitte prüfen Sie Ihre E-Mails",
+		"register.open_link":             "Wir haben Ihnen einen Link zur Bestätigung Ihrer E-Mail-Adresse geschickt. Öffnen Sie ihn, um die Registrierung abzuschließen.",
+		"register.verified":              "Ihre E-Mail-Adresse wurde bestätigt",
+		"register.sign_in_now":           "Sie können sich jetzt anmelden.",
+
+		"mail.verify.subject": "Bestätigen Sie Ihre E-Mail-Adresse",
+		"mail.verify.body":    "Hallo,\n\nbitte bestätigen Sie Ihre E-Mail-Adresse, indem Sie den folgenden Link öffnen:\n\n%s\n\nFalls Sie sich nicht registriert haben, können Sie diese E-Mail ignorieren.\n",
+
+		"password_forgot.heading":   "Passwort vergessen?",
+		"password_forgot.hint":      "Geben Sie Ihre E-Mail-Adresse ein. Wir schicken Ihnen einen Link, mit dem Sie ein neues Passwort wählen können.",
+		"password_forgot.email":     "E-Mail-Adresse",
+		"password_forgot.submit":    "Link senden",
+		"password_forgot.back":      "Zurück zur Anmeldung",
+		"password_forgot.sent":      "Bitte prüfen Sie Ihre E-Mails",
+		"password_forgot.open_link": "Falls ein Konto mit dieser Adresse existiert, haben wir Ihnen einen Link geschickt, mit dem Sie ein neues Passwort wählen können.",
+
+		"password_reset.heading":               "Neues Passwort wählen",
+		"password_reset.password":              "Neues Passwort",
+		"password_reset.password_confirmation": "Neues Passwort wiederholen",
+		"password_reset.submit":                "Passwort ändern",
+		"password_reset.done":                  "Ihr Passwort wurde geändert",
+		"password_reset.sign_in_now":           "Sie können sich jetzt mit Ihrem neuen Passwort anmelden.",
+		"password_change.heading":              "Bitte wählen Sie ein neues Passwort",
+		"password_change.hint":                 "Ihr Passwort erfüllt unsere Anforderungen an Passwörter nicht mehr.",
+		"password_change.skip":                 "Später",
+		"notice.password_changed":              "Ihr Passwort wurde geändert. Bitte melden Sie sich mit Ihrem neuen Passwort an.",
+
+		"mail.reset.subject": "Setzen Sie Ihr Passwort zurück",
+		"mail.reset.body":    "Hallo,\n\njemand hat angefordert, das Passwort Ihres Kontos zurückzusetzen. Öffnen Sie den folgenden Link, um ein neues Passwort zu wählen:\n\n%s\n\nFalls Sie das nicht angefordert haben, können Sie diese E-Mail ignorieren. Ihr Passwort bleibt unverändert.\n",
+
+		"email_login.heading":    "Anmeldung per E-Mail",
+		"email_login.hint":       "Geben Sie Ihre E-Mail-Adresse ein. Wir schicken Ihnen einen Link und einen Code zur Anmeldung.",
+		"email_login.email":      "E-Mail-Adresse",
+		"email_login.submit":     "Code senden",
+		"email_login.sent":       "Bitte prüfen Sie Ihre E-Mails",
+		"email_login.enter_code": "Falls ein Konto mit dieser Adresse existiert, haben wir Ihnen einen Link und einen Code geschickt. Öffnen Sie den Link oder geben Sie den Code ein.",
+		"email_login.code":       "Code",
+		"email_login.verify":     "Anmelden",
+		"email_login.back":       "Mit Passwort anmelden",
+
+		"mail.login.subject": "Ihr Anmeldecode",
+		"mail.login.body":    "Hallo,\n\nöffnen Sie den folgenden Link, um sich anzumelden:\n\n%s\n\nOder geben Sie den Code %s auf der Anmeldeseite ein.\n\nFalls Sie sich nicht anmelden wollten, können Sie diese E-Mail ignorieren.\n",
+
+		"sms.heading_second_factor": "Bestätigen Sie Ihre Identität",
+		"sms.heading_verify":        "Bestätigen Sie Ihre Telefonnummer",
+		"sms.sent":                  "Wir haben Ihnen einen Code per SMS an %s geschickt.",
+		"sms.code":                  "Code",
+		"sms.submit":                "Weiter",
+		"sms.resend":                "Neuen Code senden",
+		"sms.resent":                "Wir haben Ihnen einen neuen Code geschickt.",
+		"sms.skip":                  "Später",
+		"sms.message":               "%s ist Ihr Anmeldecode. Geben Sie ihn nicht weiter.",
+
+		"error.required":                 "Bitte füllen Sie dieses Feld aus",
+		"error.invalid_email":            "Bitte geben Sie eine gültige E-Mail-Adresse ein",
+		"error.password_mismatch":        "Die Passwörter stimmen nicht überein",
+		"error.password_too_short":       "Das Passwort ist zu kurz",
+		"error.password_missing_lower":   "Das Passwort muss einen Kleinbuchstaben enthalten",
+		"error.password_missing_upper":   "Das Passwort muss einen Großbuchstaben enthalten",
+		"error.password_missing_digit":   "Das Passwort muss eine Ziffer enthalten",
+		"error.password_missing_symbol":  "Das Passwort muss ein Sonderzeichen enthalten",
+		"error.password_denied_word":     "Das Passwort enthält ein nicht erlaubtes Wort",
+		"error.password_breached":        "Das Passwort ist bei einem Datenleck bekannt geworden und nicht mehr sicher",
+		"error.registration_failed":      "Die Registrierung ist fehlgeschlagen. Bitte versuchen Sie es später erneut",
+		"error.invalid_token":            "Der Link ist ungültig oder abgelaufen",
+		"error.password_reset_failed":    "Das Passwort konnte nicht geändert werden. Bitte versuchen Sie es später erneut",
+		"error.invalid_code":             "Der Code ist ungültig oder abgelaufen",
+		"error.email_login_failed":       "Die Anmeldung ist fehlgeschlagen. Bitte versuchen Sie es erneut",
+		"error.sms_failed":               "Die SMS konnte nicht gesendet werden. Bitte versuchen Sie es später erneut",
+		"error.password_change_required": "Bitte wählen Sie ein neues Passwort",
+		"error.password_change_failed":   "Das Passwort konnte nicht geändert werden. Bitte versuchen Sie es später erneut",
+		"error.email_not_verified":       "Bitte bestätigen Sie zuerst Ihre E-Mail-Adresse",
+
+		"scope.openid":         "Ihre Identität",
+		"scope.profile":        "Ihre grundlegenden Profilinformationen, wie Ihr Name",
+		"scope.email":          "Ihre E-Mail-Adresse",
+		"scope.address":        "Ihre Postanschrift",
+		"scope.phone":          "Ihre Telefonnummer",
+		"scope.offline_access": "Zugriff auf Ihre Daten, während Sie nicht anwesend sind",
+		"scope.offline":        "Zugriff auf Ihre Daten, während Sie nicht anwesend sind",
+	},
+}
@@ -0,0 +1,145 @@
+package i18n
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is used if none of the locales preferred by the user is supported
+const DefaultLocale = "en"
+
+// ContextKey is the key the Preferences of the user are stored with in the request context
+const ContextKey = "i18n.preferences"
+
+// Preferences holds the locales preferred by the user
+type Preferences struct {
+	// Selected is the locale explicitly selected by the user using the language switcher
+	Selected string
+	// Accepted are the locales from the Accept-Language header ordered by their quality
+	Accepted []string
+}
+
+// Locale negotiates the locale to use. The locale explicitly selected by the user takes precedence
+// over the ui_locales requested by the client, which take precedence over the locales accepted by
+// the browser.
+func (p *Preferences) Locale(uiLocales []string) string {
+	if locale, ok := Match(p.Selected); ok {
+		return locale
+	}
+	for _, candidates := range [][]string{uiLocales, p.Accepted} {
+		for _, candidate := range candidates {
+			if locale, ok := Match(candidate); ok {
+				return locale
+			}
+		}
+	}
+	return DefaultLocale
+}
+
+// SupportedLocales returns the locales a message catalog exists for
+func SupportedLocales() []string {
+	var locales []string
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Match returns the supported locale for the given language tag. Tags like "de-DE" and "de_AT"
+// match the "de" locale.
+func Match(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if len(tag) == 0 {
+		return "", false
+	}
+	if _, ok := catalogs[tag]; ok {
+		return tag, true
+	}
+
+	// tags consisting of separators only have no base language
+	base := strings.FieldsFunc(tag, func(r rune) bool { return r == '-' || r == '_' })
+	if len(base) == 0 {
+		return "", false
+	}
+	if _, ok := catalogs[base[0]]; ok {
+		return base[0], true
+	}
+	return "", false
+}
+
+// ParseAcceptLanguage returns the language tags from the given Accept-Language header value
+// ordered by their quality
+func ParseAcceptLanguage(header string) []string {
+	type weightedTag struct {
+		tag     string
+		quality float64
+	}
+
+	var tags []weightedTag
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.TrimSpace(fields[0])
+		if len(tag) == 0 || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			tags = append(tags, weightedTag{tag: tag, quality: quality})
+		}
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].quality > tags[j].quality })
+
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
+
+// Lookup returns the message for the given key in the given locale
+func Lookup(locale, key string) (string, bool) {
+	message, ok := catalogs[locale][key]
+	return message, ok
+}
+
+// Translate returns the message for the given key in the given locale formatted with the given
+// arguments. If there is no such message, the message of the default locale is used. If there is
+// no message at all, the key itself is returned.
+func Translate(locale, key string, args ...interface{}) string {
+	message, ok := Lookup(locale, key)
+	if !ok {
+		if message, ok = Lookup(DefaultLocale, key); !ok {
+			message = key
+		}
+	}
+
+	if len(args) != 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// FuncMap returns the functions to be used by templates. "t" translates a message key
+// into the locale passed as first argument.
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"t": func(locale interface{}, key string, args ...interface{}) string {
+			l, _ := locale.(string)
+			return Translate(l, key, args...)
+		},
+	}
+}
@@ -0,0 +1,56 @@
+package i18n
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseAcceptLanguageOrdersByQuality(t *testing.T) {
+	// WHEN
+	tags := ParseAcceptLanguage("fr;q=0.5, de-DE, en;q=0.8, *;q=0.1, es;q=0")
+
+	// THEN
+	assert.Equal(t, []string{"de-DE", "en", "fr"}, tags)
+}
+
+func TestLocaleNegotiation(t *testing.T) {
+	// GIVEN
+	prefs := &Preferences{Accepted: []string{"fr", "de-AT"}}
+
+	// WHEN & THEN
+	assert.Equal(t, "de", prefs.Locale(nil), "Accepted locales must be used if nothing else is given")
+	assert.Equal(t, "en", prefs.Locale([]string{"en-GB"}), "ui_locales must take precedence over accepted locales")
+
+	prefs.Selected = "de"
+	assert.Equal(t, "de", prefs.Locale([]string{"en-GB"}), "Selected locale must take precedence over ui_locales")
+
+	assert.Equal(t, DefaultLocale, (&Preferences{}).Locale([]string{"fr"}))
+}
+
+func TestMatchIgnoresTagsWithoutLanguage(t *testing.T) {
+	for _, tag := range []string{"-", "_", "--", " -_ "} {
+		// WHEN
+		locale, ok := Match(tag)
+
+		// THEN
+		assert.False(t, ok, "Tag %q must not match", tag)
+		assert.Empty(t, locale)
+	}
+	assert.Equal(t, DefaultLocale, (&Preferences{Selected: "-", Accepted: []string{"--"}}).Locale([]string{"_"}))
+}
+
+func TestTranslateFallsBackToDefaultLocaleAndKey(t *testing.T) {
+	// WHEN & THEN
+	assert.Equal(t, "Anmelden", Translate("de", "login.submit"))
+	assert.Equal(t, "Sign in", Translate("fr", "login.submit"))
+	assert.Equal(t, "unknown.key", Translate("de", "unknown.key"))
+	assert.Equal(t, "Authorize Foo", Translate("en", "consent.heading", "Foo"))
+}
+
+func TestAllCatalogsDefineTheSameMessages(t *testing.T) {
+	for locale, catalog := range catalogs {
+		for key := range catalogs[DefaultLocale] {
+			assert.Contains(t, catalog, key, "Message %s is missing in catalog %s", key, locale)
+		}
+	}
+}
@@ -19,7 +19,9 @@ func ConfigureLogging(conf config.Configuration) {
 	zerolog.MessageFieldName = "short_message"
 	zerolog.ErrorFieldName = "full_message"
 	zerolog.CallerFieldName = "_caller"
-	zerolog.SetGlobalLevel(conf.LogLevel())
+	// falls back to info for unsupported levels, which are reported by the config validation
+	level, _ := conf.LogLevel()
+	zerolog.SetGlobalLevel(level)
 
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -40,6 +42,21 @@ func ConfigureLogging(conf config.Configuration) {
 			}))
 }
 
+// Reconfigure applies the log level of the given configuration when the returned function is called
+func Reconfigure(conf config.Configuration) (func(), error) {
+	level, err := conf.LogLevel()
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if zerolog.GlobalLevel() != level {
+			log.Info().Str("_level", level.String()).Msg("Changing log level")
+		}
+		zerolog.SetGlobalLevel(level)
+	}, nil
+}
+
 func toSyslogLevel(level zerolog.Level) int {
 	switch level {
 	case zerolog.DebugLevel, zerolog.TraceLevel:
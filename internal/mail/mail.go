@@ -0,0 +1,88 @@
+// Package mail sends emails to users, like the links to verify their email addresses
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"login-provider/internal/config"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotConfigured is returned if no SMTP server is configured
+var ErrNotConfigured = errors.New("no SMTP server configured")
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender sends emails. Tests replace the SMTP implementation to capture the messages.
+type Sender interface {
+	Send(ctx context.Context, message *Message) error
+}
+
+// SmtpSender sends emails via the configured SMTP server. STARTTLS is used if the server supports it.
+type SmtpSender struct {
+	conf config.Configuration
+	now  func() time.Time
+}
+
+// NewSmtpSender creates a sender, which reads the settings of the SMTP server on each email. This
+// way it follows configuration changes.
+func NewSmtpSender(conf config.Configuration) *SmtpSender {
+	return &SmtpSender{conf: conf, now: time.Now}
+}
+
+func (s *SmtpSender) Send(_ context.Context, message *Message) error {
+	mailConf, err := s.conf.MailConfig()
+	if err != nil {
+		return err
+	}
+	if len(mailConf.Host) == 0 {
+		return ErrNotConfigured
+	}
+	// the recipient is entered by users and must not smuggle in additional headers
+	if strings.ContainsAny(message.To, "\r\n") {
+		return fmt.Errorf("invalid recipient %q", message.To)
+	}
+
+	var auth smtp.Auth
+	if len(mailConf.Username) != 0 {
+		auth = smtp.PlainAuth("", mailConf.Username, mailConf.Password, mailConf.Host)
+	}
+
+	addr := net.JoinHostPort(mailConf.Host, strconv.Itoa(mailConf.Port))
+	if err := smtp.SendMail(addr, auth, mailConf.From, []string{message.To}, compose(mailConf.From, message, s.now())); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// compose creates the RFC 5322 representation of the message. The subject is encoded to allow
+// non ASCII characters.
+func compose(from string, message *Message, date time.Time) []byte {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		buf.WriteString(name + ": " + value + "\r\n")
+	}
+
+	header("From", from)
+	header("To", message.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	buf.WriteString("\r\n")
+	buf.WriteString(message.Body)
+	return buf.Bytes()
+}
@@ -0,0 +1,69 @@
+package mail
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"login-provider/internal/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mailConfiguration struct {
+	config.Configuration
+	mail *config.MailConfig
+}
+
+func (c *mailConfiguration) MailConfig() (*config.MailConfig, error) {
+	return c.mail, nil
+}
+
+func TestComposeEncodesSubject(t *testing.T) {
+	// GIVEN
+	date := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// WHEN
+	data := string(compose("login@example.com", &Message{
+		To:      "foo@example.com",
+		Subject: "Bestätigen Sie Ihre E-Mail-Adresse",
+		Body:    "Hallo",
+	}, date))
+
+	// THEN
+	headers, body := split(t, data)
+	assert.Contains(t, headers, "From: login@example.com")
+	assert.Contains(t, headers, "To: foo@example.com")
+	assert.Contains(t, headers, "Subject: =?utf-8?q?Best=C3=A4tigen_Sie_Ihre_E-Mail-Adresse?=")
+	assert.Contains(t, headers, "Date: Fri, 01 May 2020 12:00:00 +0000")
+	assert.Equal(t, "Hallo", body)
+}
+
+func TestSendRequiresSmtpServer(t *testing.T) {
+	// GIVEN
+	sender := NewSmtpSender(&mailConfiguration{mail: &config.MailConfig{}})
+
+	// WHEN
+	err := sender.Send(context.Background(), &Message{To: "foo@example.com"})
+
+	// THEN
+	assert.Equal(t, ErrNotConfigured, err)
+}
+
+func TestSendRejectsHeaderInjection(t *testing.T) {
+	// GIVEN
+	sender := NewSmtpSender(&mailConfiguration{mail: &config.MailConfig{Host: "127.0.0.1", Port: 25}})
+
+	// WHEN
+	err := sender.Send(context.Background(), &Message{To: "foo@example.com\r\nBcc: bar@example.com"})
+
+	// THEN
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid recipient")
+}
+
+func split(t *testing.T, data string) ([]string, string) {
+	parts := strings.SplitN(data, "\r\n\r\n", 2)
+	require.Len(t, parts, 2)
+	return strings.Split(parts[0], "\r\n"), parts[1]
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"login-provider/internal/audit"
+)
+
+// AuditOrigin adds the client IP, the request id and the user agent of the request to the audit
+// events emitted while handling it. It must be used after RequestId and ClientIP.
+func AuditOrigin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := audit.WithOrigin(c.Request.Context(), &audit.Origin{
+			RemoteAddr: c.GetString(ClientIPKey),
+			RequestID:  c.Request.Header.Get(requestIdHeaderName),
+			UserAgent:  c.Request.UserAgent(),
+		})
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
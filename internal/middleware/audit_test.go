@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"login-provider/internal/audit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuditOriginIsAddedToContext(t *testing.T) {
+	// GIVEN
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/login", nil)
+	ctx.Request.Header.Set(requestIdHeaderName, "login-provider:foo")
+	ctx.Request.Header.Set("User-Agent", "Mozilla/5.0")
+	ctx.Set(ClientIPKey, "192.0.2.1")
+	middleware := AuditOrigin()
+
+	// WHEN
+	middleware(ctx)
+
+	// THEN
+	origin := audit.OriginFrom(ctx.Request.Context())
+	require.NotNil(t, origin, "Origin must be added to the request context")
+	assert.Equal(t, "192.0.2.1", origin.RemoteAddr)
+	assert.Equal(t, "login-provider:foo", origin.RequestID)
+	assert.Equal(t, "Mozilla/5.0", origin.UserAgent)
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"login-provider/internal/config"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIPKey is the key of the address of the client in the gin context
+const ClientIPKey = "client_ip"
+
+// ClientIP determines the address of the client. The X-Forwarded-For header is only followed as far
+// as the request passed the configured trusted proxies, because anybody can send the header. Unlike
+// gin's ClientIP it must be used where the address matters, like for rate limits.
+func ClientIP(conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		trusted, err := conf.TrustedProxies()
+		if err != nil {
+			log.Ctx(c.Request.Context()).Err(err).Msg("Failed to read the trusted proxies")
+		}
+		c.Set(ClientIPKey, clientIP(c.Request, trusted))
+
+		c.Next()
+	}
+}
+
+// clientIP returns the first address from the right of the X-Forwarded-For header, which is not a
+// trusted proxy, if the request comes from a trusted proxy. The remote address is returned otherwise.
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	address, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		address = strings.TrimSpace(r.RemoteAddr)
+	}
+	if !isTrusted(address, trusted) {
+		return address
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// a malformed entry is not trustworthy, nor is anything left of it
+			break
+		}
+		address = hop
+		if !isTrusted(hop, trusted) {
+			break
+		}
+	}
+	return address
+}
+
+func isTrusted(address string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPFollowsOnlyTrustedProxies(t *testing.T) {
+	// GIVEN
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{proxies}
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		expectedIP   string
+	}{
+		{"no proxy", "192.0.2.1:4711", "", "192.0.2.1"},
+		{"untrusted sender", "192.0.2.1:4711", "198.51.100.7", "192.0.2.1"},
+		{"trusted proxy", "10.0.0.1:4711", "198.51.100.7", "198.51.100.7"},
+		{"spoofed entry", "10.0.0.1:4711", "203.0.113.9, 198.51.100.7", "198.51.100.7"},
+		{"chain of proxies", "10.0.0.1:4711", "198.51.100.7, 10.1.1.1", "198.51.100.7"},
+		{"malformed entry", "10.0.0.1:4711", "foo, 10.1.1.1", "10.1.1.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/device", nil)
+			r.RemoteAddr = test.remoteAddr
+			if len(test.forwardedFor) != 0 {
+				r.Header.Set("X-Forwarded-For", test.forwardedFor)
+			}
+
+			// WHEN
+			ip := clientIP(r, trusted)
+
+			// THEN
+			assert.Equal(t, test.expectedIP, ip)
+		})
+	}
+}
+
+func TestClientIPIsAddedToContext(t *testing.T) {
+	// GIVEN
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/device", nil)
+	ctx.Request.RemoteAddr = "192.0.2.1:4711"
+	ctx.Request.Header.Set("X-Forwarded-For", "198.51.100.7")
+	middleware := ClientIP(&MockConfiguration{})
+
+	// WHEN
+	middleware(ctx)
+
+	// THEN
+	assert.Equal(t, "192.0.2.1", ctx.GetString(ClientIPKey))
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"login-provider/internal/i18n"
+)
+
+const (
+	localeQueryParam   = "lang"
+	localeCookieName   = "locale"
+	localeCookieMaxAge = 365 * 24 * 60 * 60
+)
+
+// Locale collects the locales preferred by the user. A locale selected via the "lang" query
+// parameter is remembered in a cookie.
+func Locale() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		prefs := &i18n.Preferences{
+			Accepted: i18n.ParseAcceptLanguage(c.Request.Header.Get("Accept-Language")),
+		}
+
+		if locale, ok := i18n.Match(c.Query(localeQueryParam)); ok {
+			prefs.Selected = locale
+			c.SetCookie(localeCookieName, locale, localeCookieMaxAge, "/", "", false, true)
+		} else if cookie, err := c.Cookie(localeCookieName); err == nil {
+			prefs.Selected = cookie
+		}
+
+		c.Set(i18n.ContextKey, prefs)
+
+		c.Next()
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"login-provider/internal/i18n"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestLocaleSelectedViaQueryParameterIsRemembered(t *testing.T) {
+	// GIVEN
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = &http.Request{Header: make(http.Header), URL: &url.URL{RawQuery: "lang=de-DE"}}
+	ctx.Request.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	middleware := Locale()
+
+	// WHEN
+	middleware(ctx)
+
+	// THEN
+	value, ok := ctx.Get(i18n.ContextKey)
+	require.True(t, ok, "Preferences must be set")
+	prefs := value.(*i18n.Preferences)
+	require.Equal(t, "de", prefs.Selected)
+	require.Equal(t, []string{"en-US", "en"}, prefs.Accepted)
+	require.Contains(t, w.Header().Get("Set-Cookie"), localeCookieName+"=de")
+}
+
+func TestLocaleIsTakenFromCookie(t *testing.T) {
+	// GIVEN
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = &http.Request{Header: make(http.Header), URL: &url.URL{}}
+	ctx.Request.AddCookie(&http.Cookie{Name: localeCookieName, Value: "de"})
+	middleware := Locale()
+
+	// WHEN
+	middleware(ctx)
+
+	// THEN
+	value, _ := ctx.Get(i18n.ContextKey)
+	require.Equal(t, "de", value.(*i18n.Preferences).Locale([]string{"en"}))
+}
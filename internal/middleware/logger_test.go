@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/mock"
 	"login-provider/internal/config"
 	"login-provider/internal/logging"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -33,30 +34,126 @@ func (c *MockConfiguration) Address() string {
 	return ":8080"
 }
 
+func (c *MockConfiguration) MetricsAddress() string {
+	return ""
+}
+
 func (c *MockConfiguration) TlsConfig() (*config.TlsConfig, error) {
 	return nil, nil
 }
 
-func (c *MockConfiguration) LogLevel() zerolog.Level  {
-	return zerolog.InfoLevel
+func (c *MockConfiguration) LogLevel() (zerolog.Level, error) {
+	return zerolog.InfoLevel, nil
 }
 
 func (c *MockConfiguration) TlsTrustStore() (string, error) {
 	return "", nil
 }
 
-func (c *MockConfiguration) RegisterUrl() string  {
-	return ""
+func (c *MockConfiguration) UpstreamTlsConfig(config.Upstream) *config.UpstreamTlsConfig {
+	return &config.UpstreamTlsConfig{}
+}
+
+func (c *MockConfiguration) UpstreamAuthConfig(config.Upstream) (*config.UpstreamAuthConfig, error) {
+	return &config.UpstreamAuthConfig{}, nil
+}
+
+func (c *MockConfiguration) ResilienceConfig(config.Upstream) *config.ResilienceConfig {
+	return &config.ResilienceConfig{}
+}
+
+func (c *MockConfiguration) RegisterUrl() (*url.URL, error) {
+	return nil, nil
 }
 
-func (c *MockConfiguration) HydraAdminUrl() string  {
+func (c *MockConfiguration) HydraAdminUrl() (*url.URL, error) {
+	return url.Parse("http://127.0.0.1:4445")
+}
+
+func (c *MockConfiguration) HydraApiVersion() (config.HydraVersion, error) {
+	return config.HydraAuto, nil
+}
+
+func (c *MockConfiguration) AuthenticateUrl() (*url.URL, error) {
+	return url.Parse("http://127.0.0.1:8090/authenticate")
+}
+
+func (c *MockConfiguration) ClaimsConfig() *config.ClaimsConfig {
+	return &config.ClaimsConfig{}
+}
+
+func (c *MockConfiguration) ConsentConfig() *config.ConsentConfig {
+	return &config.ConsentConfig{}
+}
+
+func (c *MockConfiguration) DeviceConfig() *config.DeviceConfig {
+	return &config.DeviceConfig{}
+}
+
+func (c *MockConfiguration) AccountsConfig() (*config.AccountsConfig, error) {
+	return &config.AccountsConfig{}, nil
+}
+
+func (c *MockConfiguration) PublicUrl() (*url.URL, error) {
+	return nil, nil
+}
+
+func (c *MockConfiguration) UsersConfig() *config.UsersConfig {
+	return &config.UsersConfig{}
+}
+
+func (c *MockConfiguration) RegistrationConfig() *config.RegistrationConfig {
+	return &config.RegistrationConfig{}
+}
+
+func (c *MockConfiguration) PasswordResetConfig() *config.PasswordResetConfig {
+	return &config.PasswordResetConfig{}
+}
+
+func (c *MockConfiguration) SmsConfig() *config.SmsConfig {
+	return &config.SmsConfig{}
+}
+
+func (c *MockConfiguration) TrustedProxies() ([]*net.IPNet, error) {
+	return nil, nil
+}
+
+func (c *MockConfiguration) AuditConfig() (*config.AuditConfig, error) {
+	return &config.AuditConfig{}, nil
+}
+
+func (c *MockConfiguration) PasswordlessConfig() *config.PasswordlessConfig {
+	return &config.PasswordlessConfig{}
+}
+
+func (c *MockConfiguration) PasswordPolicyConfig() *config.PasswordPolicyConfig {
+	return &config.PasswordPolicyConfig{}
+}
+
+func (c *MockConfiguration) MailConfig() (*config.MailConfig, error) {
+	return &config.MailConfig{}, nil
+}
+
+func (c *MockConfiguration) LogoutConfig() *config.LogoutConfig {
+	return &config.LogoutConfig{BackChannel: &config.BackChannelConfig{}}
+}
+
+func (c *MockConfiguration) TemplatesDirectory() string {
 	return ""
 }
 
-func (c *MockConfiguration) AuthenticateUrl() string  {
+func (c *MockConfiguration) StaticDirectory() string {
 	return ""
 }
 
+func (c *MockConfiguration) Themes() map[string]*config.Theme {
+	return map[string]*config.Theme{}
+}
+
+func (c *MockConfiguration) ClientThemes() map[string]string {
+	return map[string]string{}
+}
+
 func TestLoggerMiddlewareAddsRequiredMDC(t *testing.T) {
 	// GIVEN
 	logging.ConfigureLogging(&MockConfiguration{})
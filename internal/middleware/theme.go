@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"login-provider/internal/config"
+	"login-provider/internal/theme"
+)
+
+// Theme selects the default theme for the request. Handlers, which know the client a page is
+// rendered for, replace it with the theme selected for that client.
+func Theme(conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(theme.DataKey, theme.Select(conf.Themes(), conf.ClientThemes(), "", ""))
+
+		c.Next()
+	}
+}
@@ -0,0 +1,143 @@
+// Package password_policy checks passwords against the configured rules when they are set or used to log in
+package password_policy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"login-provider/internal/config"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The rules a password can break
+var (
+	ErrTooShort      = errors.New("password is too short")
+	ErrMissingLower  = errors.New("password contains no lower case letter")
+	ErrMissingUpper  = errors.New("password contains no upper case letter")
+	ErrMissingDigit  = errors.New("password contains no digit")
+	ErrMissingSymbol = errors.New("password contains no symbol")
+	ErrDeniedWord    = errors.New("password contains a denied word")
+	ErrBreached      = errors.New("password appeared in a data breach")
+)
+
+// Violated returns whether the error returned by Check is a rule the password breaks
+func Violated(err error) bool {
+	for _, rule := range []error{ErrTooShort, ErrMissingLower, ErrMissingUpper, ErrMissingDigit, ErrMissingSymbol,
+		ErrDeniedWord, ErrBreached} {
+		if errors.Is(err, rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixLength is the number of hex digits of the SHA-1 hash, which select the range file
+const prefixLength = 5
+
+// Policy checks passwords
+type Policy struct {
+	conf *config.PasswordPolicyConfig
+}
+
+func NewPolicy(conf *config.PasswordPolicyConfig) *Policy {
+	return &Policy{conf: conf}
+}
+
+// MinLength returns the minimum number of characters of a password
+func (p *Policy) MinLength() int {
+	return p.conf.MinLength
+}
+
+// Check returns the first rule the given password breaks, respectively nil if it follows all of them.
+// The length is counted in characters, not in bytes. Other errors are returned if the breached
+// passwords can't be read.
+func (p *Policy) Check(password string) error {
+	if utf8.RuneCountInString(password) < p.conf.MinLength {
+		return ErrTooShort
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	switch {
+	case p.conf.RequireLower && !lower:
+		return ErrMissingLower
+	case p.conf.RequireUpper && !upper:
+		return ErrMissingUpper
+	case p.conf.RequireDigit && !digit:
+		return ErrMissingDigit
+	case p.conf.RequireSymbol && !symbol:
+		return ErrMissingSymbol
+	}
+
+	lowerPassword := strings.ToLower(password)
+	for _, word := range p.conf.DeniedWords {
+		if word = strings.ToLower(strings.TrimSpace(word)); len(word) != 0 && strings.Contains(lowerPassword, word) {
+			return ErrDeniedWord
+		}
+	}
+
+	if len(p.conf.BreachedDirectory) != 0 {
+		breached, err := p.breached(password)
+		if err != nil {
+			return err
+		} else if breached {
+			return ErrBreached
+		}
+	}
+	return nil
+}
+
+// breached looks the SHA-1 hash of the password up in the range file of its first hex digits. The
+// files list the remaining digits of the hashes in upper case with the number of breaches, like
+// 0018A45C4D1DEF81644B54AB7F969B88D65:3. Entries without breaches pad the files and are ignored.
+func (p *Policy) breached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	f, err := os.Open(filepath.Join(p.conf.BreachedDirectory, hash[:prefixLength]+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		// the corpus does not contain the range
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read breached passwords: %w", err)
+	}
+	defer f.Close()
+
+	suffix := hash[prefixLength:]
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		if !strings.EqualFold(fields[0], suffix) {
+			continue
+		}
+		if len(fields) == 2 {
+			if count, err := strconv.Atoi(fields[1]); err == nil && count == 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read breached passwords: %w", err)
+	}
+	return false, nil
+}
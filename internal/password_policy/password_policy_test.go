@@ -0,0 +1,65 @@
+package password_policy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"login-provider/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckCountsCharacters(t *testing.T) {
+	// GIVEN
+	policy := NewPolicy(&config.PasswordPolicyConfig{MinLength: 4})
+
+	// WHEN & THEN
+	assert.Equal(t, ErrTooShort, policy.Check("abc"))
+	assert.NoError(t, policy.Check("äöüß"), "Multi byte characters must be counted once")
+}
+
+func TestCheckRequiresCharacterClasses(t *testing.T) {
+	// GIVEN
+	policy := NewPolicy(&config.PasswordPolicyConfig{
+		MinLength:     8,
+		RequireLower:  true,
+		RequireUpper:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+	})
+
+	// WHEN & THEN
+	assert.Equal(t, ErrMissingLower, policy.Check("ABCDEFG1!"))
+	assert.Equal(t, ErrMissingUpper, policy.Check("abcdefg1!"))
+	assert.Equal(t, ErrMissingDigit, policy.Check("abcdefG!!"))
+	assert.Equal(t, ErrMissingSymbol, policy.Check("abcdefG12"))
+	assert.NoError(t, policy.Check("abcdefG1!"))
+}
+
+func TestCheckDeniesWords(t *testing.T) {
+	// GIVEN
+	policy := NewPolicy(&config.PasswordPolicyConfig{MinLength: 4, DeniedWords: []string{"Example", " "}})
+
+	// WHEN & THEN
+	assert.Equal(t, ErrDeniedWord, policy.Check("myEXAMPLE1"))
+	assert.NoError(t, policy.Check("my password"), "Blank words must be ignored")
+}
+
+func TestCheckFindsBreachedPasswords(t *testing.T) {
+	// GIVEN
+	dir, err := ioutil.TempDir("", "breached")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// the SHA-1 hash of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8, of "letmein" B7A875FC1EA228B9061041B7CEC4BD3C52AB3CE3
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "5BAA6.txt"),
+		[]byte("003D68EB55068C33ACE09247EE4C639306B:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\r\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "B7A87.txt"),
+		[]byte("5FC1EA228B9061041B7CEC4BD3C52AB3CE3:0\r\n"), 0600))
+	policy := NewPolicy(&config.PasswordPolicyConfig{MinLength: 4, BreachedDirectory: dir})
+
+	// WHEN & THEN
+	assert.Equal(t, ErrBreached, policy.Check("password"))
+	assert.NoError(t, policy.Check("letmein"), "Padding entries must be ignored")
+	assert.NoError(t, policy.Check("correct horse battery staple"), "Missing ranges must be ignored")
+}
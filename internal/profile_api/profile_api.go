@@ -2,12 +2,17 @@ package profile_api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/mitchellh/mapstructure"
 	"io/ioutil"
+	"login-provider/internal/config"
+	"login-provider/internal/upstream"
 	"login-provider/internal/utils"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -29,6 +34,13 @@ type User struct {
 	Address     *Address   `json:"address" mapstructure:"address"`
 	Email       string     `json:"email" mapstructure:"email"`
 	PhoneNumber string     `json:"phone" mapstructure:"phone"`
+	// PhoneNumberVerified is set by the authentication service or once the user entered a code sent to the number
+	PhoneNumberVerified bool     `json:"phone_verified" mapstructure:"phone_verified"`
+	Groups              []string `json:"groups" mapstructure:"groups"`
+	Roles               []string `json:"roles" mapstructure:"roles"`
+	// Attributes holds arbitrary additional attributes of the user as provided by
+	// the authentication service. These are exposed as claims as is.
+	Attributes map[string]interface{} `json:"attributes" mapstructure:"attributes"`
 }
 
 type AuthenticationRequest struct {
@@ -41,8 +53,12 @@ type AuthenticationResponse struct {
 	User       User   `json:"user" mapstructure:"user"`
 }
 
-func (ar AuthenticationResponse) CreateIdTokenClaims(grantedScopes []string) map[string]string {
-	claims := make(map[string]string)
+// reservedClaims lists the claims, which are managed by hydra and must not be overridden by
+// user attributes
+var reservedClaims = []string{"iss", "sub", "aud", "exp", "iat", "nbf", "jti", "auth_time", "nonce", "acr", "amr", "azp", "sid"}
+
+func (ar AuthenticationResponse) CreateIdTokenClaims(grantedScopes []string, cc *config.ClaimsConfig) map[string]interface{} {
+	claims := make(map[string]interface{})
 
 	if utils.Contains(grantedScopes, "profile") {
 		claims["profile"] = ar.ProfileUrl
@@ -74,29 +90,99 @@ func (ar AuthenticationResponse) CreateIdTokenClaims(grantedScopes []string) map
 
 	if utils.Contains(grantedScopes, "phone") {
 		claims["phone_number"] = ar.User.PhoneNumber
-		claims["phone_number_verified"] = "false"
+		claims["phone_number_verified"] = strconv.FormatBool(ar.User.PhoneNumberVerified)
+	}
+
+	ar.addAuthorizationClaims(claims, grantedScopes, cc)
+
+	if utils.Contains(grantedScopes, cc.AttributesScope) {
+		// groups and roles are only exposed with their scope and filtered per client, so attributes
+		// of the same name must not sneak them into the token
+		authorizationClaims := []string{"groups", "roles", cc.GroupsScope, cc.RolesScope, cc.AttributesScope}
+		for name, value := range ar.User.Attributes {
+			if _, present := claims[name]; present || utils.Contains(reservedClaims, name) ||
+				utils.Contains(authorizationClaims, name) {
+				continue
+			}
+			claims[name] = value
+		}
 	}
 
 	return claims
 }
 
+// CreateAccessTokenClaims creates the claims to be put into the access token. These are the
+// claims resource servers base their authorization decisions on, like groups and roles.
+func (ar AuthenticationResponse) CreateAccessTokenClaims(grantedScopes []string, cc *config.ClaimsConfig) map[string]interface{} {
+	claims := make(map[string]interface{})
+	ar.addAuthorizationClaims(claims, grantedScopes, cc)
+	return claims
+}
+
+func (ar AuthenticationResponse) addAuthorizationClaims(claims map[string]interface{}, grantedScopes []string, cc *config.ClaimsConfig) {
+	if utils.Contains(grantedScopes, cc.GroupsScope) && ar.User.Groups != nil {
+		claims["groups"] = ar.User.Groups
+	}
+
+	if utils.Contains(grantedScopes, cc.RolesScope) && ar.User.Roles != nil {
+		claims["roles"] = ar.User.Roles
+	}
+}
+
 func (ar *AuthenticationResponse) Unmarshal(data interface{}) error {
 	return mapstructure.Decode(data, ar)
 }
 
+// Client calls the authentication service
+type Client struct {
+	mutex      sync.RWMutex
+	httpClient *http.Client
+}
+
+func NewClient(conf config.Configuration) (*Client, error) {
+	httpClient, err := newHttpClient(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{httpClient: httpClient}, nil
+}
+
+// Reconfigure creates the http client for the given configuration. It replaces the current one
+// when the returned function is called.
+func (c *Client) Reconfigure(conf config.Configuration) (func(), error) {
+	httpClient, err := newHttpClient(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.httpClient = httpClient
+	}, nil
+}
+
+func newHttpClient(conf config.Configuration) (*http.Client, error) {
+	return upstream.NewClient(conf, config.Authenticate)
+}
+
 // TODO: follow the pattern from https://blog.golang.org/context to pass inbound-outbound
 // specific values. This way we're able to have better logging as well as passing aound
 // required tracing information, like X-Request-Id
-func AuthenticateUser(url string, userName, password string) (*AuthenticationResponse, error) {
+func (c *Client) AuthenticateUser(ctx context.Context, url string, userName, password string) (*AuthenticationResponse, error) {
 	jsonValue, _ := json.Marshal(AuthenticationRequest{UserName: userName, Password: password})
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(jsonValue))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonValue))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	httpClient := &http.Client{}
+	c.mutex.RLock()
+	httpClient := c.httpClient
+	c.mutex.RUnlock()
+
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,79 @@
+package profile_api
+
+import (
+	"github.com/stretchr/testify/assert"
+	"login-provider/internal/config"
+	"testing"
+)
+
+var claimsConfig = &config.ClaimsConfig{
+	GroupsScope:     "groups",
+	RolesScope:      "roles",
+	AttributesScope: "attributes",
+}
+
+func TestGroupsAndRolesAreExposedOnlyIfScopesAreGranted(t *testing.T) {
+	// GIVEN
+	ar := AuthenticationResponse{User: User{
+		Groups: []string{"admins"},
+		Roles:  []string{"reader"},
+	}}
+
+	// WHEN
+	withoutScopes := ar.CreateIdTokenClaims([]string{"openid"}, claimsConfig)
+	withScopes := ar.CreateIdTokenClaims([]string{"openid", "groups", "roles"}, claimsConfig)
+	accessTokenClaims := ar.CreateAccessTokenClaims([]string{"openid", "groups"}, claimsConfig)
+
+	// THEN
+	assert.NotContains(t, withoutScopes, "groups")
+	assert.NotContains(t, withoutScopes, "roles")
+	assert.Equal(t, []string{"admins"}, withScopes["groups"])
+	assert.Equal(t, []string{"reader"}, withScopes["roles"])
+	assert.Equal(t, []string{"admins"}, accessTokenClaims["groups"])
+	assert.NotContains(t, accessTokenClaims, "roles")
+}
+
+func TestAttributesDoNotOverrideStandardOrReservedClaims(t *testing.T) {
+	// GIVEN
+	ar := AuthenticationResponse{User: User{
+		Email: "foo@example.com",
+		Attributes: map[string]interface{}{
+			"department": "R&D",
+			"email":      "bar@example.com",
+			"sub":        "someone else",
+		},
+	}}
+
+	// WHEN
+	claims := ar.CreateIdTokenClaims([]string{"email", "attributes"}, claimsConfig)
+
+	// THEN
+	assert.Equal(t, "R&D", claims["department"])
+	assert.Equal(t, "foo@example.com", claims["email"])
+	assert.NotContains(t, claims, "sub")
+}
+
+func TestAttributesDoNotExposeGroupsOrRolesWithoutTheirScope(t *testing.T) {
+	// GIVEN
+	cc := &config.ClaimsConfig{GroupsScope: "org_groups", RolesScope: "org_roles", AttributesScope: "attributes"}
+	ar := AuthenticationResponse{User: User{
+		Groups: []string{"admins"},
+		Attributes: map[string]interface{}{
+			"department": "R&D",
+			"groups":     []string{"admins"},
+			"roles":      []string{"owner"},
+			"org_groups": []string{"admins"},
+			"org_roles":  []string{"owner"},
+		},
+	}}
+
+	// WHEN
+	claims := ar.CreateIdTokenClaims([]string{"openid", "attributes"}, cc)
+
+	// THEN
+	assert.Equal(t, "R&D", claims["department"])
+	assert.NotContains(t, claims, "groups")
+	assert.NotContains(t, claims, "roles")
+	assert.NotContains(t, claims, "org_groups")
+	assert.NotContains(t, claims, "org_roles")
+}
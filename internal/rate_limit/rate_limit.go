@@ -0,0 +1,71 @@
+// Package rate_limit limits the number of attempts per key, e.g. per client IP, within a sliding
+// window. It is used to protect codes users have to enter against brute-force attacks.
+package rate_limit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepThreshold is the number of keys above which keys without recent attempts are removed. They
+// are removed at most once per window to keep attempts cheap with many active keys.
+const sweepThreshold = 1024
+
+// Limiter counts the attempts per key. The zero value is not usable, use NewLimiter.
+type Limiter struct {
+	now func() time.Time
+
+	mutex     sync.Mutex
+	attempts  map[string][]time.Time
+	lastSweep time.Time
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{now: time.Now, attempts: make(map[string][]time.Time)}
+}
+
+// Allow records an attempt for the given key. It returns false without recording the attempt if
+// max attempts have been recorded for the key within the window already. A max of 0 disables the limit.
+func (l *Limiter) Allow(key string, max int, window time.Duration) bool {
+	if max <= 0 {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	if len(l.attempts) > sweepThreshold && now.Sub(l.lastSweep) >= window {
+		l.lastSweep = now
+		for k, attempts := range l.attempts {
+			if len(recent(attempts, now, window)) == 0 {
+				delete(l.attempts, k)
+			}
+		}
+	}
+
+	attempts := recent(l.attempts[key], now, window)
+	if len(attempts) >= max {
+		l.attempts[key] = attempts
+		return false
+	}
+	l.attempts[key] = append(attempts, now)
+	return true
+}
+
+// Reset forgets the attempts recorded for the given key, e.g. after a successful one
+func (l *Limiter) Reset(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.attempts, key)
+}
+
+// recent returns the attempts within the window. The attempts are ordered by time.
+func recent(attempts []time.Time, now time.Time, window time.Duration) []time.Time {
+	for i, attempt := range attempts {
+		if now.Sub(attempt) < window {
+			return attempts[i:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package rate_limit
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLimiterRejectsAttemptsAboveMax(t *testing.T) {
+	// GIVEN
+	limiter := NewLimiter()
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	// WHEN
+	first := limiter.Allow("foo", 2, time.Minute)
+	second := limiter.Allow("foo", 2, time.Minute)
+	third := limiter.Allow("foo", 2, time.Minute)
+	other := limiter.Allow("bar", 2, time.Minute)
+	now = now.Add(time.Minute)
+	later := limiter.Allow("foo", 2, time.Minute)
+
+	// THEN
+	assert.True(t, first)
+	assert.True(t, second)
+	assert.False(t, third)
+	assert.True(t, other, "Keys must be limited independently")
+	assert.True(t, later, "Attempts outside the window must not count")
+}
+
+func TestLimiterForgetsAttemptsOnReset(t *testing.T) {
+	// GIVEN
+	limiter := NewLimiter()
+	limiter.Allow("foo", 1, time.Minute)
+
+	// WHEN
+	limiter.Reset("foo")
+
+	// THEN
+	assert.True(t, limiter.Allow("foo", 1, time.Minute))
+}
+
+func TestLimiterRemovesKeysWithoutRecentAttempts(t *testing.T) {
+	// GIVEN
+	limiter := NewLimiter()
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	for i := 0; i <= sweepThreshold; i++ {
+		limiter.Allow(string(rune('a'+i)), 1, time.Minute)
+	}
+	now = now.Add(time.Minute)
+
+	// WHEN
+	limiter.Allow("foo", 1, time.Minute)
+
+	// THEN
+	assert.Len(t, limiter.attempts, 1)
+}
+
+func TestLimiterRemovesKeysAtMostOncePerWindow(t *testing.T) {
+	// GIVEN
+	limiter := NewLimiter()
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	for i := 0; i < 2*sweepThreshold; i++ {
+		limiter.Allow(fmt.Sprintf("early-%d", i), 1, time.Minute)
+	}
+	now = now.Add(30 * time.Second)
+	for i := 0; i < 2*sweepThreshold; i++ {
+		limiter.Allow(fmt.Sprintf("late-%d", i), 1, time.Minute)
+	}
+	now = now.Add(30 * time.Second)
+	limiter.Allow("foo", 1, time.Minute)
+	swept := len(limiter.attempts)
+
+	// WHEN
+	now = now.Add(30 * time.Second)
+	limiter.Allow("bar", 1, time.Minute)
+	notSwept := len(limiter.attempts)
+	now = now.Add(30 * time.Second)
+	limiter.Allow("baz", 1, time.Minute)
+
+	// THEN
+	assert.Equal(t, 2*sweepThreshold+1, swept, "The early keys must be removed")
+	assert.Equal(t, 2*sweepThreshold+2, notSwept, "The late keys must be kept until a window passed since the last sweep")
+	assert.Len(t, limiter.attempts, 2)
+}
@@ -0,0 +1,178 @@
+// Package sms sends text messages to users, like the codes to verify their phone numbers
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"login-provider/internal/config"
+	"login-provider/internal/upstream"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotConfigured is returned if no SMS gateway is configured
+var ErrNotConfigured = errors.New("no SMS gateway configured")
+
+// ErrInvalidNumber is returned if a phone number can't be sent messages to
+var ErrInvalidNumber = errors.New("invalid phone number")
+
+// Message is a text message to a phone number
+type Message struct {
+	To   string `json:"to"`
+	Body string `json:"body"`
+}
+
+// Gateway sends text messages. Tests replace the configured implementation to capture the messages.
+type Gateway interface {
+	Send(ctx context.Context, message *Message) error
+}
+
+// ConfiguredGateway sends the messages via the gateway configured with sms.gateway. It reads the
+// settings on each message, so it follows configuration changes.
+type ConfiguredGateway struct {
+	conf       config.Configuration
+	mutex      sync.RWMutex
+	httpClient *http.Client
+	// fileMutex keeps the messages appended to the file from interleaving
+	fileMutex sync.Mutex
+	now       func() time.Time
+}
+
+func NewConfiguredGateway(conf config.Configuration) (*ConfiguredGateway, error) {
+	httpClient, err := upstream.NewClient(conf, config.SmsWebhook)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConfiguredGateway{conf: conf, httpClient: httpClient, now: time.Now}, nil
+}
+
+// Reconfigure creates the http client of the webhook for the given configuration. It replaces the
+// current one when the returned function is called.
+func (g *ConfiguredGateway) Reconfigure(conf config.Configuration) (func(), error) {
+	httpClient, err := upstream.NewClient(conf, config.SmsWebhook)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		g.httpClient = httpClient
+	}, nil
+}
+
+func (g *ConfiguredGateway) Send(ctx context.Context, message *Message) error {
+	to, err := NormalizeNumber(message.To)
+	if err != nil {
+		return err
+	}
+	message = &Message{To: to, Body: message.Body}
+
+	smsConf := g.conf.SmsConfig()
+	switch smsConf.Gateway {
+	case config.SmsGatewayWebhook:
+		return g.post(ctx, smsConf.WebhookUrl, message)
+	case config.SmsGatewayFile:
+		return g.append(smsConf.File, message)
+	default:
+		return ErrNotConfigured
+	}
+}
+
+// post sends the message as JSON to the webhook
+func (g *ConfiguredGateway) post(ctx context.Context, url string, message *Message) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	g.mutex.RLock()
+	httpClient := g.httpClient
+	g.mutex.RUnlock()
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call SMS webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("SMS webhook responded with status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// append writes the message as a line of JSON to the file
+func (g *ConfiguredGateway) append(file string, message *Message) error {
+	line, err := json.Marshal(struct {
+		Time time.Time `json:"time"`
+		*Message
+	}{Time: g.now(), Message: message})
+	if err != nil {
+		return err
+	}
+
+	g.fileMutex.Lock()
+	defer g.fileMutex.Unlock()
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// NormalizeNumber removes the separators people put into phone numbers, like spaces, dashes and
+// parentheses. The result consists of digits with an optional leading plus.
+func NormalizeNumber(number string) (string, error) {
+	number = strings.TrimSpace(number)
+
+	var normalized strings.Builder
+	for i, r := range number {
+		switch {
+		case r >= '0' && r <= '9':
+			normalized.WriteRune(r)
+		case r == '+' && i == 0:
+			normalized.WriteRune(r)
+		case strings.ContainsRune(" -./()", r):
+		default:
+			return "", ErrInvalidNumber
+		}
+	}
+
+	// E.164 allows up to 15 digits
+	digits := len(strings.TrimPrefix(normalized.String(), "+"))
+	if digits < 6 || digits > 15 {
+		return "", ErrInvalidNumber
+	}
+	return normalized.String(), nil
+}
+
+// MaskNumber hides all but the last digits of a phone number, so it can be shown to whoever
+// knows the password
+func MaskNumber(number string) string {
+	const visible = 3
+	if len(number) <= visible {
+		return number
+	}
+	return strings.Repeat("•", len(number)-visible) + number[len(number)-visible:]
+}
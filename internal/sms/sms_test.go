@@ -0,0 +1,89 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"login-provider/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type smsConfiguration struct {
+	config.Configuration
+	sms *config.SmsConfig
+}
+
+func (c *smsConfiguration) SmsConfig() *config.SmsConfig {
+	return c.sms
+}
+
+func TestWebhookReceivesMessage(t *testing.T) {
+	// GIVEN
+	var received Message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	gateway := &ConfiguredGateway{
+		conf:       &smsConfiguration{sms: &config.SmsConfig{Gateway: config.SmsGatewayWebhook, WebhookUrl: server.URL}},
+		httpClient: server.Client(),
+		now:        time.Now,
+	}
+
+	// WHEN
+	err := gateway.Send(context.Background(), &Message{To: "+49 (170) 123-4567", Body: "Your code is 123456"})
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, Message{To: "+491701234567", Body: "Your code is 123456"}, received)
+}
+
+func TestFileGatewayAppendsMessages(t *testing.T) {
+	// GIVEN
+	dir, err := ioutil.TempDir("", "sms")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "sms.log")
+	gateway := &ConfiguredGateway{
+		conf: &smsConfiguration{sms: &config.SmsConfig{Gateway: config.SmsGatewayFile, File: file}},
+		now:  func() time.Time { return time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC) },
+	}
+
+	// WHEN
+	require.NoError(t, gateway.Send(context.Background(), &Message{To: "+491701234567", Body: "first"}))
+	require.NoError(t, gateway.Send(context.Background(), &Message{To: "+491701234567", Body: "second"}))
+
+	// THEN
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{"time":"2020-05-01T12:00:00Z","to":"+491701234567","body":"second"}`, lines[1])
+}
+
+func TestNormalizeNumber(t *testing.T) {
+	for number, expected := range map[string]string{
+		"+49 170 1234567":  "+491701234567",
+		"0170/123 45-67":   "01701234567",
+		"+1 (555) 0100100": "+15550100100",
+		"12345":            "",
+		"+49 170 1234567x": "",
+		"49+1701234567":    "",
+	} {
+		normalized, err := NormalizeNumber(number)
+		if len(expected) == 0 {
+			assert.Equal(t, ErrInvalidNumber, err, number)
+		} else {
+			assert.Equal(t, expected, normalized, number)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package theme
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+	"github.com/rs/zerolog/log"
+	"html/template"
+	"io/fs"
+	"login-provider/internal/config"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DataKey is the key the selected theme is expected under in the data passed to the templates
+const DataKey = "theme"
+
+// Renderer renders the pages using the template set of the theme selected for the page. The
+// template set of a theme consists of the default templates overridden by the templates from
+// the template directory of the theme.
+type Renderer struct {
+	defaults fs.FS
+	funcs    template.FuncMap
+
+	mutex sync.RWMutex
+	sets  *templateSets
+}
+
+type templateSets struct {
+	defaultTemplates *template.Template
+	themeTemplates   map[string]*template.Template
+}
+
+// NewRenderer loads the default templates from the given file system and creates the template
+// sets for all themes, which configure an own template directory. If an override directory is
+// given, the templates from it replace the default ones with the same name.
+func NewRenderer(defaults fs.FS, overrideDir string, themes map[string]*config.Theme, funcs template.FuncMap) (*Renderer, error) {
+	r := &Renderer{defaults: defaults, funcs: funcs}
+
+	sets, err := r.load(overrideDir, themes)
+	if err != nil {
+		return nil, err
+	}
+
+	r.sets = sets
+	return r, nil
+}
+
+// Reconfigure loads the templates for the given configuration. The loaded templates replace the
+// current ones when the returned function is called.
+func (r *Renderer) Reconfigure(conf config.Configuration) (func(), error) {
+	sets, err := r.load(conf.TemplatesDirectory(), conf.Themes())
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.sets = sets
+	}, nil
+}
+
+func (r *Renderer) load(overrideDir string, themes map[string]*config.Theme) (*templateSets, error) {
+	defaultTemplates, err := template.New("").Funcs(r.funcs).ParseFS(r.defaults, "*")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(overrideDir) != 0 {
+		if defaultTemplates, err = defaultTemplates.ParseGlob(filepath.Join(overrideDir, "*")); err != nil {
+			return nil, err
+		}
+		log.Info().Str("_directory", overrideDir).Msg("Loaded templates overriding the default ones")
+	}
+
+	sets := &templateSets{
+		defaultTemplates: defaultTemplates,
+		themeTemplates:   make(map[string]*template.Template),
+	}
+
+	for name, theme := range themes {
+		if len(theme.TemplateDir) == 0 {
+			continue
+		}
+
+		themeTemplates, err := defaultTemplates.Clone()
+		if err != nil {
+			return nil, err
+		}
+		// templates with the same name as the default ones replace these
+		if themeTemplates, err = themeTemplates.ParseGlob(filepath.Join(theme.TemplateDir, "*")); err != nil {
+			return nil, err
+		}
+
+		log.Info().Str("_theme", name).Msg("Loaded templates of theme")
+		sets.themeTemplates[name] = themeTemplates
+	}
+
+	return sets, nil
+}
+
+func (r *Renderer) Instance(name string, data interface{}) render.Render {
+	r.mutex.RLock()
+	sets := r.sets
+	r.mutex.RUnlock()
+
+	templates := sets.defaultTemplates
+	if h, ok := data.(gin.H); ok {
+		if theme, ok := h[DataKey].(*config.Theme); ok && theme != nil {
+			if themeTemplates, ok := sets.themeTemplates[theme.Name]; ok {
+				templates = themeTemplates
+			}
+		}
+	}
+
+	return render.HTML{
+		Template: templates,
+		Name:     name,
+		Data:     data,
+	}
+}
+
+// Select returns the theme to use for the given client. The theme named in the metadata of the
+// client takes precedence over the theme assigned to the client by configuration. If neither
+// names an existing theme, the default theme is used.
+func Select(themes map[string]*config.Theme, clientThemes map[string]string, clientID, metadataTheme string) *config.Theme {
+	if theme, ok := themes[metadataTheme]; ok {
+		return theme
+	}
+	// viper treats keys case insensitive and lower cases these
+	if theme, ok := themes[clientThemes[strings.ToLower(clientID)]]; ok {
+		return theme
+	}
+	return themes[config.DefaultTheme]
+}
@@ -0,0 +1,148 @@
+package theme
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"login-provider/internal/config"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func writeTemplate(t *testing.T, dir, name, content string) {
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+}
+
+func renderPage(t *testing.T, r *Renderer, name string, data gin.H) string {
+	w := httptest.NewRecorder()
+	require.NoError(t, r.Instance(name, data).Render(w))
+	return w.Body.String()
+}
+
+func TestThemeTemplatesOverrideDefaultTemplates(t *testing.T) {
+	// GIVEN
+	defaultDir, err := ioutil.TempDir("", "templates")
+	require.NoError(t, err)
+	defer os.RemoveAll(defaultDir)
+	themeDir, err := ioutil.TempDir("", "theme")
+	require.NoError(t, err)
+	defer os.RemoveAll(themeDir)
+
+	writeTemplate(t, defaultDir, "header.html", `default header`)
+	writeTemplate(t, defaultDir, "page.html", `{{ template "header.html" . }} {{ .theme.LogoUrl }}`)
+	writeTemplate(t, themeDir, "header.html", `acme header`)
+
+	themes := map[string]*config.Theme{
+		config.DefaultTheme: {Name: config.DefaultTheme, LogoUrl: "default.svg"},
+		"acme":              {Name: "acme", LogoUrl: "acme.svg", TemplateDir: themeDir},
+	}
+
+	// WHEN
+	r, err := NewRenderer(os.DirFS(defaultDir), "", themes, nil)
+	require.NoError(t, err)
+
+	// THEN
+	assert.Equal(t, "default header default.svg", renderPage(t, r, "page.html", gin.H{DataKey: themes[config.DefaultTheme]}))
+	assert.Equal(t, "acme header acme.svg", renderPage(t, r, "page.html", gin.H{DataKey: themes["acme"]}))
+	assert.Equal(t, "default header ", renderPage(t, r, "page.html", gin.H{}))
+}
+
+func TestOverrideDirectoryReplacesDefaultTemplates(t *testing.T) {
+	// GIVEN
+	overrideDir, err := ioutil.TempDir("", "override")
+	require.NoError(t, err)
+	defer os.RemoveAll(overrideDir)
+	writeTemplate(t, overrideDir, "header.html", `custom header`)
+
+	defaults := fstest.MapFS{
+		"header.html": {Data: []byte(`default header`)},
+		"page.html":   {Data: []byte(`{{ template "header.html" . }}`)},
+	}
+
+	// WHEN
+	r, err := NewRenderer(defaults, overrideDir, nil, nil)
+	require.NoError(t, err)
+
+	// THEN
+	assert.Equal(t, "custom header", renderPage(t, r, "page.html", gin.H{}))
+}
+
+func TestNewRendererFailsForMissingTemplates(t *testing.T) {
+	// WHEN
+	_, err := NewRenderer(os.DirFS(filepath.Join(os.TempDir(), "does-not-exist")), "", nil, nil)
+
+	// THEN
+	assert.Error(t, err)
+}
+
+func TestSelectPrefersMetadataOverConfiguredClientThemes(t *testing.T) {
+	// GIVEN
+	themes := map[string]*config.Theme{
+		config.DefaultTheme: {Name: config.DefaultTheme},
+		"acme":              {Name: "acme"},
+		"other":             {Name: "other"},
+	}
+	clientThemes := map[string]string{"acme-client": "acme"}
+
+	// WHEN & THEN
+	assert.Equal(t, "acme", Select(themes, clientThemes, "ACME-Client", "").Name)
+	assert.Equal(t, "other", Select(themes, clientThemes, "acme-client", "other").Name)
+	assert.Equal(t, "acme", Select(themes, clientThemes, "acme-client", "unknown").Name)
+	assert.Equal(t, config.DefaultTheme, Select(themes, clientThemes, "foo", "").Name)
+}
+
+func TestReconfigureReplacesTemplates(t *testing.T) {
+	// GIVEN
+	defaults := fstest.MapFS{"page.html": {Data: []byte(`default page`)}}
+	overrideDir := t.TempDir()
+	writeTemplate(t, overrideDir, "page.html", `custom page`)
+
+	r, err := NewRenderer(defaults, "", nil, nil)
+	require.NoError(t, err)
+
+	conf := &reloadedConfiguration{templatesDirectory: overrideDir}
+
+	// WHEN
+	apply, err := r.Reconfigure(conf)
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, "default page", renderPage(t, r, "page.html", gin.H{}))
+	apply()
+	assert.Equal(t, "custom page", renderPage(t, r, "page.html", gin.H{}))
+}
+
+func TestReconfigureFailsForBrokenTemplates(t *testing.T) {
+	// GIVEN
+	defaults := fstest.MapFS{"page.html": {Data: []byte(`default page`)}}
+	overrideDir := t.TempDir()
+	writeTemplate(t, overrideDir, "page.html", `{{ .broken `)
+
+	r, err := NewRenderer(defaults, "", nil, nil)
+	require.NoError(t, err)
+
+	// WHEN
+	_, err = r.Reconfigure(&reloadedConfiguration{templatesDirectory: overrideDir})
+
+	// THEN
+	assert.Error(t, err)
+	assert.Equal(t, "default page", renderPage(t, r, "page.html", gin.H{}))
+}
+
+// reloadedConfiguration provides the template related settings of a reloaded configuration
+type reloadedConfiguration struct {
+	config.Configuration
+	templatesDirectory string
+}
+
+func (c *reloadedConfiguration) TemplatesDirectory() string {
+	return c.templatesDirectory
+}
+
+func (c *reloadedConfiguration) Themes() map[string]*config.Theme {
+	return map[string]*config.Theme{}
+}
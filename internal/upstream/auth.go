@@ -0,0 +1,192 @@
+package upstream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"login-provider/internal/config"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// refreshMargin is the period before the expiry of an access token, it is refreshed
+const refreshMargin = 30 * time.Second
+
+// NewClient creates the http client used to call the given upstream service with its TLS settings,
+// credentials and resilience policy
+func NewClient(conf config.Configuration, upstream config.Upstream) (*http.Client, error) {
+	transport, err := NewTransport(conf.UpstreamTlsConfig(upstream))
+	if err != nil {
+		return nil, err
+	}
+
+	authConfig, err := conf.UpstreamAuthConfig(upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	roundTripper, err := Authenticate(transport, authConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: Resilient(roundTripper, upstream, conf.ResilienceConfig(upstream))}, nil
+}
+
+// Authenticate adds the configured credentials to all requests sent via the given round tripper.
+// Access tokens retrieved with the client credentials grant are cached until shortly before they
+// expire or the upstream service rejects them.
+func Authenticate(next http.RoundTripper, conf *config.UpstreamAuthConfig) (http.RoundTripper, error) {
+	var auth authenticator
+	switch conf.Type {
+	case config.AuthNone:
+		return next, nil
+	case config.AuthBearer:
+		auth = bearerToken(conf.Token)
+	case config.AuthBasic:
+		auth = &basicAuth{username: conf.Username, password: conf.Password}
+	case config.AuthClientCredentials:
+		auth = &clientCredentials{conf: conf, client: &http.Client{Transport: next}, now: time.Now}
+	default:
+		return nil, fmt.Errorf("unsupported authentication %q", conf.Type)
+	}
+
+	return &authTransport{next: next, auth: auth}, nil
+}
+
+type authenticator interface {
+	authorize(req *http.Request) error
+	// invalidate is called if the upstream service rejected the credentials
+	invalidate()
+}
+
+type authTransport struct {
+	next http.RoundTripper
+	auth authenticator
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// round trippers must not modify the given request
+	req = req.Clone(req.Context())
+	if err := t.auth.authorize(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		t.auth.invalidate()
+	}
+	return resp, err
+}
+
+type bearerToken string
+
+func (t bearerToken) authorize(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(t))
+	return nil
+}
+
+func (t bearerToken) invalidate() {}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (a *basicAuth) authorize(req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+func (a *basicAuth) invalidate() {}
+
+// clientCredentials retrieves access tokens using the OAuth2 client credentials grant
+// (https://tools.ietf.org/html/rfc6749#section-4.4)
+type clientCredentials struct {
+	conf   *config.UpstreamAuthConfig
+	client *http.Client
+	now    func() time.Time
+
+	mutex  sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (cc *clientCredentials) authorize(req *http.Request) error {
+	token, err := cc.accessToken(req)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (cc *clientCredentials) invalidate() {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	cc.token = ""
+}
+
+func (cc *clientCredentials) accessToken(req *http.Request) (string, error) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	if len(cc.token) != 0 && (cc.expiry.IsZero() || cc.now().Before(cc.expiry.Add(-refreshMargin))) {
+		return cc.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(cc.conf.Scopes) != 0 {
+		form.Set("scope", strings.Join(cc.conf.Scopes, " "))
+	}
+
+	tokenReq, err := http.NewRequestWithContext(req.Context(), http.MethodPost, cc.conf.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenReq.Header.Set("Accept", "application/json")
+	// client_secret_basic requires the credentials to be form encoded
+	tokenReq.SetBasicAuth(url.QueryEscape(cc.conf.ClientId), url.QueryEscape(cc.conf.ClientSecret))
+
+	resp, err := cc.client.Do(tokenReq)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve access token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to retrieve access token: unexpected status code %d", resp.StatusCode)
+	}
+
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("failed to parse access token response: %w", err)
+	}
+	if len(token.AccessToken) == 0 {
+		return "", errors.New("no access token received")
+	}
+	if len(token.TokenType) != 0 && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", fmt.Errorf("unsupported token type %q", token.TokenType)
+	}
+
+	cc.token = token.AccessToken
+	cc.expiry = time.Time{}
+	if token.ExpiresIn > 0 {
+		cc.expiry = cc.now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return cc.token, nil
+}
@@ -0,0 +1,182 @@
+package upstream
+
+import (
+	"login-provider/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenServer issues access tokens for the client credentials grant and counts the issued tokens
+type tokenServer struct {
+	*httptest.Server
+	mutex  sync.Mutex
+	issued int
+}
+
+func newTokenServer(t *testing.T, expiresIn int) *tokenServer {
+	ts := &tokenServer{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// client_secret_basic credentials are form encoded
+		id, secret, _ := r.BasicAuth()
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+		if r.PostFormValue("grant_type") != "client_credentials" || id != "login-provider" || secret != "s3cr%t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		ts.mutex.Lock()
+		ts.issued++
+		token := "token-" + strconv.Itoa(ts.issued)
+		ts.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"` + token + `","token_type":"bearer","expires_in":` + strconv.Itoa(expiresIn) + `,"scope":"` + r.PostFormValue("scope") + `"}`))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// newUpstream returns the Authorization headers of the received requests. It rejects requests
+// authorized with the token given in reject.
+func newUpstream(t *testing.T, reject string) (*httptest.Server, *[]string) {
+	var mutex sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		received = append(received, r.Header.Get("Authorization"))
+		mutex.Unlock()
+		if len(reject) != 0 && r.Header.Get("Authorization") == "Bearer "+reject {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &received
+}
+
+func send(t *testing.T, roundTripper http.RoundTripper, url string) int {
+	resp, err := (&http.Client{Transport: roundTripper}).Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAuthenticateWithStaticCredentials(t *testing.T) {
+	// GIVEN
+	server, received := newUpstream(t, "")
+
+	bearer, err := Authenticate(http.DefaultTransport, &config.UpstreamAuthConfig{Type: config.AuthBearer, Token: "static"})
+	require.NoError(t, err)
+	basic, err := Authenticate(http.DefaultTransport, &config.UpstreamAuthConfig{Type: config.AuthBasic, Username: "login", Password: "provider"})
+	require.NoError(t, err)
+	none, err := Authenticate(http.DefaultTransport, &config.UpstreamAuthConfig{})
+	require.NoError(t, err)
+
+	// WHEN
+	send(t, bearer, server.URL)
+	send(t, basic, server.URL)
+	send(t, none, server.URL)
+
+	// THEN
+	assert.Equal(t, []string{"Bearer static", "Basic bG9naW46cHJvdmlkZXI=", ""}, *received)
+}
+
+func TestAuthenticateCachesClientCredentialsToken(t *testing.T) {
+	// GIVEN
+	tokens := newTokenServer(t, 3600)
+	server, received := newUpstream(t, "")
+
+	roundTripper, err := Authenticate(http.DefaultTransport, &config.UpstreamAuthConfig{
+		Type:         config.AuthClientCredentials,
+		ClientId:     "login-provider",
+		ClientSecret: "s3cr%t",
+		TokenUrl:     tokens.URL,
+		Scopes:       []string{"hydra.admin"},
+	})
+	require.NoError(t, err)
+
+	// WHEN
+	send(t, roundTripper, server.URL)
+	send(t, roundTripper, server.URL)
+
+	// THEN
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-1"}, *received)
+	assert.Equal(t, 1, tokens.issued)
+}
+
+func TestAuthenticateRefreshesExpiringClientCredentialsToken(t *testing.T) {
+	// GIVEN
+	tokens := newTokenServer(t, 60)
+	server, received := newUpstream(t, "")
+
+	roundTripper, err := Authenticate(http.DefaultTransport, &config.UpstreamAuthConfig{
+		Type:         config.AuthClientCredentials,
+		ClientId:     "login-provider",
+		ClientSecret: "s3cr%t",
+		TokenUrl:     tokens.URL,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	roundTripper.(*authTransport).auth.(*clientCredentials).now = func() time.Time { return now }
+	send(t, roundTripper, server.URL)
+
+	// WHEN
+	now = now.Add(45 * time.Second)
+	send(t, roundTripper, server.URL)
+
+	// THEN
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-2"}, *received)
+}
+
+func TestAuthenticateDropsRejectedClientCredentialsToken(t *testing.T) {
+	// GIVEN
+	tokens := newTokenServer(t, 0)
+	server, received := newUpstream(t, "token-1")
+
+	roundTripper, err := Authenticate(http.DefaultTransport, &config.UpstreamAuthConfig{
+		Type:         config.AuthClientCredentials,
+		ClientId:     "login-provider",
+		ClientSecret: "s3cr%t",
+		TokenUrl:     tokens.URL,
+	})
+	require.NoError(t, err)
+
+	// WHEN
+	first := send(t, roundTripper, server.URL)
+	second := send(t, roundTripper, server.URL)
+
+	// THEN
+	assert.Equal(t, http.StatusUnauthorized, first)
+	assert.Equal(t, http.StatusOK, second)
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-2"}, *received)
+}
+
+func TestAuthenticateFailsIfNoTokenIsIssued(t *testing.T) {
+	// GIVEN
+	tokens := newTokenServer(t, 3600)
+	server, received := newUpstream(t, "")
+
+	roundTripper, err := Authenticate(http.DefaultTransport, &config.UpstreamAuthConfig{
+		Type:         config.AuthClientCredentials,
+		ClientId:     "login-provider",
+		ClientSecret: "wrong",
+		TokenUrl:     tokens.URL,
+	})
+	require.NoError(t, err)
+
+	// WHEN
+	_, err = (&http.Client{Transport: roundTripper}).Get(server.URL)
+
+	// THEN
+	assert.Error(t, err)
+	assert.Empty(t, *received)
+}
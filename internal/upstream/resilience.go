@@ -0,0 +1,314 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"github.com/rs/zerolog/log"
+	"io"
+	"io/ioutil"
+	"login-provider/internal/config"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrUnavailable is returned if an upstream service can't be reached or the circuit breaker
+// rejects calls to it
+var ErrUnavailable = errors.New("upstream service temporarily unavailable")
+
+var errCircuitOpen = errors.New("circuit breaker open")
+
+// retryBackoff is the base of the exponential backoff between retries. The actual delay is
+// chosen randomly up to the current backoff to spread retries of concurrent requests.
+const retryBackoff = 100 * time.Millisecond
+
+// metrics exposes the circuit breaker states and the retries per upstream service via expvar
+var metrics = expvar.NewMap("upstreams")
+
+// breakers holds the circuit breaker of each upstream service. They outlive the transports
+// created again on configuration changes, so reloading neither closes an open circuit nor
+// resets the published state.
+var breakers = struct {
+	mutex      sync.Mutex
+	byUpstream map[config.Upstream]*breaker
+}{byUpstream: make(map[config.Upstream]*breaker)}
+
+// UnavailableError wraps the error, which made an upstream service unavailable
+type UnavailableError struct {
+	Upstream config.Upstream
+	Err      error
+}
+
+func (e *UnavailableError) Error() string {
+	return string(e.Upstream) + ": " + ErrUnavailable.Error() + ": " + e.Err.Error()
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// Resilient limits each attempt to call the upstream service to the configured timeout, retries
+// idempotent requests failed due to network errors or an unavailable upstream service and stops
+// calling the upstream service for a while if it failed repeatedly.
+func Resilient(next http.RoundTripper, upstream config.Upstream, conf *config.ResilienceConfig) http.RoundTripper {
+	return &resilientTransport{
+		next:     next,
+		upstream: upstream,
+		conf:     conf,
+		breaker:  breakerFor(upstream, conf),
+	}
+}
+
+// breakerFor returns the circuit breaker of the given upstream service updated to the given
+// settings. It is created on first use.
+func breakerFor(upstream config.Upstream, conf *config.ResilienceConfig) *breaker {
+	breakers.mutex.Lock()
+	defer breakers.mutex.Unlock()
+
+	b, ok := breakers.byUpstream[upstream]
+	if !ok {
+		b = newBreaker(upstream, conf)
+		breakers.byUpstream[upstream] = b
+		return b
+	}
+	b.configure(conf)
+	return b
+}
+
+type resilientTransport struct {
+	next     http.RoundTripper
+	upstream config.Upstream
+	conf     *config.ResilienceConfig
+	breaker  *breaker
+}
+
+func (t *resilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := 1
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		attempts += t.conf.MaxRetries
+	}
+
+	for attempt := 1; ; attempt++ {
+		if !t.breaker.allow() {
+			metrics.Add(string(t.upstream)+"_rejected", 1)
+			return nil, &UnavailableError{Upstream: t.upstream, Err: errCircuitOpen}
+		}
+
+		resp, err := t.attempt(req)
+		failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+		// requests canceled by the client don't tell anything about the upstream service
+		if req.Context().Err() == nil {
+			t.breaker.record(!failed)
+		} else {
+			t.breaker.release()
+		}
+
+		if !t.retryable(req, resp, err) || attempt == attempts {
+			if err != nil && req.Context().Err() == nil {
+				return nil, &UnavailableError{Upstream: t.upstream, Err: err}
+			}
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		metrics.Add(string(t.upstream)+"_retries", 1)
+		logger := log.Ctx(req.Context())
+		logger.Debug().Str("_upstream", string(t.upstream)).Int("_attempt", attempt).Msg("Retrying upstream call")
+
+		backoff := retryBackoff << (attempt - 1)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(time.Duration(rand.Int63n(int64(backoff)))):
+		}
+	}
+}
+
+// attempt sends the request limiting it to the configured timeout
+func (t *resilientTransport) attempt(req *http.Request) (*http.Response, error) {
+	if t.conf.Timeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.conf.Timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// the timeout covers reading the response as well
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (t *resilientTransport) retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+type breakerState string
+
+const (
+	closed   breakerState = "closed"
+	open     breakerState = "open"
+	halfOpen breakerState = "half_open"
+)
+
+// breaker opens after the configured number of consecutive failures. While open, calls are
+// rejected. After the configured duration a single call is let through to probe the upstream
+// service. The breaker closes if it succeeds and opens again otherwise.
+type breaker struct {
+	upstream config.Upstream
+	now      func() time.Time
+
+	mutex     sync.Mutex
+	threshold int
+	duration  time.Duration
+	state     breakerState
+	gauge     *expvar.String
+	failures  int
+	openedAt  time.Time
+	probing   bool
+}
+
+func newBreaker(upstream config.Upstream, conf *config.ResilienceConfig) *breaker {
+	b := &breaker{
+		upstream:  upstream,
+		threshold: conf.FailureThreshold,
+		duration:  conf.OpenDuration,
+		now:       time.Now,
+		state:     closed,
+		gauge:     new(expvar.String),
+	}
+	b.publish()
+	metrics.Set(string(upstream)+"_circuit_state", b.gauge)
+	return b
+}
+
+// configure applies changed settings keeping the state. A circuit disabled by the new settings
+// is closed.
+func (b *breaker) configure(conf *config.ResilienceConfig) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.threshold = conf.FailureThreshold
+	b.duration = conf.OpenDuration
+	if b.threshold <= 0 && b.state != closed {
+		b.failures = 0
+		b.probing = false
+		b.transition(closed)
+	}
+}
+
+func (b *breaker) allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.threshold <= 0 {
+		return true
+	}
+
+	switch b.state {
+	case open:
+		if b.now().Sub(b.openedAt) < b.duration {
+			return false
+		}
+		b.transition(halfOpen)
+		b.probing = true
+		return true
+	case halfOpen:
+		// only a single probe is let through at a time
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *breaker) record(success bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.probing = false
+	if success {
+		b.failures = 0
+		if b.state != closed {
+			b.transition(closed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == halfOpen || b.failures >= b.threshold {
+		b.openedAt = b.now()
+		if b.state != open {
+			b.transition(open)
+		}
+	}
+}
+
+// release lets the next call probe the upstream service if the current probe was canceled
+func (b *breaker) release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+func (b *breaker) transition(state breakerState) {
+	from := b.state
+	b.state = state
+	b.publish()
+	metrics.Add(string(b.upstream)+"_circuit_state_changes", 1)
+
+	logger := log.With().Str("_upstream", string(b.upstream)).Str("_from", string(from)).Str("_to", string(state)).Logger()
+	switch state {
+	case open:
+		logger.Warn().Int("_failures", b.failures).Msg("Circuit breaker opened. Calls to the upstream service are rejected")
+	case halfOpen:
+		logger.Info().Msg("Circuit breaker half open. Probing the upstream service")
+	default:
+		logger.Info().Msg("Circuit breaker closed. Upstream service available again")
+	}
+}
+
+func (b *breaker) publish() {
+	b.gauge.Set(string(b.state))
+}
@@ -0,0 +1,214 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"login-provider/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyServer responds with the given status codes in order and with 200 afterwards
+type flakyServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	calls    int
+}
+
+func newFlakyServer(t *testing.T, statuses ...int) *flakyServer {
+	fs := &flakyServer{statuses: statuses}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.mutex.Lock()
+		defer fs.mutex.Unlock()
+
+		status := http.StatusOK
+		if fs.calls < len(fs.statuses) {
+			status = fs.statuses[fs.calls]
+		}
+		fs.calls++
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+func (fs *flakyServer) called() int {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.calls
+}
+
+// counter returns the current value of the given metric
+func counter(name string) int64 {
+	if value, ok := metrics.Get(name).(*expvar.Int); ok {
+		return value.Value()
+	}
+	return 0
+}
+
+func call(roundTripper http.RoundTripper, ctx context.Context, method, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := roundTripper.RoundTrip(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestResilientRetriesIdempotentRequests(t *testing.T) {
+	// GIVEN
+	server := newFlakyServer(t, http.StatusServiceUnavailable, http.StatusBadGateway)
+	roundTripper := Resilient(http.DefaultTransport, "retry_get", &config.ResilienceConfig{MaxRetries: 2})
+	retries := counter("retry_get_retries")
+
+	// WHEN
+	status, err := call(roundTripper, context.Background(), http.MethodGet, server.URL)
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 3, server.called())
+	assert.Equal(t, int64(2), counter("retry_get_retries")-retries)
+}
+
+func TestResilientDoesNotRetryOtherRequests(t *testing.T) {
+	// GIVEN
+	server := newFlakyServer(t, http.StatusServiceUnavailable)
+	roundTripper := Resilient(http.DefaultTransport, "retry_put", &config.ResilienceConfig{MaxRetries: 2})
+
+	// WHEN
+	status, err := call(roundTripper, context.Background(), http.MethodPut, server.URL)
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, 1, server.called())
+}
+
+func TestResilientLimitsEachAttemptToTimeout(t *testing.T) {
+	// GIVEN
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+	roundTripper := Resilient(http.DefaultTransport, "timeout", &config.ResilienceConfig{Timeout: 50 * time.Millisecond})
+
+	// WHEN
+	start := time.Now()
+	_, err := call(roundTripper, context.Background(), http.MethodGet, server.URL)
+
+	// THEN
+	assert.True(t, errors.Is(err, ErrUnavailable))
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+}
+
+func TestResilientOpensCircuitAfterRepeatedFailures(t *testing.T) {
+	// GIVEN
+	server := newFlakyServer(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	roundTripper := Resilient(http.DefaultTransport, "breaker", &config.ResilienceConfig{
+		FailureThreshold: 2,
+		OpenDuration:     time.Minute,
+	}).(*resilientTransport)
+	now := time.Now()
+	roundTripper.breaker.now = func() time.Time { return now }
+	changes := counter("breaker_circuit_state_changes")
+
+	call(roundTripper, context.Background(), http.MethodGet, server.URL)
+	call(roundTripper, context.Background(), http.MethodGet, server.URL)
+
+	// WHEN
+	_, rejectedErr := call(roundTripper, context.Background(), http.MethodGet, server.URL)
+	now = now.Add(time.Minute)
+	status, probeErr := call(roundTripper, context.Background(), http.MethodGet, server.URL)
+
+	// THEN
+	assert.True(t, errors.Is(rejectedErr, ErrUnavailable))
+	require.NoError(t, probeErr)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 3, server.called(), "The open circuit must not call the upstream service")
+	assert.Equal(t, `"closed"`, metrics.Get("breaker_circuit_state").String())
+	assert.Equal(t, int64(3), counter("breaker_circuit_state_changes")-changes, "closed -> open -> half open -> closed")
+}
+
+func TestResilientReopensCircuitIfProbeFails(t *testing.T) {
+	// GIVEN
+	server := newFlakyServer(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	roundTripper := Resilient(http.DefaultTransport, "probe", &config.ResilienceConfig{
+		FailureThreshold: 1,
+		OpenDuration:     time.Minute,
+	}).(*resilientTransport)
+	now := time.Now()
+	roundTripper.breaker.now = func() time.Time { return now }
+
+	call(roundTripper, context.Background(), http.MethodGet, server.URL)
+	now = now.Add(time.Minute)
+
+	// WHEN
+	call(roundTripper, context.Background(), http.MethodGet, server.URL)
+	_, err := call(roundTripper, context.Background(), http.MethodGet, server.URL)
+
+	// THEN
+	assert.True(t, errors.Is(err, ErrUnavailable))
+	assert.Equal(t, 2, server.called())
+	assert.Equal(t, `"open"`, metrics.Get("probe_circuit_state").String())
+}
+
+func TestResilientIgnoresRequestsCanceledByTheClient(t *testing.T) {
+	// GIVEN
+	server := newFlakyServer(t)
+	roundTripper := Resilient(http.DefaultTransport, "canceled", &config.ResilienceConfig{
+		FailureThreshold: 1,
+		OpenDuration:     time.Minute,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// WHEN
+	_, canceledErr := call(roundTripper, ctx, http.MethodGet, server.URL)
+	status, err := call(roundTripper, context.Background(), http.MethodGet, server.URL)
+
+	// THEN
+	assert.False(t, errors.Is(canceledErr, ErrUnavailable))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestResilientKeepsCircuitStateOnReconfigure(t *testing.T) {
+	// GIVEN
+	server := newFlakyServer(t, http.StatusInternalServerError)
+	roundTripper := Resilient(http.DefaultTransport, "reconfigured", &config.ResilienceConfig{
+		FailureThreshold: 1,
+		OpenDuration:     time.Minute,
+	}).(*resilientTransport)
+	now := time.Now()
+	roundTripper.breaker.now = func() time.Time { return now }
+	call(roundTripper, context.Background(), http.MethodGet, server.URL)
+
+	// WHEN
+	reconfigured := Resilient(http.DefaultTransport, "reconfigured", &config.ResilienceConfig{
+		FailureThreshold: 1,
+		OpenDuration:     2 * time.Minute,
+	})
+	now = now.Add(time.Minute)
+	_, err := call(reconfigured, context.Background(), http.MethodGet, server.URL)
+
+	// THEN
+	assert.True(t, errors.Is(err, ErrUnavailable), "The circuit must stay open for the new duration")
+	assert.Equal(t, 1, server.called())
+	assert.Equal(t, `"open"`, metrics.Get("reconfigured_circuit_state").String())
+}
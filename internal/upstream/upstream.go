@@ -0,0 +1,120 @@
+// Package upstream creates the clients used to call upstream services, like the hydra admin API
+// and the authentication service. All of them share the same TLS settings. Each upstream service
+// may require its own client certificate and credentials.
+package upstream
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"login-provider/internal/config"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// NewTransport creates a transport for the given TLS settings. The system-wide trust store is used
+// if no explicit one is configured.
+func NewTransport(conf *config.UpstreamTlsConfig) (*http.Transport, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if len(conf.TrustStoreFile) != 0 {
+		pool, err := loadTrustStore(conf.TrustStoreFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if conf.ClientCertificate != nil {
+		certificate, err := newClientCertificate(conf.ClientCertificate)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = certificate.get
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+func loadTrustStore(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trust store: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates found in trust store " + file)
+	}
+	return pool, nil
+}
+
+// clientCertificate loads the certificate again if its files changed. New connections use the
+// rotated certificate without restart.
+type clientCertificate struct {
+	files       *config.Certificate
+	mutex       sync.Mutex
+	modified    time.Time
+	certificate *tls.Certificate
+}
+
+func newClientCertificate(files *config.Certificate) (*clientCertificate, error) {
+	cc := &clientCertificate{files: files}
+	if err := cc.load(); err != nil {
+		return nil, err
+	}
+	return cc, nil
+}
+
+func (cc *clientCertificate) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	if modified, err := cc.lastModified(); err == nil && !modified.Equal(cc.modified) {
+		if err := cc.load(); err != nil {
+			l := log.With().Err(err).Str("_file", cc.files.CertFile).Logger()
+			l.Error().Msg("Failed to load the changed client certificate. Keeping the active one")
+		} else {
+			log.Info().Str("_file", cc.files.CertFile).Msg("Client certificate reloaded")
+		}
+	}
+	return cc.certificate, nil
+}
+
+func (cc *clientCertificate) load() error {
+	modified, err := cc.lastModified()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(cc.files.CertFile, cc.files.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load client certificate %s: %w", cc.files.CertFile, err)
+	}
+
+	cc.certificate = &certificate
+	cc.modified = modified
+	return nil
+}
+
+// lastModified returns the latest modification time of the key and the certificate file
+func (cc *clientCertificate) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{cc.files.CertFile, cc.files.KeyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,194 @@
+package upstream
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"login-provider/internal/config"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type ca struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	file        string
+}
+
+func newCA(t *testing.T, dir string) *ca {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	file := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	return &ca{certificate: certificate, key: key, file: file}
+}
+
+// issue writes a certificate signed by the ca and returns its files
+func (c *ca) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage) *config.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, c.certificate, &key.PublicKey, c.key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	files := &config.Certificate{CertFile: filepath.Join(dir, name+".crt"), KeyFile: filepath.Join(dir, name+".key")}
+	require.NoError(t, ioutil.WriteFile(files.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(files.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600))
+	return files
+}
+
+// newServer starts a server requiring client certificates issued by the ca. It responds with the
+// common name of the client certificate.
+func newServer(t *testing.T, dir string, c *ca) *httptest.Server {
+	files := c.issue(t, dir, "upstream", x509.ExtKeyUsageServerAuth)
+	certificate, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(c.certificate)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func get(transport *http.Transport, url string) (string, error) {
+	resp, err := (&http.Client{Transport: transport}).Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestTransportAuthenticatesWithClientCertificate(t *testing.T) {
+	// GIVEN
+	dir := t.TempDir()
+	c := newCA(t, dir)
+	server := newServer(t, dir, c)
+
+	transport, err := NewTransport(&config.UpstreamTlsConfig{
+		TrustStoreFile:    c.file,
+		ClientCertificate: c.issue(t, dir, "login-provider", x509.ExtKeyUsageClientAuth),
+	})
+	require.NoError(t, err)
+
+	// WHEN
+	name, err := get(transport, server.URL)
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, "login-provider", name)
+}
+
+func TestTransportFailsWithoutClientCertificate(t *testing.T) {
+	// GIVEN
+	dir := t.TempDir()
+	c := newCA(t, dir)
+	server := newServer(t, dir, c)
+
+	transport, err := NewTransport(&config.UpstreamTlsConfig{TrustStoreFile: c.file})
+	require.NoError(t, err)
+
+	// WHEN
+	_, err = get(transport, server.URL)
+
+	// THEN
+	assert.Error(t, err)
+}
+
+func TestTransportUsesRotatedClientCertificate(t *testing.T) {
+	// GIVEN
+	dir := t.TempDir()
+	c := newCA(t, dir)
+	server := newServer(t, dir, c)
+
+	files := c.issue(t, dir, "login-provider", x509.ExtKeyUsageClientAuth)
+	transport, err := NewTransport(&config.UpstreamTlsConfig{TrustStoreFile: c.file, ClientCertificate: files})
+	require.NoError(t, err)
+
+	name, err := get(transport, server.URL)
+	require.NoError(t, err)
+	require.Equal(t, "login-provider", name)
+
+	// WHEN
+	rotated := c.issue(t, dir, "rotated", x509.ExtKeyUsageClientAuth)
+	require.NoError(t, os.Rename(rotated.CertFile, files.CertFile))
+	require.NoError(t, os.Rename(rotated.KeyFile, files.KeyFile))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(files.CertFile, later, later))
+	transport.CloseIdleConnections()
+
+	name, err = get(transport, server.URL)
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", name)
+}
+
+func TestNewTransportFailsForInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	c := newCA(t, dir)
+	files := c.issue(t, dir, "login-provider", x509.ExtKeyUsageClientAuth)
+
+	for _, conf := range []*config.UpstreamTlsConfig{
+		{TrustStoreFile: files.KeyFile},
+		{TrustStoreFile: filepath.Join(dir, "missing.pem")},
+		{ClientCertificate: &config.Certificate{CertFile: files.CertFile, KeyFile: c.file}},
+	} {
+		// WHEN
+		_, err := NewTransport(conf)
+
+		// THEN
+		assert.Error(t, err)
+	}
+}
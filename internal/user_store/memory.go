@@ -0,0 +1,239 @@
+package user_store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps users and tokens in memory. If a file is given, they are written to it on each
+// change and read from it on start. This is meant for single instance deployments.
+type memoryStore struct {
+	mutex  sync.RWMutex
+	file   string
+	users  map[string]*User
+	tokens map[string]*Token
+	now    func() time.Time
+}
+
+// fileContent is the content of the file users and tokens are kept in
+type fileContent struct {
+	Users  []*User  `json:"users"`
+	Tokens []*Token `json:"tokens"`
+}
+
+// NewMemoryStore creates a store losing all users on restart
+func NewMemoryStore() Store {
+	return newMemoryStore("")
+}
+
+// NewFileStore creates a store keeping the users in the given JSON file. The file is created if it
+// does not exist.
+func NewFileStore(file string) (Store, error) {
+	s := newMemoryStore(file)
+
+	data, err := ioutil.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read users: %w", err)
+	}
+
+	var content fileContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("failed to read users from %s: %w", file, err)
+	}
+	for _, user := range content.Users {
+		s.users[user.ID] = user
+	}
+	for _, token := range content.Tokens {
+		s.tokens[token.Hash] = token
+	}
+	return s, nil
+}
+
+func newMemoryStore(file string) *memoryStore {
+	return &memoryStore{
+		file:   file,
+		users:  make(map[string]*User),
+		tokens: make(map[string]*Token),
+		now:    time.Now,
+	}
+}
+
+func (s *memoryStore) Create(_ context.Context, user *User) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.byEmail(user.Email); err == nil {
+		return ErrExists
+	}
+	if _, ok := s.users[user.ID]; ok {
+		return ErrExists
+	}
+
+	created := *user
+	s.users[user.ID] = &created
+	return s.persist(func() { delete(s.users, user.ID) })
+}
+
+func (s *memoryStore) ByID(_ context.Context, id string) (*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	found := *user
+	return &found, nil
+}
+
+func (s *memoryStore) ByEmail(_ context.Context, email string) (*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	user, err := s.byEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	found := *user
+	return &found, nil
+}
+
+func (s *memoryStore) byEmail(email string) (*User, error) {
+	key := normalizeEmail(email)
+	for _, user := range s.users {
+		if normalizeEmail(user.Email) == key {
+			return user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryStore) Update(_ context.Context, user *User) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous, ok := s.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if other, err := s.byEmail(user.Email); err == nil && other.ID != user.ID {
+		return ErrExists
+	}
+
+	updated := *user
+	s.users[user.ID] = &updated
+	return s.persist(func() { s.users[user.ID] = previous })
+}
+
+func (s *memoryStore) SaveToken(_ context.Context, token *Token) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.pruneTokens()
+	saved := *token
+	s.tokens[token.Hash] = &saved
+	return s.persist(func() { delete(s.tokens, token.Hash) })
+}
+
+func (s *memoryStore) ConsumeToken(_ context.Context, purpose, value string) (*Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.pruneTokens()
+	hash := hashToken(value)
+	token, ok := s.tokens[hash]
+	if !ok || token.Purpose != purpose {
+		return nil, ErrInvalidToken
+	}
+
+	delete(s.tokens, hash)
+	if err := s.persist(func() { s.tokens[hash] = token }); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s *memoryStore) RemoveTokens(_ context.Context, purpose, userID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := make(map[string]*Token)
+	for hash, token := range s.tokens {
+		if token.Purpose == purpose && token.UserID == userID {
+			removed[hash] = token
+			delete(s.tokens, hash)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	return s.persist(func() {
+		for hash, token := range removed {
+			s.tokens[hash] = token
+		}
+	})
+}
+
+// pruneTokens drops expired tokens, so unused tokens don't pile up. The caller must hold the lock.
+func (s *memoryStore) pruneTokens() {
+	now := s.now()
+	for hash, token := range s.tokens {
+		if now.After(token.ExpiresAt) {
+			delete(s.tokens, hash)
+		}
+	}
+}
+
+// persist writes users and tokens to the file, if any. The change is reverted with the given
+// function if the file can't be written. The caller must hold the lock.
+func (s *memoryStore) persist(revert func()) error {
+	if len(s.file) == 0 {
+		return nil
+	}
+
+	content := fileContent{Users: []*User{}, Tokens: []*Token{}}
+	for _, user := range s.users {
+		content.Users = append(content.Users, user)
+	}
+	for _, token := range s.tokens {
+		content.Tokens = append(content.Tokens, token)
+	}
+
+	if err := writeFile(s.file, content); err != nil {
+		revert()
+		return fmt.Errorf("failed to write users: %w", err)
+	}
+	return nil
+}
+
+// writeFile replaces the file atomically, so it is never left half written
+func writeFile(file string, content fileContent) error {
+	data, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,86 @@
+package user_store
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Passwords are hashed with PBKDF2-HMAC-SHA256. The number of iterations is stored with the hash,
+// so it can be increased without invalidating existing passwords.
+const (
+	hashAlgorithm  = "pbkdf2-sha256"
+	hashIterations = 310000
+	saltLength     = 16
+	keyLength      = 32
+)
+
+// HashPassword returns the hash of the given password encoded as "pbkdf2-sha256$iterations$salt$key"
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := pbkdf2([]byte(password), salt, hashIterations, keyLength)
+	return fmt.Sprintf("%s$%d$%s$%s", hashAlgorithm, hashIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// CheckPassword returns whether the password matches the hash created by HashPassword
+func CheckPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != hashAlgorithm {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	key := pbkdf2([]byte(password), salt, iterations, len(expected))
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+// pbkdf2 derives a key as specified by RFC 8018 using HMAC-SHA256 as pseudorandom function
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	blocks := (keyLen + prf.Size() - 1) / prf.Size()
+
+	var key []byte
+	index := make([]byte, 4)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(index, uint32(block))
+		prf.Write(index)
+		u := prf.Sum(nil)
+
+		t := make([]byte, len(u))
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for x := range t {
+				t[x] ^= u[x]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,157 @@
+// Package user_store keeps the users registered with the login provider itself together with the
+// tokens sent to them, like the ones to verify their email addresses. Users of the authentication
+// service are not kept here.
+package user_store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"login-provider/internal/config"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrDisabled is returned if no user store is configured
+	ErrDisabled = errors.New("user store disabled")
+	// ErrNotFound is returned if there is no such user
+	ErrNotFound = errors.New("user not found")
+	// ErrExists is returned if a user with the same email address is registered already
+	ErrExists = errors.New("user exists already")
+	// ErrInvalidToken is returned if a token is unknown, has been used already or is expired
+	ErrInvalidToken = errors.New("invalid or expired token")
+)
+
+// The purposes tokens are issued for. A token is only accepted for the purpose it was issued for.
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+	PurposeLoginLink     = "login_link"
+	PurposeLoginCode     = "login_code"
+)
+
+// User is a user registered with the login provider
+type User struct {
+	// ID is the subject of the user in hydra
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	// PhoneVerified is set once the user entered a code sent to the phone number kept in Fields
+	PhoneVerified bool `json:"phone_verified,omitempty"`
+	// PasswordHash is created by HashPassword
+	PasswordHash string `json:"password_hash"`
+	// Fields holds the additional fields entered on registration, see config.RegistrationFields
+	Fields    map[string]string `json:"fields,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// Token is a single use token sent to a user. Only the hash of the token is stored.
+type Token struct {
+	Hash    string `json:"hash"`
+	Purpose string `json:"purpose"`
+	UserID  string `json:"user_id"`
+	// Challenge is the login challenge the user returns to after using the token
+	Challenge string    `json:"challenge,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// PasswordHash and Fields are the ones entered on registration. They are applied once the email
+	// address is verified with the token, so only the owner of the address decides which ones count.
+	PasswordHash string            `json:"password_hash,omitempty"`
+	Fields       map[string]string `json:"fields,omitempty"`
+}
+
+// Store keeps users and tokens. Email addresses are compared case insensitive.
+type Store interface {
+	// Create adds a new user. ErrExists is returned if the email address is taken.
+	Create(ctx context.Context, user *User) error
+	ByID(ctx context.Context, id string) (*User, error)
+	ByEmail(ctx context.Context, email string) (*User, error)
+	// Update replaces the user with the same id
+	Update(ctx context.Context, user *User) error
+	SaveToken(ctx context.Context, token *Token) error
+	// ConsumeToken returns the token with the given value and deletes it, so it can't be used again.
+	// ErrInvalidToken is returned if there is no such token for the given purpose or if it is expired.
+	ConsumeToken(ctx context.Context, purpose, value string) (*Token, error)
+	// RemoveTokens deletes the tokens issued to the given user for the given purpose
+	RemoveTokens(ctx context.Context, purpose, userID string) error
+}
+
+// New creates the configured store
+func New(conf *config.UsersConfig) (Store, error) {
+	switch conf.Store {
+	case config.UsersStoreNone:
+		return nil, ErrDisabled
+	case config.UsersStoreMemory:
+		return NewMemoryStore(), nil
+	case config.UsersStoreFile:
+		return NewFileStore(conf.File)
+	default:
+		return nil, fmt.Errorf("unsupported user store %q", conf.Store)
+	}
+}
+
+// NewToken creates a random token. The value is sent to the user, the token is put into the store.
+func NewToken(purpose, userID, challenge string, ttl time.Duration) (string, *Token, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", nil, err
+	}
+	value := base64.RawURLEncoding.EncodeToString(data)
+
+	return value, &Token{
+		Hash:      hashToken(value),
+		Purpose:   purpose,
+		UserID:    userID,
+		Challenge: challenge,
+		ExpiresAt: time.Now().Add(ttl),
+	}, nil
+}
+
+// codeDigits is the length of the codes created by NewCode
+const codeDigits = 6
+
+// NewCode creates a random numeric code, which users can type. Codes are short, so they are only valid
+// together with the login challenge they were created for. Use CodeValue to consume the token.
+func NewCode(purpose, userID, challenge string, ttl time.Duration) (string, *Token, error) {
+	code, err := RandomCode()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return code, &Token{
+		Hash:      hashToken(CodeValue(challenge, code)),
+		Purpose:   purpose,
+		UserID:    userID,
+		Challenge: challenge,
+		ExpiresAt: time.Now().Add(ttl),
+	}, nil
+}
+
+// RandomCode returns a random numeric code, which users can type
+func RandomCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(math.Pow10(codeDigits))))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", codeDigits, n.Int64()), nil
+}
+
+// CodeValue returns the value of the token of a code entered for the given login challenge
+func CodeValue(challenge, code string) string {
+	return challenge + " " + strings.TrimSpace(code)
+}
+
+func hashToken(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// normalizeEmail returns the key email addresses are compared with
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,175 @@
+package user_store
+
+import (
+	"context"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPbkdf2MatchesTestVector(t *testing.T) {
+	// WHEN
+	key := pbkdf2([]byte("passwd"), []byte("salt"), 1, 64)
+
+	// THEN (RFC 7914, section 11)
+	assert.Equal(t, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"+
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783", hex.EncodeToString(key))
+}
+
+func TestCheckPassword(t *testing.T) {
+	// GIVEN
+	hash, err := HashPassword("secret")
+	require.NoError(t, err)
+
+	// WHEN & THEN
+	assert.True(t, CheckPassword(hash, "secret"))
+	assert.False(t, CheckPassword(hash, "Secret"))
+	assert.False(t, CheckPassword("secret", "secret"), "Passwords must never be stored in plain text")
+}
+
+func TestCreateRejectsTakenEmailAddress(t *testing.T) {
+	// GIVEN
+	store := NewMemoryStore()
+	require.NoError(t, store.Create(context.Background(), &User{ID: "1", Email: "Foo@example.com"}))
+
+	// WHEN
+	err := store.Create(context.Background(), &User{ID: "2", Email: "foo@EXAMPLE.com "})
+
+	// THEN
+	assert.Equal(t, ErrExists, err)
+	user, err := store.ByEmail(context.Background(), "FOO@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "1", user.ID)
+}
+
+func TestConsumeTokenOnlyOnce(t *testing.T) {
+	// GIVEN
+	store := NewMemoryStore()
+	value, token, err := NewToken(PurposeVerifyEmail, "1", "foo", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveToken(context.Background(), token))
+
+	// WHEN
+	_, wrongPurpose := store.ConsumeToken(context.Background(), PurposeResetPassword, value)
+	consumed, err := store.ConsumeToken(context.Background(), PurposeVerifyEmail, value)
+	_, again := store.ConsumeToken(context.Background(), PurposeVerifyEmail, value)
+
+	// THEN
+	assert.Equal(t, ErrInvalidToken, wrongPurpose)
+	require.NoError(t, err)
+	assert.Equal(t, "1", consumed.UserID)
+	assert.Equal(t, "foo", consumed.Challenge)
+	assert.Equal(t, ErrInvalidToken, again)
+}
+
+func TestConsumeTokenRejectsExpiredToken(t *testing.T) {
+	// GIVEN
+	store := NewMemoryStore()
+	value, token, err := NewToken(PurposeVerifyEmail, "1", "", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveToken(context.Background(), token))
+	store.(*memoryStore).now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+	// WHEN
+	_, err = store.ConsumeToken(context.Background(), PurposeVerifyEmail, value)
+
+	// THEN
+	assert.Equal(t, ErrInvalidToken, err)
+}
+
+func TestSaveTokenDropsExpiredTokensWithoutFile(t *testing.T) {
+	// GIVEN
+	store := NewMemoryStore()
+	_, expired, err := NewToken(PurposeVerifyEmail, "1", "", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveToken(context.Background(), expired))
+	store.(*memoryStore).now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, valid, err := NewToken(PurposeVerifyEmail, "2", "", 3*time.Hour)
+	require.NoError(t, err)
+
+	// WHEN
+	err = store.SaveToken(context.Background(), valid)
+
+	// THEN
+	require.NoError(t, err)
+	assert.NotContains(t, store.(*memoryStore).tokens, expired.Hash)
+	assert.Contains(t, store.(*memoryStore).tokens, valid.Hash)
+}
+
+func TestRemoveTokensOfUser(t *testing.T) {
+	// GIVEN
+	store := NewMemoryStore()
+	save := func(purpose, userID string) string {
+		value, token, err := NewToken(purpose, userID, "foo", time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, store.SaveToken(context.Background(), token))
+		return value
+	}
+	removed := save(PurposeVerifyEmail, "1")
+	otherPurpose := save(PurposeResetPassword, "1")
+	otherUser := save(PurposeVerifyEmail, "2")
+
+	// WHEN
+	err := store.RemoveTokens(context.Background(), PurposeVerifyEmail, "1")
+
+	// THEN
+	require.NoError(t, err)
+	_, err = store.ConsumeToken(context.Background(), PurposeVerifyEmail, removed)
+	assert.Equal(t, ErrInvalidToken, err)
+	_, err = store.ConsumeToken(context.Background(), PurposeResetPassword, otherPurpose)
+	assert.NoError(t, err)
+	_, err = store.ConsumeToken(context.Background(), PurposeVerifyEmail, otherUser)
+	assert.NoError(t, err)
+}
+
+func TestFileStoreKeepsUsersAndTokens(t *testing.T) {
+	// GIVEN
+	dir, err := ioutil.TempDir("", "users")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "users.json")
+
+	store, err := NewFileStore(file)
+	require.NoError(t, err)
+	require.NoError(t, store.Create(context.Background(), &User{ID: "1", Email: "foo@example.com"}))
+	value, token, err := NewToken(PurposeVerifyEmail, "1", "", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveToken(context.Background(), token))
+
+	// WHEN
+	reopened, err := NewFileStore(file)
+	require.NoError(t, err)
+
+	// THEN
+	user, err := reopened.ByID(context.Background(), "1")
+	require.NoError(t, err)
+	assert.Equal(t, "foo@example.com", user.Email)
+	_, err = reopened.ConsumeToken(context.Background(), PurposeVerifyEmail, value)
+	assert.NoError(t, err)
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "The file holds password hashes and must not be readable by others")
+}
+
+func TestCodeIsOnlyValidForItsChallenge(t *testing.T) {
+	// GIVEN
+	store := NewMemoryStore()
+	code, token, err := NewCode(PurposeLoginCode, "1", "foo", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveToken(context.Background(), token))
+
+	// WHEN
+	_, otherChallenge := store.ConsumeToken(context.Background(), PurposeLoginCode, CodeValue("bar", code))
+	consumed, err := store.ConsumeToken(context.Background(), PurposeLoginCode, CodeValue("foo", " "+code))
+
+	// THEN
+	assert.Regexp(t, `^\d{6}$`, code)
+	assert.Equal(t, ErrInvalidToken, otherChallenge)
+	require.NoError(t, err)
+	assert.Equal(t, "1", consumed.UserID)
+}
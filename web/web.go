@@ -0,0 +1,15 @@
+// Package web holds the default templates and static assets, like style sheets, scripts and
+// images, of the pages. These are embedded into the binary to make it self-contained.
+package web
+
+import "embed"
+
+// Templates holds the default templates in the "templates" directory
+//
+//go:embed templates
+var Templates embed.FS
+
+// Static holds the static assets in the "static" directory
+//
+//go:embed static
+var Static embed.FS